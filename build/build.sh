@@ -9,7 +9,21 @@ rm -f dist/kandalf*
 if [ -z "$VERSION" ]; then
   VERSION="0.0.1-dev"
 fi
-echo "Building application version $VERSION"
+if [ -z "$COMMIT" ]; then
+  COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo "unknown")
+fi
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+echo "Building application version $VERSION, commit $COMMIT"
+
+# "-s -w" strips debug information, build metadata is reported by "kandalf version", admin status and startup log
+LDFLAGS="-s -w -X main.version=${VERSION} -X main.commit=${COMMIT} -X main.buildDate=${BUILD_DATE}"
+
+# Go plugins require cgo, that is not cross-compiled, so binary opening pipes plugins is built for host platform only
+if [ -n "$PLUGINS" ]; then
+  echo "Building binary with Go plugins support"
+  CGO_ENABLED=1 go build -ldflags "${LDFLAGS}" -o "dist/kandalf" $PKG_SRC
+  exit 0
+fi
 
 # Build 386 amd64 binaries
 OS_PLATFORM_ARG=(linux darwin windows freebsd openbsd)
@@ -17,7 +31,7 @@ OS_ARCH_ARG=(386 amd64)
 for OS in ${OS_PLATFORM_ARG[@]}; do
   for ARCH in ${OS_ARCH_ARG[@]}; do
     echo "Building binary for $OS/$ARCH..."
-    GOARCH=$ARCH GOOS=$OS CGO_ENABLED=0 go build -ldflags "-s -w" -ldflags "-X main.version=${VERSION}" -o "dist/kandalf_$OS-$ARCH" $PKG_SRC
+    GOARCH=$ARCH GOOS=$OS CGO_ENABLED=0 go build -ldflags "${LDFLAGS}" -o "dist/kandalf_$OS-$ARCH" $PKG_SRC
   done
 done
 
@@ -27,9 +41,9 @@ OS_ARCH_ARG=(arm arm64)
 for OS in ${OS_PLATFORM_ARG[@]}; do
   for ARCH in ${OS_ARCH_ARG[@]}; do
     echo "Building binary for $OS/$ARCH..."
-    GOARCH=$ARCH GOOS=$OS CGO_ENABLED=0 go build -ldflags "-s -w" -ldflags "-X main.version=${VERSION}" -o "dist/kandalf_$OS-$ARCH" $PKG_SRC
+    GOARCH=$ARCH GOOS=$OS CGO_ENABLED=0 go build -ldflags "${LDFLAGS}" -o "dist/kandalf_$OS-$ARCH" $PKG_SRC
   done
 done
 
 echo "Building default binary"
-GOARCH=$ARCH GOOS=$OS CGO_ENABLED=0 go build -ldflags "-s -w" -ldflags "-X main.version=${VERSION}" -o "dist/kandalf" $PKG_SRC
+GOARCH=$ARCH GOOS=$OS CGO_ENABLED=0 go build -ldflags "${LDFLAGS}" -o "dist/kandalf" $PKG_SRC
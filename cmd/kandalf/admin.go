@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/metrics"
+	"github.com/hellofresh/kandalf/pkg/workers"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// adminShutdownTimeout is max amount of time to wait for admin HTTP server requests to complete on shutdown
+const adminShutdownTimeout = 5 * time.Second
+
+var (
+	errNotServing          = errors.New("application is starting or shutting down")
+	errAMQPDisconnected    = errors.New("AMQP connection is closed, reconnecting")
+	errNoReplicationLeader = errors.New("replication cluster has no leader")
+)
+
+// readinessCheck is named check of application component, it returns error while component is not ready
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// readiness is "/readyz" endpoint handler, application is ready when it is serving and all components checks pass
+type readiness struct {
+	sync.RWMutex
+
+	serving bool
+	checks  []readinessCheck
+}
+
+// add registers component check, checks are added as components are initialised
+func (r *readiness) add(name string, check func() error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.checks = append(r.checks, readinessCheck{name: name, check: check})
+}
+
+// setServing marks application as serving once it is initialised and as not serving once it is shutting down,
+// so load balancers stop sending traffic before it exits
+func (r *readiness) setServing(serving bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.serving = serving
+}
+
+// ServeHTTP responds with results of all checks, status is 503 if application is not serving or any check fails
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.RLock()
+	serving, checks := r.serving, r.checks
+	r.RUnlock()
+
+	status, body := http.StatusOK, "app: ok\n"
+	if !serving {
+		status, body = http.StatusServiceUnavailable, fmt.Sprintf("app: %s\n", errNotServing)
+	}
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			status = http.StatusServiceUnavailable
+			body += fmt.Sprintf("%s: %s\n", c.name, err)
+		} else {
+			body += fmt.Sprintf("%s: ok\n", c.name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, body)
+}
+
+// statusSection is named section of application status, report returns JSON serialisable component status
+type statusSection struct {
+	name   string
+	report func() (interface{}, error)
+}
+
+// status is "/status" endpoint handler responding with runtime status of application components in JSON
+type status struct {
+	sync.RWMutex
+
+	nodeID    string
+	startedAt time.Time
+	sections  []statusSection
+}
+
+func newStatus(nodeID string) *status {
+	return &status{nodeID: nodeID, startedAt: time.Now()}
+}
+
+// add registers component status section, sections are added as components are initialised
+func (s *status) add(name string, report func() (interface{}, error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.sections = append(s.sections, statusSection{name: name, report: report})
+}
+
+// report returns status of all the sections mapped by section name, section that fails to report its status gets
+// "error" field instead
+func (s *status) report() map[string]interface{} {
+	s.RLock()
+	sections := s.sections
+	s.RUnlock()
+
+	body := map[string]interface{}{
+		"version":   version,
+		"build":     currentBuild(),
+		"node":      s.nodeID,
+		"startedAt": s.startedAt,
+	}
+	for _, section := range sections {
+		report, err := section.report()
+		if err != nil {
+			report = map[string]string{"error": err.Error()}
+		}
+		body[section.name] = report
+	}
+
+	return body
+}
+
+// ServeHTTP responds with status of all the sections
+func (s *status) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.report()); err != nil {
+		log.WithError(err).Error("Failed to write status response")
+	}
+}
+
+// connectionStatus is status of AMQP connection
+type connectionStatus struct {
+	DSN       string `json:"dsn"`
+	Connected bool   `json:"connected"`
+	Blocked   bool   `json:"blocked"`
+	// Backlog are numbers of messages ready for delivery in pipes queues mapped by queue name
+	Backlog map[string]int `json:"backlog"`
+}
+
+// redactDSN returns DSN with password masked, so it can be exposed in status
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return ""
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	return u.String()
+}
+
+// messageSampling is "/debug/messages" endpoint handler, GET responds with sample ratios of sampled pipes mapped
+// by pipe key in JSON, POST with "pipe" key and "ratio" form values changes pipe sampling, 0 ratio stops it
+type messageSampling struct {
+	sync.RWMutex
+
+	worker *workers.BridgeWorker
+}
+
+// setWorker sets worker pipes are sampled by once it is initialised
+func (s *messageSampling) setWorker(worker *workers.BridgeWorker) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.worker = worker
+}
+
+func (s *messageSampling) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.RLock()
+	worker := s.worker
+	s.RUnlock()
+
+	if worker == nil {
+		http.Error(w, errNotServing.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		ratio, err := strconv.ParseFloat(req.FormValue("ratio"), 64)
+		if err != nil {
+			http.Error(w, "ratio must be a number", http.StatusBadRequest)
+			return
+		}
+		if err := worker.SampleMessages(req.FormValue("pipe"), ratio); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(worker.SampledPipes()); err != nil {
+		log.WithError(err).Error("Failed to write sampled pipes response")
+	}
+}
+
+// pipesSettings is "/pipes" endpoint handler responding with effective settings of running pipes in JSON
+type pipesSettings struct {
+	sync.RWMutex
+
+	list func() ([]config.EffectivePipe, error)
+}
+
+// setList sets function listing running pipes settings once pipes are consumed
+func (s *pipesSettings) setList(list func() ([]config.EffectivePipe, error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.list = list
+}
+
+func (s *pipesSettings) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.RLock()
+	list := s.list
+	s.RUnlock()
+
+	if list == nil {
+		http.Error(w, errNotServing.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	pipes, err := list()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(pipes); err != nil {
+		log.WithError(err).Error("Failed to write pipes response")
+	}
+}
+
+// loopbackOnly wraps handler, so it responds with 403 to clients connected not from loopback address,
+// e.g. profiles are taken from production instances with port forwarding only
+func loopbackOnly(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// startAdminServer starts admin HTTP server in background. "/healthz" responds with 200 as long as process is alive,
+// as server is started once configuration is loaded, "/readyz" runs readiness checks, "/status" reports runtime status
+// rendered by "/dashboard" page, "/pipes" lists effective settings of running pipes and "/metrics" endpoint is served only with Prometheus stats client, as other clients push metrics instead
+// of collecting them. Profiling endpoints are served under "/debug/pprof/" and messages sampling under "/debug/messages"
+// when they are enabled.
+func startAdminServer(adminConfig config.AdminConfig, statsClient client.Client, ready *readiness, appStatus *status, sampling *messageSampling, pipes *pipesSettings) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/readyz", ready)
+	mux.Handle("/status", appStatus)
+	mux.Handle("/pipes", pipes)
+	mux.HandleFunc("/dashboard", dashboard)
+	if adminConfig.PprofEnabled {
+		mux.Handle("/debug/pprof/", loopbackOnly(http.HandlerFunc(pprof.Index)))
+		mux.Handle("/debug/pprof/cmdline", loopbackOnly(http.HandlerFunc(pprof.Cmdline)))
+		mux.Handle("/debug/pprof/profile", loopbackOnly(http.HandlerFunc(pprof.Profile)))
+		mux.Handle("/debug/pprof/symbol", loopbackOnly(http.HandlerFunc(pprof.Symbol)))
+		mux.Handle("/debug/pprof/trace", loopbackOnly(http.HandlerFunc(pprof.Trace)))
+	}
+	if adminConfig.SamplingEnabled {
+		mux.Handle("/debug/messages", loopbackOnly(sampling))
+	}
+	if prometheusClient, ok := statsClient.(*metrics.Prometheus); ok {
+		mux.Handle("/metrics", prometheusClient.Handler())
+	} else {
+		log.Warning("Stats client is not Prometheus one, admin server does not expose metrics")
+	}
+
+	server := &http.Server{Addr: adminConfig.ListenAddress, Handler: mux}
+	go func() {
+		log.WithField("address", adminConfig.ListenAddress).Info("Starting admin HTTP server")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("Admin HTTP server failed")
+		}
+	}()
+
+	return server
+}
+
+// stopAdminServer gracefully shuts admin HTTP server down
+func stopAdminServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithError(err).Error("Got error on stopping admin HTTP server")
+	}
+}
@@ -1,16 +1,36 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"syscall"
 
+	"github.com/hellofresh/kandalf/pkg/alerts"
 	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/amqp10"
 	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/dedup"
+	"github.com/hellofresh/kandalf/pkg/jetstream"
+	"github.com/hellofresh/kandalf/pkg/kinesis"
+	"github.com/hellofresh/kandalf/pkg/metrics"
+	"github.com/hellofresh/kandalf/pkg/mqtt"
+	"github.com/hellofresh/kandalf/pkg/pidfile"
+	"github.com/hellofresh/kandalf/pkg/plugins"
 	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/kandalf/pkg/pulsar"
+	"github.com/hellofresh/kandalf/pkg/replication"
+	"github.com/hellofresh/kandalf/pkg/reverse"
+	"github.com/hellofresh/kandalf/pkg/schema"
 	"github.com/hellofresh/kandalf/pkg/storage"
+	"github.com/hellofresh/kandalf/pkg/systemd"
+	"github.com/hellofresh/kandalf/pkg/tracing"
 	"github.com/hellofresh/kandalf/pkg/workers"
-	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/logging-go"
 	"github.com/hellofresh/stats-go/bucket"
 	"github.com/hellofresh/stats-go/client"
 	"github.com/hellofresh/stats-go/hooks"
@@ -19,27 +39,104 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const statsReplicationSection = "replication"
+
+var errDryRunDiscovery = errors.New("discovered queues can not be consumed in dry run mode, disable queues discovery")
+
 // RunApp is main application bootstrap and runner
 func RunApp(cmd *cobra.Command, args []string) {
-	log.WithField("version", version).Info("Kandalf starting...")
+	if runServiceName != "" {
+		err := runService(runServiceName, func() { runBridge(cmd) })
+		failOnError(err, "Failed to run as Windows service")
+		return
+	}
+
+	runBridge(cmd)
+}
+
+// runBridge loads configuration and runs the bridge until it is asked to stop
+func runBridge(cmd *cobra.Command) {
+	log.WithFields(currentBuild().fields()).Info("Kandalf starting...")
 
 	globalConfig, err := config.Load(configPath)
 	failOnError(err, "Failed to load application configuration")
 
+	if backgroundFlag {
+		if foregroundFlag {
+			failOnError(errBackgroundForeground, "Invalid run mode")
+		}
+		if pidFilePath == "" {
+			failOnError(errBackgroundPidFile, "Invalid run mode")
+		}
+		pid, err := startInBackground(pidFilePath)
+		failOnError(err, "Failed to start in background")
+		fmt.Fprintf(cmd.OutOrStdout(), "Kandalf started in background, pid %d\n", pid)
+		return
+	}
+	if foregroundFlag {
+		// supervisors collect process output, so logs go to stdout regardless of configured writer
+		globalConfig.Log.Writer = logging.StdOut
+	}
+
 	err = globalConfig.Log.Apply()
 	failOnError(err, "Failed to configure logger")
 	defer globalConfig.Log.Flush()
 
-	statsClient := initStatsClient(globalConfig.Stats)
+	if pidFilePath != "" {
+		pidFile, err := pidfile.Acquire(pidFilePath)
+		failOnError(err, "Failed to write pid file")
+		defer func() {
+			if err := pidFile.Release(); err != nil {
+				log.WithError(err).Error("Got error on removing pid file")
+			}
+		}()
+	}
+
+	// envelopes, stats tags and spans carry instance id, so messages and metrics can be traced back to the instance
+	if globalConfig.Worker.NodeID == "" {
+		globalConfig.Worker.NodeID = globalConfig.Replication.NodeID
+	}
+	if globalConfig.Worker.NodeID == "" {
+		globalConfig.Worker.NodeID, _ = os.Hostname()
+	}
+	if globalConfig.Kafka.TransactionalIDPrefix == "" {
+		globalConfig.Kafka.TransactionalIDPrefix = "kandalf-" + globalConfig.Worker.NodeID + "-"
+	}
+
+	statsClient := initStatsClient(globalConfig.Stats, globalConfig.Worker.NodeID)
 	defer func() {
 		if err := statsClient.Close(); err != nil {
 			log.WithError(err).Error("Got error on closing stats client")
 		}
 	}()
 
+	shutdownTracing, err := tracing.Init(globalConfig.Tracing, globalConfig.Worker.NodeID)
+	failOnError(err, "Failed to init tracing")
+	// spans of messages drained on shutdown are exported as well
+	defer shutdownTracing()
+
+	ready := &readiness{}
+	appStatus := newStatus(globalConfig.Worker.NodeID)
+	go watchStateDump(appStatus)
+	sampling := &messageSampling{}
+	settings := &pipesSettings{}
+	if globalConfig.Admin.ListenAddress != "" {
+		adminServer := startAdminServer(globalConfig.Admin, statsClient, ready, appStatus, sampling, settings)
+		defer stopAdminServer(adminServer)
+	}
+
 	pipesList, err := config.LoadPipesFromFile(globalConfig.Kafka.PipesConfig)
 	failOnError(err, "Failed to load pipes config")
 
+	if dryRunFlag {
+		log.Warning("Running in dry run mode, messages are logged instead of being published to Kafka")
+		if globalConfig.RabbitMQ.Discovery.Enabled {
+			failOnError(errDryRunDiscovery, "Failed to run in dry run mode")
+		}
+		pipesList, err = dryRunPipes(pipesList)
+		failOnError(err, "Failed to run pipes in dry run mode")
+	}
+
 	storageURL, err := url.Parse(globalConfig.StorageDSN)
 	failOnError(err, "Failed to parse Storage DSN")
 
@@ -47,7 +144,19 @@ func RunApp(cmd *cobra.Command, args []string) {
 	failOnError(err, "Failed to establish Redis connection")
 	// Do not close storage here as it is required in Worker close to store unhandled messages
 
-	kafkaProducer, err := producer.NewKafkaProducer(globalConfig.Kafka, statsClient)
+	for _, pipe := range pipesList {
+		_, err = globalConfig.Kafka.ForCluster(pipe.KafkaCluster)
+		failOnError(err, "Failed to find Kafka cluster for pipe "+pipe.String())
+	}
+	err = globalConfig.ValidatePrefetch(pipesList)
+	failOnError(err, "Invalid RabbitMQ prefetch count")
+	err = plugins.Validate(pipesList)
+	failOnError(err, "Failed to load pipes plugins")
+
+	err = producer.CreateTopics(globalConfig.Kafka, pipesList)
+	failOnError(err, "Failed to create Kafka topics")
+
+	kafkaProducer, err := producer.NewKafkaRouter(globalConfig.Kafka, pipesList, statsClient)
 	failOnError(err, "Failed to establish Kafka connection")
 	defer func() {
 		if err := kafkaProducer.Close(); err != nil {
@@ -55,31 +164,388 @@ func RunApp(cmd *cobra.Command, args []string) {
 		}
 	}()
 
-	worker, err := workers.NewBridgeWorker(globalConfig.Worker, persistentStorage, kafkaProducer, statsClient)
+	if hasSinkPipes(pipesList, config.SinkNATS) {
+		natsProducer, err := jetstream.NewProducer(globalConfig.NATS, statsClient)
+		failOnError(err, "Failed to establish NATS connection")
+		// NATS connection is closed together with Kafka producers
+		kafkaProducer.AddSink(config.SinkNATS, natsProducer)
+	}
+	if hasSinkPipes(pipesList, config.SinkKinesis) {
+		kinesisProducer, err := kinesis.NewProducer(globalConfig.Kinesis, statsClient)
+		failOnError(err, "Failed to init Kinesis client")
+		kafkaProducer.AddSink(config.SinkKinesis, kinesisProducer)
+	}
+	if hasSinkPipes(pipesList, config.SinkPulsar) {
+		pulsarProducer, err := pulsar.NewProducer(globalConfig.Pulsar, statsClient)
+		failOnError(err, "Failed to init Pulsar client")
+		kafkaProducer.AddSink(config.SinkPulsar, pulsarProducer)
+	}
+
+	files := schema.NewFileEncoder()
+	err = files.Load(pipesList)
+	failOnError(err, "Failed to load pipes schema files")
+
+	var registry *schema.Registry
+	if hasRegistrySchemaPipes(pipesList) {
+		registry, err = schema.NewRegistry(globalConfig.Kafka.SchemaRegistry, statsClient)
+		failOnError(err, "Failed to init Schema Registry client")
+
+		err = registry.Load(pipesList)
+		failOnError(err, "Failed to load pipes schemas from Schema Registry")
+	}
+	encoder := schema.NewEncoder(registry, files)
+
+	var buffer storage.Buffer
+	if globalConfig.Worker.BufferDir != "" {
+		buffer, err = storage.NewWALBuffer(globalConfig.Worker.BufferDir)
+		failOnError(err, "Failed to open disk buffer")
+		// Do not close buffer here as it is required in Worker close to remove stored messages
+	}
+
+	var (
+		replica          storage.Buffer
+		replicatedBuffer *replication.Buffer
+		leaderCh         <-chan bool
+	)
+	if hasReplicatedPipes(pipesList) {
+		dedupWindow := dedup.NewWindow(globalConfig.Worker.DedupWindow, globalConfig.Worker.DedupMaxKeys)
+		replicatedBuffer, err = replication.NewBuffer(globalConfig.Replication, dedupWindow)
+		failOnError(err, "Failed to join replication cluster")
+		// Do not close replica here as it is required in Worker close to remove stored messages
+		replica, leaderCh = replicatedBuffer, replicatedBuffer.LeaderCh()
+		ready.add("replication", func() error {
+			if !replicatedBuffer.HasLeader() {
+				return errNoReplicationLeader
+			}
+			return nil
+		})
+		appStatus.add("replication", func() (interface{}, error) {
+			return replicatedBuffer.Status()
+		})
+		appStatus.add("raft", func() (interface{}, error) {
+			return replicatedBuffer.Stats(), nil
+		})
+	}
+
+	worker, err := workers.NewBridgeWorker(globalConfig.Worker, persistentStorage, buffer, replica, kafkaProducer, encoder, statsClient)
+	failOnError(err, "Failed to recover messages from disk buffer")
+	ready.add("worker", worker.Ready)
+	appStatus.add("worker", func() (interface{}, error) {
+		return worker.Status(), nil
+	})
+	sampling.setWorker(worker)
+	// alerter interface stays nil if alerts are not configured
+	var alerter alerts.Alerter
+	if notifier := alerts.NewNotifier(globalConfig.Alerts, globalConfig.Worker.NodeID); notifier != nil {
+		alerter = notifier
+		worker.SetAlerter(alerter)
+	}
 	defer func() {
 		if err := worker.Close(); err != nil {
 			log.WithError(err).Error("Got error on closing persistent storage")
 		}
 	}()
 
-	queuesHandler := amqp.NewQueuesHandler(pipesList, worker.MessageHandler, statsClient)
-	amqpConnection, err := amqp.NewConnection(globalConfig.RabbitDSN, queuesHandler)
-	failOnError(err, "Failed to establish initial connection to AMQP")
-	defer func() {
-		if err := amqpConnection.Close(); err != nil {
-			log.WithError(err).Error("Got error on closing AMQP connection")
+	err = worker.LoadPlugins(pipesList)
+	failOnError(err, "Failed to load pipes plugins")
+	// pipes get their state up front, so status lists pipes that are not consumed yet
+	worker.UpdatePausedPipes(pipesList)
+
+	rabbitPipes, amqp10Pipes, mqttPipes := splitPipesByProtocol(pipesList)
+	if len(amqp10Pipes) > 0 {
+		amqp10Consumer, err := amqp10.NewConsumer(globalConfig.AMQP10DSN, amqp10Pipes, worker.MessageHandler, statsClient)
+		failOnError(err, "Failed to establish initial connection to AMQP 1.0")
+		defer func() {
+			if err := amqp10Consumer.Close(); err != nil {
+				log.WithError(err).Error("Got error on closing AMQP 1.0 connection")
+			}
+		}()
+	}
+	if len(mqttPipes) > 0 {
+		mqttConsumer, err := mqtt.NewConsumer(globalConfig.MQTTDSN, globalConfig.MQTT, mqttPipes, worker.MessageHandler, statsClient)
+		failOnError(err, "Failed to establish initial connection to MQTT")
+		defer func() {
+			if err := mqttConsumer.Close(); err != nil {
+				log.WithError(err).Error("Got error on closing MQTT connections")
+			}
+		}()
+	}
+
+	reversePipes, err := config.LoadReversePipesFromFile(globalConfig.Kafka.PipesConfig)
+	failOnError(err, "Failed to load reverse pipes config")
+	if dryRunFlag && len(reversePipes) > 0 {
+		log.Warning("Reverse pipes are not started in dry run mode")
+		reversePipes = nil
+	}
+	if len(reversePipes) > 0 {
+		reverseBridge, err := reverse.NewBridge(globalConfig.RabbitDSN, globalConfig.RabbitMQ, globalConfig.Kafka, reversePipes, statsClient)
+		failOnError(err, "Failed to start reverse pipes")
+		defer func() {
+			if err := reverseBridge.Close(); err != nil {
+				log.WithError(err).Error("Got error on closing reverse pipes")
+			}
+		}()
+	}
+
+	queuesHandlers, err := newQueuesHandlers(globalConfig.RabbitDSN, globalConfig.RabbitMQ, rabbitPipes, worker.MessageHandler, statsClient)
+	failOnError(err, "Failed to build AMQP connections list")
+
+	var (
+		discovery              *amqp.Discovery
+		discoveryQueuesHandler *amqp.QueuesHandler
+	)
+	if globalConfig.RabbitMQ.Discovery.Enabled {
+		discovery, err = amqp.NewDiscovery(globalConfig.RabbitMQ.Discovery, statsClient)
+		failOnError(err, "Failed to init queues discovery")
+
+		discoveredPipes, err := discovery.Discover()
+		failOnError(err, "Failed to discover queues")
+
+		discoveryDSN, err := amqp.PipeDSN(globalConfig.RabbitDSN, config.Pipe{RabbitVHost: globalConfig.RabbitMQ.Discovery.VHost})
+		failOnError(err, "Failed to parse RabbitMQ DSN")
+
+		discoveryQueuesHandler = queuesHandlers[discoveryDSN]
+		if discoveryQueuesHandler == nil {
+			discoveryQueuesHandler = amqp.NewQueuesHandler(nil, globalConfig.RabbitMQ, worker.MessageHandler, statsClient)
+			queuesHandlers[discoveryDSN] = discoveryQueuesHandler
 		}
-	}()
+		discoveryQueuesHandler.AddPipes(discoveredPipes)
+	}
+
+	if leaderCh != nil {
+		go watchLeadership(worker, queuesHandlers, leaderCh, statsClient, alerter)
+	}
+
+	// AMQP 1.0 and MQTT pipes are not reloaded, so they are the same for the whole run
+	staticPipes := append(append([]config.Pipe{}, amqp10Pipes...), mqttPipes...)
+	settings.setList(func() ([]config.EffectivePipe, error) {
+		return effectivePipes(globalConfig.Kafka, runningPipes(queuesHandlers, staticPipes))
+	})
+
+	reloadHandlers := make(map[string]pipesHandler, len(queuesHandlers))
+	for dsn, queuesHandler := range queuesHandlers {
+		reloadHandlers[dsn] = queuesHandler
+	}
+	reloader := &pipesReloader{
+		path:           globalConfig.Kafka.PipesConfig,
+		pipes:          pipesList,
+		reversePipes:   reversePipes,
+		worker:         worker,
+		rabbitDSN:      globalConfig.RabbitDSN,
+		queuesHandlers: reloadHandlers,
+		kafkaConfig:    globalConfig.Kafka,
+		globalConfig:   globalConfig,
+		router:         kafkaProducer,
+		registry:       registry,
+		files:          files,
+		replicated:     replica != nil,
+		dryRun:         dryRunFlag,
+	}
+	go reloader.watch()
+
+	amqpConnections := make(map[string]*amqp.Connection, len(queuesHandlers))
+	for dsn, queuesHandler := range queuesHandlers {
+		amqpConnection, err := amqp.NewConnection(dsn, globalConfig.RabbitMQ, queuesHandler.Init, statsClient)
+		failOnError(err, "Failed to establish initial connection to AMQP")
+		dsn, queuesHandler := dsn, queuesHandler
+		amqpConnection.NotifyBlocked(func(blocked bool) {
+			queuesHandler.SetBlocked(blocked)
+			worker.SetConnectionBlocked(dsn, blocked)
+		})
+		defer func() {
+			if err := amqpConnection.Close(); err != nil {
+				log.WithError(err).Error("Got error on closing AMQP connection")
+			}
+		}()
+		ready.add("rabbitmq", func() error {
+			if !amqpConnection.IsConnected() {
+				return errAMQPDisconnected
+			}
+			return nil
+		})
+		amqpConnections[dsn] = amqpConnection
+	}
+	appStatus.add("rabbitmq", func() (interface{}, error) {
+		connections := make([]connectionStatus, 0, len(amqpConnections))
+		for dsn, amqpConnection := range amqpConnections {
+			connections = append(connections, connectionStatus{
+				DSN:       redactDSN(dsn),
+				Connected: amqpConnection.IsConnected(),
+				Blocked:   amqpConnection.IsBlocked(),
+				Backlog:   queuesHandlers[dsn].Backlog(),
+			})
+		}
+		sort.Slice(connections, func(i, j int) bool { return connections[i].DSN < connections[j].DSN })
+		return connections, nil
+	})
 
 	forever := make(chan bool)
 
 	worker.Go(forever)
+	if discovery != nil {
+		discovery.Go(discoveryQueuesHandler, forever)
+	}
+	if globalConfig.RabbitMQ.BacklogInterval > 0 {
+		for _, queuesHandler := range queuesHandlers {
+			queuesHandler.GoReportBacklog(globalConfig.RabbitMQ.BacklogInterval, forever)
+		}
+	}
+
+	if watchdogTimeout := systemd.WatchdogInterval(); watchdogTimeout > 0 {
+		log.WithField("timeout", watchdogTimeout.String()).Info("Starting systemd watchdog")
+		systemd.GoWatchdog(watchdogTimeout, func() error { return worker.Alive(watchdogTimeout) }, forever)
+	}
 
 	log.Infof("[*] Waiting for users. To exit press CTRL+C")
-	<-forever
+	ready.setServing(true)
+	systemd.NotifyState(systemd.Ready)
+	waitForShutdown()
+	systemd.NotifyState(systemd.Stopping)
+	ready.setServing(false)
+
+	exitCode = shutdown(globalConfig.Shutdown, worker, replicatedBuffer, queuesHandlers, forever)
+}
+
+// waitForShutdown blocks until application is asked to stop with SIGINT, SIGTERM or Windows service stop
+func waitForShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case sig := <-signals:
+		log.WithField("signal", sig.String()).Info("Shutting down Kandalf")
+	case <-stopRequests:
+		log.Info("Shutting down Kandalf, service stop is requested")
+	}
+	signal.Stop(signals)
+}
+
+func splitPipesByProtocol(pipes []config.Pipe) (rabbitPipes []config.Pipe, amqp10Pipes []config.Pipe, mqttPipes []config.Pipe) {
+	for _, pipe := range pipes {
+		switch pipe.Protocol {
+		case config.ProtocolAMQP10:
+			amqp10Pipes = append(amqp10Pipes, pipe)
+		case config.ProtocolMQTT:
+			mqttPipes = append(mqttPipes, pipe)
+		default:
+			rabbitPipes = append(rabbitPipes, pipe)
+		}
+	}
+
+	return rabbitPipes, amqp10Pipes, mqttPipes
+}
+
+func hasRegistrySchemaPipes(pipes []config.Pipe) bool {
+	for _, pipe := range pipes {
+		if pipe.KafkaSchema != nil && pipe.KafkaSchema.File == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasSinkPipes(pipes []config.Pipe, sink string) bool {
+	for _, pipe := range pipes {
+		if pipe.Sink == sink {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasReplicatedPipes(pipes []config.Pipe) bool {
+	for _, pipe := range pipes {
+		if pipe.Replicated {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dryRunPipes returns copies of pipes in dry run mode, pipes that can not run in dry run mode fail validation
+func dryRunPipes(pipes []config.Pipe) ([]config.Pipe, error) {
+	dryRun := make([]config.Pipe, 0, len(pipes))
+	for _, pipe := range pipes {
+		pipe.DryRun = true
+		if err := pipe.Validate(); err != nil {
+			log.WithError(err).WithField("pipe", pipe.String()).Error("Invalid dry run pipe configuration")
+			return nil, err
+		}
+		dryRun = append(dryRun, pipe)
+	}
+
+	return dryRun, nil
+}
+
+// watchLeadership replays messages replicated by the previous leader and starts replicated pipes consumers when
+// instance becomes replication leader, and stops the consumers and releases cached messages to the new leader when
+// it loses leadership, leadership is exposed as "replication.leader" state metric and its changes are alerted
+// if alerter is not nil
+func watchLeadership(worker *workers.BridgeWorker, queuesHandlers map[string]*amqp.QueuesHandler, leaderCh <-chan bool, statsClient client.Client, alerter alerts.Alerter) {
+	statsClient.TrackState(statsReplicationSection, bucket.MetricOperation{"leader"}, 0)
+	for leader := range leaderCh {
+		if !leader {
+			statsClient.TrackState(statsReplicationSection, bucket.MetricOperation{"leader"}, 0)
+			statsClient.TrackMetric(statsReplicationSection, bucket.MetricOperation{"leadership", "lost"})
+			log.Warning("Lost replication leadership, replicated pipes consumers are stopped")
+			if alerter != nil {
+				alerter.Alert(alerts.Event{
+					Type:     alerts.EventLeadershipLost,
+					Severity: alerts.SeverityWarning,
+					Summary:  "Lost replication leadership, replicated pipes consumers are stopped",
+				})
+			}
+			// replicated pipes are consumed by the new leader, so are its cached replicated messages published
+			for _, queuesHandler := range queuesHandlers {
+				queuesHandler.SetLeader(false)
+			}
+			worker.ReleaseReplica()
+			continue
+		}
+
+		statsClient.TrackState(statsReplicationSection, bucket.MetricOperation{"leader"}, 1)
+		statsClient.TrackMetric(statsReplicationSection, bucket.MetricOperation{"leadership", "acquired"})
+		log.Info("Became replication leader, recovering replicated messages")
+		if alerter != nil {
+			alerter.Alert(alerts.Event{
+				Type:     alerts.EventLeadershipAcquired,
+				Severity: alerts.SeverityInfo,
+				Summary:  "Became replication leader, replicated messages are recovered",
+			})
+		}
+		if err := worker.RecoverReplica(); err != nil {
+			log.WithError(err).Error("Failed to recover messages from replica")
+		}
+		for _, queuesHandler := range queuesHandlers {
+			queuesHandler.SetLeader(true)
+		}
+	}
+}
+
+// newQueuesHandlers groups pipes by AMQP connection they require, as pipes may be consumed from different
+// virtual hosts and with different credentials, and creates queues handler for every connection
+func newQueuesHandlers(dsn string, rabbitConfig config.RabbitMQConfig, pipes []config.Pipe, handler amqp.MessageHandler, statsClient client.Client) (map[string]*amqp.QueuesHandler, error) {
+	pipesByDSN := make(map[string][]config.Pipe)
+	for _, pipe := range pipes {
+		pipeDSN, err := amqp.PipeDSN(dsn, pipe)
+		if err != nil {
+			return nil, err
+		}
+		pipesByDSN[pipeDSN] = append(pipesByDSN[pipeDSN], pipe)
+	}
+
+	queuesHandlers := make(map[string]*amqp.QueuesHandler, len(pipesByDSN))
+	for pipeDSN, dsnPipes := range pipesByDSN {
+		queuesHandlers[pipeDSN] = amqp.NewQueuesHandler(dsnPipes, rabbitConfig, handler, statsClient)
+	}
+
+	return queuesHandlers, nil
 }
 
-func initStatsClient(config config.StatsConfig) client.Client {
+func initStatsClient(config config.StatsConfig, nodeID string) client.Client {
 	statsLogger.SetHandler(func(msg string, fields map[string]interface{}, err error) {
 		entry := log.WithFields(log.Fields(fields))
 		if err == nil {
@@ -89,7 +555,7 @@ func initStatsClient(config config.StatsConfig) client.Client {
 		}
 	})
 
-	statsClient, err := stats.NewClient(config.DSN)
+	statsClient, err := metrics.NewClient(config, nodeID)
 	failOnError(err, "Failed to init stats client!")
 
 	log.AddHook(hooks.NewLogrusHook(statsClient, config.ErrorsSection))
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/pidfile"
+)
+
+// backgroundStartTimeout is max amount of time to wait for background process to write its pid file
+const backgroundStartTimeout = 30 * time.Second
+
+var (
+	errBackgroundPidFile    = errors.New("background mode requires pid file, use --pidfile flag")
+	errBackgroundForeground = errors.New("background and foreground modes are mutually exclusive")
+	errBackgroundTimeout    = errors.New("background process did not write pid file in time")
+)
+
+// startInBackground starts detached copy of the current process without background flag and waits until it writes
+// pid file, so background process that fails to start is reported by the starting one
+func startInBackground(pidPath string) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([]string, 0, len(os.Args)-1)
+	for _, arg := range os.Args[1:] {
+		if arg != "--background" && !strings.HasPrefix(arg, "--background=") {
+			args = append(args, arg)
+		}
+	}
+
+	// background process has no terminal, so it logs to configured hooks only
+	cmd := exec.Command(executable, args...)
+	cmd.SysProcAttr = detachedProcess()
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(backgroundStartTimeout)
+	for {
+		select {
+		case err := <-exited:
+			return 0, fmt.Errorf("background process exited: %v", err)
+		case <-timeout:
+			return 0, errBackgroundTimeout
+		case <-ticker.C:
+			if pid, err := pidfile.Read(pidPath); err == nil && pid == cmd.Process.Pid {
+				return pid, nil
+			}
+		}
+	}
+}
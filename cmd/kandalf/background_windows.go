@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "syscall"
+
+// detachedProcess returns default process attributes on windows, process keeps running once its parent exits
+func detachedProcess() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/kandalf/pkg/schema"
+	"github.com/hellofresh/kandalf/pkg/storage"
+	"github.com/hellofresh/kandalf/pkg/workers"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	rabbitmq "github.com/streadway/amqp"
+)
+
+const (
+	// benchSentHeader is AMQP header of synthetic message with its generation time in nanoseconds,
+	// AMQP timestamp property has seconds precision only
+	benchSentHeader = "x-kandalf-bench-sent"
+	// benchExchange, benchRoutingKey and benchQueue are bench pipe exchange, routing key and queue, bench never
+	// consumes from or publishes to queues and exchanges of configured pipes
+	benchExchange   = "kandalf-bench"
+	benchRoutingKey = "bench"
+	benchQueue      = "kandalf-bench"
+	// benchRequeueDelay is delay before message that failed to be published is handled again
+	benchRequeueDelay = 100 * time.Millisecond
+)
+
+var (
+	benchMessages int
+	benchSize     int
+	benchInFlight int
+	benchTopic    string
+	benchPipe     string
+	benchRabbit   bool
+	benchTimeout  time.Duration
+
+	errBenchPipeNotFound = errors.New("pipe is not found in pipes configuration")
+	errBenchSettings     = errors.New("number of messages, message size and in-flight messages must be positive")
+	errBenchTimeout      = errors.New("not all the messages were published before timeout, results are partial")
+)
+
+// memoryStorage is persistent storage worker puts messages it failed to publish to, bench keeps them in memory,
+// so nothing is left behind in configured storage
+type memoryStorage struct {
+	sync.Mutex
+	data [][]byte
+}
+
+func (s *memoryStorage) Put(data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.data = append(s.data, data)
+	return nil
+}
+
+func (s *memoryStorage) Get() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.data) == 0 {
+		return nil, storage.ErrStorageIsEmpty
+	}
+	data := s.data[0]
+	s.data = s.data[1:]
+	return data, nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
+
+// benchmark tracks synthetic messages from their generation until they are published to Kafka, number of messages
+// being handled at once is limited by in-flight window
+type benchmark struct {
+	sync.Mutex
+
+	total    int
+	inFlight chan struct{}
+	done     chan struct{}
+
+	latencies []time.Duration
+	rejected  int
+	requeued  int
+	completed int
+}
+
+func newBenchmark(total int, inFlight int) *benchmark {
+	return &benchmark{
+		total:     total,
+		inFlight:  make(chan struct{}, inFlight),
+		done:      make(chan struct{}),
+		latencies: make([]time.Duration, 0, total),
+	}
+}
+
+// acquire blocks until message fits in-flight window
+func (b *benchmark) acquire() {
+	b.inFlight <- struct{}{}
+}
+
+// settle records message handling result, it returns true if message must be handled again, the same way broker
+// redelivers requeued message
+func (b *benchmark) settle(sent time.Time, err error) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if err != nil && err != amqp.ErrRejectMessage {
+		b.requeued++
+		return true
+	}
+
+	if err == nil {
+		b.latencies = append(b.latencies, time.Since(sent))
+	} else {
+		b.rejected++
+	}
+	b.completed++
+	<-b.inFlight
+	if b.completed == b.total {
+		close(b.done)
+	}
+
+	return false
+}
+
+// handler wraps message handler, so every message result is recorded once it is published, messages that must be
+// handled again are passed to requeue
+func (b *benchmark) handler(handler amqp.MessageHandler, requeue func(delivery amqp.Delivery)) amqp.MessageHandler {
+	return func(delivery amqp.Delivery, pipe config.Pipe) error {
+		sent := benchSentAt(delivery)
+		redelivery := delivery
+		settle := delivery.Settle
+		delivery.Settle = func(err error) {
+			if b.settle(sent, err) {
+				requeue(redelivery)
+			}
+			if settle != nil {
+				settle(err)
+			}
+		}
+
+		err := handler(delivery, pipe)
+		if err != amqp.ErrAckDeferred && b.settle(sent, err) {
+			requeue(redelivery)
+		}
+
+		return err
+	}
+}
+
+// wait blocks until all the messages are published or timeout expires
+func (b *benchmark) wait(timeout time.Duration) error {
+	select {
+	case <-b.done:
+		return nil
+	case <-time.After(timeout):
+		return errBenchTimeout
+	}
+}
+
+// report prints throughput and latency percentiles of published messages
+func (b *benchmark) report(w *tabwriter.Writer, elapsed time.Duration, size int) {
+	b.Lock()
+	defer b.Unlock()
+
+	latencies := append([]time.Duration(nil), b.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	rate := float64(len(latencies)) / elapsed.Seconds()
+
+	fmt.Fprintf(w, "Messages\t%d\n", b.total)
+	fmt.Fprintf(w, "Published\t%d\n", len(latencies))
+	fmt.Fprintf(w, "Rejected\t%d\n", b.rejected)
+	fmt.Fprintf(w, "Requeued\t%d\n", b.requeued)
+	fmt.Fprintf(w, "Elapsed\t%s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput\t%.0f msg/s, %.2f MB/s\n", rate, rate*float64(size)/(1024*1024))
+	if len(latencies) == 0 {
+		return
+	}
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		fmt.Fprintf(w, "Latency p%s\t%s\n", strconv.FormatFloat(p, 'f', -1, 64), percentile(latencies, p).Round(time.Microsecond))
+	}
+	fmt.Fprintf(w, "Latency max\t%s\n", latencies[len(latencies)-1].Round(time.Microsecond))
+}
+
+// percentile returns p-th percentile of sorted durations using nearest rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+func newBenchCmd() *cobra.Command {
+	benchCmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Publish synthetic messages through the whole pipeline and report max throughput and latency percentiles",
+		Args:  cobra.NoArgs,
+		Run:   runBench,
+	}
+	benchCmd.Flags().IntVarP(&benchMessages, "messages", "n", 10000, "Number of synthetic messages")
+	benchCmd.Flags().IntVarP(&benchSize, "size", "s", 1024, "Synthetic message body size in bytes")
+	benchCmd.Flags().IntVar(&benchInFlight, "in-flight", 1000, "Max number of messages being handled at once")
+	benchCmd.Flags().StringVarP(&benchTopic, "topic", "t", "kandalf-bench", "Kafka topic messages are published to")
+	benchCmd.Flags().StringVarP(&benchPipe, "pipe", "p", "", "Queue name of configured pipe messages are handled with, e.g. to include its transform and schema, by default plain pipe is used")
+	benchCmd.Flags().BoolVar(&benchRabbit, "rabbit", false, "Publish messages to RabbitMQ and consume them back, instead of passing them to worker directly")
+	benchCmd.Flags().DurationVar(&benchTimeout, "timeout", 5*time.Minute, "Max amount of time to wait for messages to be published")
+
+	return benchCmd
+}
+
+func runBench(cmd *cobra.Command, args []string) {
+	if benchMessages < 1 || benchSize < 1 || benchInFlight < 1 {
+		failOnError(errBenchSettings, "Invalid bench settings")
+	}
+
+	globalConfig, err := config.Load(configPath)
+	failOnError(err, "Failed to load application configuration")
+
+	err = globalConfig.Log.Apply()
+	failOnError(err, "Failed to configure logger")
+	defer globalConfig.Log.Flush()
+
+	pipe, err := benchPipeFor(globalConfig, benchPipe)
+	failOnError(err, "Failed to configure bench pipe")
+	pipes := []config.Pipe{pipe}
+
+	statsClient, err := stats.NewClient("noop://")
+	failOnError(err, "Failed to init stats client")
+
+	err = producer.CreateTopics(globalConfig.Kafka, pipes)
+	failOnError(err, "Failed to create Kafka topics")
+
+	kafkaProducer, err := producer.NewKafkaRouter(globalConfig.Kafka, pipes, statsClient)
+	failOnError(err, "Failed to establish Kafka connection")
+	defer func() {
+		if err := kafkaProducer.Close(); err != nil {
+			log.WithError(err).Error("Got error on closing kafka producer")
+		}
+	}()
+
+	files := schema.NewFileEncoder()
+	err = files.Load(pipes)
+	failOnError(err, "Failed to load pipe schema file")
+
+	var registry *schema.Registry
+	if hasRegistrySchemaPipes(pipes) {
+		registry, err = schema.NewRegistry(globalConfig.Kafka.SchemaRegistry, statsClient)
+		failOnError(err, "Failed to init Schema Registry client")
+
+		err = registry.Load(pipes)
+		failOnError(err, "Failed to load pipe schema from Schema Registry")
+	}
+
+	// bench leaves disk buffer and storage of configured instance intact
+	worker, err := workers.NewBridgeWorker(globalConfig.Worker, &memoryStorage{}, nil, nil, kafkaProducer, schema.NewEncoder(registry, files), statsClient)
+	failOnError(err, "Failed to init bridge worker")
+	defer func() {
+		if err := worker.Close(); err != nil {
+			log.WithError(err).Error("Got error on closing bridge worker")
+		}
+	}()
+
+	err = worker.LoadPlugins(pipes)
+	failOnError(err, "Failed to load pipe plugin")
+
+	interrupt := make(chan bool)
+	worker.Go(interrupt)
+	defer close(interrupt)
+
+	bench := newBenchmark(benchMessages, benchInFlight)
+	log.WithFields(log.Fields{"messages": benchMessages, "size": benchSize, "in_flight": benchInFlight, "topic": benchTopic}).
+		Info("Starting bench")
+
+	start := time.Now()
+	if benchRabbit {
+		amqpConnection, err := benchRabbitMQ(globalConfig, pipe, worker, bench, statsClient)
+		failOnError(err, "Failed to bench with RabbitMQ")
+		defer func() {
+			if err := amqpConnection.Close(); err != nil {
+				log.WithError(err).Error("Got error on closing AMQP connection")
+			}
+		}()
+	} else {
+		benchWorker(pipe, worker, bench)
+	}
+
+	err = bench.wait(benchTimeout)
+	elapsed := time.Since(start)
+	if err != nil {
+		log.WithError(err).Warning("Bench timed out")
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	bench.report(w, elapsed, benchSize)
+	failOnError(w.Flush(), "Failed to write bench results")
+}
+
+// benchPipeFor returns pipe synthetic messages are handled with, that is plain pipe or configured pipe with the given
+// queue name. Pipe consumes from and publishes to bench exchange, queue and topic, and its messages are acknowledged
+// once they are published, so every message latency is measured up to Kafka acknowledgement.
+func benchPipeFor(globalConfig *config.GlobalConfig, queueName string) (config.Pipe, error) {
+	pipe := config.Pipe{Protocol: config.ProtocolAMQP091}
+	if queueName != "" {
+		pipes, err := config.LoadPipesFromFile(globalConfig.Kafka.PipesConfig)
+		if err != nil {
+			return pipe, err
+		}
+
+		found := false
+		for _, p := range pipes {
+			if p.RabbitQueueName == queueName {
+				pipe, found = p, true
+				break
+			}
+		}
+		if !found {
+			return pipe, errBenchPipeNotFound
+		}
+	}
+
+	pipe.Protocol = config.ProtocolAMQP091
+	pipe.RabbitExchangeName = benchExchange
+	pipe.RabbitExchangeType = config.ExchangeTypeTopic
+	pipe.RabbitRoutingKey = []string{benchRoutingKey}
+	pipe.RabbitBindingArguments = nil
+	pipe.RabbitHeadersMatch = ""
+	pipe.RabbitQueueName = benchQueue
+	pipe.RabbitDurableQueue = false
+	pipe.RabbitAutoDeleteQueue = true
+	pipe.RabbitTransientExchange = true
+	pipe.RabbitExistingQueue = false
+	pipe.RabbitRetry = nil
+	pipe.KafkaTopic = benchTopic
+	pipe.KafkaRouteKey = ""
+	pipe.KafkaRoutes = nil
+	pipe.KafkaSinks = nil
+	pipe.KafkaDelivery = config.DeliveryEndToEnd
+	pipe.Sink = ""
+	pipe.DedupKey = ""
+	pipe.RateLimit = ""
+	pipe.Paused = false
+	pipe.Replicated = false
+	pipe.DryRun = false
+
+	if _, err := globalConfig.Kafka.ForCluster(pipe.KafkaCluster); err != nil {
+		return pipe, err
+	}
+
+	return pipe, pipe.Validate()
+}
+
+// benchWorker passes synthetic messages to worker with pipe handlers number of goroutines, the same way
+// AMQP consumer does
+func benchWorker(pipe config.Pipe, worker *workers.BridgeWorker, bench *benchmark) {
+	// every message in the channel is in-flight one, so requeueing never blocks
+	deliveries := make(chan amqp.Delivery, benchInFlight)
+	handler := bench.handler(worker.MessageHandler, func(delivery amqp.Delivery) {
+		time.AfterFunc(benchRequeueDelay, func() { deliveries <- delivery })
+	})
+
+	for i := 0; i < pipe.HandlersNumber(); i++ {
+		go func() {
+			for delivery := range deliveries {
+				handler(delivery, pipe)
+			}
+		}()
+	}
+
+	go func() {
+		for i := 0; i < benchMessages; i++ {
+			bench.acquire()
+			body := benchBody(i, benchSize)
+			deliveries <- amqp.Delivery{
+				Body:        body,
+				Exchange:    benchExchange,
+				RoutingKey:  benchRoutingKey,
+				ContentType: "application/json",
+				MessageID:   benchMessageID(i),
+				Timestamp:   time.Now(),
+				Headers:     map[string]interface{}{benchSentHeader: time.Now().UnixNano()},
+			}
+		}
+	}()
+}
+
+// benchRabbitMQ publishes synthetic messages to bench exchange and consumes them back with bench pipe queue
+// consumer, so messages go through the whole pipeline, including broker
+func benchRabbitMQ(globalConfig *config.GlobalConfig, pipe config.Pipe, worker *workers.BridgeWorker, bench *benchmark, statsClient client.Client) (*amqp.Connection, error) {
+	dsn, err := amqp.PipeDSN(globalConfig.RabbitDSN, pipe)
+	if err != nil {
+		return nil, err
+	}
+
+	// consumer requeues messages by itself
+	queuesHandler := amqp.NewQueuesHandler(
+		[]config.Pipe{pipe},
+		globalConfig.RabbitMQ,
+		bench.handler(worker.MessageHandler, func(amqp.Delivery) {}),
+		statsClient,
+	)
+	var conn *rabbitmq.Connection
+	amqpConnection, err := amqp.NewConnection(dsn, globalConfig.RabbitMQ, func(c *rabbitmq.Connection) error {
+		conn = c
+		return queuesHandler.Init(c)
+	}, statsClient)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		amqpConnection.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer channel.Close()
+
+		for i := 0; i < benchMessages; i++ {
+			bench.acquire()
+			err := channel.Publish(benchExchange, benchRoutingKey, false, false, rabbitmq.Publishing{
+				Body:        benchBody(i, benchSize),
+				ContentType: "application/json",
+				MessageId:   benchMessageID(i),
+				Timestamp:   time.Now(),
+				Headers:     rabbitmq.Table{benchSentHeader: time.Now().UnixNano()},
+			})
+			if err != nil {
+				log.WithError(err).Error("Failed to publish bench message to RabbitMQ, stopping bench")
+				return
+			}
+		}
+	}()
+
+	return amqpConnection, nil
+}
+
+func benchMessageID(i int) string {
+	return "bench-" + strconv.Itoa(i)
+}
+
+// benchBody returns JSON object of the given size, so it passes pipes transforms and schemas expecting JSON
+func benchBody(i int, size int) []byte {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `{"id":%q,"payload":"`, benchMessageID(i))
+	for body.Len() < size-2 {
+		body.WriteByte('x')
+	}
+	body.WriteString(`"}`)
+
+	return body.Bytes()
+}
+
+// benchSentAt returns synthetic message generation time
+func benchSentAt(delivery amqp.Delivery) time.Time {
+	if sent, ok := delivery.Headers[benchSentHeader].(int64); ok {
+		return time.Unix(0, sent)
+	}
+
+	return delivery.Timestamp
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/kandalf/pkg/storage"
+	"github.com/hellofresh/stats-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// replayBatchSize is max number of messages published at once on buffer replay
+const replayBatchSize = 100
+
+var (
+	bufferDir string
+
+	errMissingBufferDir = errors.New("disk buffer directory is not set, use --dir flag or WORKER_BUFFER_DIR")
+	errMessageNotFound  = errors.New("message is not found in disk buffer")
+)
+
+// bufferedMessage is message read from disk buffer with its sequence number
+type bufferedMessage struct {
+	seq uint64
+	msg *producer.Message
+}
+
+func newBufferCmd() *cobra.Command {
+	bufferCmd := &cobra.Command{
+		Use:   "buffer",
+		Short: "Inspect and replay disk buffer of stopped kandalf instance",
+	}
+	bufferCmd.PersistentFlags().StringVarP(&bufferDir, "dir", "d", "", "Disk buffer directory, default is worker.bufferDir from configuration")
+
+	bufferCmd.AddCommand(
+		&cobra.Command{
+			Use:   "ls",
+			Short: "List buffered messages",
+			Args:  cobra.NoArgs,
+			Run:   runBufferList,
+		},
+		&cobra.Command{
+			Use:   "dump <seq|id>",
+			Short: "Print buffered message by its sequence number or message id",
+			Args:  cobra.ExactArgs(1),
+			Run:   runBufferDump,
+		},
+		&cobra.Command{
+			Use:   "replay [seq...]",
+			Short: "Publish buffered messages to Kafka and remove published ones from buffer, all messages by default",
+			Run:   runBufferReplay,
+		},
+		&cobra.Command{
+			Use:   "verify",
+			Short: "Check record checksums of buffer file without modifying it, fails if the file is corrupted",
+			Args:  cobra.NoArgs,
+			Run:   runBufferVerify,
+		},
+	)
+
+	return bufferCmd
+}
+
+func runBufferList(cmd *cobra.Command, args []string) {
+	buffer, _ := openBuffer()
+	defer buffer.Close()
+
+	messages, err := readBuffer(buffer)
+	failOnError(err, "Failed to read disk buffer")
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SEQ\tID\tTOPIC\tCLUSTER\tSIZE\tATTEMPTS\tCREATED")
+	for _, m := range messages {
+		created := "-"
+		if m.msg.CreatedAt > 0 {
+			created = time.Unix(0, m.msg.CreatedAt).UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%s\n", m.seq, m.msg.ID, m.msg.Topic, m.msg.Cluster, len(m.msg.Body), m.msg.Attempts, created)
+	}
+	w.Flush()
+}
+
+func runBufferDump(cmd *cobra.Command, args []string) {
+	buffer, _ := openBuffer()
+	defer buffer.Close()
+
+	messages, err := readBuffer(buffer)
+	failOnError(err, "Failed to read disk buffer")
+
+	m, err := findMessage(messages, args[0])
+	failOnError(err, "Failed to find message "+args[0])
+
+	data, err := json.MarshalIndent(m.msg, "", "  ")
+	failOnError(err, "Failed to marshal message")
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
+}
+
+func runBufferReplay(cmd *cobra.Command, args []string) {
+	buffer, globalConfig := openBuffer()
+	defer buffer.Close()
+
+	messages, err := readBuffer(buffer)
+	failOnError(err, "Failed to read disk buffer")
+
+	if len(args) > 0 {
+		selected := make([]bufferedMessage, 0, len(args))
+		for _, arg := range args {
+			m, err := findMessage(messages, arg)
+			failOnError(err, "Failed to find message "+arg)
+			selected = append(selected, m)
+		}
+		messages = selected
+	}
+
+	pipesList, err := config.LoadPipesFromFile(globalConfig.Kafka.PipesConfig)
+	failOnError(err, "Failed to load pipes config")
+
+	statsClient, err := stats.NewClient("noop://")
+	failOnError(err, "Failed to init stats client")
+
+	// transactional producers of replay must not fence the ones of running instance that share pipes
+	globalConfig.Kafka.TransactionalIDPrefix = "kandalf-replay-" + strconv.Itoa(os.Getpid()) + "-"
+
+	kafkaProducer, err := producer.NewKafkaRouter(globalConfig.Kafka, pipesList, statsClient)
+	failOnError(err, "Failed to establish Kafka connection")
+	defer func() {
+		if err := kafkaProducer.Close(); err != nil {
+			log.WithError(err).Error("Got error on closing kafka producer")
+		}
+	}()
+
+	var published, failed int
+	for start := 0; start < len(messages); start += replayBatchSize {
+		end := start + replayBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		batch := make([]producer.Message, 0, end-start)
+		for _, m := range messages[start:end] {
+			batch = append(batch, *m.msg)
+		}
+
+		var seqs []uint64
+		for i, err := range kafkaProducer.PublishBatch(batch) {
+			m := messages[start+i]
+			if err != nil {
+				log.WithError(err).WithField("seq", m.seq).WithField("msg", m.msg.String()).Error("Failed to replay message, keeping it in buffer")
+				failed++
+				continue
+			}
+			seqs = append(seqs, m.seq)
+		}
+
+		// messages that are published but not removed are published once again on the next replay or start
+		err := buffer.Remove(seqs...)
+		failOnError(err, "Failed to remove replayed messages from disk buffer")
+		published += len(seqs)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Replayed %d messages, %d failed and kept in buffer\n", published, failed)
+}
+
+func runBufferVerify(cmd *cobra.Command, args []string) {
+	dir, _ := bufferDirectory()
+
+	report, err := storage.VerifyWALBuffer(dir)
+	failOnError(err, "Failed to verify disk buffer")
+
+	w := cmd.OutOrStdout()
+	fmt.Fprintf(w, "Records: %d\n", report.Records)
+	fmt.Fprintf(w, "Live messages: %d\n", report.Live)
+	fmt.Fprintf(w, "Valid bytes: %d\n", report.ValidBytes)
+	fmt.Fprintf(w, "Invalid bytes: %d\n", report.InvalidBytes)
+
+	if report.TailError != "" {
+		// invalid tail is truncated on the next start or buffer command, records before it are kept
+		failOnError(fmt.Errorf("%s at offset %d", report.TailError, report.ValidBytes), "Disk buffer is corrupted")
+	}
+}
+
+// openBuffer opens disk buffer from --dir flag or application configuration, buffer can not be opened
+// while kandalf instance that uses it is running
+func openBuffer() (*storage.WALBuffer, *config.GlobalConfig) {
+	dir, globalConfig := bufferDirectory()
+
+	buffer, err := storage.NewWALBuffer(dir)
+	failOnError(err, "Failed to open disk buffer, make sure kandalf instance that uses it is stopped")
+
+	return buffer, globalConfig
+}
+
+// bufferDirectory returns disk buffer directory from --dir flag or application configuration
+func bufferDirectory() (string, *config.GlobalConfig) {
+	globalConfig, err := config.Load(configPath)
+	failOnError(err, "Failed to load application configuration")
+
+	dir := bufferDir
+	if dir == "" {
+		dir = globalConfig.Worker.BufferDir
+	}
+	if dir == "" {
+		failOnError(errMissingBufferDir, "Failed to open disk buffer")
+	}
+
+	// buffer is not created in a wrong directory when path is mistyped
+	_, err = os.Stat(dir)
+	failOnError(err, "Failed to open disk buffer")
+
+	return dir, globalConfig
+}
+
+// readBuffer reads all messages from disk buffer, corrupted ones are reported and skipped
+func readBuffer(buffer storage.Buffer) ([]bufferedMessage, error) {
+	var messages []bufferedMessage
+	err := buffer.Each(func(seq uint64, data []byte) error {
+		var msg *producer.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.WithError(err).WithField("seq", seq).Warning("Failed to unmarshal buffered message, skipping it")
+			return nil
+		}
+
+		messages = append(messages, bufferedMessage{seq: seq, msg: msg})
+		return nil
+	})
+
+	return messages, err
+}
+
+// findMessage finds message by its sequence number or message id
+func findMessage(messages []bufferedMessage, ref string) (bufferedMessage, error) {
+	seq, seqErr := strconv.ParseUint(ref, 10, 64)
+	for _, m := range messages {
+		if (seqErr == nil && m.seq == seq) || m.msg.ID.String() == ref {
+			return m, nil
+		}
+	}
+
+	return bufferedMessage{}, errMessageNotFound
+}
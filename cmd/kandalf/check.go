@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/plugins"
+	"github.com/hellofresh/kandalf/pkg/schema"
+	"github.com/hellofresh/kandalf/pkg/workers"
+	"github.com/hellofresh/stats-go"
+	"github.com/spf13/cobra"
+)
+
+var pipesFile string
+
+func newCheckCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "check",
+		Short: "Check application and pipes configuration without connecting to anything, fails if it is invalid",
+		Args:  cobra.NoArgs,
+		Run:   runCheck,
+	}
+}
+
+func newPipesCmd() *cobra.Command {
+	pipesCmd := &cobra.Command{
+		Use:   "pipes",
+		Short: "Inspect pipes configuration",
+	}
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate pipes configuration and list pipes, fails if any pipe is invalid",
+		Args:  cobra.NoArgs,
+		Run:   runPipesValidate,
+	}
+	validateCmd.Flags().StringVarP(&pipesFile, "file", "f", "", "Pipes configuration file, default is kafka.pipesConfig from configuration")
+	pipesCmd.AddCommand(validateCmd, newPipesListCmd())
+
+	return pipesCmd
+}
+
+func runCheck(cmd *cobra.Command, args []string) {
+	globalConfig, err := config.Load(configPath)
+	failOnError(err, "Failed to load application configuration")
+
+	_, err = url.Parse(globalConfig.StorageDSN)
+	failOnError(err, "Failed to parse Storage DSN")
+
+	err = workers.ValidateConfig(globalConfig.Worker)
+	failOnError(err, "Invalid worker configuration")
+
+	if globalConfig.RabbitMQ.Discovery.Enabled {
+		statsClient, err := stats.NewClient("noop://")
+		failOnError(err, "Failed to init stats client")
+
+		_, err = amqp.NewDiscovery(globalConfig.RabbitMQ.Discovery, statsClient)
+		failOnError(err, "Invalid queues discovery configuration")
+	}
+
+	pipesList := validatePipes(globalConfig, globalConfig.Kafka.PipesConfig)
+	reversePipes := validateReversePipes(globalConfig, globalConfig.Kafka.PipesConfig)
+	fmt.Fprintf(cmd.OutOrStdout(), "Configuration is valid, %d pipes, %d reverse pipes\n", len(pipesList), len(reversePipes))
+}
+
+func runPipesValidate(cmd *cobra.Command, args []string) {
+	globalConfig, err := config.Load(configPath)
+	failOnError(err, "Failed to load application configuration")
+
+	path := pipesFile
+	if path == "" {
+		path = globalConfig.Kafka.PipesConfig
+	}
+	pipesList := validatePipes(globalConfig, path)
+	reversePipes := validateReversePipes(globalConfig, path)
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PIPE\tPROTOCOL\tSINK\tCLUSTER\tTOPICS")
+	for _, pipe := range pipesList {
+		sink := pipe.Sink
+		if sink == "" {
+			sink = config.SinkKafka
+		}
+		cluster := pipe.KafkaCluster
+		if cluster == "" {
+			cluster = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pipe.String(), pipe.Protocol, sink, cluster, strings.Join(pipe.Topics(), ","))
+	}
+	// reverse pipes consume Kafka topics and publish to RabbitMQ
+	for _, pipe := range reversePipes {
+		cluster := pipe.KafkaCluster
+		if cluster == "" {
+			cluster = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pipe.String(), "kafka", "rabbitmq", cluster, strings.Join(pipe.KafkaTopics, ","))
+	}
+	failOnError(w.Flush(), "Failed to write pipes list")
+}
+
+// validatePipes loads pipes from configuration file and checks everything bridge checks on start without
+// connecting to anything - pipes settings, their Kafka clusters and schema files
+func validatePipes(globalConfig *config.GlobalConfig, path string) []config.Pipe {
+	pipesList, err := config.LoadPipesFromFile(path)
+	failOnError(err, "Failed to load pipes config")
+
+	for _, pipe := range pipesList {
+		_, err = globalConfig.Kafka.ForCluster(pipe.KafkaCluster)
+		failOnError(err, "Failed to find Kafka cluster for pipe "+pipe.String())
+	}
+	err = globalConfig.ValidatePrefetch(pipesList)
+	failOnError(err, "Invalid RabbitMQ prefetch count")
+	err = plugins.Validate(pipesList)
+	failOnError(err, "Failed to load pipes plugins")
+
+	err = schema.NewFileEncoder().Load(pipesList)
+	failOnError(err, "Failed to load pipes schema files")
+
+	return pipesList
+}
+
+// validateReversePipes loads reverse pipes from configuration file and checks their settings and Kafka clusters
+func validateReversePipes(globalConfig *config.GlobalConfig, path string) []config.ReversePipe {
+	reversePipes, err := config.LoadReversePipesFromFile(path)
+	failOnError(err, "Failed to load reverse pipes config")
+
+	for _, pipe := range reversePipes {
+		_, err = globalConfig.Kafka.ForCluster(pipe.KafkaCluster)
+		failOnError(err, "Failed to find Kafka cluster for reverse pipe "+pipe.String())
+	}
+
+	return reversePipes
+}
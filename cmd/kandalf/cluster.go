@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/replication"
+	"github.com/spf13/cobra"
+)
+
+// clusterStatusTimeout is max amount of time to wait for instance status response
+const clusterStatusTimeout = 10 * time.Second
+
+var (
+	adminAddress string
+
+	errMissingAdminAddress = errors.New("admin server address is not set, use --admin flag or ADMIN_LISTEN_ADDRESS")
+	errNoReplication       = errors.New("instance has no pipes with replication enabled")
+)
+
+func newClusterCmd() *cobra.Command {
+	clusterCmd := &cobra.Command{
+		Use:   "cluster",
+		Short: "Inspect replication cluster of running kandalf instances",
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Print replication cluster members and leader as seen by running instance",
+		Args:  cobra.NoArgs,
+		Run:   runClusterStatus,
+	}
+	statusCmd.Flags().StringVarP(&adminAddress, "admin", "a", "", "Admin server address of running instance, e.g. localhost:8080, default is admin.listenAddress from configuration")
+	clusterCmd.AddCommand(statusCmd)
+
+	return clusterCmd
+}
+
+func runClusterStatus(cmd *cobra.Command, args []string) {
+	address, err := adminURL(adminAddress)
+	failOnError(err, "Failed to find running instance")
+
+	status, err := fetchClusterStatus(address)
+	failOnError(err, "Failed to get replication cluster status from "+address)
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Instance state: %s\n", status.State)
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tADDRESS\tROLE")
+	for _, peer := range status.Peers {
+		role := "follower"
+		if peer.Leader {
+			role = "leader"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", peer.ID, peer.Address, role)
+	}
+	failOnError(w.Flush(), "Failed to write cluster status")
+}
+
+// adminURL returns base URL of running instance admin server, address defaults to admin.listenAddress from configuration
+// and address without host is local one
+func adminURL(address string) (string, error) {
+	if address == "" {
+		globalConfig, err := config.Load(configPath)
+		if err != nil {
+			return "", err
+		}
+		address = globalConfig.Admin.ListenAddress
+	}
+	if address == "" {
+		return "", errMissingAdminAddress
+	}
+	if strings.HasPrefix(address, ":") {
+		address = "localhost" + address
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	return strings.TrimSuffix(address, "/"), nil
+}
+
+// fetchClusterStatus reads replication section of running instance "/status" endpoint
+func fetchClusterStatus(address string) (replication.Status, error) {
+	httpClient := &http.Client{Timeout: clusterStatusTimeout}
+	resp, err := httpClient.Get(address + "/status")
+	if err != nil {
+		return replication.Status{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return replication.Status{}, fmt.Errorf("unexpected status response code %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Replication *struct {
+			replication.Status
+			Error string `json:"error"`
+		} `json:"replication"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return replication.Status{}, err
+	}
+	if body.Replication == nil {
+		return replication.Status{}, errNoReplication
+	}
+	if body.Replication.Error != "" {
+		return replication.Status{}, errors.New(body.Replication.Error)
+	}
+
+	return body.Replication.Status, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// dashboard is "/dashboard" endpoint handler serving single page that polls "/status" endpoint and renders
+// pipes, topics, cluster members, throughput sparklines and recent errors, page has no external dependencies,
+// so it works in isolated networks
+func dashboard(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, dashboardPage)
+}
+
+// dashboardPage is dashboard HTML page, it must not contain backquotes
+const dashboardPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Kandalf</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #222; }
+h1 { font-size: 20px; margin: 0 0 4px; }
+h2 { font-size: 16px; margin: 24px 0 8px; }
+table { border-collapse: collapse; width: 100%; font-size: 13px; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #eee; vertical-align: middle; }
+th { color: #666; font-weight: normal; }
+.meta { color: #666; font-size: 13px; }
+.ok { color: #1a7f37; }
+.warn { color: #9a6700; }
+.fail { color: #cf222e; }
+.num { text-align: right; font-variant-numeric: tabular-nums; }
+svg polyline { fill: none; stroke: #0969da; stroke-width: 1.5; }
+</style>
+</head>
+<body>
+<h1>Kandalf</h1>
+<div class="meta" id="meta">Loading status...</div>
+
+<h2>Worker</h2>
+<table><tbody id="worker"></tbody></table>
+
+<h2>Pipes</h2>
+<table>
+<thead><tr><th>Pipe</th><th>State</th><th class="num">Handled</th><th class="num">Failed</th><th class="num">Msg/s</th><th>Throughput</th><th class="num">Published KB/s</th><th class="num">Latency avg/max</th></tr></thead>
+<tbody id="pipes"></tbody>
+</table>
+
+<h2>Topics</h2>
+<table>
+<thead><tr><th>Topic</th><th class="num">Published</th><th class="num">Failed</th><th class="num">Msg/s</th><th>Throughput</th><th class="num">KB/s</th><th class="num">Latency avg/max</th></tr></thead>
+<tbody id="topics"></tbody>
+</table>
+
+<h2>Connections</h2>
+<table>
+<thead><tr><th>RabbitMQ</th><th>State</th><th>Backlog</th></tr></thead>
+<tbody id="connections"></tbody>
+</table>
+
+<h2>Cluster</h2>
+<table>
+<thead><tr><th>Node</th><th>Address</th><th>Role</th></tr></thead>
+<tbody id="cluster"></tbody>
+</table>
+
+<h2>Recent errors</h2>
+<table>
+<thead><tr><th>Time</th><th>Source</th><th>Error</th></tr></thead>
+<tbody id="errors"></tbody>
+</table>
+
+<script>
+"use strict";
+
+// status rates are counted over the last minute
+var pollInterval = 10000;
+var historySize = 60;
+var history = {};
+
+function el(tag, text, className) {
+  var e = document.createElement(tag);
+  if (text !== undefined) { e.textContent = text; }
+  if (className) { e.className = className; }
+  return e;
+}
+
+function row(cells) {
+  var tr = document.createElement("tr");
+  cells.forEach(function (cell) { tr.appendChild(cell); });
+  return tr;
+}
+
+function fill(id, rows, empty, columns) {
+  var body = document.getElementById(id);
+  body.textContent = "";
+  if (rows.length === 0) {
+    var td = el("td", empty, "meta");
+    td.colSpan = columns;
+    rows = [row([td])];
+  }
+  rows.forEach(function (r) { body.appendChild(r); });
+}
+
+function track(key, rate) {
+  var points = history[key] || [];
+  points.push(rate);
+  if (points.length > historySize) { points.shift(); }
+  history[key] = points;
+  return points;
+}
+
+function sparkline(points) {
+  var width = 120, height = 20;
+  var max = Math.max.apply(null, points.concat([1]));
+  var step = width / (historySize - 1);
+  var offset = width - step * (points.length - 1);
+  var coords = points.map(function (p, i) {
+    return (offset + i * step).toFixed(1) + "," + (height - 1 - p / max * (height - 2)).toFixed(1);
+  });
+  var svg = document.createElementNS("http://www.w3.org/2000/svg", "svg");
+  svg.setAttribute("width", width);
+  svg.setAttribute("height", height);
+  var line = document.createElementNS("http://www.w3.org/2000/svg", "polyline");
+  line.setAttribute("points", coords.join(" "));
+  svg.appendChild(line);
+  var td = el("td");
+  td.appendChild(svg);
+  return td;
+}
+
+function latency(w) {
+  if (!w.latencyMaxSeconds) { return el("td", "-", "num"); }
+  return el("td", (w.latencyAvgSeconds || 0).toFixed(2) + "s / " + w.latencyMaxSeconds.toFixed(2) + "s", "num");
+}
+
+function traffic(key, name, state, t, published, errors) {
+  var cells = [el("td", name)];
+  if (state) { cells.push(el("td", state, state === "running" ? "ok" : "warn")); }
+  cells.push(el("td", t.handled, "num"));
+  cells.push(el("td", t.failed, t.failed > 0 ? "num fail" : "num"));
+  cells.push(el("td", t.lastMinute.messagesPerSecond.toFixed(1), "num"));
+  cells.push(sparkline(track(key, t.lastMinute.messagesPerSecond)));
+  cells.push(el("td", (published.bytesPerSecond / 1024).toFixed(1), "num"));
+  cells.push(latency(published));
+  if (t.lastError) { errors.push({at: t.lastErrorAt, source: name, error: t.lastError}); }
+  return row(cells);
+}
+
+function render(status) {
+  var meta = document.getElementById("meta");
+  meta.className = "meta";
+  meta.textContent = "Node " + status.node + ", version " + (status.version || "dev") +
+    ", started at " + new Date(status.startedAt).toLocaleString() + ", updated at " + new Date().toLocaleTimeString();
+
+  // worker is not reported until it is initialised
+  var worker = status.worker || {circuit: "unknown", pipes: [], topics: [], buffer: {messages: 0, inFlight: 0, bytes: 0, ageSeconds: 0}};
+  var breaker = worker.circuit === "closed" ? "ok" : "fail";
+  fill("worker", [
+    row([el("th", "Circuit breaker"), el("td", worker.circuit, breaker)]),
+    row([el("th", "Consumption"), el("td", worker.draining ? "draining" : (worker.paused ? "paused" : "running"), worker.paused || worker.draining ? "warn" : "ok")]),
+    row([el("th", "Buffer"), el("td", worker.buffer.messages + " messages, " + worker.buffer.inFlight + " in flight, " +
+      worker.buffer.bytes + " bytes, oldest " + worker.buffer.ageSeconds.toFixed(1) + "s ago")])
+  ], "", 2);
+
+  var errors = [];
+  fill("pipes", worker.pipes.map(function (p) {
+    return traffic("pipe:" + p.key, p.key, p.state, p, p.published, errors);
+  }), "No pipes", 8);
+  fill("topics", worker.topics.map(function (t) {
+    return traffic("topic:" + t.topic, t.topic, null, t, t.lastMinute, errors);
+  }), "Nothing is published yet", 7);
+
+  fill("connections", (status.rabbitmq || []).map(function (c) {
+    var state = c.connected ? (c.blocked ? "blocked" : "connected") : "reconnecting";
+    var backlog = Object.keys(c.backlog || {}).sort().map(function (queue) { return queue + ": " + c.backlog[queue]; });
+    return row([el("td", c.dsn), el("td", state, c.connected && !c.blocked ? "ok" : "fail"), el("td", backlog.join(", "))]);
+  }), "No RabbitMQ connections", 3);
+
+  var replication = status.replication;
+  if (replication && replication.error) {
+    fill("cluster", [row([el("td", replication.error, "fail")])], "", 3);
+  } else {
+    fill("cluster", ((replication && replication.peers) || []).map(function (p) {
+      return row([el("td", p.id), el("td", p.address), el("td", p.leader ? "leader" : "follower", p.leader ? "ok" : "")]);
+    }), replication ? "Cluster has no peers" : "Replication is not enabled", 3);
+  }
+
+  errors.sort(function (a, b) { return new Date(b.at) - new Date(a.at); });
+  fill("errors", errors.map(function (e) {
+    return row([el("td", new Date(e.at).toLocaleString()), el("td", e.source), el("td", e.error, "fail")]);
+  }), "No errors since start", 3);
+}
+
+function poll() {
+  fetch("/status", {cache: "no-store"})
+    .then(function (resp) { return resp.json(); })
+    .then(render)
+    .catch(function (err) {
+      var meta = document.getElementById("meta");
+      meta.textContent = "Failed to load status: " + err;
+      meta.className = "meta fail";
+    })
+    .then(function () { setTimeout(poll, pollInterval); });
+}
+
+poll();
+</script>
+</body>
+</html>
+`
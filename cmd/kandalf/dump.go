@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stateDumpMessage is message of every log record of internal state dump, so the whole dump is found by it
+const stateDumpMessage = "State dump"
+
+// watchStateDump logs internal state snapshot on every SIGUSR1 or Windows service state dump control, so wedged
+// instance can be examined without debugger or admin server access
+func watchStateDump(appStatus *status) {
+	signals := make(chan os.Signal, 1)
+	notifySignals(signals, stateDumpSignals)
+
+	for {
+		select {
+		case <-signals:
+		case <-stateDumpRequests:
+		}
+
+		dumpState(appStatus)
+	}
+}
+
+// dumpState logs status sections and running goroutines counted by their role, every record is a flat list of fields,
+// so it is readable with any log format. Arrays of objects, e.g. pipes or peers, are logged with a record per item.
+func dumpState(appStatus *status) {
+	log.Info("Dumping internal state")
+
+	report := appStatus.report()
+	names := make([]string, 0, len(report))
+	for name := range report {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	app := log.Fields{"section": "app", "goroutines": runtime.NumGoroutine()}
+	var sections []string
+	for _, name := range names {
+		// sections are JSON serialisable, so they are flattened in their JSON form
+		value, err := jsonValue(report[name])
+		if err != nil {
+			log.WithError(err).WithField("section", name).Error("Failed to dump state section")
+			continue
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			sections = append(sections, name)
+			report[name] = value
+		default:
+			app[name] = value
+		}
+	}
+	log.WithFields(app).Info(stateDumpMessage)
+
+	for _, name := range sections {
+		dumpValue(name, report[name])
+	}
+	dumpGoroutines()
+}
+
+// jsonValue converts value to its generic JSON form of maps, slices and scalars
+func jsonValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	err = json.Unmarshal(data, &generic)
+	return generic, err
+}
+
+// dumpValue logs JSON value of the section, nested objects are flattened to dotted fields, while nested arrays of
+// objects are logged as subsections
+func dumpValue(section string, value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) == 0 {
+			log.WithField("section", section).WithField("len", 0).Info(stateDumpMessage)
+		}
+		for _, item := range v {
+			dumpValue(section, item)
+		}
+	case map[string]interface{}:
+		fields := log.Fields{"section": section}
+		subsections := make(map[string][]interface{})
+		flattenFields("", v, fields, subsections)
+		log.WithFields(fields).Info(stateDumpMessage)
+
+		keys := make([]string, 0, len(subsections))
+		for key := range subsections {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			dumpValue(section+"."+key, subsections[key])
+		}
+	default:
+		log.WithField("section", section).WithField("value", v).Info(stateDumpMessage)
+	}
+}
+
+// flattenFields puts object scalars and arrays of scalars to fields by dotted keys and arrays of objects
+// to subsections
+func flattenFields(prefix string, object map[string]interface{}, fields log.Fields, subsections map[string][]interface{}) {
+	for key, value := range object {
+		key = prefix + key
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenFields(key+".", v, fields, subsections)
+		case []interface{}:
+			if hasObjects(v) {
+				subsections[key] = v
+			} else {
+				fields[key] = fmt.Sprint(v)
+			}
+		default:
+			fields[key] = v
+		}
+	}
+}
+
+func hasObjects(values []interface{}) bool {
+	for _, value := range values {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return true
+		}
+	}
+
+	return false
+}
+
+// dumpGoroutines logs numbers of running goroutines by their role, that is goroutine entry function,
+// e.g. worker loop, AMQP consumers or raft routines
+func dumpGoroutines() {
+	roles := goroutineRoles()
+	names := make([]string, 0, len(roles))
+	for role := range roles {
+		names = append(names, role)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if roles[names[i]] != roles[names[j]] {
+			return roles[names[i]] > roles[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	for _, role := range names {
+		log.WithFields(log.Fields{"section": "goroutines", "role": role, "count": roles[role]}).Info(stateDumpMessage)
+	}
+}
+
+// goroutineRoles counts running goroutines by their entry function
+func goroutineRoles() map[string]int {
+	var records []runtime.StackRecord
+	n := runtime.NumGoroutine()
+	for {
+		// goroutines may be started in between, so profile is taken with some room
+		records = make([]runtime.StackRecord, n+n/4+8)
+		var ok bool
+		if n, ok = runtime.GoroutineProfile(records); ok {
+			break
+		}
+	}
+
+	roles := make(map[string]int)
+	for _, record := range records[:n] {
+		role := "unknown"
+		frames := runtime.CallersFrames(record.Stack())
+		for {
+			frame, more := frames.Next()
+			if frame.Function != "" && frame.Function != "runtime.goexit" {
+				role = frame.Function
+			}
+			if !more {
+				break
+			}
+		}
+		roles[role]++
+	}
+
+	return roles
+}
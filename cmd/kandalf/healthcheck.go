@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// exitUnhealthy is exit code of failed health check, Docker HEALTHCHECK treats any code but 0 and 1 as reserved
+const exitUnhealthy = 1
+
+var (
+	healthcheckReady   bool
+	healthcheckTimeout time.Duration
+)
+
+func newHealthcheckCmd() *cobra.Command {
+	healthcheckCmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "Check health of running instance with its admin server, exits with 1 if it is unhealthy, e.g. for Docker HEALTHCHECK",
+		Args:  cobra.NoArgs,
+		Run:   runHealthcheck,
+	}
+	healthcheckCmd.Flags().StringVarP(&adminAddress, "admin", "a", "", "Admin server address of running instance, e.g. localhost:8080, default is admin.listenAddress from configuration")
+	healthcheckCmd.Flags().BoolVar(&healthcheckReady, "ready", false, "Check readiness with \"/readyz\" instead of liveness with \"/healthz\"")
+	healthcheckCmd.Flags().DurationVar(&healthcheckTimeout, "timeout", 5*time.Second, "Max amount of time to wait for health check response")
+
+	return healthcheckCmd
+}
+
+// runHealthcheck prints health check response body, it does not panic on failures, so exit code is always
+// either 0 or exitUnhealthy
+func runHealthcheck(cmd *cobra.Command, args []string) {
+	path := "/healthz"
+	if healthcheckReady {
+		path = "/readyz"
+	}
+
+	body, err := fetchHealth(path)
+	fmt.Fprint(cmd.OutOrStdout(), body)
+	if err != nil {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Health check failed:", err)
+		exitCode = exitUnhealthy
+	}
+}
+
+// fetchHealth requests health check endpoint of running instance and returns its response body, it returns error
+// for any response but 200
+func fetchHealth(path string) (string, error) {
+	address, err := adminURL(adminAddress)
+	if err != nil {
+		return "", err
+	}
+
+	httpClient := &http.Client{Timeout: healthcheckTimeout}
+	resp, err := httpClient.Get(address + path)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return string(body), fmt.Errorf("unexpected %s response code %d", path, resp.StatusCode)
+	}
+
+	return string(body), nil
+}
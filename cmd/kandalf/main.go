@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	log "github.com/sirupsen/logrus"
@@ -9,9 +8,19 @@ import (
 )
 
 var (
+	// version, commit and buildDate are set at build time with linker flags, see build/build.sh
 	version     string
+	commit      string
+	buildDate   string
 	configPath  string
 	versionFlag bool
+	dryRunFlag  bool
+	pidFilePath string
+	// foregroundFlag and backgroundFlag set explicit run mode, default one is foreground with configured log writer
+	foregroundFlag bool
+	backgroundFlag bool
+	// exitCode is process exit code set by application on shutdown
+	exitCode = exitOK
 )
 
 func failOnError(err error, msg string) {
@@ -24,7 +33,7 @@ func main() {
 	versionString := "Kandalf v" + version
 	cobra.OnInitialize(func() {
 		if versionFlag {
-			fmt.Println(versionString)
+			failOnError(printVersion(os.Stdout, versionString), "Failed to print version")
 			os.Exit(0)
 		}
 	})
@@ -35,11 +44,51 @@ func main() {
 		Long: versionString + `. RabbitMQ to Kafka bridge.
 
 Complete documentation is available at https://github.com/hellofresh/kandalf`,
-		Run: RunApp,
+		// bridge used to be run without command, so existing deployments keep working
+		Run: func(cmd *cobra.Command, args []string) {
+			log.Warning("Running kandalf without command is deprecated, use \"kandalf run\" instead")
+			RunApp(cmd, args)
+		},
 	}
-	RootCmd.Flags().StringVarP(&configPath, "config", "c", "", "Source of a configuration file")
+	RootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "Source of a configuration file")
 	RootCmd.Flags().BoolVarP(&versionFlag, "version", "v", false, "Print application version")
 
+	runCmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run RabbitMQ to Kafka bridge",
+		Args:  cobra.NoArgs,
+		Run:   RunApp,
+	}
+	runCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Run all the pipes in dry run mode, messages are logged instead of being published to Kafka")
+	runCmd.Flags().StringVar(&pidFilePath, "pidfile", "", "Write process id to the file, stale pid file of dead process is replaced")
+	runCmd.Flags().BoolVar(&foregroundFlag, "foreground", false, "Run in foreground with logs written to stdout, e.g. under supervisor")
+	runCmd.Flags().BoolVar(&backgroundFlag, "background", false, "Start detached process in background and exit once it writes pid file, requires --pidfile")
+	runCmd.Flags().StringVar(&runServiceName, "service", "", "Run as Windows service with the name, it is set by \"kandalf service install\"")
+	failOnError(runCmd.Flags().MarkHidden("service"), "Failed to hide service flag")
+
+	RootCmd.AddCommand(
+		runCmd,
+		&cobra.Command{
+			Use:   "version",
+			Short: "Print application version and build metadata - commit, build date and Go version",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				failOnError(printVersion(cmd.OutOrStdout(), versionString), "Failed to print version")
+			},
+		},
+		newConfigCmd(),
+		newCheckCmd(),
+		newPipesCmd(),
+		newClusterCmd(),
+		newBufferCmd(),
+		newBenchCmd(),
+		newHealthcheckCmd(),
+		newServiceCmd(),
+	)
+
 	err := RootCmd.Execute()
 	failOnError(err, "Failed to execute root command")
+	if exitCode != exitOK {
+		os.Exit(exitCode)
+	}
 }
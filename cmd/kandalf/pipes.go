@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var pipesListJSON bool
+
+func newPipesListCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Print effective settings of pipes running instance consumes, after defaults and overrides are applied",
+		Args:  cobra.NoArgs,
+		Run:   runPipesList,
+	}
+	listCmd.Flags().StringVarP(&adminAddress, "admin", "a", "", "Admin server address of running instance, e.g. localhost:8080, default is admin.listenAddress from configuration")
+	listCmd.Flags().BoolVar(&pipesListJSON, "json", false, "Print settings as JSON")
+
+	return listCmd
+}
+
+func runPipesList(cmd *cobra.Command, args []string) {
+	address, err := adminURL(adminAddress)
+	failOnError(err, "Failed to find running instance")
+
+	pipes, err := fetchPipes(address)
+	failOnError(err, "Failed to get pipes from "+address)
+
+	if pipesListJSON {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		failOnError(encoder.Encode(pipes), "Failed to write pipes list")
+		return
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	for i, pipe := range pipes {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		writePipeSettings(w, pipe)
+	}
+	failOnError(w.Flush(), "Failed to write pipes list")
+}
+
+// writePipeSettings writes pipe settings grouped by what they apply to, one group per line
+func writePipeSettings(w io.Writer, pipe config.EffectivePipe) {
+	fmt.Fprintf(w, "PIPE\t%s\n", pipe.Key)
+
+	queue := []string{pipe.Protocol}
+	if pipe.DurableQueue {
+		queue = append(queue, "durable")
+	}
+	if pipe.AutoDeleteQueue {
+		queue = append(queue, "auto-delete")
+	}
+	if pipe.ExistingQueue {
+		queue = append(queue, "existing")
+	}
+	fmt.Fprintf(w, "queue\t%s (%s), %d consumers x %d handlers\n", pipe.Queue, strings.Join(queue, ", "), pipe.Consumers, pipe.Handlers)
+
+	if !pipe.ExistingQueue && pipe.Protocol == config.ProtocolAMQP091 {
+		exchange := pipe.ExchangeType
+		if pipe.TransientExchange {
+			exchange += ", transient"
+		}
+		fmt.Fprintf(w, "exchange\t%s (%s)\n", pipe.Exchange, exchange)
+		bindings := strings.Join(pipe.RoutingKeys, ", ")
+		if len(pipe.BindingArguments) > 0 {
+			arguments, _ := json.Marshal(pipe.BindingArguments)
+			bindings = fmt.Sprintf("%s %s", bindings, arguments)
+		}
+		if pipe.HeadersMatch != "" {
+			bindings = fmt.Sprintf("%s match %s", bindings, pipe.HeadersMatch)
+		}
+		fmt.Fprintf(w, "bindings\t%s\n", strings.TrimSpace(bindings))
+	} else if pipe.Protocol == config.ProtocolMQTT {
+		fmt.Fprintf(w, "filters\t%s\n", strings.Join(pipe.RoutingKeys, ", "))
+	}
+
+	fmt.Fprintf(w, "topics\t%s\n", strings.Join(pipe.Topics, ", "))
+	if pipe.ErrorTopic != "" {
+		fmt.Fprintf(w, "error topic\t%s\n", pipe.ErrorTopic)
+	}
+
+	delivery := pipe.Delivery
+	if delivery == "" {
+		delivery = "default"
+	}
+	if pipe.Sink != config.SinkKafka {
+		// Kafka producer settings do not apply to other sinks
+		fmt.Fprintf(w, "sink\t%s\n", pipe.Sink)
+		fmt.Fprintf(w, "delivery\t%s\n", delivery)
+	} else {
+		cluster := pipe.Cluster
+		if cluster == "" {
+			cluster = "main"
+		}
+		fmt.Fprintf(w, "cluster\t%s (%s)\n", cluster, strings.Join(pipe.Brokers, ","))
+
+		producer := fmt.Sprintf("acks %s, %d retries", pipe.RequiredAcks, pipe.RetryMax)
+		if pipe.Idempotent {
+			producer += ", idempotent"
+		}
+		fmt.Fprintf(w, "delivery\t%s (%s)\n", delivery, producer)
+		fmt.Fprintf(w, "batching\t%d messages, %d bytes, %s frequency, %d in flight\n",
+			pipe.FlushMessages, pipe.FlushBytes, pipe.FlushFrequency, pipe.MaxInFlight)
+		fmt.Fprintf(w, "producer\t%s partitioner, %s compression\n", pipe.Partitioner, pipe.Compression)
+	}
+
+	state := []string{"running"}
+	if pipe.Paused {
+		state = []string{"paused"}
+	}
+	if pipe.DryRun {
+		state = append(state, "dry run")
+	}
+	if pipe.Replicated {
+		state = append(state, "replicated")
+	}
+	if pipe.RateLimit != "" {
+		state = append(state, "rate limit "+pipe.RateLimit)
+	}
+	fmt.Fprintf(w, "state\t%s\n", strings.Join(state, ", "))
+}
+
+// fetchPipes reads effective pipes settings from running instance "/pipes" endpoint
+func fetchPipes(address string) ([]config.EffectivePipe, error) {
+	httpClient := &http.Client{Timeout: clusterStatusTimeout}
+	resp, err := httpClient.Get(address + "/pipes")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected pipes response code %d", resp.StatusCode)
+	}
+
+	var pipes []config.EffectivePipe
+	if err := json.NewDecoder(resp.Body).Decode(&pipes); err != nil {
+		return nil, err
+	}
+
+	return pipes, nil
+}
+
+// runningPipes returns pipes consumed by queues handlers, including reloaded and discovered ones, and AMQP 1.0 and MQTT
+// pipes that are not reloaded, sorted by pipe key
+func runningPipes(queuesHandlers map[string]*amqp.QueuesHandler, staticPipes []config.Pipe) []config.Pipe {
+	pipes := append([]config.Pipe{}, staticPipes...)
+	for _, queuesHandler := range queuesHandlers {
+		pipes = append(pipes, queuesHandler.Pipes()...)
+	}
+	sort.Slice(pipes, func(i, j int) bool { return pipes[i].Key() < pipes[j].Key() })
+
+	return pipes
+}
+
+// effectivePipes resolves settings of all the pipes with application Kafka configuration
+func effectivePipes(kafkaConfig config.KafkaConfig, pipes []config.Pipe) ([]config.EffectivePipe, error) {
+	effective := make([]config.EffectivePipe, 0, len(pipes))
+	for _, pipe := range pipes {
+		settings, err := pipe.Effective(kafkaConfig)
+		if err != nil {
+			return nil, err
+		}
+		effective = append(effective, settings)
+	}
+
+	return effective, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/kandalf/pkg/schema"
+	"github.com/hellofresh/kandalf/pkg/systemd"
+	"github.com/hellofresh/kandalf/pkg/workers"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errReloadAMQP10      = errors.New("AMQP 1.0 pipes changes require restart")
+	errReloadMQTT        = errors.New("MQTT pipes changes require restart")
+	errReloadConnection  = errors.New("pipe requires AMQP connection that was not established on start")
+	errReloadSchema      = errors.New("pipe requires Schema Registry client that was not initialised on start")
+	errReloadReplication = errors.New("pipe requires replication cluster that was not joined on start")
+	errReloadDelivery    = errors.New("pipe requires Kafka producer for its delivery class that was not created on start")
+	errReloadSink        = errors.New("pipe requires sink producer, e.g. NATS connection or Pulsar client, that was not created on start")
+)
+
+// pipesHandler starts and stops consumers of pipes consumed with single AMQP connection, it is implemented
+// by amqp.QueuesHandler
+type pipesHandler interface {
+	AddPipes(pipes []config.Pipe) []error
+	RemovePipes(pipes []config.Pipe)
+}
+
+// pipesReloader applies reloaded pipes config to running application, consumers are restarted for added, removed
+// and changed pipes only, while consumers of untouched pipes keep running
+type pipesReloader struct {
+	path   string
+	pipes  []config.Pipe
+	worker *workers.BridgeWorker
+	// reversePipes are not reloaded, their changes are reported only
+	reversePipes []config.ReversePipe
+
+	rabbitDSN      string
+	queuesHandlers map[string]pipesHandler
+	kafkaConfig    config.KafkaConfig
+	globalConfig   *config.GlobalConfig
+	router         *producer.Router
+	registry       *schema.Registry
+	files          *schema.FileEncoder
+	replicated     bool
+	// dryRun is true when application runs all the pipes in dry run mode, so reloaded pipes run in it as well
+	dryRun bool
+}
+
+// watch reloads pipes config on SIGHUP or Windows service parameters change, rate limits and paused state are applied
+// to all the pipes, while added, removed and changed pipes get their consumers started or stopped. systemd is notified
+// about reloading until reloaded config is applied
+func (r *pipesReloader) watch() {
+	signals := make(chan os.Signal, 1)
+	notifySignals(signals, reloadSignals)
+
+	for {
+		select {
+		case <-signals:
+		case <-reloadRequests:
+		}
+
+		systemd.NotifyState(systemd.Reloading)
+		r.reload()
+		systemd.NotifyState(systemd.Ready)
+	}
+}
+
+// reload loads pipes config and applies it, current pipes are kept if config fails to load
+func (r *pipesReloader) reload() {
+	log.WithField("path", r.path).Info("Reloading pipes config")
+
+	pipes, err := config.LoadPipesFromFile(r.path)
+	if err != nil {
+		log.WithError(err).Error("Failed to reload pipes config, keeping current pipes")
+		return
+	}
+	if r.dryRun {
+		if pipes, err = dryRunPipes(pipes); err != nil {
+			log.WithError(err).Error("Failed to run reloaded pipes in dry run mode, keeping current pipes")
+			return
+		}
+	}
+
+	if err := r.worker.UpdateRateLimits(pipes); err != nil {
+		log.WithError(err).Error("Failed to apply reloaded pipes rate limits")
+	}
+	r.worker.UpdatePausedPipes(pipes)
+	r.apply(pipes)
+
+	if reversePipes, err := config.LoadReversePipesFromFile(r.path); err != nil {
+		log.WithError(err).Error("Failed to reload reverse pipes config")
+	} else if !r.dryRun && !reflect.DeepEqual(r.reversePipes, reversePipes) {
+		log.Warning("Reverse pipes changes require restart, keeping current reverse pipes")
+	}
+}
+
+// apply starts and stops consumers of pipes that differ from the current ones, pipes that can not be applied
+// without restart are kept as they are, so they are retried on the next reload
+func (r *pipesReloader) apply(reloaded []config.Pipe) {
+	added, removed, changed := config.DiffPipes(r.pipes, reloaded)
+	log.WithField("added", len(added)).WithField("removed", len(removed)).WithField("changed", len(changed)).
+		Info("Applying reloaded pipes")
+
+	// kept are pipes that are not applied mapped by pipe key, nil value means pipe is not started at all
+	kept := make(map[string]*config.Pipe)
+	for i := range added {
+		if err := r.startPipe(added[i]); err != nil {
+			log.WithError(err).WithField("pipe", added[i].String()).Error("Failed to add reloaded pipe")
+			kept[added[i].Key()] = nil
+		}
+	}
+	for i := range removed {
+		if err := r.stopPipe(removed[i]); err != nil {
+			log.WithError(err).WithField("pipe", removed[i].String()).Error("Failed to remove pipe")
+			kept[removed[i].Key()] = &removed[i]
+		}
+	}
+	for i := range changed {
+		current := r.currentPipe(changed[i])
+		if stopped, err := r.restartPipe(current, changed[i]); err != nil {
+			log.WithError(err).WithField("pipe", changed[i].String()).Error("Failed to apply changed pipe")
+			if stopped {
+				kept[changed[i].Key()] = nil
+			} else {
+				kept[changed[i].Key()] = &current
+			}
+		}
+	}
+
+	pipes := make([]config.Pipe, 0, len(reloaded))
+	for _, pipe := range reloaded {
+		keptPipe, ok := kept[pipe.Key()]
+		if !ok {
+			pipes = append(pipes, pipe)
+		} else if keptPipe != nil {
+			pipes = append(pipes, *keptPipe)
+		}
+	}
+	for i := range removed {
+		if kept[removed[i].Key()] != nil {
+			pipes = append(pipes, removed[i])
+		}
+	}
+	r.pipes = pipes
+}
+
+// restartPipe stops consumers of the current pipe and starts consumers of changed one, nothing is stopped
+// if changed pipe can not be prepared, and the current pipe is started again if changed one fails to start.
+// stopped is true when neither of them is running after failure.
+func (r *pipesReloader) restartPipe(current config.Pipe, changed config.Pipe) (stopped bool, err error) {
+	switch current.Protocol {
+	case config.ProtocolAMQP10:
+		return false, errReloadAMQP10
+	case config.ProtocolMQTT:
+		return false, errReloadMQTT
+	}
+	queuesHandler, err := r.prepare(changed)
+	if err != nil {
+		return false, err
+	}
+	if err := r.stopPipe(current); err != nil {
+		return false, err
+	}
+
+	if err := queuesHandler.AddPipes([]config.Pipe{changed})[0]; err != nil {
+		if startErr := r.startPipe(current); startErr != nil {
+			log.WithError(startErr).WithField("pipe", current.String()).Error("Failed to start current pipe again")
+			return true, err
+		}
+		return false, err
+	}
+
+	return false, nil
+}
+
+func (r *pipesReloader) startPipe(pipe config.Pipe) error {
+	queuesHandler, err := r.prepare(pipe)
+	if err != nil {
+		return err
+	}
+
+	return queuesHandler.AddPipes([]config.Pipe{pipe})[0]
+}
+
+func (r *pipesReloader) stopPipe(pipe config.Pipe) error {
+	queuesHandler, err := r.queuesHandler(pipe)
+	if err != nil {
+		return err
+	}
+
+	queuesHandler.RemovePipes([]config.Pipe{pipe})
+	return nil
+}
+
+// prepare checks that pipe can be started without restart, creates its topic, loads its schema and plugin and returns
+// queues handler of AMQP connection pipe is consumed with
+func (r *pipesReloader) prepare(pipe config.Pipe) (pipesHandler, error) {
+	queuesHandler, err := r.queuesHandler(pipe)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.kafkaConfig.ForCluster(pipe.KafkaCluster); err != nil {
+		return nil, err
+	}
+	if err := r.globalConfig.ValidatePrefetch([]config.Pipe{pipe}); err != nil {
+		return nil, err
+	}
+	if pipe.Sink != "" && pipe.Sink != config.SinkKafka {
+		if !r.router.HasSink(pipe.Sink) {
+			return nil, errReloadSink
+		}
+	} else if pipe.KafkaDelivery == config.DeliveryTransactional {
+		if !r.router.HasPipeRoute(pipe) {
+			return nil, errReloadDelivery
+		}
+	} else if !r.router.HasRoute(pipe.KafkaCluster, pipe.KafkaDelivery) {
+		return nil, errReloadDelivery
+	}
+	if pipe.Replicated && !r.replicated {
+		return nil, errReloadReplication
+	}
+
+	if pipe.KafkaSchema != nil && pipe.KafkaSchema.File != "" {
+		if err := r.files.Load([]config.Pipe{pipe}); err != nil {
+			return nil, err
+		}
+	} else if pipe.KafkaSchema != nil {
+		if r.registry == nil {
+			return nil, errReloadSchema
+		}
+		if err := r.registry.Load([]config.Pipe{pipe}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.worker.LoadPlugins([]config.Pipe{pipe}); err != nil {
+		return nil, err
+	}
+
+	if err := producer.CreateTopics(r.kafkaConfig, []config.Pipe{pipe}); err != nil {
+		return nil, err
+	}
+
+	return queuesHandler, nil
+}
+
+func (r *pipesReloader) queuesHandler(pipe config.Pipe) (pipesHandler, error) {
+	switch pipe.Protocol {
+	case config.ProtocolAMQP10:
+		return nil, errReloadAMQP10
+	case config.ProtocolMQTT:
+		return nil, errReloadMQTT
+	}
+
+	pipeDSN, err := amqp.PipeDSN(r.rabbitDSN, pipe)
+	if err != nil {
+		return nil, err
+	}
+
+	queuesHandler, ok := r.queuesHandlers[pipeDSN]
+	if !ok {
+		return nil, errReloadConnection
+	}
+
+	return queuesHandler, nil
+}
+
+func (r *pipesReloader) currentPipe(pipe config.Pipe) config.Pipe {
+	for _, current := range r.pipes {
+		if current.Key() == pipe.Key() {
+			return current
+		}
+	}
+
+	return pipe
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultServiceName is name service is installed and managed with, unless --name flag is set
+const defaultServiceName = "kandalf"
+
+var (
+	serviceName string
+	// runServiceName is set by service control manager with hidden run flag, so the bridge runs as service
+	runServiceName string
+
+	errServiceUnsupported = errors.New("services are supported on windows only, use systemd unit or init script instead")
+	errServiceExists      = errors.New("service is installed already")
+	errServiceStopTimeout = errors.New("timed out waiting for service to stop")
+)
+
+func newServiceCmd() *cobra.Command {
+	serviceCmd := &cobra.Command{
+		Use:   "service",
+		Short: "Manage kandalf Windows service",
+	}
+	serviceCmd.PersistentFlags().StringVarP(&serviceName, "name", "n", defaultServiceName, "Service name")
+
+	serviceCmd.AddCommand(
+		&cobra.Command{
+			Use:   "install",
+			Short: "Install service running the bridge with configuration file from -c flag, service starts on boot",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				failOnError(installService(serviceName, configPath), "Failed to install service")
+				fmt.Fprintf(cmd.OutOrStdout(), "Service %s is installed\n", serviceName)
+			},
+		},
+		&cobra.Command{
+			Use:   "uninstall",
+			Short: "Remove installed service, running service is stopped once it is removed",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				failOnError(uninstallService(serviceName), "Failed to uninstall service")
+				fmt.Fprintf(cmd.OutOrStdout(), "Service %s is uninstalled\n", serviceName)
+			},
+		},
+		&cobra.Command{
+			Use:   "start",
+			Short: "Start installed service",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				failOnError(startService(serviceName), "Failed to start service")
+				fmt.Fprintf(cmd.OutOrStdout(), "Service %s is started\n", serviceName)
+			},
+		},
+		&cobra.Command{
+			Use:   "stop",
+			Short: "Stop running service and wait until buffered messages are drained and it exits",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				failOnError(stopService(serviceName), "Failed to stop service")
+				fmt.Fprintf(cmd.OutOrStdout(), "Service %s is stopped\n", serviceName)
+			},
+		},
+	)
+
+	return serviceCmd
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+func installService(name string, configPath string) error {
+	return errServiceUnsupported
+}
+
+func uninstallService(name string) error {
+	return errServiceUnsupported
+}
+
+func startService(name string) error {
+	return errServiceUnsupported
+}
+
+func stopService(name string) error {
+	return errServiceUnsupported
+}
+
+func runService(name string, run func()) error {
+	return errServiceUnsupported
+}
@@ -0,0 +1,242 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	// stateDumpControl is user-defined service control code that dumps internal state to the log,
+	// e.g. "sc control kandalf 128"
+	stateDumpControl = svc.Cmd(128)
+	// serviceStopTimeout is max amount of time to wait for service to stop, it is longer than default shutdown timeout
+	serviceStopTimeout = 2 * time.Minute
+	// serviceStopWaitHint is amount of time service control manager is told to wait for the next stop progress report
+	serviceStopWaitHint = 10 * time.Second
+	// eventLogID is event id of all the log records written to Windows event log
+	eventLogID = 1
+)
+
+func installService(name string, configPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{"run", "--service", name}
+	if configPath != "" {
+		// service is started in system directory, so relative path would not be found
+		path, err := filepath.Abs(configPath)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-c", path)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return errServiceExists
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: "Kandalf",
+		Description: "RabbitMQ to Kafka bridge",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// service has no console, so its logs are written to event log with service name as source
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return err
+	}
+
+	return nil
+}
+
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return err
+	}
+
+	return eventlog.Remove(name)
+}
+
+func startService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return s.Start()
+}
+
+func stopService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return errServiceStopTimeout
+		}
+		time.Sleep(300 * time.Millisecond)
+
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runService runs the bridge as service until it is stopped by service control manager, logs are written to event log
+func runService(name string, run func()) error {
+	eventLog, err := eventlog.Open(name)
+	if err != nil {
+		return err
+	}
+	defer eventLog.Close()
+	log.AddHook(&eventLogHook{log: eventLog})
+
+	// service is started in system directory, so relative paths in configuration are resolved against
+	// configuration file directory, e.g. worker.bufferDir
+	dir := config.DefaultDir()
+	if configPath != "" {
+		dir = filepath.Dir(configPath)
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+
+	return svc.Run(name, &bridgeService{run: run})
+}
+
+// bridgeService is service control handler of the bridge, service stop, parameters change and state dump control are
+// handled the same way as SIGTERM, SIGHUP and SIGUSR1 are on other platforms
+type bridgeService struct {
+	run func()
+}
+
+// Execute runs the bridge and handles service controls until the bridge exits, bridge exit code is reported as
+// service specific exit code
+func (s *bridgeService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run()
+	}()
+
+	accepts := svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	// stopping reports stop progress, so service control manager waits for buffered messages to be drained
+	var stopping <-chan time.Time
+	var checkPoint uint32
+	for {
+		select {
+		case <-done:
+			return exitCode != exitOK, uint32(exitCode)
+		case <-stopping:
+			checkPoint++
+			changes <- svc.Status{State: svc.StopPending, CheckPoint: checkPoint, WaitHint: uint32(serviceStopWaitHint / time.Millisecond)}
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				changes <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopWaitHint / time.Millisecond)}
+				if stopping == nil {
+					ticker := time.NewTicker(serviceStopWaitHint / 2)
+					defer ticker.Stop()
+					stopping = ticker.C
+				}
+				request(stopRequests)
+			case svc.ParamChange:
+				request(reloadRequests)
+			case stateDumpControl:
+				request(stateDumpRequests)
+			default:
+				log.WithField("control", r.Cmd).Warning("Unexpected service control request")
+			}
+		}
+	}
+}
+
+// eventLogHook writes log records to Windows event log, as service output goes nowhere
+type eventLogHook struct {
+	log *eventlog.Log
+}
+
+func (h *eventLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *eventLogHook) Fire(entry *log.Entry) error {
+	msg, err := entry.String()
+	if err != nil {
+		return err
+	}
+
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel, log.ErrorLevel:
+		return h.log.Error(eventLogID, msg)
+	case log.WarnLevel:
+		return h.log.Warning(eventLogID, msg)
+	default:
+		return h.log.Info(eventLogID, msg)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/replication"
+	"github.com/hellofresh/kandalf/pkg/workers"
+	log "github.com/sirupsen/logrus"
+)
+
+// Application exit codes, so process supervisors and deploy tooling can tell clean shutdown from the one that
+// left messages behind, 1 and 2 are used by failures to start and Go runtime panics
+const (
+	// exitOK is clean shutdown with all the buffered messages published
+	exitOK = 0
+	// exitPartialDrain is shutdown with buffered messages moved to storage or left for RabbitMQ to redeliver
+	exitPartialDrain = 3
+	// exitShutdownTimeout is shutdown that did not complete within shutdown timeout, messages that were not published
+	// are left in disk buffer, replica or RabbitMQ
+	exitShutdownTimeout = 4
+)
+
+// shutdown coordinates graceful shutdown of application components and returns exit code. Replication leadership
+// is handed over first, so the new leader takes replicated pipes over while the instance drains, then consumers are
+// cancelled, worker loop is stopped and buffered messages are published within drain timeout. Connections are closed
+// by deferred calls once it returns, and process exits with exitShutdownTimeout if all of it takes longer than
+// shutdown timeout.
+func shutdown(shutdownConfig config.ShutdownConfig, worker *workers.BridgeWorker, replicatedBuffer *replication.Buffer, queuesHandlers map[string]*amqp.QueuesHandler, interrupt chan bool) int {
+	deadline := time.Now().Add(shutdownConfig.Timeout)
+	time.AfterFunc(shutdownConfig.Timeout, func() {
+		log.WithField("timeout", shutdownConfig.Timeout.String()).Error("Failed to shut down within timeout, exiting")
+		os.Exit(exitShutdownTimeout)
+	})
+
+	if replicatedBuffer != nil {
+		if err := replicatedBuffer.TransferLeadership(); err != nil {
+			log.WithError(err).Warning("Failed to hand replication leadership over, the new leader is elected once instance leaves")
+		}
+		if !replicatedBuffer.IsLeader() {
+			worker.ReleaseReplica()
+		}
+	}
+
+	for _, queuesHandler := range queuesHandlers {
+		queuesHandler.Cancel()
+	}
+	// stop worker loop and queues discovery, so buffered messages are drained without new ones coming,
+	// deferred calls close AMQP connections and store or requeue messages that are not drained
+	close(interrupt)
+
+	drainTimeout := shutdownConfig.DrainTimeout
+	if remaining := time.Until(deadline); remaining < drainTimeout {
+		drainTimeout = remaining
+	}
+	if !worker.Drain(drainTimeout) {
+		return exitPartialDrain
+	}
+
+	return exitOK
+}
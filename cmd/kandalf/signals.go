@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+)
+
+// reloadRequests, stateDumpRequests and stopRequests are handled the same way as reload, state dump and shutdown
+// signals are, they are sent by Windows service control handler, as there are no such signals for services
+var (
+	reloadRequests    = make(chan struct{}, 1)
+	stateDumpRequests = make(chan struct{}, 1)
+	stopRequests      = make(chan struct{}, 1)
+)
+
+// request sends request without blocking, request that is not handled yet is not duplicated
+func request(requests chan<- struct{}) {
+	select {
+	case requests <- struct{}{}:
+	default:
+	}
+}
+
+// notifySignals relays signals to the channel, unlike signal.Notify it relays nothing if signals list is empty
+func notifySignals(c chan<- os.Signal, signals []os.Signal) {
+	if len(signals) > 0 {
+		signal.Notify(c, signals...)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals reload pipes config and stateDumpSignals dump internal state to the log
+var (
+	reloadSignals    = []os.Signal{syscall.SIGHUP}
+	stateDumpSignals = []os.Signal{syscall.SIGUSR1}
+)
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// there are no SIGHUP and SIGUSR1 on windows, pipes config is reloaded and internal state is dumped with service
+// controls instead, see service_windows.go
+var (
+	reloadSignals    []os.Signal
+	stateDumpSignals []os.Signal
+)
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// unknownBuildValue is build metadata value for binaries built without linker flags, e.g. with plain "go build"
+const unknownBuildValue = "unknown"
+
+// buildInfo is metadata of running binary, version, commit and build date are set at build time with linker flags
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+func currentBuild() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	for _, value := range []*string{&info.Version, &info.Commit, &info.BuildDate} {
+		if *value == "" {
+			*value = unknownBuildValue
+		}
+	}
+
+	return info
+}
+
+// fields returns build metadata as log fields
+func (b buildInfo) fields() log.Fields {
+	return log.Fields{
+		"version":    b.Version,
+		"commit":     b.Commit,
+		"build_date": b.BuildDate,
+		"go_version": b.GoVersion,
+		"platform":   b.Platform,
+	}
+}
+
+// printVersion prints application version with its build metadata
+func printVersion(out io.Writer, versionString string) error {
+	info := currentBuild()
+	fmt.Fprintln(out, versionString)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Commit:\t%s\n", info.Commit)
+	fmt.Fprintf(w, "Built:\t%s\n", info.BuildDate)
+	fmt.Fprintf(w, "Go:\t%s %s\n", info.GoVersion, info.Platform)
+	return w.Flush()
+}
@@ -0,0 +1,138 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// webhookTimeout is max amount of time for webhook request
+const webhookTimeout = 10 * time.Second
+
+// Event types alerts are fired on
+const (
+	EventLeadershipAcquired = "leadership-acquired"
+	EventLeadershipLost     = "leadership-lost"
+	EventPublishFailing     = "publish-failing"
+	EventCircuitOpen        = "circuit-open"
+	EventBufferAlert        = "buffer-alert"
+)
+
+// Event severities, they are the same as PagerDuty ones
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// Event is critical event operators are alerted about
+type Event struct {
+	// Type is event type, events of the same type are throttled together
+	Type string
+	// Severity is one of Severity* constants
+	Severity string
+	// Summary is short human readable event description
+	Summary string
+	// Details are event context values
+	Details map[string]interface{}
+}
+
+// Alerter is notified about critical events
+type Alerter interface {
+	// Alert fires alert on event, it must not block, as events are reported from messages handling paths
+	Alert(event Event)
+}
+
+// Notifier is Alerter firing Slack and PagerDuty webhooks
+type Notifier struct {
+	sync.Mutex
+
+	config     config.AlertsConfig
+	nodeID     string
+	httpClient *http.Client
+	// firedAt are times of the last alerts mapped by event type
+	firedAt map[string]time.Time
+	// suppressed are numbers of throttled events since the last alerts mapped by event type
+	suppressed map[string]int
+}
+
+// NewNotifier creates Notifier, it returns nil if no webhooks are configured
+func NewNotifier(alertsConfig config.AlertsConfig, nodeID string) *Notifier {
+	if alertsConfig.SlackWebhookURL == "" && alertsConfig.PagerDutyRoutingKey == "" {
+		return nil
+	}
+
+	return &Notifier{
+		config:     alertsConfig,
+		nodeID:     nodeID,
+		httpClient: &http.Client{Timeout: webhookTimeout},
+		firedAt:    make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+// Alert fires webhooks in background, unless alert of the same event type was fired within throttle interval,
+// events that are throttled are counted and reported with the next alert of their type
+func (n *Notifier) Alert(event Event) {
+	now := time.Now()
+
+	n.Lock()
+	if firedAt, ok := n.firedAt[event.Type]; ok && now.Sub(firedAt) < n.config.Throttle {
+		n.suppressed[event.Type]++
+		n.Unlock()
+		log.WithField("event", event.Type).Debug("Alert is throttled")
+		return
+	}
+	suppressed := n.suppressed[event.Type]
+	n.firedAt[event.Type] = now
+	delete(n.suppressed, event.Type)
+	n.Unlock()
+
+	if suppressed > 0 {
+		details := make(map[string]interface{}, len(event.Details)+1)
+		for k, v := range event.Details {
+			details[k] = v
+		}
+		details["suppressed"] = suppressed
+		event.Details = details
+	}
+
+	go n.fire(event)
+}
+
+// fire posts event to all the configured webhooks
+func (n *Notifier) fire(event Event) {
+	if n.config.SlackWebhookURL != "" {
+		n.post(n.config.SlackWebhookURL, slackPayload(event, n.nodeID), event)
+	}
+	if n.config.PagerDutyRoutingKey != "" {
+		n.post(n.config.PagerDutyURL, pagerDutyTrigger(event, n.nodeID, n.config.PagerDutyRoutingKey), event)
+	}
+}
+
+func (n *Notifier) post(url string, payload interface{}, event Event) {
+	logger := log.WithField("event", event.Type)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.WithError(err).Error("Failed to encode alert")
+		return
+	}
+
+	resp, err := n.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.WithError(err).Error("Failed to fire alert webhook")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		logger.WithError(fmt.Errorf("unexpected response status %s", resp.Status)).Error("Failed to fire alert webhook")
+	}
+}
@@ -0,0 +1,99 @@
+package alerts
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookServer records bodies of webhooks posted to it
+func webhookServer(t *testing.T) (*httptest.Server, chan map[string]interface{}) {
+	bodies := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &body))
+		bodies <- body
+	}))
+
+	return server, bodies
+}
+
+func receive(t *testing.T, bodies chan map[string]interface{}) map[string]interface{} {
+	select {
+	case body := <-bodies:
+		return body
+	case <-time.After(time.Second):
+		require.Fail(t, "webhook is not fired")
+	}
+
+	return nil
+}
+
+func TestNewNotifier(t *testing.T) {
+	assert.Nil(t, NewNotifier(config.AlertsConfig{}, "kandalf-1"))
+	assert.NotNil(t, NewNotifier(config.AlertsConfig{SlackWebhookURL: "http://slack"}, "kandalf-1"))
+}
+
+func TestNotifier_Alert(t *testing.T) {
+	slack, slackBodies := webhookServer(t)
+	defer slack.Close()
+	pagerDuty, pagerDutyBodies := webhookServer(t)
+	defer pagerDuty.Close()
+
+	notifier := NewNotifier(config.AlertsConfig{
+		SlackWebhookURL:     slack.URL,
+		PagerDutyRoutingKey: "routing-key",
+		PagerDutyURL:        pagerDuty.URL,
+		Throttle:            time.Hour,
+	}, "kandalf-1")
+
+	event := Event{
+		Type:     EventCircuitOpen,
+		Severity: SeverityCritical,
+		Summary:  "Circuit breaker is open",
+		Details:  map[string]interface{}{"failures": 100},
+	}
+	notifier.Alert(event)
+
+	assert.Equal(t, map[string]interface{}{
+		"text": "[CRITICAL] kandalf kandalf-1: Circuit breaker is open\n• failures: 100",
+	}, receive(t, slackBodies))
+	assert.Equal(t, map[string]interface{}{
+		"routing_key":  "routing-key",
+		"event_action": "trigger",
+		"dedup_key":    "kandalf/kandalf-1/circuit-open",
+		"payload": map[string]interface{}{
+			"summary":        "Circuit breaker is open",
+			"source":         "kandalf-1",
+			"severity":       "critical",
+			"component":      "kandalf",
+			"class":          "circuit-open",
+			"custom_details": map[string]interface{}{"failures": float64(100)},
+		},
+	}, receive(t, pagerDutyBodies))
+
+	// events of the same type are throttled and counted, while other types are not affected
+	notifier.Alert(event)
+	notifier.Alert(event)
+	notifier.Alert(Event{Type: EventLeadershipLost, Severity: SeverityWarning, Summary: "Lost leadership"})
+	assert.Equal(t, "[WARNING] kandalf kandalf-1: Lost leadership", receive(t, slackBodies)["text"])
+	receive(t, pagerDutyBodies)
+
+	notifier.firedAt[EventCircuitOpen] = time.Now().Add(-2 * time.Hour)
+	notifier.Alert(event)
+	assert.Equal(t, "[CRITICAL] kandalf kandalf-1: Circuit breaker is open\n• failures: 100\n• suppressed: 2",
+		receive(t, slackBodies)["text"])
+	// original event details are not changed
+	assert.Len(t, event.Details, 1)
+}
@@ -0,0 +1,6 @@
+/*
+Package alerts holds webhooks fired on critical events, e.g. replication leadership change or circuit breaker
+opening, so operators are notified in Slack or paged via PagerDuty. Alerts are throttled by event type, so burst
+of events results in a single notification.
+*/
+package alerts
@@ -0,0 +1,62 @@
+package alerts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// slackMessage is Slack incoming webhook message
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+func slackPayload(event Event, nodeID string) slackMessage {
+	text := fmt.Sprintf("[%s] kandalf %s: %s", strings.ToUpper(event.Severity), nodeID, event.Summary)
+
+	keys := make([]string, 0, len(event.Details))
+	for k := range event.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		text += fmt.Sprintf("\n• %s: %v", k, event.Details[k])
+	}
+
+	return slackMessage{Text: text}
+}
+
+// pagerDutyEvent is PagerDuty Events API v2 trigger event
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Component     string                 `json:"component"`
+	Class         string                 `json:"class"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+// pagerDutyTrigger returns trigger event deduplicated by instance and event type, so repeated events of the same
+// problem update open incident instead of opening new ones
+func pagerDutyTrigger(event Event, nodeID string, routingKey string) pagerDutyEvent {
+	return pagerDutyEvent{
+		RoutingKey:  routingKey,
+		EventAction: "trigger",
+		DedupKey:    "kandalf/" + nodeID + "/" + event.Type,
+		Payload: pagerDutyPayload{
+			Summary:       event.Summary,
+			Source:        nodeID,
+			Severity:      event.Severity,
+			Component:     "kandalf",
+			Class:         event.Type,
+			CustomDetails: event.Details,
+		},
+	}
+}
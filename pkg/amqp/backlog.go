@@ -0,0 +1,84 @@
+package amqp
+
+import (
+	"time"
+
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// statsOpBacklog is state metric operation of number of messages ready for delivery in pipe queue
+const statsOpBacklog = "backlog"
+
+// ReportBacklog inspects queues of all the pipes with passive declaration and reports number of messages ready
+// for delivery as "amqp.backlog.<queue>" state metric, so kandalf falling behind at the source is noticed before
+// messages pile up in worker buffer. Nothing is reported while connection is closed.
+func (h *QueuesHandler) ReportBacklog() {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.conn == nil || h.conn.IsClosed() {
+		return
+	}
+
+	var channel *amqp.Channel
+	defer func() {
+		if channel != nil {
+			channel.Close()
+		}
+	}()
+
+	for _, pipe := range h.pipes {
+		if channel == nil {
+			var err error
+			if channel, err = h.conn.Channel(); err != nil {
+				log.WithError(err).Error("Failed to open AMQP channel for queues backlog inspection")
+				return
+			}
+		}
+
+		queue, err := channel.QueueInspect(pipe.ConsumedQueue())
+		if err != nil {
+			log.WithError(err).WithField("queue", pipe.ConsumedQueue()).Warning("Failed to inspect queue backlog")
+			// channel is closed by server on inspection errors, so get a new one for the next pipe
+			channel = nil
+			continue
+		}
+
+		h.backlog[pipe.RabbitQueueName] = queue.Messages
+		h.statsClient.TrackState(statsAMQPSection, bucket.MetricOperation{statsOpBacklog, pipe.RabbitQueueName}, queue.Messages)
+	}
+}
+
+// Backlog returns numbers of messages ready for delivery in pipes queues mapped by queue name, as they were
+// at the last backlog report
+func (h *QueuesHandler) Backlog() map[string]int {
+	h.Lock()
+	defer h.Unlock()
+
+	backlog := make(map[string]int, len(h.backlog))
+	for queue, messages := range h.backlog {
+		backlog[queue] = messages
+	}
+
+	return backlog
+}
+
+// GoReportBacklog reports pipes queues backlog with interval in background until interrupted
+func (h *QueuesHandler) GoReportBacklog(interval time.Duration, interrupt chan bool) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-interrupt:
+				return
+			case <-ticker.C:
+				h.ReportBacklog()
+			}
+		}
+	}()
+}
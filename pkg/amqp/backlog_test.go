@@ -0,0 +1,29 @@
+package amqp
+
+import (
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueuesHandler_Backlog(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	orders, payments := config.Pipe{RabbitQueueName: "orders"}, config.Pipe{RabbitQueueName: "payments"}
+	h := NewQueuesHandler([]config.Pipe{orders, payments}, config.RabbitMQConfig{}, nil, statsClient)
+
+	// backlog is not reported before connection is established
+	h.ReportBacklog()
+	assert.Empty(t, h.Backlog())
+
+	h.backlog["orders"] = 42
+	h.backlog["payments"] = 7
+	backlog := h.Backlog()
+	assert.Equal(t, map[string]int{"orders": 42, "payments": 7}, backlog)
+
+	// returned backlog is a copy and removed pipes are not reported anymore
+	backlog["orders"] = 0
+	h.RemovePipes([]config.Pipe{payments})
+	assert.Equal(t, map[string]int{"orders": 42}, h.Backlog())
+}
@@ -0,0 +1,61 @@
+package amqp
+
+import "sync"
+
+// blockedState is RabbitMQ connection blocked state. Publishing to connection blocked by broker, e.g. because of
+// memory or disk alarm, hangs until broker unblocks it, so publishers wait for the connection to be unblocked instead.
+type blockedState struct {
+	sync.RWMutex
+
+	// unblocked is closed while connection is not blocked
+	unblocked chan struct{}
+}
+
+func newBlockedState() *blockedState {
+	unblocked := make(chan struct{})
+	close(unblocked)
+
+	return &blockedState{unblocked: unblocked}
+}
+
+// set changes blocked state, it returns false if state is the same already
+func (s *blockedState) set(blocked bool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if blocked == isClosed(s.unblocked) {
+		if blocked {
+			s.unblocked = make(chan struct{})
+		} else {
+			close(s.unblocked)
+		}
+		return true
+	}
+
+	return false
+}
+
+func (s *blockedState) isBlocked() bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return !isClosed(s.unblocked)
+}
+
+// wait returns channel that is closed once connection is unblocked, it is closed already while connection
+// is not blocked
+func (s *blockedState) wait() <-chan struct{} {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.unblocked
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
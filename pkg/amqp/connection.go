@@ -1,30 +1,45 @@
 package amqp
 
 import (
+	"net"
+	"sync"
 	"time"
 
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
 	log "github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 )
 
+const defaultLocale = "en_US"
+
 // InitQueuesHandler is a handler function type for AMQP connection
 type InitQueuesHandler func(conn *amqp.Connection) error
 
 // Connection struct holds data for AMQP connection
 type Connection struct {
-	dsn        string
-	initQueues InitQueuesHandler
-	conn       *amqp.Connection
+	sync.RWMutex
+
+	dsn         string
+	config      config.RabbitMQConfig
+	initQueues  InitQueuesHandler
+	conn        *amqp.Connection
+	blocked     *blockedState
+	connected   bool
+	statsClient client.Client
+	// blockedHandlers are notified when broker blocks or unblocks the connection
+	blockedHandlers []func(blocked bool)
 }
 
 // NewConnection instantiates and establishes new AMQP connection
-func NewConnection(dsn string, initQueues InitQueuesHandler) (*Connection, error) {
-	c := &Connection{dsn, initQueues, nil}
+func NewConnection(dsn string, rabbitConfig config.RabbitMQConfig, initQueues InitQueuesHandler, statsClient client.Client) (*Connection, error) {
+	c := &Connection{dsn: dsn, config: rabbitConfig, initQueues: initQueues, blocked: newBlockedState(), statsClient: statsClient}
 
 	if err := c.establishConnection(); nil != err {
 		return c, err
 	}
-	if err := c.initQueues(c.conn); nil != err {
+	if err := c.initQueues(c.connection()); nil != err {
 		return c, err
 	}
 	c.initNotifyClose()
@@ -32,42 +47,177 @@ func NewConnection(dsn string, initQueues InitQueuesHandler) (*Connection, error
 	return c, nil
 }
 
+// PipeDSN returns DSN of AMQP connection for the pipe - DSN with virtual host and credentials overridden
+// by the pipe ones, when they are set. Result is normalised, so pipes sharing connection get the same DSN.
+func PipeDSN(dsn string, pipe config.Pipe) (string, error) {
+	uri, err := amqp.ParseURI(dsn)
+	if err != nil {
+		return "", err
+	}
+
+	if pipe.RabbitVHost != "" {
+		uri.Vhost = pipe.RabbitVHost
+	}
+	if pipe.RabbitUsername != "" {
+		uri.Username = pipe.RabbitUsername
+		uri.Password = pipe.RabbitPassword
+	}
+
+	return uri.String(), nil
+}
+
+// IsBlocked returns true when broker blocked the connection, e.g. because of memory or disk alarm
+func (c *Connection) IsBlocked() bool {
+	return c.blocked.isBlocked()
+}
+
+// Unblocked returns channel that is closed once broker unblocks the connection, it is closed already while
+// connection is not blocked, so publishers wait on it before publishing
+func (c *Connection) Unblocked() <-chan struct{} {
+	return c.blocked.wait()
+}
+
+// NotifyBlocked registers handler that is called when broker blocks or unblocks the connection, it is called
+// right away if the connection is blocked already. Handler must not call connection methods.
+func (c *Connection) NotifyBlocked(handler func(blocked bool)) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.blockedHandlers = append(c.blockedHandlers, handler)
+	if c.blocked.isBlocked() {
+		handler(true)
+	}
+}
+
+// IsConnected returns true while AMQP connection is open, it is false while connection is being re-established
+func (c *Connection) IsConnected() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.connected
+}
+
+// Channel opens new channel on AMQP connection, it fails while connection is being re-established
+func (c *Connection) Channel() (*amqp.Channel, error) {
+	return c.connection().Channel()
+}
+
 // Close closes AMQP connection
 func (c *Connection) Close() error {
-	return c.conn.Close()
+	return c.connection().Close()
 }
 
-func (c *Connection) establishConnection() error {
-	var err error
+// connection returns current AMQP connection, it is replaced by reconnect goroutine, while channels are opened
+// by publishers concurrently
+func (c *Connection) connection() *amqp.Connection {
+	c.RLock()
+	defer c.RUnlock()
 
+	return c.conn
+}
+
+func (c *Connection) establishConnection() error {
 	log.WithField("dsn", c.dsn).Info("Establishing RabbitMQ connection")
-	if c.conn, err = amqp.Dial(c.dsn); nil != err {
+	conn, err := amqp.DialConfig(c.dsn, amqp.Config{
+		Heartbeat:  c.config.Heartbeat,
+		ChannelMax: c.config.ChannelMax,
+		Locale:     defaultLocale,
+		Dial:       c.dial,
+	})
+	if nil != err {
 		return err
 	}
 
+	c.Lock()
+	c.conn = conn
+	c.Unlock()
+
 	return nil
 }
 
+// dial establishes TCP connection with deadline for the whole handshake, zero timeout means no deadline,
+// amqp library clears the deadline once connection is open and heartbeats take over
+func (c *Connection) dial(network, addr string) (net.Conn, error) {
+	if c.config.ConnectionTimeout <= 0 {
+		return net.Dial(network, addr)
+	}
+
+	conn, err := net.DialTimeout(network, addr, c.config.ConnectionTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(c.config.ConnectionTimeout)); err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
 func (c *Connection) initNotifyClose() {
+	conn := c.connection()
+	c.setConnected(true)
+	c.initNotifyBlocked(conn)
+
 	go func() {
-		shutdownError := <-c.conn.NotifyClose(make(chan *amqp.Error))
+		shutdownError := <-conn.NotifyClose(make(chan *amqp.Error))
+		c.setConnected(false)
+		// publishers waiting for closed connection to be unblocked fail on its channels instead
+		c.setBlocked(false)
 		log.WithField("error", shutdownError).Error("Caught AMQP close notification")
 		if nil != shutdownError {
-			log.WithField("timeout", c.conn.Config.Heartbeat).
+			log.WithField("timeout", conn.Config.Heartbeat).
 				Info("Caught AMQP close notification with error, trying to reconnect")
-			c.reEstablishConnection(c.conn.Config.Heartbeat)
+			c.reEstablishConnection(conn.Config.Heartbeat)
+		}
+	}()
+}
+
+func (c *Connection) initNotifyBlocked(conn *amqp.Connection) {
+	c.setBlocked(false)
+
+	go func() {
+		// channel is closed by amqp library on connection shutdown
+		for blocking := range conn.NotifyBlocked(make(chan amqp.Blocking, 1)) {
+			c.setBlocked(blocking.Active)
+			if blocking.Active {
+				log.WithField("reason", blocking.Reason).
+					Warn("RabbitMQ blocked the connection, publishing is paused until broker unblocks it")
+			} else {
+				log.Info("RabbitMQ unblocked the connection")
+			}
 		}
 	}()
 }
 
+func (c *Connection) setBlocked(blocked bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.blocked.set(blocked) {
+		for _, handler := range c.blockedHandlers {
+			handler(blocked)
+		}
+	}
+}
+
+func (c *Connection) setConnected(connected bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.connected = connected
+}
+
 func (c *Connection) reEstablishConnection(timeout time.Duration) {
 	for {
 		time.Sleep(timeout)
-		if err := c.establishConnection(); err != nil {
+		err := c.establishConnection()
+		c.statsClient.TrackOperation(statsAMQPSection, bucket.MetricOperation{statsOpReconnect}, nil, nil == err)
+		if err != nil {
 			log.WithError(err).WithField("timeout", timeout).
 				Error("Failed to establish new connection, will try later")
 		} else {
-			if err := c.initQueues(c.conn); nil != err {
+			if err := c.initQueues(c.connection()); nil != err {
 				log.WithError(err).WithField("timeout", timeout).
 					Error("Failed to init new queues, will try later")
 			} else {
@@ -0,0 +1,192 @@
+package amqp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// consumerRestartTimeout is a pause between attempts to re-establish stopped consumer
+const consumerRestartTimeout = 5 * time.Second
+
+// consumer is a single pipe queue consumer with its own channel
+type consumer struct {
+	sync.Mutex
+
+	conn          *amqp.Connection
+	pipe          config.Pipe
+	tag           string
+	prefetchCount int
+	handler       MessageHandler
+	blocked       *blockedState
+	statsClient   client.Client
+
+	// channel is consumer channel, it is replaced on consumer restart
+	channel *amqp.Channel
+	// stopped is true once consumer is stopped because its pipe is removed or changed, so it is not restarted
+	stopped bool
+	// cancelled is true once consumer is cancelled on shutdown, its channel is left open for deferred settlements
+	cancelled bool
+}
+
+// start opens dedicated channel for pipe queue consumer and consumes messages in go-routine.
+// If consumer stops while connection is still alive, e.g. server cancelled it because queue was deleted,
+// consumer is re-established, otherwise it is left for connection reconnect logic.
+func (c *consumer) start() error {
+	operation := bucket.MetricOperation{statsOpConnect, "channel", c.pipe.RabbitQueueName}
+	channel, err := c.conn.Channel()
+	c.statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
+	if err != nil {
+		log.WithError(err).Error("Failed to open AMQP channel for consumer")
+		return err
+	}
+
+	c.Lock()
+	if c.stopped {
+		c.Unlock()
+		channel.Close()
+		return nil
+	}
+	c.channel = channel
+	c.Unlock()
+
+	if c.prefetchCount > 0 {
+		// limits number of unacknowledged messages, so server stops deliveries while handler is blocked,
+		// but every pipe handler gets its message
+		prefetchCount := c.prefetchCount
+		if prefetchCount < c.pipe.HandlersNumber() {
+			prefetchCount = c.pipe.HandlersNumber()
+		}
+		if err = channel.Qos(prefetchCount, 0, false); err != nil {
+			log.WithError(err).Error("Failed to set AMQP channel prefetch count")
+			return err
+		}
+	}
+
+	cancellations := channel.NotifyCancel(make(chan string, 1))
+
+	operation = bucket.MetricOperation{statsOpConnect, "consume", c.pipe.RabbitQueueName}
+	deliveries, err := channel.Consume(c.pipe.ConsumedQueue(), c.tag, false, false, false, false, nil)
+	c.statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
+	if err != nil {
+		log.WithError(err).Error("Failed to register a consumer")
+		return err
+	}
+
+	go func() {
+		consumeMessages(channel, deliveries, c.pipe, c.handler, c.blocked, c.statsClient)
+
+		select {
+		case <-cancellations:
+			log.WithField("pipe", c.pipe.String()).WithField("consumer", c.tag).
+				Warn("Consumer was cancelled by server")
+			c.statsClient.TrackMetric(statsAMQPSection, bucket.MetricOperation{statsOpCancel, c.pipe.RabbitQueueName})
+		default:
+		}
+		if c.isCancelled() {
+			// channel is closed with connection, so messages handled already are still settled
+			log.WithField("consumer", c.tag).Info("Consumer cancelled")
+			return
+		}
+		// channel may be already closed by server, nothing to do with the error here
+		channel.Close()
+
+		if c.isStopped() {
+			log.WithField("consumer", c.tag).Info("Consumer stopped")
+			return
+		}
+		c.restart()
+	}()
+
+	return nil
+}
+
+// stop closes consumer channel, so server requeues messages that are delivered, but not acknowledged yet,
+// and consumer is not restarted
+func (c *consumer) stop() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.stopped = true
+	if c.channel != nil {
+		// channel may be already closed by server, nothing to do with the error here
+		c.channel.Close()
+	}
+}
+
+// cancel stops deliveries to consumer, but keeps its channel open, so messages that are delivered already are
+// acknowledged once they are handled, and consumer is not restarted
+func (c *consumer) cancel() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.stopped = true
+	c.cancelled = true
+	if c.channel != nil {
+		if err := c.channel.Cancel(c.tag, false); err != nil {
+			// channel is already closed, so server requeues unacknowledged messages anyway
+			log.WithError(err).WithField("consumer", c.tag).Warning("Failed to cancel consumer")
+		}
+	}
+}
+
+func (c *consumer) isCancelled() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.cancelled
+}
+
+func (c *consumer) isStopped() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.stopped
+}
+
+func (c *consumer) restart() {
+	for {
+		if c.isStopped() {
+			return
+		}
+		if c.conn.IsClosed() {
+			log.WithField("consumer", c.tag).
+				Debug("AMQP connection is closed, consumer will be started on reconnect")
+			return
+		}
+
+		log.WithField("consumer", c.tag).WithField("timeout", consumerRestartTimeout).
+			Info("Consumer stopped while connection is alive, trying to re-establish it")
+		time.Sleep(consumerRestartTimeout)
+
+		if err := redeclareQueue(c.conn, c.pipe, c.statsClient); err != nil {
+			log.WithError(err).WithField("consumer", c.tag).Error("Failed to re-declare queue, will try later")
+			continue
+		}
+
+		if err := c.start(); err != nil {
+			log.WithError(err).WithField("consumer", c.tag).Error("Failed to re-establish consumer, will try later")
+			continue
+		}
+
+		log.WithField("consumer", c.tag).Info("Consumer re-established")
+		return
+	}
+}
+
+// redeclareQueue declares pipe queues on a short-living channel, as channel is closed by server
+// on any declaration error
+func redeclareQueue(conn *amqp.Connection, pipe config.Pipe, statsClient client.Client) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	return declarePipe(channel, pipe, statsClient)
+}
@@ -0,0 +1,35 @@
+package amqp
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Delivery is a message consumed from AMQP broker, it does not depend on protocol message was consumed with
+type Delivery struct {
+	Body          []byte
+	Exchange      string
+	RoutingKey    string
+	Headers       map[string]interface{}
+	ContentType   string
+	CorrelationID string
+	MessageID     string
+	Timestamp     time.Time
+	// Settle acknowledges, rejects or requeues message according to handling error the same way as MessageHandler
+	// result does, it must be called exactly once if MessageHandler returns ErrAckDeferred
+	Settle func(err error)
+}
+
+func newDelivery(msg amqp.Delivery) Delivery {
+	return Delivery{
+		Body:          msg.Body,
+		Exchange:      msg.Exchange,
+		RoutingKey:    msg.RoutingKey,
+		Headers:       msg.Headers,
+		ContentType:   msg.ContentType,
+		CorrelationID: msg.CorrelationId,
+		MessageID:     msg.MessageId,
+		Timestamp:     msg.Timestamp,
+	}
+}
@@ -0,0 +1,164 @@
+package amqp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	discoveryRequestTimeout = 10 * time.Second
+
+	statsOpDiscovery = "discovery"
+)
+
+// Discovery finds queues matching name pattern using RabbitMQ management HTTP API and creates pipes for them
+type Discovery struct {
+	config      config.DiscoveryConfig
+	statsClient client.Client
+
+	apiURL        *url.URL
+	queuePattern  *regexp.Regexp
+	topicTemplate *template.Template
+	httpClient    *http.Client
+}
+
+type managementQueue struct {
+	Name  string `json:"name"`
+	VHost string `json:"vhost"`
+}
+
+// TopicTemplateData is data available in discovered pipe topic template
+type TopicTemplateData struct {
+	// Queue is discovered queue name
+	Queue string
+	// VHost is discovered queue virtual host
+	VHost string
+}
+
+// NewDiscovery instantiates new queues discovery
+func NewDiscovery(discoveryConfig config.DiscoveryConfig, statsClient client.Client) (*Discovery, error) {
+	apiURL, err := url.Parse(discoveryConfig.ManagementURL)
+	if err != nil {
+		return nil, err
+	}
+
+	queuePattern, err := regexp.Compile(discoveryConfig.QueuePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	topicTemplate, err := template.New("topic").Parse(discoveryConfig.TopicTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Discovery{
+		config:        discoveryConfig,
+		statsClient:   statsClient,
+		apiURL:        apiURL,
+		queuePattern:  queuePattern,
+		topicTemplate: topicTemplate,
+		httpClient:    &http.Client{Timeout: discoveryRequestTimeout},
+	}, nil
+}
+
+// Discover requests queues list from management API and returns pipes for queues matching the pattern
+func (d *Discovery) Discover() ([]config.Pipe, error) {
+	queues, err := d.listQueues()
+
+	operation := bucket.MetricOperation{statsOpDiscovery, "list"}
+	d.statsClient.TrackOperation(statsAMQPSection, operation, nil, err == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var pipes []config.Pipe
+	for _, queue := range queues {
+		if !d.queuePattern.MatchString(queue.Name) {
+			continue
+		}
+
+		var topic bytes.Buffer
+		if err := d.topicTemplate.Execute(&topic, TopicTemplateData{Queue: queue.Name, VHost: queue.VHost}); err != nil {
+			return nil, err
+		}
+
+		pipes = append(pipes, config.Pipe{
+			Protocol:            config.ProtocolAMQP091,
+			KafkaTopic:          topic.String(),
+			RabbitQueueName:     queue.Name,
+			RabbitVHost:         queue.VHost,
+			RabbitExistingQueue: true,
+			RabbitConsumers:     1,
+		})
+	}
+
+	return pipes, nil
+}
+
+// Go runs discovery periodically in go-routine and adds discovered pipes to the handler
+func (d *Discovery) Go(queuesHandler *QueuesHandler, interrupt chan bool) {
+	ticker := time.NewTicker(d.config.Interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-interrupt:
+				return
+			case <-ticker.C:
+				pipes, err := d.Discover()
+				if err != nil {
+					log.WithError(err).Error("Failed to discover queues")
+					continue
+				}
+				queuesHandler.AddPipes(pipes)
+			}
+		}
+	}()
+}
+
+func (d *Discovery) listQueues() ([]managementQueue, error) {
+	// management API expects vhost as a single path segment, so default "/" vhost is sent as "%2F"
+	endpoint := *d.apiURL
+	endpoint.User = nil
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(endpoint.String(), "/")+"/api/queues/"+url.PathEscape(d.config.VHost), nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.apiURL.User != nil {
+		password, _ := d.apiURL.User.Password()
+		req.SetBasicAuth(d.apiURL.User.Username(), password)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("management API responded with status %d", resp.StatusCode)
+	}
+
+	var queues []managementQueue
+	if err := json.NewDecoder(resp.Body).Decode(&queues); err != nil {
+		return nil, err
+	}
+
+	return queues, nil
+}
@@ -0,0 +1,75 @@
+package amqp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscovery_Discover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/queues/%2F", r.URL.EscapedPath())
+
+		user, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "guest", user)
+		assert.Equal(t, "secret", password)
+
+		w.Write([]byte(`[{"name":"orders.created","vhost":"/"},{"name":"users.created","vhost":"/"},{"name":"orders.paid","vhost":"/"}]`))
+	}))
+	defer server.Close()
+
+	managementURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	managementURL.User = url.UserPassword("guest", "secret")
+
+	statsClient, _ := stats.NewClient("memory://")
+	discovery, err := NewDiscovery(config.DiscoveryConfig{
+		ManagementURL: managementURL.String(),
+		VHost:         "/",
+		QueuePattern:  `^orders\.`,
+		TopicTemplate: "rabbit-{{.Queue}}",
+	}, statsClient)
+	require.NoError(t, err)
+
+	pipes, err := discovery.Discover()
+	require.NoError(t, err)
+	require.Len(t, pipes, 2)
+
+	assert.Equal(t, "orders.created", pipes[0].RabbitQueueName)
+	assert.Equal(t, "rabbit-orders.created", pipes[0].KafkaTopic)
+	assert.True(t, pipes[0].RabbitExistingQueue)
+	assert.Equal(t, "/", pipes[0].RabbitVHost)
+	assert.Equal(t, "orders.paid", pipes[1].RabbitQueueName)
+	assert.Equal(t, "rabbit-orders.paid", pipes[1].KafkaTopic)
+}
+
+func TestDiscovery_Discover_error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	statsClient, _ := stats.NewClient("memory://")
+	discovery, err := NewDiscovery(config.DiscoveryConfig{ManagementURL: server.URL, VHost: "/", TopicTemplate: "{{.Queue}}"}, statsClient)
+	require.NoError(t, err)
+
+	_, err = discovery.Discover()
+	assert.Error(t, err)
+}
+
+func TestNewDiscovery_error(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+
+	_, err := NewDiscovery(config.DiscoveryConfig{QueuePattern: "(unclosed", TopicTemplate: "{{.Queue}}"}, statsClient)
+	assert.Error(t, err)
+
+	_, err = NewDiscovery(config.DiscoveryConfig{TopicTemplate: "{{.Queue"}, statsClient)
+	assert.Error(t, err)
+}
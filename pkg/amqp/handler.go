@@ -1,6 +1,10 @@
 package amqp
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+
 	"github.com/hellofresh/kandalf/pkg/config"
 	"github.com/hellofresh/stats-go/bucket"
 	"github.com/hellofresh/stats-go/client"
@@ -9,92 +13,450 @@ import (
 )
 
 const (
-	exchangeTypeTopic = "topic"
+	headersMatchArgument = "x-match"
 
 	statsAMQPSection = "amqp"
 	statsOpConnect   = "connect"
 	statsOpConsume   = "consume"
+	statsOpCancel    = "cancel"
+	statsOpReconnect = "reconnect"
 )
 
+// ErrRejectMessage is an error MessageHandler returns for messages that must not be redelivered,
+// such messages are rejected without requeue, so broker routes them to dead letter exchange, if there is one
+var ErrRejectMessage = errors.New("message is rejected")
+
+// ErrAckDeferred is an error MessageHandler returns for messages it settles later with Delivery.Settle,
+// e.g. once they are published
+var ErrAckDeferred = errors.New("message acknowledgement is deferred")
+
 // MessageHandler is a handler function type for consumed messages
-type MessageHandler func(body []byte, pipe config.Pipe) error
+type MessageHandler func(msg Delivery, pipe config.Pipe) error
+
+// QueuesHandler declares queues and starts consumers for pipes on every AMQP (re)connection
+type QueuesHandler struct {
+	sync.Mutex
+
+	pipes         []config.Pipe
+	prefetchCount int
+	handler       MessageHandler
+	statsClient   client.Client
+	conn          *amqp.Connection
+	// blocked is blocked state of handler connection, retry queues publishes wait while it is blocked
+	blocked *blockedState
+	// consumers are running consumers of pipes mapped by pipe queue name
+	consumers map[string][]*consumer
+	// backlog are numbers of messages ready for delivery in pipes queues mapped by queue name
+	backlog map[string]int
+	// cancelled is true once consumption is cancelled on shutdown, so consumers are not started on reconnect
+	cancelled bool
+	// leader is true while instance is replication leader, replicated pipes are consumed by the leader only
+	leader bool
+}
 
 // NewQueuesHandler instantiates queues initialisation handler
-func NewQueuesHandler(pipes []config.Pipe, handler MessageHandler, statsClient client.Client) InitQueuesHandler {
-	return func(conn *amqp.Connection) error {
-		operation := bucket.MetricOperation{statsOpConnect, "channel"}
-		channel, err := conn.Channel()
-		statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
-		if err != nil {
-			log.WithError(err).Error("Failed to open AMQP channel")
+func NewQueuesHandler(pipes []config.Pipe, rabbitConfig config.RabbitMQConfig, handler MessageHandler, statsClient client.Client) *QueuesHandler {
+	return &QueuesHandler{
+		pipes:         pipes,
+		prefetchCount: rabbitConfig.PrefetchCount,
+		handler:       handler,
+		statsClient:   statsClient,
+		blocked:       newBlockedState(),
+		consumers:     make(map[string][]*consumer),
+		backlog:       make(map[string]int),
+	}
+}
+
+// Init declares queues and starts consumers for all known pipes, it is InitQueuesHandler for AMQP connection
+func (h *QueuesHandler) Init(conn *amqp.Connection) error {
+	h.Lock()
+	defer h.Unlock()
+
+	h.conn = conn
+	// consumers of the previous connection are stopped with it
+	h.consumers = make(map[string][]*consumer)
+	if h.cancelled {
+		return nil
+	}
+
+	operation := bucket.MetricOperation{statsOpConnect, "channel"}
+	channel, err := conn.Channel()
+	h.statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
+	if err != nil {
+		log.WithError(err).Error("Failed to open AMQP channel")
+		return err
+	}
+	// channel is used for declarations only, consumers open their own channels
+	defer channel.Close()
+
+	for _, pipe := range h.pipes {
+		if err = h.startPipe(channel, pipe); err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// AddPipes starts consuming for pipes that are not known to the handler yet, pipes are matched by queue name.
+// Pipes that failed to start are not remembered, so they can be added again later. Returned errors are start
+// errors of the pipes by their index, nil for started pipes and pipes that are started on reconnect.
+func (h *QueuesHandler) AddPipes(pipes []config.Pipe) []error {
+	h.Lock()
+	defer h.Unlock()
+
+	errs := make([]error, len(pipes))
+	var channel *amqp.Channel
+	for i, pipe := range pipes {
+		if h.hasPipe(pipe.RabbitQueueName) {
+			continue
+		}
+
+		log.WithField("pipe", pipe.String()).Info("Adding new pipe")
+		if h.cancelled || h.conn == nil || h.conn.IsClosed() {
+			// pipe will be started on reconnect with all the others, unless consumption is cancelled
+			h.pipes = append(h.pipes, pipe)
+			continue
+		}
 
-		for _, pipe := range pipes {
-			operation = bucket.MetricOperation{statsOpConnect, "exchange", pipe.RabbitExchangeName}
-			err = channel.ExchangeDeclare(
-				pipe.RabbitExchangeName,
-				exchangeTypeTopic,
-				!pipe.RabbitTransientExchange,
-				false,
-				false,
-				false,
-				nil,
-			)
-			statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
-			if err != nil {
-				log.WithError(err).Error("Failed to declare exchange")
-				return err
+		if channel == nil {
+			var err error
+			if channel, err = h.conn.Channel(); err != nil {
+				log.WithError(err).Error("Failed to open AMQP channel for new pipes")
+				for j := i; j < len(pipes); j++ {
+					errs[j] = err
+				}
+				return errs
 			}
+			defer channel.Close()
+		}
+
+		if err := h.startPipe(channel, pipe); err != nil {
+			log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to start new pipe")
+			errs[i] = err
+			// channel is closed by server on declaration errors, so get a new one for the next pipe
+			channel = nil
+			continue
+		}
+		h.pipes = append(h.pipes, pipe)
+	}
+
+	return errs
+}
+
+// RemovePipes stops consuming for pipes known to the handler, pipes are matched by queue name. Messages that are
+// delivered to pipe consumers, but not acknowledged yet, are requeued by server.
+func (h *QueuesHandler) RemovePipes(pipes []config.Pipe) {
+	h.Lock()
+	defer h.Unlock()
 
-			operation = bucket.MetricOperation{statsOpConnect, "queue", pipe.RabbitQueueName}
-			queue, err := channel.QueueDeclare(pipe.RabbitQueueName, pipe.RabbitDurableQueue, pipe.RabbitAutoDeleteQueue, false, true, nil)
-			statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
-			if err != nil {
-				log.WithError(err).Error("Failed to declare queue")
-				return err
+	for _, pipe := range pipes {
+		if !h.hasPipe(pipe.RabbitQueueName) {
+			continue
+		}
+
+		log.WithField("pipe", pipe.String()).Info("Removing pipe")
+		for _, c := range h.consumers[pipe.RabbitQueueName] {
+			c.stop()
+		}
+		delete(h.consumers, pipe.RabbitQueueName)
+		delete(h.backlog, pipe.RabbitQueueName)
+
+		for i := range h.pipes {
+			if h.pipes[i].RabbitQueueName == pipe.RabbitQueueName {
+				h.pipes = append(h.pipes[:i], h.pipes[i+1:]...)
+				break
 			}
+		}
+	}
+}
 
-			for i := range pipe.RabbitRoutingKey {
-				operation = bucket.MetricOperation{statsOpConnect, "bind", pipe.RabbitRoutingKey[i]}
-				err = channel.QueueBind(queue.Name, pipe.RabbitRoutingKey[i], pipe.RabbitExchangeName, true, nil)
-				statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
-				if err != nil {
-					log.WithError(err).Error("Failed to bind the queue")
-					return err
-				}
+// SetLeader starts consumers of replicated pipes when instance becomes replication leader and stops them when it
+// loses leadership, so followers do not take replicated pipes messages they can not accept. Messages delivered
+// to stopped consumers, but not acknowledged yet, are requeued by server.
+func (h *QueuesHandler) SetLeader(leader bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.leader == leader {
+		return
+	}
+	h.leader = leader
+
+	if !leader {
+		for _, pipe := range h.pipes {
+			if !pipe.Replicated {
+				continue
+			}
+			log.WithField("pipe", pipe.String()).Info("Stopping replicated pipe consumers on follower")
+			for _, c := range h.consumers[pipe.RabbitQueueName] {
+				c.stop()
 			}
+			delete(h.consumers, pipe.RabbitQueueName)
+		}
+		return
+	}
+
+	if h.cancelled || h.conn == nil || h.conn.IsClosed() {
+		// replicated pipes are started on reconnect with all the others, unless consumption is cancelled
+		return
+	}
 
-			ch, err := channel.Consume(queue.Name, queue.Name+"_consumer", false, false, false, false, nil)
-			statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
-			if err != nil {
-				log.WithError(err).Error("Failed to register a consumer")
-				return nil
+	var channel *amqp.Channel
+	for _, pipe := range h.pipes {
+		if !pipe.Replicated {
+			continue
+		}
+
+		if channel == nil {
+			var err error
+			if channel, err = h.conn.Channel(); err != nil {
+				log.WithError(err).Error("Failed to open AMQP channel for replicated pipes")
+				return
 			}
+			defer channel.Close()
+		}
+
+		log.WithField("pipe", pipe.String()).Info("Starting replicated pipe consumers on leader")
+		if err := h.startPipe(channel, pipe); err != nil {
+			log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to start replicated pipe")
+			// channel is closed by server on declaration errors, so get a new one for the next pipe
+			channel = nil
+		}
+	}
+}
+
+// SetBlocked sets blocked state of handler connection, it is a Connection.NotifyBlocked handler. Failed messages
+// of pipes with retry queues are held while connection is blocked, instead of hanging on publishing.
+func (h *QueuesHandler) SetBlocked(blocked bool) {
+	h.blocked.set(blocked)
+}
+
+// Cancel stops deliveries to consumers of all the pipes on shutdown, so buffered messages are drained without new
+// ones coming. Channels are kept open, so messages that are delivered already are acknowledged once they are
+// handled, the rest is requeued by server when connection is closed.
+func (h *QueuesHandler) Cancel() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.cancelled = true
+	for _, consumers := range h.consumers {
+		for _, c := range consumers {
+			c.cancel()
+		}
+	}
+}
+
+// Pipes returns pipes known to the handler, including pipes that are consumed once connection is established
+func (h *QueuesHandler) Pipes() []config.Pipe {
+	h.Lock()
+	defer h.Unlock()
+
+	pipes := make([]config.Pipe, len(h.pipes))
+	copy(pipes, h.pipes)
 
-			go consumeMessages(ch, pipe, handler, statsClient)
+	return pipes
+}
+
+func (h *QueuesHandler) hasPipe(queueName string) bool {
+	for _, pipe := range h.pipes {
+		if pipe.RabbitQueueName == queueName {
+			return true
 		}
+	}
+
+	return false
+}
 
+func (h *QueuesHandler) startPipe(channel *amqp.Channel, pipe config.Pipe) error {
+	if err := declarePipe(channel, pipe, h.statsClient); err != nil {
+		return err
+	}
+	if pipe.Replicated && !h.leader {
+		// queue keeps messages for consumers of the leader, they are started once instance becomes the leader
+		log.WithField("pipe", pipe.String()).Debug("Instance is not replication leader, replicated pipe is not consumed")
 		return nil
 	}
+
+	consumers := make([]*consumer, 0, consumersNumber(pipe))
+	for i := 0; i < consumersNumber(pipe); i++ {
+		c := &consumer{
+			conn:          h.conn,
+			pipe:          pipe,
+			tag:           consumerTag(pipe, i),
+			prefetchCount: h.prefetchCount,
+			handler:       h.handler,
+			blocked:       h.blocked,
+			statsClient:   h.statsClient,
+		}
+		if err := c.start(); err != nil {
+			// pipe is started again as a whole, so consumers started already must not be left running
+			for _, started := range consumers {
+				started.stop()
+			}
+			return err
+		}
+		consumers = append(consumers, c)
+	}
+	h.consumers[pipe.RabbitQueueName] = consumers
+
+	return nil
 }
 
-func consumeMessages(messages <-chan amqp.Delivery, pipe config.Pipe, handler MessageHandler, statsClient client.Client) {
-	for msg := range messages {
-		err := handler(msg.Body, pipe)
+// declarePipe declares all the queues and bindings pipe requires
+func declarePipe(channel *amqp.Channel, pipe config.Pipe, statsClient client.Client) error {
+	if pipe.RabbitExistingQueue {
+		log.WithField("queue", pipe.RabbitQueueName).
+			Info("Pipe uses pre-existing queue, skipping exchange and queue declaration")
+	} else if err := declareQueue(channel, pipe, statsClient); err != nil {
+		return err
+	}
+
+	if pipe.RabbitRetry != nil && !pipe.DryRun {
+		return declareRetryQueues(channel, pipe, statsClient)
+	}
 
-		operation := bucket.MetricOperation{statsOpConsume, pipe.RabbitQueueName}
+	return nil
+}
+
+func declareQueue(channel *amqp.Channel, pipe config.Pipe, statsClient client.Client) error {
+	operation := bucket.MetricOperation{statsOpConnect, "exchange", pipe.RabbitExchangeName}
+	err := channel.ExchangeDeclare(
+		pipe.RabbitExchangeName,
+		exchangeType(pipe),
+		!pipe.RabbitTransientExchange,
+		false,
+		false,
+		false,
+		nil,
+	)
+	statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
+	if err != nil {
+		log.WithError(err).Error("Failed to declare exchange")
+		return err
+	}
+
+	durable, autoDelete := pipe.RabbitDurableQueue, pipe.RabbitAutoDeleteQueue
+	if pipe.DryRun {
+		// dry run queue holds copies of pipe messages only while dry run instance is consuming them
+		durable, autoDelete = false, true
+	}
+	operation = bucket.MetricOperation{statsOpConnect, "queue", pipe.ConsumedQueue()}
+	queue, err := channel.QueueDeclare(pipe.ConsumedQueue(), durable, autoDelete, false, true, nil)
+	statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
+	if err != nil {
+		log.WithError(err).Error("Failed to declare queue")
+		return err
+	}
+
+	routingKeys := pipe.RabbitRoutingKey
+	if len(routingKeys) == 0 {
+		// headers exchange ignores routing key, so the queue is bound once by binding arguments only
+		routingKeys = []string{""}
+	}
+	bindingArgs := bindingArguments(pipe)
+	for i := range routingKeys {
+		operation = bucket.MetricOperation{statsOpConnect, "bind", routingKeys[i]}
+		err = channel.QueueBind(queue.Name, routingKeys[i], pipe.RabbitExchangeName, true, bindingArgs)
 		statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
 		if err != nil {
-			log.WithError(err).WithField("pipe", pipe.String()).
-				Error("Failed to consume AMQP message")
-			if err = msg.Nack(false, true); err != nil {
-				log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to NAck AMQP message")
-			}
-		} else {
-			if err = msg.Ack(false); err != nil {
-				log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to NAck AMQP message")
-			}
+			log.WithError(err).Error("Failed to bind the queue")
+			return err
+		}
+	}
+
+	return nil
+}
+
+func consumersNumber(pipe config.Pipe) int {
+	if pipe.RabbitConsumers < 1 || pipe.RabbitStrictOrdering {
+		return 1
+	}
+
+	return pipe.RabbitConsumers
+}
+
+func consumerTag(pipe config.Pipe, i int) string {
+	if i == 0 {
+		return pipe.RabbitQueueName + "_consumer"
+	}
+
+	return fmt.Sprintf("%s_consumer_%d", pipe.RabbitQueueName, i)
+}
+
+func exchangeType(pipe config.Pipe) string {
+	if pipe.RabbitExchangeType == "" {
+		return config.ExchangeTypeTopic
+	}
+
+	return pipe.RabbitExchangeType
+}
+
+func bindingArguments(pipe config.Pipe) amqp.Table {
+	if len(pipe.RabbitBindingArguments) == 0 && pipe.RabbitHeadersMatch == "" {
+		return nil
+	}
+
+	args := amqp.Table{}
+	for k, v := range pipe.RabbitBindingArguments {
+		args[k] = v
+	}
+	if pipe.RabbitHeadersMatch != "" {
+		args[headersMatchArgument] = pipe.RabbitHeadersMatch
+	}
+
+	return args
+}
+
+// consumeMessages handles consumer messages with pipe handlers number of goroutines and blocks until
+// deliveries channel is closed and all the taken messages are handled
+func consumeMessages(channel *amqp.Channel, messages <-chan amqp.Delivery, pipe config.Pipe, handler MessageHandler, blocked *blockedState, statsClient client.Client) {
+	var wg sync.WaitGroup
+	for i := 0; i < pipe.HandlersNumber(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleMessages(channel, messages, pipe, handler, blocked, statsClient)
+		}()
+	}
+	wg.Wait()
+}
+
+func handleMessages(channel *amqp.Channel, messages <-chan amqp.Delivery, pipe config.Pipe, handler MessageHandler, blocked *blockedState, statsClient client.Client) {
+	for msg := range messages {
+		// message is captured by deferred settlement, so it must not be shared between iterations
+		msg := msg
+		delivery := newDelivery(msg)
+		delivery.Settle = func(err error) {
+			settleMessage(channel, msg, pipe, err, blocked, statsClient)
+		}
+
+		if err := handler(delivery, pipe); err != ErrAckDeferred {
+			settleMessage(channel, msg, pipe, err, blocked, statsClient)
+		}
+	}
+}
+
+// settleMessage acknowledges, rejects or requeues message according to handling error, it is safe to call
+// concurrently from different goroutines, as channel methods are synchronized
+func settleMessage(channel *amqp.Channel, msg amqp.Delivery, pipe config.Pipe, err error, blocked *blockedState, statsClient client.Client) {
+	operation := bucket.MetricOperation{statsOpConsume, pipe.RabbitQueueName}
+	statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
+	if err == ErrRejectMessage {
+		if err = msg.Nack(false, false); err != nil {
+			log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to reject AMQP message")
+		}
+	} else if err != nil {
+		log.WithError(err).WithField("pipe", pipe.String()).
+			Error("Failed to consume AMQP message")
+		if pipe.RabbitRetry != nil && !pipe.DryRun {
+			retryMessage(channel, msg, pipe, blocked, statsClient)
+		} else if err = msg.Nack(false, true); err != nil {
+			log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to NAck AMQP message")
+		}
+	} else {
+		if err = msg.Ack(false); err != nil {
+			log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to NAck AMQP message")
 		}
 	}
 }
@@ -0,0 +1,103 @@
+package amqp
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumeMessages_concurrency(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	pipe := config.Pipe{RabbitQueueName: "orders", Concurrency: 3}
+
+	messages := make(chan amqp.Delivery, pipe.Concurrency)
+	for i := 0; i < pipe.Concurrency; i++ {
+		messages <- amqp.Delivery{}
+	}
+	close(messages)
+
+	// every handler blocks until all the messages are taken, so test hangs unless they are handled concurrently
+	var started, taken sync.WaitGroup
+	started.Add(pipe.Concurrency)
+	taken.Add(1)
+	handler := func(delivery Delivery, pipe config.Pipe) error {
+		started.Done()
+		taken.Wait()
+		return ErrAckDeferred
+	}
+
+	go func() {
+		started.Wait()
+		taken.Done()
+	}()
+	consumeMessages(nil, messages, pipe, handler, newBlockedState(), statsClient)
+
+	assert.Empty(t, messages)
+}
+
+func TestQueuesHandler_Cancel(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	h := NewQueuesHandler([]config.Pipe{{RabbitQueueName: "orders"}}, config.RabbitMQConfig{}, nil, statsClient)
+	c := &consumer{pipe: h.pipes[0], tag: consumerTag(h.pipes[0], 0)}
+	h.consumers["orders"] = []*consumer{c}
+
+	h.Cancel()
+	assert.True(t, c.isCancelled())
+	assert.True(t, c.isStopped())
+
+	// consumers are not started again on reconnect, while pipes are kept
+	assert.NoError(t, h.Init(nil))
+	assert.Empty(t, h.consumers)
+	assert.Len(t, h.pipes, 1)
+}
+
+func TestQueuesHandler_SetLeader(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	h := NewQueuesHandler([]config.Pipe{{RabbitQueueName: "orders", Replicated: true}, {RabbitQueueName: "payments"}}, config.RabbitMQConfig{}, nil, statsClient)
+	h.SetLeader(true)
+	replicated := &consumer{pipe: h.pipes[0], tag: consumerTag(h.pipes[0], 0)}
+	plain := &consumer{pipe: h.pipes[1], tag: consumerTag(h.pipes[1], 0)}
+	h.consumers["orders"] = []*consumer{replicated}
+	h.consumers["payments"] = []*consumer{plain}
+
+	// follower stops consuming replicated pipes only, while pipes are kept
+	h.SetLeader(false)
+	assert.True(t, replicated.isStopped())
+	assert.False(t, plain.isStopped())
+	assert.Len(t, h.consumers, 1)
+	assert.Len(t, h.pipes, 2)
+
+	// consumers of replicated pipes are started on reconnect once instance becomes the leader
+	h.SetLeader(true)
+	assert.True(t, h.leader)
+	assert.Len(t, h.consumers, 1)
+}
+
+func TestQueuesHandler_Pipes(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	h := NewQueuesHandler([]config.Pipe{{RabbitQueueName: "orders"}}, config.RabbitMQConfig{}, nil, statsClient)
+
+	// pipes added before connection is established are known to the handler
+	errs := h.AddPipes([]config.Pipe{{RabbitQueueName: "orders"}, {RabbitQueueName: "payments"}})
+	assert.Equal(t, []error{nil, nil}, errs)
+	pipes := h.Pipes()
+	assert.Equal(t, []config.Pipe{{RabbitQueueName: "orders"}, {RabbitQueueName: "payments"}}, pipes)
+
+	// returned pipes are a copy
+	pipes[0].RabbitQueueName = "changed"
+	assert.Equal(t, "orders", h.Pipes()[0].RabbitQueueName)
+}
+
+func TestConsumerTag(t *testing.T) {
+	pipe := config.Pipe{RabbitQueueName: "orders"}
+	assert.Equal(t, "orders", pipe.ConsumedQueue())
+
+	// dry run pipe gets copies of messages to its own queue
+	pipe.DryRun = true
+	assert.Equal(t, "orders.dry-run", pipe.ConsumedQueue())
+	assert.Equal(t, "orders_consumer", consumerTag(pipe, 0))
+}
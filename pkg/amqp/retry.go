@@ -0,0 +1,116 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// retryAttemptHeader is a message header with the number of redelivery attempts made for the message
+	retryAttemptHeader = "x-kandalf-retry-attempt"
+
+	statsOpRetry = "retry"
+)
+
+// retryQueueName returns name of the retry queue, that holds messages for the given delay
+// and dead-letters them back to pipe queue
+func retryQueueName(pipe config.Pipe, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", pipe.RabbitQueueName, delay/time.Millisecond)
+}
+
+// declareRetryQueues declares retry queue for every pipe retry delay. Retry queues have no consumers,
+// messages expire in them after queue TTL and are dead-lettered with default exchange back to pipe queue.
+func declareRetryQueues(channel *amqp.Channel, pipe config.Pipe, statsClient client.Client) error {
+	for _, delay := range pipe.RabbitRetry.Delays() {
+		name := retryQueueName(pipe, delay)
+		args := amqp.Table{
+			"x-message-ttl":             int64(delay / time.Millisecond),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": pipe.RabbitQueueName,
+		}
+
+		operation := bucket.MetricOperation{statsOpConnect, "queue", name}
+		_, err := channel.QueueDeclare(name, true, false, false, false, args)
+		statsClient.TrackOperation(statsAMQPSection, operation, nil, nil == err)
+		if err != nil {
+			log.WithError(err).WithField("queue", name).Error("Failed to declare retry queue")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retryMessage publishes failed message to retry queue for delayed redelivery and acknowledges the original one.
+// When retry attempts are exhausted message is rejected without requeue, so it is dead-lettered if pipe queue
+// has dead letter exchange configured, or dropped otherwise. Message is held while connection is blocked by broker,
+// as publishing to blocked connection hangs until it is unblocked.
+func retryMessage(channel *amqp.Channel, msg amqp.Delivery, pipe config.Pipe, blocked *blockedState, statsClient client.Client) {
+	attempt := retryAttempt(msg) + 1
+	logger := log.WithField("pipe", pipe.String()).WithField("attempt", attempt)
+
+	if pipe.RabbitRetry.MaxAttempts > 0 && attempt > pipe.RabbitRetry.MaxAttempts {
+		logger.Error("Message retry attempts are exhausted, rejecting it")
+		statsClient.TrackOperation(statsAMQPSection, bucket.MetricOperation{statsOpRetry, pipe.RabbitQueueName, "exhausted"}, nil, true)
+		if err := msg.Nack(false, false); err != nil {
+			logger.WithError(err).Error("Failed to NAck AMQP message")
+		}
+		return
+	}
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[retryAttemptHeader] = int32(attempt)
+
+	queueName := retryQueueName(pipe, pipe.RabbitRetry.Delay(attempt))
+	<-blocked.wait()
+	err := channel.Publish("", queueName, false, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	})
+	statsClient.TrackOperation(statsAMQPSection, bucket.MetricOperation{statsOpRetry, pipe.RabbitQueueName}, nil, nil == err)
+	if err != nil {
+		logger.WithError(err).Error("Failed to publish message to retry queue, requeueing it")
+		if err = msg.Nack(false, true); err != nil {
+			logger.WithError(err).Error("Failed to NAck AMQP message")
+		}
+		return
+	}
+
+	logger.WithField("queue", queueName).Debug("Message is scheduled for delayed redelivery")
+	if err = msg.Ack(false); err != nil {
+		logger.WithError(err).Error("Failed to Ack AMQP message")
+	}
+}
+
+func retryAttempt(msg amqp.Delivery) int {
+	switch attempt := msg.Headers[retryAttemptHeader].(type) {
+	case int32:
+		return int(attempt)
+	case int64:
+		return int(attempt)
+	case int:
+		return attempt
+	}
+
+	return 0
+}
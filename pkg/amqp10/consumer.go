@@ -0,0 +1,219 @@
+package amqp10
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+	amqp10 "github.com/vcabbage/amqp"
+)
+
+const (
+	// linkCredit is max number of unsettled messages broker sends to receiver
+	linkCredit = 10
+	// reconnectTimeout is a pause between attempts to re-establish broken connection
+	reconnectTimeout = 5 * time.Second
+
+	statsAMQP10Section = "amqp10"
+	statsOpConnect     = "connect"
+	statsOpConsume     = "consume"
+)
+
+// Consumer reads messages from AMQP 1.0 broker for pipes with "amqp10" protocol
+type Consumer struct {
+	dsn         string
+	pipes       []config.Pipe
+	handler     amqp.MessageHandler
+	statsClient client.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// disposition is AMQP 1.0 message settlement, implemented by received messages
+type disposition interface {
+	Accept() error
+	Reject(e *amqp10.Error) error
+	Release() error
+}
+
+// NewConsumer instantiates new AMQP 1.0 consumer, establishes connection and starts receiving messages
+// for given pipes in go-routines. Connection is re-established on any connection or link error.
+func NewConsumer(dsn string, pipes []config.Pipe, handler amqp.MessageHandler, statsClient client.Client) (*Consumer, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Consumer{dsn: dsn, pipes: pipes, handler: handler, statsClient: statsClient, ctx: ctx, cancel: cancel}
+
+	conn, err := c.connect()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.run(conn)
+	}()
+
+	return c, nil
+}
+
+// Close stops receiving messages and waits until AMQP 1.0 connection is closed
+func (c *Consumer) Close() error {
+	c.cancel()
+	c.wg.Wait()
+	return nil
+}
+
+func (c *Consumer) connect() (*amqp10.Client, error) {
+	log.Info("Establishing AMQP 1.0 connection")
+
+	operation := bucket.MetricOperation{statsOpConnect, "client"}
+	conn, err := amqp10.Dial(c.dsn)
+	c.statsClient.TrackOperation(statsAMQP10Section, operation, nil, err == nil)
+
+	return conn, err
+}
+
+func (c *Consumer) run(conn *amqp10.Client) {
+	for {
+		err := c.consume(conn)
+		conn.Close()
+
+		if c.ctx.Err() != nil {
+			log.Info("AMQP 1.0 consumer stopped")
+			return
+		}
+		log.WithError(err).WithField("timeout", reconnectTimeout).
+			Error("AMQP 1.0 consumer failed, trying to reconnect")
+
+		for {
+			select {
+			case <-time.After(reconnectTimeout):
+			case <-c.ctx.Done():
+				log.Info("AMQP 1.0 consumer stopped")
+				return
+			}
+
+			if conn, err = c.connect(); err == nil {
+				break
+			}
+			log.WithError(err).WithField("timeout", reconnectTimeout).
+				Error("Failed to establish new AMQP 1.0 connection, will try later")
+		}
+	}
+}
+
+// consume starts receivers for all pipes and blocks until one of them fails or consumer is closed
+func (c *Consumer) consume(conn *amqp10.Client) error {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(c.pipes))
+	for _, pipe := range c.pipes {
+		// every pipe handler gets its message
+		credit := uint32(linkCredit)
+		if handlers := uint32(pipe.HandlersNumber()); credit < handlers {
+			credit = handlers
+		}
+
+		operation := bucket.MetricOperation{statsOpConnect, "receiver", pipe.RabbitQueueName}
+		receiver, err := session.NewReceiver(
+			amqp10.LinkSourceAddress(pipe.RabbitQueueName),
+			amqp10.LinkCredit(credit),
+		)
+		c.statsClient.TrackOperation(statsAMQP10Section, operation, nil, err == nil)
+		if err != nil {
+			log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to create AMQP 1.0 receiver")
+			return err
+		}
+
+		go func(receiver *amqp10.Receiver, pipe config.Pipe) {
+			errs <- c.receive(ctx, receiver, pipe)
+		}(receiver, pipe)
+	}
+
+	select {
+	case err = <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// receive handles received messages with pipe handlers number of goroutines and blocks until receiver fails,
+// messages that are being handled are settled after that either
+func (c *Consumer) receive(ctx context.Context, receiver *amqp10.Receiver, pipe config.Pipe) error {
+	handlers := make(chan struct{}, pipe.HandlersNumber())
+	for {
+		msg, err := receiver.Receive(ctx)
+		if err != nil {
+			return err
+		}
+
+		// blocks until one of the handlers is free
+		handlers <- struct{}{}
+		go func() {
+			defer func() { <-handlers }()
+			c.handle(msg, pipe)
+		}()
+	}
+}
+
+func (c *Consumer) handle(msg *amqp10.Message, pipe config.Pipe) {
+	delivery := newDelivery(msg)
+	delivery.Settle = func(err error) {
+		c.settle(msg, pipe, err)
+	}
+
+	if err := c.handler(delivery, pipe); err != amqp.ErrAckDeferred {
+		c.settle(msg, pipe, err)
+	}
+}
+
+// settle accepts, rejects or releases message according to handling error
+func (c *Consumer) settle(msg disposition, pipe config.Pipe, err error) {
+	operation := bucket.MetricOperation{statsOpConsume, pipe.RabbitQueueName}
+	c.statsClient.TrackOperation(statsAMQP10Section, operation, nil, nil == err)
+	if err == amqp.ErrRejectMessage {
+		// rejected message is dead-lettered by broker, if it supports dead-lettering
+		msg.Reject(nil)
+	} else if err != nil {
+		log.WithError(err).WithField("pipe", pipe.String()).
+			Error("Failed to consume AMQP 1.0 message")
+		// released message is redelivered by broker
+		msg.Release()
+	} else {
+		msg.Accept()
+	}
+}
+
+func newDelivery(msg *amqp10.Message) amqp.Delivery {
+	delivery := amqp.Delivery{Body: msg.GetData(), Headers: msg.ApplicationProperties}
+	if msg.Properties != nil {
+		// AMQP 1.0 has no routing keys, subject is the closest thing to it, e.g. Service Bus message label
+		delivery.RoutingKey = msg.Properties.Subject
+		delivery.ContentType = string(msg.Properties.ContentType)
+		delivery.Timestamp = msg.Properties.CreationTime
+		if msg.Properties.CorrelationID != nil {
+			delivery.CorrelationID = fmt.Sprint(msg.Properties.CorrelationID)
+		}
+		if msg.Properties.MessageID != nil {
+			delivery.MessageID = fmt.Sprint(msg.Properties.MessageID)
+		}
+	}
+
+	return delivery
+}
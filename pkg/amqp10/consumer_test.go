@@ -0,0 +1,91 @@
+package amqp10
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	stats "github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	amqp10 "github.com/vcabbage/amqp"
+)
+
+type mockDisposition struct {
+	outcome string
+}
+
+func (m *mockDisposition) Accept() error {
+	m.outcome = "accepted"
+	return nil
+}
+
+func (m *mockDisposition) Reject(e *amqp10.Error) error {
+	m.outcome = "rejected"
+	return nil
+}
+
+func (m *mockDisposition) Release() error {
+	m.outcome = "released"
+	return nil
+}
+
+func TestConsumer_settle(t *testing.T) {
+	pipe := config.Pipe{RabbitQueueName: "orders", Protocol: config.ProtocolAMQP10}
+
+	for _, tc := range []struct {
+		err     error
+		outcome string
+	}{
+		{nil, "accepted"},
+		// rejected messages are dead-lettered by broker
+		{amqp.ErrRejectMessage, "rejected"},
+		// the rest of errors are transient, so messages are redelivered
+		{errors.New("buffer is full"), "released"},
+	} {
+		statsClient, _ := stats.NewClient("memory://")
+		c := &Consumer{statsClient: statsClient}
+
+		msg := &mockDisposition{}
+		c.settle(msg, pipe, tc.err)
+		assert.Equal(t, tc.outcome, msg.outcome, tc.err)
+
+		memoryStats, _ := statsClient.(*client.Memory)
+		if tc.err == nil {
+			assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.consume.orders.-", statsAMQP10Section)])
+		} else {
+			assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-fail.consume.orders.-", statsAMQP10Section)])
+		}
+	}
+}
+
+func TestNewDelivery(t *testing.T) {
+	creationTime := time.Date(2021, 5, 4, 12, 0, 0, 0, time.UTC)
+	msg := &amqp10.Message{
+		Data:                  [][]byte{[]byte(`{"id":1}`)},
+		ApplicationProperties: map[string]interface{}{"tenant": "de"},
+		Properties: &amqp10.MessageProperties{
+			MessageID:     "message-1",
+			CorrelationID: uint64(42),
+			Subject:       "order.created",
+			ContentType:   "application/json",
+			CreationTime:  creationTime,
+		},
+	}
+
+	delivery := newDelivery(msg)
+	assert.Equal(t, []byte(`{"id":1}`), delivery.Body)
+	assert.Equal(t, map[string]interface{}{"tenant": "de"}, delivery.Headers)
+	assert.Equal(t, "order.created", delivery.RoutingKey)
+	assert.Equal(t, "application/json", delivery.ContentType)
+	assert.Equal(t, creationTime, delivery.Timestamp)
+	assert.Equal(t, "message-1", delivery.MessageID)
+	assert.Equal(t, "42", delivery.CorrelationID)
+
+	// message without properties has body and application properties only
+	delivery = newDelivery(&amqp10.Message{Data: [][]byte{[]byte("body")}})
+	assert.Equal(t, amqp.Delivery{Body: []byte("body")}, delivery)
+}
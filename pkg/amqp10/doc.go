@@ -0,0 +1,4 @@
+/*
+Package amqp10 holds code required for reading messages from AMQP 1.0 brokers, e.g. Azure Service Bus.
+*/
+package amqp10
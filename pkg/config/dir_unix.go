@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package config
+
+// DefaultDir returns directory application and pipes configuration files are looked up in by default
+func DefaultDir() string {
+	return "/etc/kandalf/conf"
+}
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DefaultDir returns directory application and pipes configuration files are looked up in by default,
+// it is under ProgramData, as there is no /etc on windows
+func DefaultDir() string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+
+	return filepath.Join(programData, "kandalf", "conf")
+}
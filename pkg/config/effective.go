@@ -0,0 +1,149 @@
+package config
+
+// EffectivePipe contains pipe settings bridge actually applies, once pipe defaults, Kafka cluster and delivery class
+// overrides are resolved
+type EffectivePipe struct {
+	// Key is pipe key pipes are matched by on reload
+	Key string `json:"key"`
+	// VHost is RabbitMQ virtual host pipe is consumed from, empty one stands for RabbitDSN virtual host
+	VHost    string `json:"vhost,omitempty"`
+	Protocol string `json:"protocol"`
+
+	Exchange          string                 `json:"exchange"`
+	ExchangeType      string                 `json:"exchangeType"`
+	TransientExchange bool                   `json:"transientExchange"`
+	RoutingKeys       []string               `json:"routingKeys"`
+	BindingArguments  map[string]interface{} `json:"bindingArguments,omitempty"`
+	HeadersMatch      string                 `json:"headersMatch,omitempty"`
+	// Queue is the queue pipe consumes from, it differs from configured one in dry run mode
+	Queue           string `json:"queue"`
+	DurableQueue    bool   `json:"durableQueue"`
+	AutoDeleteQueue bool   `json:"autoDeleteQueue"`
+	ExistingQueue   bool   `json:"existingQueue"`
+	Consumers       int    `json:"consumers"`
+	Handlers        int    `json:"handlers"`
+
+	// Sink is messaging system pipe publishes to, Kafka producer settings are empty for other sinks
+	Sink string `json:"sink"`
+	// Topic is pipe topic as configured, so it may be a template expanded per message
+	Topic string `json:"topic,omitempty"`
+	// Topics are pipe destination topics known up front, see Pipe.Topics
+	Topics     []string `json:"topics"`
+	ErrorTopic string   `json:"errorTopic,omitempty"`
+	Cluster    string   `json:"cluster,omitempty"`
+	Brokers    []string `json:"brokers"`
+	// Delivery is pipe durability class, empty one publishes messages with Kafka configuration as is
+	Delivery     string `json:"delivery,omitempty"`
+	RequiredAcks string `json:"requiredAcks"`
+	Idempotent   bool   `json:"idempotent"`
+	RetryMax     int    `json:"retryMax"`
+	// TransactionalID is transactional.id of transactional pipe own producer
+	TransactionalID string `json:"transactionalId,omitempty"`
+
+	FlushMessages  int    `json:"flushMessages"`
+	FlushBytes     int    `json:"flushBytes"`
+	FlushFrequency string `json:"flushFrequency"`
+	MaxInFlight    int    `json:"maxInFlight"`
+	Partitioner    string `json:"partitioner"`
+	Compression    string `json:"compression"`
+
+	RateLimit  string `json:"rateLimit,omitempty"`
+	Paused     bool   `json:"paused"`
+	Replicated bool   `json:"replicated"`
+	DryRun     bool   `json:"dryRun"`
+}
+
+// Effective returns settings pipe is consumed and published with, Kafka configuration is the main one, pipe cluster
+// is resolved from it
+func (p Pipe) Effective(kafkaConfig KafkaConfig) (EffectivePipe, error) {
+	sink := p.Sink
+	if sink == "" {
+		sink = SinkKafka
+	}
+
+	var producerConfig KafkaConfig
+	retryMax, maxInFlight, frequency := 0, 0, ""
+	if sink == SinkKafka {
+		clusterConfig, err := kafkaConfig.ForCluster(p.KafkaCluster)
+		if err != nil {
+			return EffectivePipe{}, err
+		}
+		producerConfig = clusterConfig.ForDelivery(p.KafkaDelivery)
+		if p.KafkaDelivery == DeliveryTransactional {
+			producerConfig = producerConfig.ForTransactionalPipe(p.Key())
+		}
+
+		retryMax = producerConfig.MaxRetry
+		if producerConfig.Retry.Max != nil {
+			retryMax = *producerConfig.Retry.Max
+		}
+		maxInFlight = producerConfig.MaxInFlight
+		frequency = producerConfig.FlushFrequency.String()
+		if producerConfig.Idempotent {
+			// idempotent producer is limited to single in-flight request per broker
+			maxInFlight = 1
+		}
+	}
+	durable, autoDelete := p.RabbitDurableQueue, p.RabbitAutoDeleteQueue
+	if p.DryRun {
+		// dry run queue holds copies of pipe messages only while dry run instance is consuming them
+		durable, autoDelete = false, true
+	}
+
+	exchangeType := p.RabbitExchangeType
+	if exchangeType == "" {
+		exchangeType = ExchangeTypeTopic
+	}
+	protocol := p.Protocol
+	if protocol == "" {
+		protocol = ProtocolAMQP091
+	}
+	consumers := p.RabbitConsumers
+	if consumers < 1 || p.RabbitStrictOrdering {
+		consumers = 1
+	}
+
+	return EffectivePipe{
+		Key:      p.Key(),
+		VHost:    p.RabbitVHost,
+		Protocol: protocol,
+
+		Exchange:          p.RabbitExchangeName,
+		ExchangeType:      exchangeType,
+		TransientExchange: p.RabbitTransientExchange,
+		RoutingKeys:       p.RabbitRoutingKey,
+		BindingArguments:  p.RabbitBindingArguments,
+		HeadersMatch:      p.RabbitHeadersMatch,
+		Queue:             p.ConsumedQueue(),
+		DurableQueue:      durable,
+		AutoDeleteQueue:   autoDelete,
+		ExistingQueue:     p.RabbitExistingQueue,
+		Consumers:         consumers,
+		Handlers:          p.HandlersNumber(),
+
+		Sink:         sink,
+		Topic:        p.KafkaTopic,
+		Topics:       p.Topics(),
+		ErrorTopic:   p.KafkaErrorTopic,
+		Cluster:      p.KafkaCluster,
+		Brokers:      producerConfig.Brokers,
+		Delivery:     p.KafkaDelivery,
+		RequiredAcks: producerConfig.RequiredAcks,
+		Idempotent:   producerConfig.Idempotent,
+		RetryMax:     retryMax,
+
+		TransactionalID: producerConfig.TransactionalID,
+
+		FlushMessages:  producerConfig.FlushMessages,
+		FlushBytes:     producerConfig.FlushBytes,
+		FlushFrequency: frequency,
+		MaxInFlight:    maxInFlight,
+		Partitioner:    producerConfig.Partitioner,
+		Compression:    producerConfig.Compression,
+
+		RateLimit:  p.RateLimit,
+		Paused:     p.Paused,
+		Replicated: p.Replicated,
+		DryRun:     p.DryRun,
+	}, nil
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipe_Effective(t *testing.T) {
+	retryMax := 3
+	kafkaConfig := KafkaConfig{
+		Brokers:        []string{"main:9092"},
+		MaxRetry:       5,
+		RequiredAcks:   "leader",
+		MaxInFlight:    5,
+		FlushMessages:  100,
+		FlushFrequency: 50 * time.Millisecond,
+		Partitioner:    "hash",
+		Compression:    "lz4",
+		Clusters: map[string]KafkaClusterConfig{
+			"dc2": {Brokers: []string{"dc2:9092"}},
+		},
+	}
+	pipe := Pipe{
+		RabbitExchangeName: "customers",
+		RabbitRoutingKey:   []string{"order.created"},
+		RabbitQueueName:    "kandalf-orders",
+		RabbitDurableQueue: true,
+		RabbitConsumers:    4,
+		Concurrency:        2,
+		KafkaTopic:         "orders-{{.RoutingKey}}",
+		KafkaSinks:         []string{"orders-audit"},
+	}
+
+	effective, err := pipe.Effective(kafkaConfig)
+	require.NoError(t, err)
+	assert.Equal(t, EffectivePipe{
+		Key:            "/kandalf-orders",
+		Protocol:       ProtocolAMQP091,
+		Exchange:       "customers",
+		ExchangeType:   ExchangeTypeTopic,
+		RoutingKeys:    []string{"order.created"},
+		Queue:          "kandalf-orders",
+		DurableQueue:   true,
+		Consumers:      4,
+		Handlers:       2,
+		Sink:           SinkKafka,
+		Topic:          "orders-{{.RoutingKey}}",
+		Topics:         []string{"orders-{{.RoutingKey}}", "orders-audit"},
+		Brokers:        []string{"main:9092"},
+		RequiredAcks:   "leader",
+		RetryMax:       5,
+		FlushMessages:  100,
+		FlushFrequency: "50ms",
+		MaxInFlight:    5,
+		Partitioner:    "hash",
+		Compression:    "lz4",
+	}, effective)
+
+	// cluster, delivery class and retry overrides are resolved
+	kafkaConfig.Retry.Max = &retryMax
+	kafkaConfig.Idempotent = true
+	pipe.KafkaCluster = "dc2"
+	pipe.KafkaDelivery = DeliveryEndToEnd
+	pipe.RabbitStrictOrdering = true
+	effective, err = pipe.Effective(kafkaConfig)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dc2:9092"}, effective.Brokers)
+	assert.Equal(t, "all", effective.RequiredAcks)
+	assert.True(t, effective.Idempotent)
+	assert.Equal(t, 3, effective.RetryMax)
+	assert.Equal(t, 1, effective.MaxInFlight)
+	assert.Equal(t, 1, effective.Consumers)
+
+	// dry run pipe consumes copies of messages from its own transient queue
+	pipe.DryRun = true
+	effective, err = pipe.Effective(kafkaConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "kandalf-orders"+DryRunQueueSuffix, effective.Queue)
+	assert.False(t, effective.DurableQueue)
+	assert.True(t, effective.AutoDeleteQueue)
+
+	pipe.KafkaCluster = "dc3"
+	_, err = pipe.Effective(kafkaConfig)
+	assert.Equal(t, ErrUnknownKafkaCluster, err)
+
+	// Kafka producer settings do not apply to pipes publishing to NATS
+	pipe.KafkaCluster = ""
+	pipe.Sink = SinkNATS
+	effective, err = pipe.Effective(kafkaConfig)
+	require.NoError(t, err)
+	assert.Equal(t, SinkNATS, effective.Sink)
+	assert.Empty(t, effective.Brokers)
+	assert.Empty(t, effective.RequiredAcks)
+	assert.Empty(t, effective.FlushFrequency)
+	assert.Equal(t, 0, effective.MaxInFlight)
+}
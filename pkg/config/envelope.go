@@ -0,0 +1,21 @@
+package config
+
+// EnvelopeAvroSchema is the default schema of pipes with avro envelope, it is registered under pipe topic subject.
+// Timestamps are Unix time in milliseconds, AMQP timestamp is 0 if message has no timestamp property.
+const EnvelopeAvroSchema = `{
+  "type": "record",
+  "name": "Envelope",
+  "namespace": "com.hellofresh.kandalf",
+  "fields": [
+    {"name": "body", "type": "bytes"},
+    {"name": "exchange", "type": "string"},
+    {"name": "routingKey", "type": "string"},
+    {"name": "headers", "type": {"type": "map", "values": "string"}},
+    {"name": "contentType", "type": "string"},
+    {"name": "correlationId", "type": "string"},
+    {"name": "messageId", "type": "string"},
+    {"name": "timestamp", "type": "long"},
+    {"name": "consumedAt", "type": "long"},
+    {"name": "node", "type": "string"}
+  ]
+}`
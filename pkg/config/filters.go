@@ -0,0 +1,46 @@
+package config
+
+import (
+	"errors"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrFilterNotBool is an error raised when pipe filter expression does not evaluate to bool
+var ErrFilterNotBool = errors.New("filter expression must evaluate to bool")
+
+// Pipe filter expression variables
+const (
+	FilterRoutingKey = "routingKey"
+	FilterExchange   = "exchange"
+	FilterHeaders    = "headers"
+	FilterBody       = "body"
+	FilterData       = "data"
+)
+
+// CompileFilter parses and type-checks pipe filter CEL expression, e.g. 'headers.region == "eu"', expression gets
+// message routing key, exchange, headers with values converted to strings, raw body and decoded JSON body data
+func CompileFilter(expr string) (cel.Program, error) {
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewVar(FilterRoutingKey, decls.String),
+		decls.NewVar(FilterExchange, decls.String),
+		decls.NewVar(FilterHeaders, decls.NewMapType(decls.String, decls.String)),
+		decls.NewVar(FilterBody, decls.String),
+		decls.NewVar(FilterData, decls.Dyn),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if !proto.Equal(ast.ResultType(), decls.Bool) {
+		return nil, ErrFilterNotBool
+	}
+
+	return env.Program(ast)
+}
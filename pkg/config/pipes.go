@@ -2,10 +2,370 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
+const (
+	// ExchangeTypeTopic is the default RabbitMQ exchange type, messages are bound by routing key
+	ExchangeTypeTopic = "topic"
+	// ExchangeTypeHeaders is RabbitMQ headers exchange type, messages are bound by headers binding arguments
+	ExchangeTypeHeaders = "headers"
+
+	// ProtocolAMQP091 is the default pipe protocol, messages are consumed from RabbitMQ using AMQP 0-9-1
+	ProtocolAMQP091 = "amqp091"
+	// ProtocolAMQP10 is pipe protocol for consuming messages from AMQP 1.0 brokers, e.g. Azure Service Bus
+	ProtocolAMQP10 = "amqp10"
+	// ProtocolMQTT is pipe protocol for consuming messages from MQTT brokers, pipe routing keys are topic filters
+	ProtocolMQTT = "mqtt"
+
+	// HeadersMatchAll requires all binding arguments to match message headers
+	HeadersMatchAll = "all"
+	// HeadersMatchAny requires at least one binding argument to match message headers
+	HeadersMatchAny = "any"
+
+	// PartitionKeyRoutingKey is partition key expression that uses message routing key as Kafka message key
+	PartitionKeyRoutingKey = "routingKey"
+	// PartitionKeyHeaderPrefix is partition key expression prefix for using message header value as Kafka message key,
+	// e.g. "header:customer-id"
+	PartitionKeyHeaderPrefix = "header:"
+	// PartitionKeyJSONPrefix is partition key expression prefix for using JSON message body field as Kafka message key,
+	// nested fields are separated by dots, e.g. "json:customer.id"
+	PartitionKeyJSONPrefix = "json:"
+
+	// DedupMessageID is dedup key expression that uses AMQP message id property as message dedup key
+	DedupMessageID = "messageId"
+
+	// TimestampProperty is timestamp expression that uses AMQP message timestamp property as Kafka record timestamp.
+	// Other timestamp expressions use the same "header:<name>" and "json:<field>" syntax as partition key does.
+	TimestampProperty = "timestamp"
+
+	// OversizePolicyDrop drops messages exceeding pipe max message size
+	OversizePolicyDrop = "drop"
+	// OversizePolicyDeadLetter rejects messages exceeding pipe max message size without requeue,
+	// so broker routes them to queue dead letter exchange, if there is one
+	OversizePolicyDeadLetter = "dead-letter"
+	// OversizePolicyTruncate truncates messages exceeding pipe max message size to max size
+	// and marks them with original size header
+	OversizePolicyTruncate = "truncate-with-header"
+
+	// DeliveryFireAndForget publishes pipe messages without waiting for brokers acknowledgement (acks=0),
+	// messages failed to be published are dropped instead of being moved to storage
+	DeliveryFireAndForget = "fire-and-forget"
+	// DeliveryAtLeastOnce publishes pipe messages waiting for all in-sync replicas acknowledgement (acks=all)
+	DeliveryAtLeastOnce = "at-least-once"
+	// DeliveryEndToEnd publishes pipe messages like DeliveryAtLeastOnce and acknowledges AMQP messages only once
+	// they are acknowledged by Kafka, messages failed to be published are redelivered by AMQP broker
+	DeliveryEndToEnd = "end-to-end"
+	// DeliveryTransactional publishes every batch of pipe messages within Kafka transaction with pipe own
+	// transactional producer and acknowledges AMQP messages once transaction is committed, it requires confluent client
+	DeliveryTransactional = "transactional"
+
+	// EnvelopeJSON wraps pipe messages into JSON envelope with AMQP metadata, JSON body is embedded as is
+	EnvelopeJSON = "json"
+	// EnvelopeAvro wraps pipe messages into envelope in Avro JSON encoding, that is serialized with pipe Avro schema,
+	// EnvelopeAvroSchema by default
+	EnvelopeAvro = "avro"
+
+	// SchemaTypeAvro serializes JSON message bodies in Avro JSON encoding to Avro binary encoding
+	SchemaTypeAvro = "avro"
+	// SchemaTypeJSON validates JSON message bodies against JSON Schema and publishes them as is
+	SchemaTypeJSON = "json"
+	// SchemaTypeProtobuf converts JSON message bodies to Protobuf binary encoding with descriptor set file
+	// or .proto schema from registry
+	SchemaTypeProtobuf = "protobuf"
+
+	// SinkKafka is the default pipe sink, messages are published to Kafka topics
+	SinkKafka = "kafka"
+	// SinkNATS publishes pipe messages to NATS JetStream, pipe topics are used as subjects
+	SinkNATS = "nats"
+	// SinkKinesis publishes pipe messages to AWS Kinesis Data Streams, pipe topics are used as stream names
+	SinkKinesis = "kinesis"
+	// SinkPulsar publishes pipe messages to Apache Pulsar, pipe topics are used as Pulsar topics
+	SinkPulsar = "pulsar"
+
+	// SinkPolicyAll settles AMQP message successfully only once it is accepted and published to pipe topic
+	// and all the pipe sinks, message is requeued or rejected if any of them fails
+	SinkPolicyAll = "all"
+	// SinkPolicyPrimary settles AMQP message with pipe topic result only, failures of pipe sinks are logged
+	// and tracked, but do not requeue the message
+	SinkPolicyPrimary = "primary"
+
+	// DryRunQueueSuffix is appended to dry run pipe queue name, so dry run consumes copies of pipe messages from
+	// its own queue and does not take them away from pipe queue
+	DryRunQueueSuffix = ".dry-run"
+)
+
+var (
+	// ErrUnknownExchangeType is an error raised when pipe has exchange type that is not supported
+	ErrUnknownExchangeType = errors.New("unknown exchange type, supported types are topic and headers")
+	// ErrUnknownHeadersMatch is an error raised when pipe has headers match value other than "any" or "all"
+	ErrUnknownHeadersMatch = errors.New("unknown headers match, supported values are any and all")
+	// ErrMissingBindingArguments is an error raised when headers exchange pipe has no binding arguments
+	ErrMissingBindingArguments = errors.New("headers exchange requires binding arguments")
+	// ErrInvalidRoutingKey is an error raised when topic exchange pipe has duplicate routing keys or routing key
+	// with wildcard that is not a whole word
+	ErrInvalidRoutingKey = errors.New("invalid routing key, keys must be unique and wildcards * and # must be whole dot-separated words")
+	// ErrMissingQueueName is an error raised when pipe has no queue name
+	ErrMissingQueueName = errors.New("pipe requires queue name")
+	// ErrUnknownProtocol is an error raised when pipe has protocol that is not supported
+	ErrUnknownProtocol = errors.New("unknown protocol, supported protocols are amqp091, amqp10 and mqtt")
+	// ErrInvalidTopicFilter is an error raised when MQTT pipe has no topic filters, duplicate filters or filter with
+	// wildcard that is not a whole level
+	ErrInvalidTopicFilter = errors.New("invalid topic filter, filters must be unique, + and # must be whole levels and # must be the last one")
+	// ErrInvalidMQTTQueueName is an error raised when MQTT pipe queue name can not be used as shared subscription group
+	ErrInvalidMQTTQueueName = errors.New("mqtt pipe queue name must not contain /, + and #")
+	// ErrAMQP10RabbitSettings is an error raised when AMQP 1.0 pipe has settings that apply to AMQP 0-9-1 consumers only
+	ErrAMQP10RabbitSettings = errors.New("amqp10 protocol does not support rabbit consumers and strict ordering")
+	// ErrInvalidConsumers is an error raised when pipe has negative consumers number
+	ErrInvalidConsumers = errors.New("consumers number must be positive")
+	// ErrStrictOrderingConsumers is an error raised when pipe requires strict ordering but has several consumers
+	ErrStrictOrderingConsumers = errors.New("strict ordering allows only single consumer")
+	// ErrKeyOrderingConsumers is an error raised when pipe requires key ordering but has several consumers
+	ErrKeyOrderingConsumers = errors.New("key ordering allows only single consumer")
+	// ErrInvalidConcurrency is an error raised when pipe has negative concurrency
+	ErrInvalidConcurrency = errors.New("concurrency must be positive")
+	// ErrOrderingConcurrency is an error raised when pipe requires ordering but has concurrency greater than 1
+	ErrOrderingConcurrency = errors.New("strict and key ordering allow only single message handler")
+	// ErrInvalidRetryDelay is an error raised when pipe retry policy has non-positive or inconsistent delays
+	ErrInvalidRetryDelay = errors.New("retry initial delay must be positive and not greater than max delay")
+	// ErrInvalidRetryAttempts is an error raised when pipe retry policy has negative max attempts
+	ErrInvalidRetryAttempts = errors.New("retry max attempts must not be negative")
+	// ErrInvalidMaxMessageBytes is an error raised when pipe has negative max message size
+	ErrInvalidMaxMessageBytes = errors.New("max message bytes must not be negative")
+	// ErrInvalidPartitionKey is an error raised when pipe has partition key expression that is not supported
+	ErrInvalidPartitionKey = errors.New("invalid partition key, supported expressions are routingKey, header:<name> and json:<field>")
+	// ErrInvalidRouteKey is an error raised when pipe has route key expression that is not supported
+	ErrInvalidRouteKey = errors.New("invalid route key, supported expressions are routingKey, header:<name> and json:<field>")
+	// ErrInconsistentRoutes is an error raised when pipe has route key without routes or routes without route key
+	ErrInconsistentRoutes = errors.New("route key and routes must be set together")
+	// ErrInvalidRoute is an error raised when pipe route has empty or templated topic or duplicate key
+	ErrInvalidRoute = errors.New("route requires unique key and topic that is not a template")
+	// ErrInvalidRoutePattern is an error raised when pipe route has both key and pattern or invalid pattern
+	ErrInvalidRoutePattern = errors.New("route requires either key or valid regular expression pattern")
+	// ErrInvalidDedupKey is an error raised when pipe has dedup key expression that is not supported
+	ErrInvalidDedupKey = errors.New("invalid dedup key, supported expressions are messageId, header:<name> and json:<field>")
+	// ErrInvalidTimestamp is an error raised when pipe has timestamp expression that is not supported
+	ErrInvalidTimestamp = errors.New("invalid timestamp, supported expressions are timestamp, header:<name> and json:<field>")
+	// ErrInvalidRateLimit is an error raised when pipe rate limit is not in "<messages>/<period>" format
+	ErrInvalidRateLimit = errors.New("invalid rate limit, supported format is <messages>/<period>, e.g. 500/s, 1000/m or 100/10s")
+	// ErrInvalidTopicSettings is an error raised when pipe topic settings have non-positive partitions
+	// or replication factor
+	ErrInvalidTopicSettings = errors.New("topic partitions and replication factor must be positive")
+	// ErrUnknownOversizePolicy is an error raised when pipe has oversize policy that is not supported
+	ErrUnknownOversizePolicy = errors.New("unknown oversize policy, supported policies are drop, dead-letter and truncate-with-header")
+	// ErrTemplatedTopicCreate is an error raised when pipe with topic template requires topic creation
+	ErrTemplatedTopicCreate = errors.New("topic template does not allow topic creation")
+	// ErrTemplatedErrorTopic is an error raised when pipe error topic is a template
+	ErrTemplatedErrorTopic = errors.New("error topic must not be a template")
+	// ErrInvalidSink is an error raised when pipe sink topic is empty, a template or duplicates other pipe topic
+	ErrInvalidSink = errors.New("sink requires unique topic that is not a template and differs from pipe topics")
+	// ErrUnknownSinkPolicy is an error raised when pipe has sink policy that is not supported
+	ErrUnknownSinkPolicy = errors.New("unknown sink policy, supported policies are all and primary")
+	// ErrMissingPluginPath is an error raised when pipe plugin has no path
+	ErrMissingPluginPath = errors.New("plugin requires path")
+	// ErrUnknownDelivery is an error raised when pipe has delivery class that is not supported
+	ErrUnknownDelivery = errors.New("unknown delivery, supported values are fire-and-forget, at-least-once, end-to-end and transactional")
+	// ErrTransactionalDelivery is an error raised when pipe requires transactional delivery, but Kafka client
+	// has no transactional producer
+	ErrTransactionalDelivery = errors.New("transactional delivery requires KAFKA_CLIENT=confluent")
+	// ErrTransactionalSink is an error raised when pipe with transactional delivery publishes to sink other than Kafka
+	ErrTransactionalSink = errors.New("transactional delivery requires kafka sink")
+	// ErrUnknownSchemaType is an error raised when pipe has schema type that is not supported
+	ErrUnknownSchemaType = errors.New("unknown schema type, supported types are avro, json and protobuf")
+	// ErrProtobufSchema is an error raised when pipe Protobuf schema has descriptor set file, but no message name
+	ErrProtobufSchema = errors.New("protobuf schema file requires message name")
+	// ErrSchemaFile is an error raised when pipe schema has both schema file and Schema Registry settings
+	ErrSchemaFile = errors.New("schema file can not be combined with registry subject or schema")
+	// ErrUnknownEnvelope is an error raised when pipe has envelope format that is not supported
+	ErrUnknownEnvelope = errors.New("unknown envelope, supported formats are json and avro")
+	// ErrEnvelopeSchema is an error raised when pipe with avro envelope has schema of other type
+	ErrEnvelopeSchema = errors.New("avro envelope requires avro schema")
+	// ErrSchemaTruncate is an error raised when pipe with schema truncates oversize messages,
+	// as truncated payload can not be deserialized
+	ErrSchemaTruncate = errors.New("schema does not allow truncate-with-header oversize policy")
+	// ErrDryRunQueue is an error raised when dry run pipe consumes from AMQP 1.0 address or pre-existing queue,
+	// as dry run requires its own queue bound to pipe exchange
+	ErrDryRunQueue = errors.New("dry run requires pipe exchange and bindings, it is not supported for amqp10, mqtt and existing queues")
+	// ErrUnknownSink is an error raised when pipe has sink that is not supported
+	ErrUnknownSink = errors.New("unknown sink, supported sinks are kafka, nats, kinesis and pulsar")
+	// ErrNATSKafkaSettings is an error raised when pipe publishing to NATS has settings that apply to Kafka only
+	ErrNATSKafkaSettings = errors.New("nats sink does not support kafka cluster, topic creation and partition key")
+	// ErrKinesisKafkaSettings is an error raised when pipe publishing to Kinesis has settings that apply to Kafka only
+	ErrKinesisKafkaSettings = errors.New("kinesis sink does not support kafka cluster and topic creation")
+	// ErrPulsarKafkaSettings is an error raised when pipe publishing to Pulsar has settings that apply to Kafka only
+	ErrPulsarKafkaSettings = errors.New("pulsar sink does not support kafka cluster and topic creation")
+)
+
+// RetryPolicy contains settings for delayed redelivery of messages that failed to be handled.
+// Delay doubles with every attempt starting from InitialDelay until it reaches MaxDelay.
+type RetryPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// MaxAttempts is number of redelivery attempts before message is rejected, 0 means unlimited
+	MaxAttempts int `json:",omitempty"`
+}
+
+// TopicSettings contains settings for creating pipe destination topic
+type TopicSettings struct {
+	Partitions        int
+	ReplicationFactor int
+	// Configs are topic config entries, e.g. "retention.ms"
+	Configs map[string]string `json:",omitempty"`
+}
+
+// PluginSettings contains settings for Go plugin that filters and transforms pipe messages
+type PluginSettings struct {
+	// Path is path to plugin built with "go build -buildmode=plugin"
+	Path string
+	// Config is plugin specific configuration passed to plugin constructor
+	Config map[string]string `json:",omitempty"`
+}
+
+// SchemaSettings contains settings for serializing pipe messages with Schema Registry schema
+type SchemaSettings struct {
+	// Type is schema type - "avro", "json" or "protobuf"
+	Type string
+	// Subject is registry subject schema is registered under, default is "<topic>-value"
+	Subject string `json:",omitempty"`
+	// Schema is schema definition registered under subject, subject latest version is used if not set
+	Schema string `json:",omitempty"`
+	// File is path to schema file - Avro schema, JSON Schema or Protobuf descriptor set, messages are converted
+	// with schema from the file and published without Schema Registry wire format framing
+	File string `json:",omitempty"`
+	// Message is full name of Protobuf message type from descriptor set or registry schema messages are converted to,
+	// it defaults to the first message type of registry schema
+	Message string `json:",omitempty"`
+}
+
+// Route is destination topic of pipe messages with the given route key value or route key matching pattern
+type Route struct {
+	Key   string
+	Topic string
+	// Pattern is regular expression route key is matched with instead of Key, Topic may refer to its capturing
+	// groups, e.g. pattern `^order\.(\w+)\.created$` and topic "orders.$1"
+	Pattern string `json:",omitempty"`
+}
+
+// HasStaticTopic checks if route topic is the same for all the messages, that is route topic does not refer
+// to pattern capturing groups
+func (r Route) HasStaticTopic() bool {
+	return r.Pattern == "" || !strings.Contains(r.Topic, "$")
+}
+
+// RateLimit is max number of messages per period, zero value means no limit
+type RateLimit struct {
+	Messages int
+	Per      time.Duration
+}
+
+// rateLimitUnits are rate limit periods that may be used without number, e.g. "500/s"
+var rateLimitUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseRateLimit parses rate limit expression in "<messages>/<period>" format, period is either s, m, h
+// or duration string, e.g. "500/s" or "100/10s", empty expression means no limit
+func ParseRateLimit(expression string) (RateLimit, error) {
+	if expression == "" {
+		return RateLimit{}, nil
+	}
+
+	parts := strings.SplitN(expression, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+
+	messages, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || messages < 1 {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+
+	period := strings.TrimSpace(parts[1])
+	per, ok := rateLimitUnits[period]
+	if !ok {
+		if per, err = time.ParseDuration(period); err != nil || per <= 0 {
+			return RateLimit{}, ErrInvalidRateLimit
+		}
+	}
+
+	return RateLimit{Messages: messages, Per: per}, nil
+}
+
+// HeadersMapping contains settings for copying AMQP message headers and properties to Kafka record headers
+type HeadersMapping struct {
+	// Include is list of headers to copy, all headers are copied if it is empty
+	Include []string `json:",omitempty"`
+	// Exclude is list of headers that are never copied
+	Exclude []string `json:",omitempty"`
+}
+
+// Allowed returns true if header with the given name must be copied
+func (m HeadersMapping) Allowed(name string) bool {
+	for _, excluded := range m.Exclude {
+		if excluded == name {
+			return false
+		}
+	}
+
+	if len(m.Include) == 0 {
+		return true
+	}
+	for _, included := range m.Include {
+		if included == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delay returns redelivery delay for the given attempt, attempts are counted from 1
+func (r RetryPolicy) Delay(attempt int) time.Duration {
+	delay := r.InitialDelay
+	for i := 1; i < attempt && delay < r.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > r.MaxDelay {
+		return r.MaxDelay
+	}
+	return delay
+}
+
+// Delays returns all distinct redelivery delays of the policy in ascending order
+func (r RetryPolicy) Delays() []time.Duration {
+	var delays []time.Duration
+	for attempt := 1; ; attempt++ {
+		delay := r.Delay(attempt)
+		delays = append(delays, delay)
+		if delay >= r.MaxDelay {
+			return delays
+		}
+	}
+}
+
+// Validate checks that retry policy settings are consistent
+func (r RetryPolicy) Validate() error {
+	if r.InitialDelay <= 0 || r.MaxDelay < r.InitialDelay {
+		return ErrInvalidRetryDelay
+	}
+	if r.MaxAttempts < 0 {
+		return ErrInvalidRetryAttempts
+	}
+
+	return nil
+}
+
 // Pipe contains settings for single bridge pipe between Kafka and RabbitMQ
 type Pipe struct {
 	KafkaTopic              string
@@ -15,6 +375,158 @@ type Pipe struct {
 	RabbitQueueName         string
 	RabbitDurableQueue      bool
 	RabbitAutoDeleteQueue   bool
+	// RabbitExchangeType is RabbitMQ exchange type, either "topic" (default) or "headers"
+	RabbitExchangeType string `json:",omitempty"`
+	// RabbitBindingArguments are queue binding arguments, for headers exchange these are headers to match
+	RabbitBindingArguments map[string]interface{} `json:",omitempty"`
+	// RabbitHeadersMatch defines how headers exchange binding arguments are matched - "all" (default) or "any"
+	RabbitHeadersMatch string `json:",omitempty"`
+	// RabbitExistingQueue disables exchange and queue declaration and binding, messages are consumed
+	// from already existing queue with the name RabbitQueueName
+	RabbitExistingQueue bool `json:",omitempty"`
+	// RabbitConsumers is number of parallel consumers, each with its own channel, for the pipe queue, default is 1
+	RabbitConsumers int `json:",omitempty"`
+	// RabbitStrictOrdering guarantees that messages are consumed in the queue order, so it allows only single consumer
+	RabbitStrictOrdering bool `json:",omitempty"`
+	// Concurrency is number of goroutines every pipe consumer handles messages with, so slow filter, transform
+	// or schema encoding of one pipe does not slow down others, default is 1. Prefetch count is raised to it.
+	Concurrency int `json:",omitempty"`
+	// Protocol is protocol used to consume messages, either "amqp091" (default) or "amqp10".
+	// AMQP 1.0 pipes read messages from RabbitQueueName address, exchange and binding settings are not used.
+	Protocol string `json:",omitempty"`
+	// RabbitRetry enables delayed redelivery of messages that failed to be handled through TTL retry queues,
+	// without it failed messages are requeued immediately
+	RabbitRetry *RetryPolicy `json:",omitempty"`
+	// KafkaMaxMessageBytes is max message body size, messages exceeding it are handled according
+	// to KafkaOversizePolicy instead of being published to Kafka, 0 (default) means no limit
+	KafkaMaxMessageBytes int `json:",omitempty"`
+	// KafkaOversizePolicy defines how messages exceeding KafkaMaxMessageBytes are handled -
+	// "dead-letter" (default), "drop" or "truncate-with-header"
+	KafkaOversizePolicy string `json:",omitempty"`
+	// KafkaRouteKey is expression for message route key that chooses destination topic from KafkaRoutes -
+	// "routingKey", "header:<name>" or "json:<field>", e.g. "json:event_type"
+	KafkaRouteKey string `json:",omitempty"`
+	// KafkaRoutes are destination topics of messages with route key values, messages with route key that has
+	// no route are published to KafkaTopic, or rejected if it is empty
+	KafkaRoutes []Route `json:",omitempty"`
+	// KafkaPartitionKey is expression for Kafka message key, so messages of the same entity land on the same partition -
+	// "routingKey", "header:<name>" or "json:<field>", messages are published without key if not set
+	KafkaPartitionKey string `json:",omitempty"`
+	// KafkaKeyOrdering guarantees that messages with the same partition key, or routing key if pipe has no partition
+	// key, are published in consume order even across retries, so it allows only single consumer. Failed messages
+	// are retried in place, holding back the rest of messages with their key only, instead of moving to storage.
+	KafkaKeyOrdering bool `json:",omitempty"`
+	// KafkaTimestamp is expression for Kafka record timestamp, so it reflects when event happened - "timestamp"
+	// for AMQP timestamp property, "header:<name>" or "json:<field>" with Unix time in milliseconds or RFC 3339 time,
+	// messages are published with publish time if not set
+	KafkaTimestamp string `json:",omitempty"`
+	// KafkaHeaders enables copying AMQP message headers and properties to Kafka record headers
+	KafkaHeaders *HeadersMapping `json:",omitempty"`
+	// KafkaCluster is name of Kafka cluster from Kafka clusters config messages are published to,
+	// default is empty - main cluster
+	KafkaCluster string `json:",omitempty"`
+	// Sink is messaging system pipe messages are published to - "kafka" (default) or "nats" for NATS JetStream.
+	// Pipe topics, sinks and error topic are NATS subjects for "nats" sink, streams capturing them must exist.
+	Sink string `json:",omitempty"`
+	// KafkaCreateTopic enables creating pipe topic on start if it does not exist yet
+	KafkaCreateTopic *TopicSettings `json:",omitempty"`
+	// KafkaErrorTopic is topic in the pipe cluster messages that can never be published to their destination
+	// topic are written to with failure metadata headers, default is empty - such messages are dropped
+	KafkaErrorTopic string `json:",omitempty"`
+	// KafkaSinks are additional topics in the pipe cluster every message is published to besides its destination
+	// topic, e.g. audit topic, message copies are buffered, published and retried independently
+	KafkaSinks []string `json:",omitempty"`
+	// KafkaSinkPolicy defines how failure of some of the pipe sinks is handled - "all" (default) or "primary"
+	KafkaSinkPolicy string `json:",omitempty"`
+	// KafkaDelivery is pipe durability class - "fire-and-forget", "at-least-once" or "end-to-end",
+	// default is empty - Kafka required acks config is used
+	KafkaDelivery string `json:",omitempty"`
+	// KafkaSchema enables serializing messages with Schema Registry schema in Confluent wire format
+	KafkaSchema *SchemaSettings `json:",omitempty"`
+	// Filter is CEL expression evaluated per message, e.g. 'headers.region == "eu"', only messages it is true for
+	// are forwarded to Kafka, the rest are acknowledged and dropped, default is empty - all messages are forwarded
+	Filter string `json:",omitempty"`
+	// Transform is Go template expanded per message with the same data as topic template, its result replaces
+	// message body before it is encoded with schema and published, e.g. '{{toJSON (omit .Data "email")}}',
+	// default is empty - body is published as is
+	Transform string `json:",omitempty"`
+	// Plugin enables filtering and transforming messages with Go plugin, plugin filter is applied after Filter
+	// expression and plugin transformer gets body transformed with Transform template
+	Plugin *PluginSettings `json:",omitempty"`
+	// KafkaEnvelope wraps message body into envelope with exchange, routing key, AMQP properties, consume time
+	// and kandalf instance id - "json" or "avro", default is empty - body is published as is
+	KafkaEnvelope string `json:",omitempty"`
+	// DedupKey is expression for message dedup key, messages with the key published within dedup window are dropped -
+	// "messageId" for AMQP message id property, "header:<name>" or "json:<field>", messages are not deduplicated if not set
+	DedupKey string `json:",omitempty"`
+	// RateLimit is max rate messages are consumed from pipe queue with, e.g. "500/s", messages over the limit wait
+	// for their turn, so other pipes are not starved, default is empty - no limit. It is reloaded on SIGHUP.
+	RateLimit string `json:",omitempty"`
+	// Paused stops consuming messages from pipe queue, so they wait in the queue until pipe is resumed,
+	// e.g. while pipe topic is broken, default is false. It is reloaded on SIGHUP.
+	Paused bool `json:",omitempty"`
+	// Replicated enables replicating accepted messages to replication cluster before they are acknowledged,
+	// so they are not lost if the instance crashes, at the cost of publish latency
+	Replicated bool `json:",omitempty"`
+	// RabbitVHost is RabbitMQ virtual host of the pipe queue, default is virtual host of RabbitDSN.
+	// Pipes with the same virtual host and credentials share single connection.
+	RabbitVHost string `json:",omitempty"`
+	// RabbitUsername overrides RabbitDSN username for the pipe connection
+	RabbitUsername string `json:",omitempty"`
+	// RabbitPassword overrides RabbitDSN password for the pipe connection
+	RabbitPassword string `json:"-"`
+	// DryRun consumes copies of pipe messages from transient queue with the same bindings, transforms them and logs
+	// what would be published instead of publishing it, so pipe is tested against live traffic without taking
+	// messages away from pipe queue, default is false
+	DryRun bool `json:",omitempty"`
+}
+
+// HandlersNumber returns number of goroutines every pipe consumer handles messages with
+func (p Pipe) HandlersNumber() int {
+	if p.Concurrency < 1 {
+		return 1
+	}
+
+	return p.Concurrency
+}
+
+// ConsumedQueue returns name of the queue pipe consumes from, dry run pipe consumes from its own queue
+func (p Pipe) ConsumedQueue() string {
+	if p.DryRun {
+		return p.RabbitQueueName + DryRunQueueSuffix
+	}
+
+	return p.RabbitQueueName
+}
+
+// IsTopicTemplate checks if pipe topic is a template expanded per message, e.g. "events.{{.RoutingKey}}"
+func IsTopicTemplate(topic string) bool {
+	return strings.Contains(topic, "{{")
+}
+
+// Topics returns pipe destination topics - KafkaTopic, if it is set, KafkaRoutes static topics and KafkaSinks topics,
+// without duplicates
+func (p Pipe) Topics() []string {
+	var topics []string
+	seen := make(map[string]bool)
+	if p.KafkaTopic != "" {
+		topics = append(topics, p.KafkaTopic)
+		seen[p.KafkaTopic] = true
+	}
+	for _, route := range p.KafkaRoutes {
+		if route.HasStaticTopic() && !seen[route.Topic] {
+			topics = append(topics, route.Topic)
+			seen[route.Topic] = true
+		}
+	}
+	for _, topic := range p.KafkaSinks {
+		if !seen[topic] {
+			topics = append(topics, topic)
+			seen[topic] = true
+		}
+	}
+
+	return topics
 }
 
 func (p Pipe) String() string {
@@ -22,6 +534,335 @@ func (p Pipe) String() string {
 	return string(b)
 }
 
+// Validate checks that pipe settings are consistent
+func (p Pipe) Validate() error {
+	if p.RabbitQueueName == "" {
+		return ErrMissingQueueName
+	}
+
+	if p.RabbitConsumers < 0 {
+		return ErrInvalidConsumers
+	}
+	if p.RabbitStrictOrdering && p.RabbitConsumers > 1 {
+		return ErrStrictOrderingConsumers
+	}
+	if p.KafkaKeyOrdering && p.RabbitConsumers > 1 {
+		return ErrKeyOrderingConsumers
+	}
+	if p.Concurrency < 0 {
+		return ErrInvalidConcurrency
+	}
+	if (p.RabbitStrictOrdering || p.KafkaKeyOrdering) && p.Concurrency > 1 {
+		return ErrOrderingConcurrency
+	}
+
+	if !validPartitionKey(p.KafkaPartitionKey) {
+		return ErrInvalidPartitionKey
+	}
+	if err := p.validateRoutes(); err != nil {
+		return err
+	}
+	if err := p.validateSinks(); err != nil {
+		return err
+	}
+	if !validTimestamp(p.KafkaTimestamp) {
+		return ErrInvalidTimestamp
+	}
+	if !validDedupKey(p.DedupKey) {
+		return ErrInvalidDedupKey
+	}
+	if _, err := ParseRateLimit(p.RateLimit); err != nil {
+		return err
+	}
+
+	if IsTopicTemplate(p.KafkaTopic) {
+		if _, err := ParseTemplate("topic", p.KafkaTopic); err != nil {
+			return err
+		}
+		if p.KafkaCreateTopic != nil {
+			return ErrTemplatedTopicCreate
+		}
+	}
+	if IsTopicTemplate(p.KafkaErrorTopic) {
+		return ErrTemplatedErrorTopic
+	}
+	if p.Filter != "" {
+		if _, err := CompileFilter(p.Filter); err != nil {
+			return err
+		}
+	}
+	if p.Transform != "" {
+		if _, err := ParseTemplate("transform", p.Transform); err != nil {
+			return err
+		}
+	}
+	if p.Plugin != nil && p.Plugin.Path == "" {
+		return ErrMissingPluginPath
+	}
+
+	if p.KafkaCreateTopic != nil && (p.KafkaCreateTopic.Partitions < 1 || p.KafkaCreateTopic.ReplicationFactor < 1) {
+		return ErrInvalidTopicSettings
+	}
+
+	if p.KafkaMaxMessageBytes < 0 {
+		return ErrInvalidMaxMessageBytes
+	}
+	switch p.KafkaOversizePolicy {
+	case "", OversizePolicyDrop, OversizePolicyDeadLetter, OversizePolicyTruncate:
+	default:
+		return ErrUnknownOversizePolicy
+	}
+
+	switch p.KafkaEnvelope {
+	case "", EnvelopeJSON:
+	case EnvelopeAvro:
+		if p.KafkaSchema == nil || p.KafkaSchema.Type != SchemaTypeAvro {
+			return ErrEnvelopeSchema
+		}
+	default:
+		return ErrUnknownEnvelope
+	}
+
+	switch p.KafkaDelivery {
+	case "", DeliveryFireAndForget, DeliveryAtLeastOnce, DeliveryEndToEnd:
+	case DeliveryTransactional:
+		if p.Sink != "" && p.Sink != SinkKafka {
+			return ErrTransactionalSink
+		}
+	default:
+		return ErrUnknownDelivery
+	}
+
+	if p.KafkaSchema != nil {
+		switch p.KafkaSchema.Type {
+		case SchemaTypeAvro, SchemaTypeJSON:
+		case SchemaTypeProtobuf:
+			// message name of registry schema defaults to its first message type
+			if p.KafkaSchema.File != "" && p.KafkaSchema.Message == "" {
+				return ErrProtobufSchema
+			}
+		default:
+			return ErrUnknownSchemaType
+		}
+		if p.KafkaSchema.File != "" && (p.KafkaSchema.Subject != "" || p.KafkaSchema.Schema != "") {
+			return ErrSchemaFile
+		}
+		if p.KafkaOversizePolicy == OversizePolicyTruncate {
+			return ErrSchemaTruncate
+		}
+	}
+
+	switch p.Sink {
+	case "", SinkKafka:
+	case SinkNATS:
+		if p.KafkaCluster != "" || p.KafkaCreateTopic != nil || p.KafkaPartitionKey != "" {
+			return ErrNATSKafkaSettings
+		}
+	case SinkKinesis:
+		// partition key is Kinesis partition key, so it is supported
+		if p.KafkaCluster != "" || p.KafkaCreateTopic != nil {
+			return ErrKinesisKafkaSettings
+		}
+	case SinkPulsar:
+		// partition key is Pulsar message key, so it is supported
+		if p.KafkaCluster != "" || p.KafkaCreateTopic != nil {
+			return ErrPulsarKafkaSettings
+		}
+	default:
+		return ErrUnknownSink
+	}
+
+	if p.DryRun && (p.Protocol == ProtocolAMQP10 || p.Protocol == ProtocolMQTT || p.RabbitExistingQueue) {
+		return ErrDryRunQueue
+	}
+
+	switch p.Protocol {
+	case "", ProtocolAMQP091:
+		if p.RabbitRetry != nil {
+			if err := p.RabbitRetry.Validate(); err != nil {
+				return err
+			}
+		}
+	case ProtocolAMQP10:
+		// AMQP 1.0 pipe has single receiver, consumers are defaulted to 1 on load
+		if p.RabbitConsumers > 1 || p.RabbitStrictOrdering {
+			return ErrAMQP10RabbitSettings
+		}
+		// AMQP 1.0 has no exchanges and bindings, so the rest of settings are not used
+		return nil
+	case ProtocolMQTT:
+		// queue name identifies MQTT pipe client and its shared subscriptions group
+		if strings.ContainsAny(p.RabbitQueueName, "/+#") {
+			return ErrInvalidMQTTQueueName
+		}
+		if !validTopicFilters(p.RabbitRoutingKey) {
+			return ErrInvalidTopicFilter
+		}
+		return nil
+	default:
+		return ErrUnknownProtocol
+	}
+
+	if p.RabbitExistingQueue {
+		// exchange and bindings are managed outside of kandalf, nothing to check here
+		return nil
+	}
+
+	switch p.RabbitExchangeType {
+	case "", ExchangeTypeTopic:
+		if !validRoutingKeys(p.RabbitRoutingKey) {
+			return ErrInvalidRoutingKey
+		}
+	case ExchangeTypeHeaders:
+		if len(p.RabbitBindingArguments) == 0 {
+			return ErrMissingBindingArguments
+		}
+	default:
+		return ErrUnknownExchangeType
+	}
+
+	switch p.RabbitHeadersMatch {
+	case "", HeadersMatchAll, HeadersMatchAny:
+	default:
+		return ErrUnknownHeadersMatch
+	}
+
+	return nil
+}
+
+// validateRoutes checks that pipe route key and routes are consistent
+func (p Pipe) validateRoutes() error {
+	if (p.KafkaRouteKey == "") != (len(p.KafkaRoutes) == 0) {
+		return ErrInconsistentRoutes
+	}
+	if !validPartitionKey(p.KafkaRouteKey) {
+		return ErrInvalidRouteKey
+	}
+
+	keys := make(map[string]bool, len(p.KafkaRoutes))
+	for _, route := range p.KafkaRoutes {
+		if route.Topic == "" || IsTopicTemplate(route.Topic) {
+			return ErrInvalidRoute
+		}
+		if route.Pattern != "" {
+			if route.Key != "" {
+				return ErrInvalidRoutePattern
+			}
+			if _, err := regexp.Compile(route.Pattern); err != nil {
+				return ErrInvalidRoutePattern
+			}
+			continue
+		}
+
+		if keys[route.Key] {
+			return ErrInvalidRoute
+		}
+		keys[route.Key] = true
+	}
+
+	return nil
+}
+
+// validateSinks checks that pipe sinks are static topics that message is not published to already
+func (p Pipe) validateSinks() error {
+	switch p.KafkaSinkPolicy {
+	case "", SinkPolicyAll, SinkPolicyPrimary:
+	default:
+		return ErrUnknownSinkPolicy
+	}
+
+	topics := map[string]bool{"": true, p.KafkaTopic: true}
+	for _, route := range p.KafkaRoutes {
+		topics[route.Topic] = true
+	}
+	for _, topic := range p.KafkaSinks {
+		if topics[topic] || IsTopicTemplate(topic) {
+			return ErrInvalidSink
+		}
+		topics[topic] = true
+	}
+
+	return nil
+}
+
+// validRoutingKeys checks that topic exchange binding keys are unique and their wildcards are whole words,
+// e.g. "order.*" or "order.#", as RabbitMQ matches "order.cre*" literally
+func validRoutingKeys(keys []string) bool {
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+
+		for _, word := range strings.Split(key, ".") {
+			if word != "*" && word != "#" && strings.ContainsAny(word, "*#") {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// validTopicFilters checks that MQTT topic filters are unique and their wildcards are whole levels - "+" matches
+// exactly one level and "#" matches any number of levels, so it must be the last one
+func validTopicFilters(filters []string) bool {
+	if len(filters) == 0 {
+		return false
+	}
+
+	seen := make(map[string]bool, len(filters))
+	for _, filter := range filters {
+		if filter == "" || seen[filter] {
+			return false
+		}
+		seen[filter] = true
+
+		levels := strings.Split(filter, "/")
+		for i, level := range levels {
+			if level != "+" && level != "#" && strings.ContainsAny(level, "+#") {
+				return false
+			}
+			if level == "#" && i != len(levels)-1 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func validPartitionKey(expression string) bool {
+	switch {
+	case expression == "", expression == PartitionKeyRoutingKey:
+		return true
+	case strings.HasPrefix(expression, PartitionKeyHeaderPrefix):
+		return len(expression) > len(PartitionKeyHeaderPrefix)
+	case strings.HasPrefix(expression, PartitionKeyJSONPrefix):
+		return len(expression) > len(PartitionKeyJSONPrefix)
+	}
+
+	return false
+}
+
+func validDedupKey(expression string) bool {
+	if expression == DedupMessageID {
+		return true
+	}
+
+	return expression != PartitionKeyRoutingKey && validPartitionKey(expression)
+}
+
+func validTimestamp(expression string) bool {
+	if expression == TimestampProperty {
+		return true
+	}
+
+	// header and body field expressions are the same as partition key ones
+	return expression != PartitionKeyRoutingKey && validPartitionKey(expression)
+}
+
 // LoadPipesFromFile loads pipes config from file
 func LoadPipesFromFile(pipesConfigPath string) ([]Pipe, error) {
 	pipesConfigReader := viper.New()
@@ -38,5 +879,72 @@ func LoadPipesFromFile(pipesConfigPath string) ([]Pipe, error) {
 		return nil, err
 	}
 
+	for i := range pipes.Pipes {
+		if pipes.Pipes[i].Protocol == "" {
+			pipes.Pipes[i].Protocol = ProtocolAMQP091
+		}
+		if pipes.Pipes[i].RabbitExchangeType == "" {
+			pipes.Pipes[i].RabbitExchangeType = ExchangeTypeTopic
+		}
+		if pipes.Pipes[i].RabbitConsumers == 0 {
+			pipes.Pipes[i].RabbitConsumers = 1
+		}
+		// viper lowercases config keys, so cluster names are matched in lower case
+		pipes.Pipes[i].KafkaCluster = strings.ToLower(pipes.Pipes[i].KafkaCluster)
+		if pipes.Pipes[i].KafkaEnvelope == EnvelopeAvro && pipes.Pipes[i].KafkaSchema == nil {
+			pipes.Pipes[i].KafkaSchema = &SchemaSettings{Type: SchemaTypeAvro, Schema: EnvelopeAvroSchema}
+		}
+		if pipes.Pipes[i].KafkaMaxMessageBytes > 0 && pipes.Pipes[i].KafkaOversizePolicy == "" {
+			pipes.Pipes[i].KafkaOversizePolicy = OversizePolicyDeadLetter
+		}
+
+		if err := pipes.Pipes[i].Validate(); err != nil {
+			log.WithError(err).WithField("pipe", pipes.Pipes[i].String()).Error("Invalid pipe configuration")
+			return nil, err
+		}
+	}
+
 	return pipes.Pipes, nil
 }
+
+// DiffPipes compares reloaded pipes with the current ones, pipes are matched by virtual host and queue name.
+// Pipes that differ in rate limit or paused flag only are not changed, as these settings are applied without
+// restarting pipe consumers. Changed pipes are returned with reloaded settings.
+func DiffPipes(current []Pipe, reloaded []Pipe) (added []Pipe, removed []Pipe, changed []Pipe) {
+	currentPipes := make(map[string]Pipe, len(current))
+	for _, pipe := range current {
+		currentPipes[pipe.Key()] = pipe
+	}
+
+	reloadedKeys := make(map[string]bool, len(reloaded))
+	for _, pipe := range reloaded {
+		reloadedKeys[pipe.Key()] = true
+
+		currentPipe, ok := currentPipes[pipe.Key()]
+		if !ok {
+			added = append(added, pipe)
+		} else if !reflect.DeepEqual(currentPipe.withoutRuntimeSettings(), pipe.withoutRuntimeSettings()) {
+			changed = append(changed, pipe)
+		}
+	}
+
+	for _, pipe := range current {
+		if !reloadedKeys[pipe.Key()] {
+			removed = append(removed, pipe)
+		}
+	}
+
+	return added, removed, changed
+}
+
+// Key identifies pipe by virtual host and queue name
+func (p Pipe) Key() string {
+	return p.RabbitVHost + "/" + p.RabbitQueueName
+}
+
+// withoutRuntimeSettings returns pipe copy without settings that are applied to running pipe
+func (p Pipe) withoutRuntimeSettings() Pipe {
+	p.RateLimit = ""
+	p.Paused = false
+	return p
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -18,6 +19,13 @@ func assertPipes(t *testing.T, pipes []Pipe) {
 	assert.Equal(t, true, pipes[0].RabbitDurableQueue)
 	assert.Equal(t, false, pipes[0].RabbitAutoDeleteQueue)
 	assert.Equal(t, false, pipes[0].RabbitTransientExchange)
+	assert.Equal(t, 1, pipes[0].RabbitConsumers)
+	assert.Equal(t, true, pipes[0].RabbitStrictOrdering)
+	assert.Equal(t, "json:customer.id", pipes[0].KafkaPartitionKey)
+	assert.True(t, pipes[0].KafkaKeyOrdering)
+	assert.False(t, pipes[1].KafkaKeyOrdering)
+	assert.Equal(t, TimestampProperty, pipes[0].KafkaTimestamp)
+	assert.Empty(t, pipes[1].KafkaTimestamp)
 
 	assert.Equal(t, "customers", pipes[1].RabbitExchangeName)
 	assert.Equal(t, []string{"badge.received"}, pipes[1].RabbitRoutingKey)
@@ -26,6 +34,14 @@ func assertPipes(t *testing.T, pipes []Pipe) {
 	assert.Equal(t, false, pipes[1].RabbitDurableQueue)
 	assert.Equal(t, true, pipes[1].RabbitAutoDeleteQueue)
 	assert.Equal(t, true, pipes[1].RabbitTransientExchange)
+	assert.Equal(t, "dc2", pipes[1].KafkaCluster)
+	assert.Empty(t, pipes[0].KafkaCluster)
+	assert.Equal(t, EnvelopeJSON, pipes[1].KafkaEnvelope)
+	assert.Nil(t, pipes[1].KafkaSchema)
+	assert.Equal(t, EnvelopeAvro, pipes[5].KafkaEnvelope)
+	require.NotNil(t, pipes[5].KafkaSchema)
+	assert.Equal(t, SchemaSettings{Type: SchemaTypeAvro, Schema: EnvelopeAvroSchema}, *pipes[5].KafkaSchema)
+	assert.Empty(t, pipes[0].KafkaEnvelope)
 
 	assert.Equal(t, "users", pipes[2].RabbitExchangeName)
 	assert.Equal(t, []string{"user.de.registered", "user.at.registered", "user.ch.registered"}, pipes[2].RabbitRoutingKey)
@@ -34,9 +50,95 @@ func assertPipes(t *testing.T, pipes []Pipe) {
 	assert.Equal(t, true, pipes[2].RabbitDurableQueue)
 	assert.Equal(t, false, pipes[2].RabbitAutoDeleteQueue)
 	assert.Equal(t, false, pipes[2].RabbitTransientExchange)
+	assert.Equal(t, 4, pipes[2].RabbitConsumers)
+	require.NotNil(t, pipes[2].KafkaCreateTopic)
+	assert.Equal(t, DeliveryAtLeastOnce, pipes[2].KafkaDelivery)
+	assert.Empty(t, pipes[1].KafkaDelivery)
+	assert.True(t, pipes[2].Replicated)
+	assert.False(t, pipes[0].Replicated)
+	assert.Equal(t, DedupMessageID, pipes[2].DedupKey)
+	assert.Empty(t, pipes[0].DedupKey)
+	assert.Equal(t, "500/s", pipes[2].RateLimit)
+	assert.Equal(t, `headers["x-test-user"] != "true"`, pipes[2].Filter)
+	assert.Empty(t, pipes[1].Filter)
+	assert.Empty(t, pipes[0].RateLimit)
+	assert.Equal(t, TopicSettings{Partitions: 12, ReplicationFactor: 3, Configs: map[string]string{"retention.ms": "604800000"}}, *pipes[2].KafkaCreateTopic)
 
 	assert.Equal(t, "missing.transient.exchange", pipes[3].KafkaTopic)
 	assert.Equal(t, false, pipes[3].RabbitTransientExchange)
+	assert.True(t, pipes[3].Paused)
+	assert.False(t, pipes[2].Paused)
+	assert.Equal(t, ExchangeTypeTopic, pipes[3].RabbitExchangeType)
+
+	assert.Equal(t, "billing", pipes[4].RabbitExchangeName)
+	assert.Equal(t, ExchangeTypeHeaders, pipes[4].RabbitExchangeType)
+	assert.Empty(t, pipes[4].RabbitRoutingKey)
+	assert.Equal(t, map[string]interface{}{"region": "eu", "type": "invoice"}, pipes[4].RabbitBindingArguments)
+	assert.Equal(t, HeadersMatchAll, pipes[4].RabbitHeadersMatch)
+	assert.Equal(t, "header:customer-id", pipes[4].KafkaPartitionKey)
+	require.NotNil(t, pipes[4].KafkaHeaders)
+	assert.Empty(t, pipes[4].KafkaHeaders.Include)
+	assert.Equal(t, []string{"type"}, pipes[4].KafkaHeaders.Exclude)
+	assert.Nil(t, pipes[3].KafkaHeaders)
+	assert.Equal(t, "eu-invoices", pipes[4].KafkaTopic)
+	assert.Equal(t, false, pipes[4].RabbitExistingQueue)
+
+	assert.Equal(t, "managed-queue-events", pipes[5].KafkaTopic)
+	assert.Equal(t, "managed.events", pipes[5].RabbitQueueName)
+	assert.Equal(t, true, pipes[5].RabbitExistingQueue)
+	assert.Empty(t, pipes[5].RabbitExchangeName)
+	assert.Equal(t, ProtocolAMQP091, pipes[5].Protocol)
+	assert.Equal(t, "team-events", pipes[5].RabbitVHost)
+	assert.Equal(t, "events", pipes[5].RabbitUsername)
+	assert.Equal(t, "events-secret", pipes[5].RabbitPassword)
+	assert.NotContains(t, pipes[5].String(), "events-secret")
+	assert.Empty(t, pipes[4].RabbitVHost)
+
+	assert.Equal(t, "service-bus-orders", pipes[6].KafkaTopic)
+	assert.Equal(t, "orders", pipes[6].RabbitQueueName)
+	assert.Equal(t, ProtocolAMQP10, pipes[6].Protocol)
+
+	assert.Equal(t, "payments", pipes[7].KafkaTopic)
+	assert.Nil(t, pipes[6].RabbitRetry)
+	require.NotNil(t, pipes[7].RabbitRetry)
+	assert.Equal(t, RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Minute, MaxAttempts: 10}, *pipes[7].RabbitRetry)
+	assert.Equal(t, 1000000, pipes[7].KafkaMaxMessageBytes)
+	assert.Equal(t, OversizePolicyDeadLetter, pipes[7].KafkaOversizePolicy)
+	assert.Equal(t, `{{toJSON (omit .Data "card")}}`, pipes[7].Transform)
+	assert.Equal(t, "payments-errors", pipes[7].KafkaErrorTopic)
+	assert.Nil(t, pipes[0].KafkaSchema)
+	require.NotNil(t, pipes[7].KafkaSchema)
+	assert.Equal(t, SchemaSettings{Type: SchemaTypeJSON, Subject: "payments-value"}, *pipes[7].KafkaSchema)
+
+	assert.Equal(t, 0, pipes[0].KafkaMaxMessageBytes)
+	assert.Empty(t, pipes[0].KafkaOversizePolicy)
+
+	assert.Equal(t, `{{.Header "tenant"}}.audit`, pipes[8].KafkaTopic)
+	assert.True(t, IsTopicTemplate(pipes[8].KafkaTopic))
+	assert.False(t, IsTopicTemplate(pipes[7].KafkaTopic))
+
+	assert.Equal(t, "json:event_type", pipes[9].KafkaRouteKey)
+	assert.Equal(t, []Route{
+		{Key: "order.created", Topic: "new-orders"},
+		{Key: "badge.received", Topic: "loyalty"},
+		{Pattern: `^customer\.(\w+)$`, Topic: "customers.$1"},
+	}, pipes[9].KafkaRoutes)
+	assert.Equal(t, []string{"customer-events", "new-orders", "loyalty"}, pipes[9].Topics())
+	assert.Empty(t, pipes[8].KafkaRoutes)
+	assert.Equal(t, []string{"payments-audit"}, pipes[7].KafkaSinks)
+	assert.Equal(t, SinkPolicyPrimary, pipes[7].KafkaSinkPolicy)
+	assert.Equal(t, []string{"payments", "payments-audit"}, pipes[7].Topics())
+	assert.Empty(t, pipes[0].KafkaSinks)
+
+	require.NotNil(t, pipes[1].Plugin)
+	assert.Equal(t, PluginSettings{Path: "/etc/kandalf/plugins/loyalty.so", Config: map[string]string{"tiersURL": "http://loyalty/tiers"}}, *pipes[1].Plugin)
+	assert.Nil(t, pipes[0].Plugin)
+	assert.Equal(t, 4, pipes[1].Concurrency)
+	assert.Equal(t, 0, pipes[0].Concurrency)
+
+	assert.Equal(t, ProtocolMQTT, pipes[10].Protocol)
+	assert.Equal(t, []string{"sensors/+/temperature", "sensors/+/humidity"}, pipes[10].RabbitRoutingKey)
+	assert.Equal(t, "kandalf-sensors", pipes[10].RabbitQueueName)
 }
 
 func TestLoadPipesFromFile(t *testing.T) {
@@ -51,7 +153,7 @@ func TestLoadPipesFromFile(t *testing.T) {
 
 	pipes, err := LoadPipesFromFile(pipesPath)
 	require.NoError(t, err)
-	assert.Len(t, pipes, 4)
+	assert.Len(t, pipes, 11)
 
 	assertPipes(t, pipes)
 }
@@ -95,3 +197,432 @@ func TestPipe_String(t *testing.T) {
 	assert.Equal(t, pipeJSON, pipe.String())
 	assert.Equal(t, pipeJSON, fmt.Sprintf("%s", pipe))
 }
+
+func TestPipe_Validate(t *testing.T) {
+	pipe := Pipe{RabbitExchangeType: ExchangeTypeTopic}
+	assert.Equal(t, ErrMissingQueueName, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitExchangeType: ExchangeTypeTopic}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitExchangeType: "fanout"}
+	assert.Equal(t, ErrUnknownExchangeType, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitExchangeType: ExchangeTypeHeaders}
+	assert.Equal(t, ErrMissingBindingArguments, pipe.Validate())
+
+	pipe = Pipe{
+		RabbitQueueName:        "queue",
+		RabbitExchangeType:     ExchangeTypeHeaders,
+		RabbitBindingArguments: map[string]interface{}{"region": "eu"},
+		RabbitHeadersMatch:     HeadersMatchAny,
+	}
+	assert.NoError(t, pipe.Validate())
+
+	pipe.RabbitHeadersMatch = "some"
+	assert.Equal(t, ErrUnknownHeadersMatch, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitRoutingKey: []string{"order.created", "order.*.paid", "customer.#", "#"}}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitRoutingKey: []string{"order.cre*"}}
+	assert.Equal(t, ErrInvalidRoutingKey, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitRoutingKey: []string{"order.#paid"}}
+	assert.Equal(t, ErrInvalidRoutingKey, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitRoutingKey: []string{"order.*", "order.*"}}
+	assert.Equal(t, ErrInvalidRoutingKey, pipe.Validate())
+
+	// bindings of existing queue are managed outside of kandalf
+	pipe = Pipe{RabbitQueueName: "queue", RabbitExistingQueue: true, RabbitRoutingKey: []string{"order.cre*"}}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitConsumers: -1}
+	assert.Equal(t, ErrInvalidConsumers, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitConsumers: 2, RabbitStrictOrdering: true}
+	assert.Equal(t, ErrStrictOrderingConsumers, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitConsumers: 1, RabbitStrictOrdering: true}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitConsumers: 2, KafkaKeyOrdering: true}
+	assert.Equal(t, ErrKeyOrderingConsumers, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaKeyOrdering: true}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Protocol: "stomp"}
+	assert.Equal(t, ErrUnknownProtocol, pipe.Validate())
+
+	// exchange settings are not checked for AMQP 1.0 and pre-existing queue as they are not used
+	pipe = Pipe{RabbitQueueName: "queue", RabbitExchangeType: "fanout", Protocol: ProtocolAMQP10}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Protocol: ProtocolAMQP10, RabbitConsumers: 1}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Protocol: ProtocolAMQP10, RabbitConsumers: 2}
+	assert.Equal(t, ErrAMQP10RabbitSettings, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Protocol: ProtocolAMQP10, RabbitStrictOrdering: true}
+	assert.Equal(t, ErrAMQP10RabbitSettings, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitExchangeType: "fanout", RabbitExistingQueue: true}
+	assert.NoError(t, pipe.Validate())
+
+	for _, filters := range [][]string{{"sensors/+/temperature"}, {"sensors/#"}, {"#"}, {"+"}, {"sensors", "alerts/+"}} {
+		pipe = Pipe{RabbitQueueName: "queue", Protocol: ProtocolMQTT, RabbitRoutingKey: filters}
+		assert.NoError(t, pipe.Validate(), filters)
+	}
+
+	for _, filters := range [][]string{nil, {""}, {"sensors/#/temperature"}, {"sensors/temp+"}, {"sensors#"}, {"sensors/+", "sensors/+"}} {
+		pipe = Pipe{RabbitQueueName: "queue", Protocol: ProtocolMQTT, RabbitRoutingKey: filters}
+		assert.Equal(t, ErrInvalidTopicFilter, pipe.Validate(), filters)
+	}
+
+	pipe = Pipe{RabbitQueueName: "sensors/queue", Protocol: ProtocolMQTT, RabbitRoutingKey: []string{"sensors/#"}}
+	assert.Equal(t, ErrInvalidMQTTQueueName, pipe.Validate())
+
+	for _, partitionKey := range []string{"", "routingKey", "header:customer-id", "json:customer.id"} {
+		pipe = Pipe{RabbitQueueName: "queue", KafkaPartitionKey: partitionKey}
+		assert.NoError(t, pipe.Validate())
+	}
+	for _, partitionKey := range []string{"exchange", "header:", "json:"} {
+		pipe = Pipe{RabbitQueueName: "queue", KafkaPartitionKey: partitionKey}
+		assert.Equal(t, ErrInvalidPartitionKey, pipe.Validate())
+	}
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaCreateTopic: &TopicSettings{Partitions: 3, ReplicationFactor: 1}}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaCreateTopic: &TopicSettings{ReplicationFactor: 1}}
+	assert.Equal(t, ErrInvalidTopicSettings, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaCreateTopic: &TopicSettings{Partitions: 3}}
+	assert.Equal(t, ErrInvalidTopicSettings, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events.{{.RoutingKey}}"}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events.{{.RoutingKey"}
+	assert.Error(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events.{{.RoutingKey}}", KafkaCreateTopic: &TopicSettings{Partitions: 3, ReplicationFactor: 1}}
+	assert.Equal(t, ErrTemplatedTopicCreate, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", KafkaErrorTopic: "{{.RoutingKey}}.errors"}
+	assert.Equal(t, ErrTemplatedErrorTopic, pipe.Validate())
+
+	routes := []Route{{Key: "order.created", Topic: "orders"}, {Key: "order.paid", Topic: "payments"}}
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "json:event_type", KafkaRoutes: routes}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "body", KafkaRoutes: routes}
+	assert.Equal(t, ErrInvalidRouteKey, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "json:event_type"}
+	assert.Equal(t, ErrInconsistentRoutes, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRoutes: routes}
+	assert.Equal(t, ErrInconsistentRoutes, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "routingKey", KafkaRoutes: append(routes, Route{Key: "order.created", Topic: "other"})}
+	assert.Equal(t, ErrInvalidRoute, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "routingKey", KafkaRoutes: []Route{{Key: "order.created", Topic: "{{.RoutingKey}}"}}}
+	assert.Equal(t, ErrInvalidRoute, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "routingKey", KafkaRoutes: []Route{{Key: "order.created"}}}
+	assert.Equal(t, ErrInvalidRoute, pipe.Validate())
+
+	patternRoutes := []Route{{Pattern: `^order\.(\w+)\.created$`, Topic: "orders.$1"}, {Pattern: `^order\.`, Topic: "orders"}}
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "routingKey", KafkaRoutes: append(routes, patternRoutes...)}
+	assert.NoError(t, pipe.Validate())
+	assert.Equal(t, []string{"orders", "payments"}, pipe.Topics())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "routingKey", KafkaRoutes: []Route{{Key: "order.created", Pattern: `^order\.`, Topic: "orders"}}}
+	assert.Equal(t, ErrInvalidRoutePattern, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "routingKey", KafkaRoutes: []Route{{Pattern: `^order\.(`, Topic: "orders"}}}
+	assert.Equal(t, ErrInvalidRoutePattern, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "routingKey", KafkaRoutes: []Route{{Pattern: `^order\.`}}}
+	assert.Equal(t, ErrInvalidRoute, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", KafkaSinks: []string{"audit"}, KafkaSinkPolicy: SinkPolicyAll}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", KafkaSinks: []string{"audit"}, KafkaSinkPolicy: "any"}
+	assert.Equal(t, ErrUnknownSinkPolicy, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", KafkaSinks: []string{"audit", "audit"}}
+	assert.Equal(t, ErrInvalidSink, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", KafkaSinks: []string{"events"}}
+	assert.Equal(t, ErrInvalidSink, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaRouteKey: "routingKey", KafkaRoutes: routes, KafkaSinks: []string{routes[0].Topic}}
+	assert.Equal(t, ErrInvalidSink, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", KafkaSinks: []string{"audit.{{.RoutingKey}}"}}
+	assert.Equal(t, ErrInvalidSink, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", KafkaSinks: []string{""}}
+	assert.Equal(t, ErrInvalidSink, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Filter: `routingKey == "order.created" && data.total > 10.0`}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Concurrency: 4}
+	assert.NoError(t, pipe.Validate())
+	assert.Equal(t, 4, pipe.HandlersNumber())
+	assert.Equal(t, 1, Pipe{}.HandlersNumber())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Concurrency: -1}
+	assert.Equal(t, ErrInvalidConcurrency, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Concurrency: 4, RabbitStrictOrdering: true}
+	assert.Equal(t, ErrOrderingConcurrency, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Concurrency: 4, KafkaKeyOrdering: true}
+	assert.Equal(t, ErrOrderingConcurrency, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Plugin: &PluginSettings{Path: "enrich.so"}}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Plugin: &PluginSettings{Config: map[string]string{"region": "eu"}}}
+	assert.Equal(t, ErrMissingPluginPath, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Filter: `routingKey ==`}
+	assert.Error(t, pipe.Validate())
+
+	// expressions are type-checked
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Filter: `headers.region == 1`}
+	assert.Error(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Filter: `size(headers)`}
+	assert.Equal(t, ErrFilterNotBool, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Filter: `tenant == "de"`}
+	assert.Error(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaEnvelope: EnvelopeJSON}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaEnvelope: "xml"}
+	assert.Equal(t, ErrUnknownEnvelope, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaEnvelope: EnvelopeAvro}
+	assert.Equal(t, ErrEnvelopeSchema, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaEnvelope: EnvelopeAvro, KafkaSchema: &SchemaSettings{Type: SchemaTypeJSON}}
+	assert.Equal(t, ErrEnvelopeSchema, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaEnvelope: EnvelopeAvro, KafkaSchema: &SchemaSettings{Type: SchemaTypeAvro, Subject: "envelopes"}}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Transform: `{{toJSON (omit .Data "email")}}`}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaTopic: "events", Transform: `{{unknown .Data}}`}
+	assert.Error(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaMaxMessageBytes: -1}
+	assert.Equal(t, ErrInvalidMaxMessageBytes, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaMaxMessageBytes: 1024, KafkaOversizePolicy: "split"}
+	assert.Equal(t, ErrUnknownOversizePolicy, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaMaxMessageBytes: 1024, KafkaOversizePolicy: OversizePolicyTruncate}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaDelivery: DeliveryFireAndForget}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaDelivery: DeliveryEndToEnd}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaDelivery: DeliveryTransactional}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaDelivery: DeliveryTransactional, Sink: SinkNATS}
+	assert.Equal(t, ErrTransactionalSink, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaDelivery: "exactly-once"}
+	assert.Equal(t, ErrUnknownDelivery, pipe.Validate())
+
+	for _, timestamp := range []string{"", TimestampProperty, "header:occurred-at", "json:occurredAt"} {
+		pipe = Pipe{RabbitQueueName: "queue", KafkaTimestamp: timestamp}
+		assert.NoError(t, pipe.Validate(), timestamp)
+	}
+
+	for _, timestamp := range []string{PartitionKeyRoutingKey, "header:", "json:", "now"} {
+		pipe = Pipe{RabbitQueueName: "queue", KafkaTimestamp: timestamp}
+		assert.Equal(t, ErrInvalidTimestamp, pipe.Validate(), timestamp)
+	}
+
+	for _, key := range []string{"", DedupMessageID, "header:idempotency-key", "json:event.id"} {
+		pipe = Pipe{RabbitQueueName: "queue", DedupKey: key}
+		assert.NoError(t, pipe.Validate(), key)
+	}
+
+	for _, key := range []string{PartitionKeyRoutingKey, "header:", "json:", "id"} {
+		pipe = Pipe{RabbitQueueName: "queue", DedupKey: key}
+		assert.Equal(t, ErrInvalidDedupKey, pipe.Validate(), key)
+	}
+
+	for _, limit := range []string{"0/s", "-1/s", "500", "fast/s", "500/d", "500/-1s"} {
+		pipe = Pipe{RabbitQueueName: "queue", RateLimit: limit}
+		assert.Equal(t, ErrInvalidRateLimit, pipe.Validate(), limit)
+	}
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaSchema: &SchemaSettings{Type: SchemaTypeAvro}}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaSchema: &SchemaSettings{Type: SchemaTypeProtobuf}}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaSchema: &SchemaSettings{Type: SchemaTypeProtobuf, File: "orders.desc"}}
+	assert.Equal(t, ErrProtobufSchema, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaSchema: &SchemaSettings{Type: SchemaTypeProtobuf, File: "orders.desc", Message: "shop.Order"}}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaSchema: &SchemaSettings{Type: SchemaTypeAvro, File: "order.avsc", Subject: "orders-value"}}
+	assert.Equal(t, ErrSchemaFile, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaSchema: &SchemaSettings{Type: "xml"}}
+	assert.Equal(t, ErrUnknownSchemaType, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", KafkaSchema: &SchemaSettings{Type: SchemaTypeJSON}, KafkaMaxMessageBytes: 100, KafkaOversizePolicy: OversizePolicyTruncate}
+	assert.Equal(t, ErrSchemaTruncate, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", DryRun: true}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitExistingQueue: true, DryRun: true}
+	assert.Equal(t, ErrDryRunQueue, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Protocol: ProtocolAMQP10, DryRun: true}
+	assert.Equal(t, ErrDryRunQueue, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Protocol: ProtocolMQTT, RabbitRoutingKey: []string{"sensors/#"}, DryRun: true}
+	assert.Equal(t, ErrDryRunQueue, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Sink: SinkNATS, KafkaTopic: "orders.created", KafkaDelivery: DeliveryAtLeastOnce}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Sink: "sqs"}
+	assert.Equal(t, ErrUnknownSink, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Sink: SinkNATS, KafkaCluster: "dc2"}
+	assert.Equal(t, ErrNATSKafkaSettings, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Sink: SinkNATS, KafkaPartitionKey: PartitionKeyRoutingKey}
+	assert.Equal(t, ErrNATSKafkaSettings, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Sink: SinkKinesis, KafkaTopic: "orders", KafkaPartitionKey: "json:customer.id"}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Sink: SinkKinesis, KafkaTopic: "orders", KafkaCluster: "dc2"}
+	assert.Equal(t, ErrKinesisKafkaSettings, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Sink: SinkKinesis, KafkaTopic: "orders", KafkaCreateTopic: &TopicSettings{Partitions: 1, ReplicationFactor: 1}}
+	assert.Equal(t, ErrKinesisKafkaSettings, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Sink: SinkPulsar, KafkaTopic: "persistent://public/default/orders", KafkaPartitionKey: PartitionKeyRoutingKey}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", Sink: SinkPulsar, KafkaTopic: "orders", KafkaCluster: "dc2"}
+	assert.Equal(t, ErrPulsarKafkaSettings, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitRetry: &RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute}}
+	assert.NoError(t, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitRetry: &RetryPolicy{MaxDelay: time.Minute}}
+	assert.Equal(t, ErrInvalidRetryDelay, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitRetry: &RetryPolicy{InitialDelay: time.Minute, MaxDelay: time.Second}}
+	assert.Equal(t, ErrInvalidRetryDelay, pipe.Validate())
+
+	pipe = Pipe{RabbitQueueName: "queue", RabbitRetry: &RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute, MaxAttempts: -1}}
+	assert.Equal(t, ErrInvalidRetryAttempts, pipe.Validate())
+}
+
+func TestParseRateLimit(t *testing.T) {
+	for expression, expected := range map[string]RateLimit{
+		"":        {},
+		"500/s":   {Messages: 500, Per: time.Second},
+		"1000/m":  {Messages: 1000, Per: time.Minute},
+		"10/h":    {Messages: 10, Per: time.Hour},
+		"100/10s": {Messages: 100, Per: 10 * time.Second},
+	} {
+		limit, err := ParseRateLimit(expression)
+		assert.NoError(t, err, expression)
+		assert.Equal(t, expected, limit, expression)
+	}
+
+	_, err := ParseRateLimit("500/0s")
+	assert.Equal(t, ErrInvalidRateLimit, err)
+}
+
+func TestHeadersMapping_Allowed(t *testing.T) {
+	mapping := HeadersMapping{}
+	assert.True(t, mapping.Allowed("content-type"))
+
+	mapping = HeadersMapping{Exclude: []string{"content-type"}}
+	assert.False(t, mapping.Allowed("content-type"))
+	assert.True(t, mapping.Allowed("exchange"))
+
+	mapping = HeadersMapping{Include: []string{"content-type", "exchange"}, Exclude: []string{"exchange"}}
+	assert.True(t, mapping.Allowed("content-type"))
+	assert.False(t, mapping.Allowed("exchange"))
+	assert.False(t, mapping.Allowed("routing-key"))
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 2*time.Second, policy.Delay(2))
+	assert.Equal(t, 4*time.Second, policy.Delay(3))
+	assert.Equal(t, 8*time.Second, policy.Delay(4))
+	assert.Equal(t, 10*time.Second, policy.Delay(5))
+	assert.Equal(t, 10*time.Second, policy.Delay(100))
+
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}, policy.Delays())
+
+	policy = RetryPolicy{InitialDelay: time.Minute, MaxDelay: time.Minute}
+	assert.Equal(t, []time.Duration{time.Minute}, policy.Delays())
+}
+
+func TestDiffPipes(t *testing.T) {
+	orders := Pipe{KafkaTopic: "orders", RabbitQueueName: "orders", RateLimit: "100/s"}
+	payments := Pipe{KafkaTopic: "payments", RabbitQueueName: "payments"}
+	invoices := Pipe{KafkaTopic: "invoices", RabbitQueueName: "invoices", RabbitVHost: "billing"}
+	current := []Pipe{orders, payments, invoices}
+
+	// runtime settings changes do not require restarting pipe consumers
+	reloadedOrders := orders
+	reloadedOrders.RateLimit = "10/s"
+	reloadedOrders.Paused = true
+
+	reloadedPayments := payments
+	reloadedPayments.KafkaTopic = "payments-v2"
+
+	// pipes are matched by virtual host as well
+	movedInvoices := invoices
+	movedInvoices.RabbitVHost = "finance"
+
+	added, removed, changed := DiffPipes(current, []Pipe{reloadedOrders, reloadedPayments, movedInvoices})
+	assert.Equal(t, []Pipe{movedInvoices}, added)
+	assert.Equal(t, []Pipe{invoices}, removed)
+	assert.Equal(t, []Pipe{reloadedPayments}, changed)
+
+	added, removed, changed = DiffPipes(current, current)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	// ExchangeTypeDirect is RabbitMQ direct exchange type, reverse pipes may publish to it
+	ExchangeTypeDirect = "direct"
+	// ExchangeTypeFanout is RabbitMQ fanout exchange type, reverse pipes may publish to it
+	ExchangeTypeFanout = "fanout"
+
+	// InitialOffsetNewest starts reverse pipe consumer group without committed offsets from the newest messages
+	InitialOffsetNewest = "newest"
+	// InitialOffsetOldest starts reverse pipe consumer group without committed offsets from the oldest messages
+	InitialOffsetOldest = "oldest"
+)
+
+var (
+	// ErrMissingReverseTopics is an error raised when reverse pipe has no Kafka topics
+	ErrMissingReverseTopics = errors.New("reverse pipe requires unique non-empty kafka topics")
+	// ErrMissingReverseGroup is an error raised when reverse pipe has no Kafka consumer group
+	ErrMissingReverseGroup = errors.New("reverse pipe requires kafka consumer group")
+	// ErrDuplicateReverseGroup is an error raised when several reverse pipes share Kafka consumer group
+	ErrDuplicateReverseGroup = errors.New("reverse pipes must have different kafka consumer groups")
+	// ErrUnknownInitialOffset is an error raised when reverse pipe has initial offset other than "newest" or "oldest"
+	ErrUnknownInitialOffset = errors.New("unknown initial offset, supported values are newest and oldest")
+	// ErrUnknownReverseExchangeType is an error raised when reverse pipe has exchange type that is not supported
+	ErrUnknownReverseExchangeType = errors.New("unknown exchange type, supported types are topic, direct, fanout and headers")
+)
+
+// ReversePipe contains settings for consuming Kafka topics with consumer group and publishing their messages
+// to RabbitMQ exchange
+type ReversePipe struct {
+	// KafkaTopics are topics pipe consumes messages from
+	KafkaTopics []string
+	// KafkaGroup is consumer group pipe commits offsets with, instances of the bridge sharing the group
+	// share topics partitions
+	KafkaGroup string
+	// KafkaCluster is a name of Kafka cluster pipe consumes messages from, empty name stands for the main cluster
+	KafkaCluster string `json:",omitempty"`
+	// KafkaInitialOffset is offset consumer group starts from when it has no committed offsets,
+	// "newest" (default) or "oldest"
+	KafkaInitialOffset string `json:",omitempty"`
+	// RabbitExchangeName is exchange pipe publishes messages to, empty name stands for default exchange
+	// that routes messages to queue named as routing key
+	RabbitExchangeName string
+	// RabbitExchangeType is exchange type pipe declares exchange with, "topic" by default
+	RabbitExchangeType string `json:",omitempty"`
+	// RabbitTransientExchange declares exchange that does not survive broker restart
+	RabbitTransientExchange bool `json:",omitempty"`
+	// RabbitRoutingKey is routing key template messages are published with, e.g. '{{.Topic}}.{{.Header "type"}}',
+	// message topic is used as routing key by default
+	RabbitRoutingKey string `json:",omitempty"`
+	// RabbitHeaders publishes Kafka record headers as message headers
+	RabbitHeaders bool `json:",omitempty"`
+	// RabbitTransientMessages publishes messages that do not survive broker restart, messages are persistent by default
+	RabbitTransientMessages bool `json:",omitempty"`
+}
+
+// String returns reverse pipe string representation
+func (p ReversePipe) String() string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+// Validate checks that reverse pipe settings are consistent
+func (p ReversePipe) Validate() error {
+	if p.KafkaGroup == "" {
+		return ErrMissingReverseGroup
+	}
+	if len(p.KafkaTopics) == 0 {
+		return ErrMissingReverseTopics
+	}
+	topics := make(map[string]bool, len(p.KafkaTopics))
+	for _, topic := range p.KafkaTopics {
+		if topic == "" || topics[topic] {
+			return ErrMissingReverseTopics
+		}
+		topics[topic] = true
+	}
+
+	switch p.KafkaInitialOffset {
+	case "", InitialOffsetNewest, InitialOffsetOldest:
+	default:
+		return ErrUnknownInitialOffset
+	}
+
+	switch p.RabbitExchangeType {
+	case ExchangeTypeTopic, ExchangeTypeDirect, ExchangeTypeFanout, ExchangeTypeHeaders:
+	default:
+		return ErrUnknownReverseExchangeType
+	}
+
+	if _, err := ParseTemplate("routingKey", p.RabbitRoutingKey); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LoadReversePipesFromFile loads reverse pipes from "reversePipes" list of pipes config file,
+// file without the list has no reverse pipes
+func LoadReversePipesFromFile(pipesConfigPath string) ([]ReversePipe, error) {
+	pipesConfigReader := viper.New()
+	pipesConfigReader.SetConfigFile(pipesConfigPath)
+	if err := pipesConfigReader.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var pipes struct {
+		ReversePipes []ReversePipe
+	}
+
+	if err := pipesConfigReader.Unmarshal(&pipes); err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]bool, len(pipes.ReversePipes))
+	for i := range pipes.ReversePipes {
+		if pipes.ReversePipes[i].RabbitExchangeType == "" {
+			pipes.ReversePipes[i].RabbitExchangeType = ExchangeTypeTopic
+		}
+		// viper lowercases config keys, so cluster names are matched in lower case
+		pipes.ReversePipes[i].KafkaCluster = strings.ToLower(pipes.ReversePipes[i].KafkaCluster)
+
+		if err := pipes.ReversePipes[i].Validate(); err != nil {
+			log.WithError(err).WithField("pipe", pipes.ReversePipes[i].String()).Error("Invalid reverse pipe configuration")
+			return nil, err
+		}
+
+		// consumer group partitions are assigned among group members, so pipes sharing group would split messages
+		if groups[pipes.ReversePipes[i].KafkaGroup] {
+			return nil, ErrDuplicateReverseGroup
+		}
+		groups[pipes.ReversePipes[i].KafkaGroup] = true
+	}
+
+	return pipes.ReversePipes, nil
+}
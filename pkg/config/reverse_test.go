@@ -0,0 +1,122 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadReversePipesFromFile(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	// .../github.com/hellofresh/kandalf/pkg/config/../../assets/pipes.yml
+	pipesPath := filepath.Join(wd, "..", "..", "assets", "pipes.yml")
+
+	pipes, err := LoadReversePipesFromFile(pipesPath)
+	require.NoError(t, err)
+	require.Len(t, pipes, 2)
+
+	assert.Equal(t, []string{"order-status", "delivery-status"}, pipes[0].KafkaTopics)
+	assert.Equal(t, "kandalf-order-status", pipes[0].KafkaGroup)
+	assert.Equal(t, InitialOffsetOldest, pipes[0].KafkaInitialOffset)
+	assert.Empty(t, pipes[0].KafkaCluster)
+	assert.Equal(t, "orders", pipes[0].RabbitExchangeName)
+	assert.Equal(t, ExchangeTypeTopic, pipes[0].RabbitExchangeType)
+	assert.Equal(t, "{{.Topic}}.{{.Data.status}}", pipes[0].RabbitRoutingKey)
+	assert.True(t, pipes[0].RabbitHeaders)
+	assert.False(t, pipes[0].RabbitTransientMessages)
+
+	assert.Equal(t, []string{"loyalty"}, pipes[1].KafkaTopics)
+	assert.Equal(t, "dc2", pipes[1].KafkaCluster)
+	assert.Empty(t, pipes[1].KafkaInitialOffset)
+	assert.Empty(t, pipes[1].RabbitExchangeName)
+	assert.Equal(t, "loyalty-badges", pipes[1].RabbitRoutingKey)
+	assert.False(t, pipes[1].RabbitHeaders)
+}
+
+func TestLoadReversePipesFromFile_NoReversePipes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-reverse")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pipesPath := filepath.Join(dir, "pipes.yml")
+	require.NoError(t, ioutil.WriteFile(pipesPath, []byte("pipes:\n- kafkaTopic: \"loyalty\"\n  rabbitQueueName: \"kandalf-loyalty\"\n"), 0600))
+
+	pipes, err := LoadReversePipesFromFile(pipesPath)
+	require.NoError(t, err)
+	assert.Empty(t, pipes)
+}
+
+func TestLoadReversePipesFromFile_DuplicateGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-reverse")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pipesPath := filepath.Join(dir, "pipes.yml")
+	require.NoError(t, ioutil.WriteFile(pipesPath, []byte(`reversePipes:
+- kafkaTopics: "orders"
+  kafkaGroup: "kandalf"
+  rabbitExchangeName: "orders"
+- kafkaTopics: "loyalty"
+  kafkaGroup: "kandalf"
+  rabbitExchangeName: "loyalty"
+`), 0600))
+
+	_, err = LoadReversePipesFromFile(pipesPath)
+	assert.Equal(t, ErrDuplicateReverseGroup, err)
+}
+
+func TestReversePipe_Validate(t *testing.T) {
+	pipe := ReversePipe{
+		KafkaTopics:        []string{"orders"},
+		KafkaGroup:         "kandalf-orders",
+		RabbitExchangeName: "orders",
+		RabbitExchangeType: ExchangeTypeTopic,
+	}
+	assert.NoError(t, pipe.Validate())
+
+	noGroup := pipe
+	noGroup.KafkaGroup = ""
+	assert.Equal(t, ErrMissingReverseGroup, noGroup.Validate())
+
+	noTopics := pipe
+	noTopics.KafkaTopics = nil
+	assert.Equal(t, ErrMissingReverseTopics, noTopics.Validate())
+
+	emptyTopic := pipe
+	emptyTopic.KafkaTopics = []string{"orders", ""}
+	assert.Equal(t, ErrMissingReverseTopics, emptyTopic.Validate())
+
+	duplicateTopic := pipe
+	duplicateTopic.KafkaTopics = []string{"orders", "orders"}
+	assert.Equal(t, ErrMissingReverseTopics, duplicateTopic.Validate())
+
+	oldest := pipe
+	oldest.KafkaInitialOffset = InitialOffsetOldest
+	assert.NoError(t, oldest.Validate())
+
+	unknownOffset := pipe
+	unknownOffset.KafkaInitialOffset = "earliest"
+	assert.Equal(t, ErrUnknownInitialOffset, unknownOffset.Validate())
+
+	fanout := pipe
+	fanout.RabbitExchangeType = ExchangeTypeFanout
+	assert.NoError(t, fanout.Validate())
+
+	unknownExchangeType := pipe
+	unknownExchangeType.RabbitExchangeType = "x-delayed-message"
+	assert.Equal(t, ErrUnknownReverseExchangeType, unknownExchangeType.Validate())
+
+	routingKey := pipe
+	routingKey.RabbitRoutingKey = `{{.Topic}}.{{.Header "type"}}`
+	assert.NoError(t, routingKey.Validate())
+
+	invalidRoutingKey := pipe
+	invalidRoutingKey.RabbitRoutingKey = "{{.Topic"
+	assert.Error(t, invalidRoutingKey.Validate())
+}
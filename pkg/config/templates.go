@@ -0,0 +1,123 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"text/template"
+)
+
+// ErrInvalidDict is an error raised when dict template function gets odd number of arguments or non-string keys
+var ErrInvalidDict = errors.New("dict requires key and value pairs with string keys")
+
+// TemplateFuncs are functions available in pipe topic and transform templates in addition to built-in ones
+var TemplateFuncs = template.FuncMap{
+	"toJSON": toJSON,
+	"omit":   omit,
+	"pick":   pick,
+	"dict":   dict,
+}
+
+// ParseTemplate parses pipe topic or transform template with TemplateFuncs
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(TemplateFuncs).Parse(text)
+}
+
+// toJSON encodes value as JSON, e.g. '{{toJSON (omit .Data "email")}}'
+func toJSON(value interface{}) (string, error) {
+	b, err := json.Marshal(value)
+	return string(b), err
+}
+
+// omit returns copy of JSON object without the given fields, nested fields are separated by dots,
+// e.g. '{{omit .Data "customer.email"}}', value that is not an object is returned as is
+func omit(value interface{}, fields ...string) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+
+	result := make(map[string]interface{}, len(object))
+	nested := make(map[string][]string)
+	for _, field := range fields {
+		parts := strings.SplitN(field, ".", 2)
+		if len(parts) == 2 {
+			nested[parts[0]] = append(nested[parts[0]], parts[1])
+		}
+	}
+
+	for name, fieldValue := range object {
+		if contains(fields, name) {
+			continue
+		}
+		if nestedFields, ok := nested[name]; ok {
+			fieldValue = omit(fieldValue, nestedFields...)
+		}
+		result[name] = fieldValue
+	}
+
+	return result
+}
+
+// pick returns JSON object with the given fields only, nested fields are separated by dots and keep their nesting,
+// e.g. '{{pick .Data "id" "customer.id"}}', missing fields are skipped
+func pick(value interface{}, fields ...string) interface{} {
+	result := make(map[string]interface{})
+	for _, field := range fields {
+		fieldValue, ok := value, true
+		for _, name := range strings.Split(field, ".") {
+			var object map[string]interface{}
+			if object, ok = fieldValue.(map[string]interface{}); !ok {
+				break
+			}
+			if fieldValue, ok = object[name]; !ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		target := result
+		names := strings.Split(field, ".")
+		for _, name := range names[:len(names)-1] {
+			child, ok := target[name].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				target[name] = child
+			}
+			target = child
+		}
+		target[names[len(names)-1]] = fieldValue
+	}
+
+	return result
+}
+
+// dict returns JSON object from key and value pairs, e.g. '{{toJSON (dict "event" .Data "source" "kandalf")}}'
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, ErrInvalidDict
+	}
+
+	result := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, ErrInvalidDict
+		}
+		result[key] = pairs[i+1]
+	}
+
+	return result, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemplate(t *testing.T) {
+	var data map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"id":1,"customer":{"id":"c-1","email":"c@example.com"},"total":10}`), &data))
+
+	for text, expected := range map[string]string{
+		`{{toJSON .}}`:                                                   `{"customer":{"email":"c@example.com","id":"c-1"},"id":1,"total":10}`,
+		`{{toJSON (omit . "total")}}`:                                    `{"customer":{"email":"c@example.com","id":"c-1"},"id":1}`,
+		`{{toJSON (omit . "customer.email")}}`:                           `{"customer":{"id":"c-1"},"id":1,"total":10}`,
+		`{{toJSON (omit . "customer.email.x")}}`:                         `{"customer":{"email":"c@example.com","id":"c-1"},"id":1,"total":10}`,
+		`{{toJSON (pick . "id" "customer.id")}}`:                         `{"customer":{"id":"c-1"},"id":1}`,
+		`{{toJSON (pick . "missing" "total.x")}}`:                        `{}`,
+		`{{toJSON (dict "event" (pick . "id"))}}`:                        `{"event":{"id":1}}`,
+		`{{toJSON (dict "customerId" .customer.id "source" "kandalf")}}`: `{"customerId":"c-1","source":"kandalf"}`,
+	} {
+		tmpl, err := ParseTemplate("test", text)
+		require.NoError(t, err, text)
+
+		var result bytes.Buffer
+		require.NoError(t, tmpl.Execute(&result, data), text)
+		assert.Equal(t, expected, result.String(), text)
+	}
+
+	tmpl, err := ParseTemplate("test", `{{toJSON (dict "key")}}`)
+	require.NoError(t, err)
+	assert.Error(t, tmpl.Execute(&bytes.Buffer{}, data))
+
+	_, err = ParseTemplate("test", `{{unknown .}}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,5 @@
+/*
+Package dedup holds time and size bounded window of published messages keys, used to drop duplicates
+caused by broker redeliveries and replication failover replays, and interface for windows storages.
+*/
+package dedup
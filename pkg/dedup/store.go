@@ -0,0 +1,47 @@
+package dedup
+
+import (
+	"sync"
+	"time"
+)
+
+// Store is an interface for storage of published messages dedup keys
+type Store interface {
+	// Seen checks if message with the key was published within dedup window
+	Seen(key string) bool
+	// Add remembers keys of published messages
+	Add(keys ...string) error
+}
+
+// MemoryStore is a Store interface implementation that keeps window in memory, so keys are forgotten on restart
+type MemoryStore struct {
+	sync.Mutex
+
+	window *Window
+}
+
+// NewMemoryStore creates in memory store with the given window size and max number of keys
+func NewMemoryStore(size time.Duration, maxKeys int) *MemoryStore {
+	return &MemoryStore{window: NewWindow(size, maxKeys)}
+}
+
+// Seen checks if key is in window
+func (s *MemoryStore) Seen(key string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.window.Contains(key, time.Now())
+}
+
+// Add adds keys to window
+func (s *MemoryStore) Add(keys ...string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		s.window.Add(key, now)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package dedup
+
+import "time"
+
+// Entry is dedup key with the time it was added to window at
+type Entry struct {
+	Key string    `json:"key"`
+	At  time.Time `json:"at"`
+}
+
+// Window remembers keys for limited amount of time and up to limited number of keys, the oldest keys
+// are forgotten first. Window is not safe for concurrent use. It does not read clock itself, so replicated
+// windows fed with the same entries have the same state.
+type Window struct {
+	size    time.Duration
+	maxKeys int
+
+	keys map[string]time.Time
+	// entries are keys in order they were added, key is added once again every time it is seen,
+	// so entries that do not match keys time are stale
+	entries []Entry
+}
+
+// NewWindow creates window that remembers keys for size amount of time, up to maxKeys keys,
+// 0 means no limit
+func NewWindow(size time.Duration, maxKeys int) *Window {
+	return &Window{size: size, maxKeys: maxKeys, keys: make(map[string]time.Time)}
+}
+
+// Add adds key to window at the given time and forgets keys that do not fit window anymore
+func (w *Window) Add(key string, at time.Time) {
+	w.keys[key] = at
+	w.entries = append(w.entries, Entry{Key: key, At: at})
+	w.prune(at)
+}
+
+// Contains checks if key was added to window within window size before now
+func (w *Window) Contains(key string, now time.Time) bool {
+	at, ok := w.keys[key]
+	return ok && (w.size <= 0 || now.Sub(at) <= w.size)
+}
+
+// Len returns number of keys in window
+func (w *Window) Len() int {
+	return len(w.keys)
+}
+
+// Entries returns window keys in order they were added
+func (w *Window) Entries() []Entry {
+	entries := make([]Entry, 0, len(w.keys))
+	for _, entry := range w.entries {
+		if at, ok := w.keys[entry.Key]; ok && at.Equal(entry.At) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Restore replaces window keys with the given entries
+func (w *Window) Restore(entries []Entry) {
+	w.keys = make(map[string]time.Time, len(entries))
+	w.entries = nil
+	for _, entry := range entries {
+		w.keys[entry.Key] = entry.At
+		w.entries = append(w.entries, entry)
+	}
+}
+
+func (w *Window) prune(now time.Time) {
+	i := 0
+	for ; i < len(w.entries); i++ {
+		entry := w.entries[i]
+		at, ok := w.keys[entry.Key]
+		stale := !ok || !at.Equal(entry.At)
+		expired := w.size > 0 && now.Sub(entry.At) > w.size
+		overflow := w.maxKeys > 0 && len(w.keys) > w.maxKeys
+		if !stale && !expired && !overflow {
+			break
+		}
+		if !stale {
+			delete(w.keys, entry.Key)
+		}
+	}
+
+	// entries slice is compacted from time to time, so it does not grow because of stale entries
+	if i > 0 {
+		w.entries = w.entries[i:]
+	}
+	if len(w.entries) > 2*len(w.keys)+1024 {
+		w.entries = w.Entries()
+	}
+}
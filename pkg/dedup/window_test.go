@@ -0,0 +1,73 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow(t *testing.T) {
+	w := NewWindow(time.Minute, 0)
+	at := time.Now()
+
+	w.Add("first", at)
+	w.Add("second", at.Add(30*time.Second))
+	assert.True(t, w.Contains("first", at.Add(time.Minute)))
+	assert.False(t, w.Contains("first", at.Add(2*time.Minute)))
+	assert.False(t, w.Contains("third", at))
+
+	// adding key prunes keys that are older than window
+	w.Add("third", at.Add(90*time.Second))
+	assert.Equal(t, 2, w.Len())
+	assert.False(t, w.Contains("first", at))
+
+	// key seen once again is remembered from the last time it was added
+	w.Add("second", at.Add(100*time.Second))
+	w.Add("fourth", at.Add(140*time.Second))
+	assert.True(t, w.Contains("second", at.Add(140*time.Second)))
+	assert.Equal(t, []string{"third", "second", "fourth"}, entriesKeys(w.Entries()))
+}
+
+func TestWindow_maxKeys(t *testing.T) {
+	w := NewWindow(0, 2)
+	at := time.Now()
+
+	w.Add("first", at)
+	w.Add("second", at)
+	w.Add("third", at)
+	assert.Equal(t, 2, w.Len())
+	assert.False(t, w.Contains("first", at))
+	assert.True(t, w.Contains("second", at.Add(time.Hour)))
+	assert.True(t, w.Contains("third", at))
+}
+
+func TestWindow_Restore(t *testing.T) {
+	at := time.Now()
+	w := NewWindow(time.Minute, 0)
+	w.Add("stale", at)
+
+	w.Restore([]Entry{{Key: "first", At: at}, {Key: "second", At: at.Add(time.Second)}})
+	assert.False(t, w.Contains("stale", at))
+	assert.True(t, w.Contains("first", at))
+	assert.Equal(t, []string{"first", "second"}, entriesKeys(w.Entries()))
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(time.Minute, 10)
+
+	assert.False(t, s.Seen("first"))
+	assert.NoError(t, s.Add("first", "second"))
+	assert.True(t, s.Seen("first"))
+	assert.True(t, s.Seen("second"))
+	assert.False(t, s.Seen("third"))
+}
+
+func entriesKeys(entries []Entry) []string {
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+
+	return keys
+}
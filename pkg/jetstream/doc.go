@@ -0,0 +1,4 @@
+/*
+Package jetstream holds code required for publishing messages to NATS JetStream for pipes with "nats" sink.
+*/
+package jetstream
@@ -0,0 +1,174 @@
+package jetstream
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// connectionName is client name NATS servers report connection with
+	connectionName = "kandalf"
+
+	statsNATSSection = "nats"
+)
+
+var (
+	// ErrMissingURL is an error raised when there are pipes with "nats" sink, but NATS servers are not configured
+	ErrMissingURL = errors.New("nats sink requires NATS servers URL")
+	// ErrAckTimeout is an error raised when JetStream does not acknowledge published message within timeout
+	ErrAckTimeout = errors.New("timed out waiting for JetStream acknowledgement")
+)
+
+// Producer is a producer.Producer implementation for publishing messages to NATS JetStream, message topic is
+// used as subject. Messages are deduplicated by JetStream within stream duplicates window by their ids, so messages
+// republished from storage are stored once.
+type Producer struct {
+	conn        *nats.Conn
+	js          nats.JetStreamContext
+	timeout     time.Duration
+	statsClient client.Client
+}
+
+// NewProducer instantiates and establishes new NATS connection, connection is re-established on failures
+// until producer is closed
+func NewProducer(natsConfig config.NATSConfig, statsClient client.Client) (*Producer, error) {
+	if natsConfig.URL == "" {
+		return nil, ErrMissingURL
+	}
+
+	options := []nats.Option{
+		nats.Name(connectionName),
+		nats.Timeout(natsConfig.Timeout),
+		// publishing fails while connection is lost, so messages are moved to storage until it is re-established
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.WithError(err).Warning("NATS connection is lost, reconnecting")
+			statsClient.TrackMetric(statsNATSSection, bucket.MetricOperation{"disconnect"})
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.WithField("url", conn.ConnectedUrl()).Info("NATS connection is re-established")
+		}),
+	}
+	if natsConfig.CredentialsFile != "" {
+		options = append(options, nats.UserCredentials(natsConfig.CredentialsFile))
+	}
+	if natsConfig.CAFile != "" {
+		options = append(options, nats.RootCAs(natsConfig.CAFile))
+	}
+	if natsConfig.CertFile != "" {
+		options = append(options, nats.ClientCert(natsConfig.CertFile, natsConfig.KeyFile))
+	}
+
+	conn, err := nats.Connect(natsConfig.URL, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := conn.JetStream(nats.MaxWait(natsConfig.Timeout), nats.PublishAsyncMaxPending(natsConfig.MaxPending))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Producer{conn: conn, js: js, timeout: natsConfig.Timeout, statsClient: statsClient}, nil
+}
+
+// Close flushes messages published without acknowledgement and closes NATS connection
+func (p *Producer) Close() error {
+	err := p.conn.FlushTimeout(p.timeout)
+	p.conn.Close()
+
+	return err
+}
+
+// Publish publishes message to JetStream and waits for its acknowledgement, fire-and-forget messages are published
+// without waiting for it
+func (p *Producer) Publish(msg producer.Message) error {
+	publishTimer := p.statsClient.BuildTimer().Start()
+
+	var err error
+	if msg.Delivery == config.DeliveryFireAndForget {
+		err = p.conn.PublishMsg(natsMessage(msg))
+	} else {
+		_, err = p.js.PublishMsg(natsMessage(msg), nats.MsgId(msg.ID.String()))
+	}
+	producer.TrackPublish(p.statsClient, statsNATSSection, msg, publishTimer, err)
+
+	return err
+}
+
+// PublishBatch publishes messages to JetStream at once and waits for all their acknowledgements, up to max pending
+// messages are published without acknowledgement at a time
+func (p *Producer) PublishBatch(msgs []producer.Message) []error {
+	publishTimer := p.statsClient.BuildTimer().Start()
+
+	errs := make([]error, len(msgs))
+	futures := make([]nats.PubAckFuture, len(msgs))
+	for i := range msgs {
+		if msgs[i].Delivery == config.DeliveryFireAndForget {
+			errs[i] = p.conn.PublishMsg(natsMessage(msgs[i]))
+			continue
+		}
+		futures[i], errs[i] = p.js.PublishMsgAsync(natsMessage(msgs[i]), nats.MsgId(msgs[i].ID.String()))
+	}
+
+	// acknowledgements are awaited within the same timeout for the whole batch, once it is over messages that
+	// are not acknowledged yet fail
+	deadline := time.NewTimer(p.timeout)
+	defer deadline.Stop()
+	expired := false
+	for i, future := range futures {
+		if future == nil {
+			continue
+		}
+
+		if expired {
+			select {
+			case ack := <-future.Ok():
+				msgs[i].Offset = int64(ack.Sequence)
+			case err := <-future.Err():
+				errs[i] = err
+			default:
+				errs[i] = ErrAckTimeout
+			}
+			continue
+		}
+
+		select {
+		case ack := <-future.Ok():
+			msgs[i].Offset = int64(ack.Sequence)
+		case err := <-future.Err():
+			errs[i] = err
+		case <-deadline.C:
+			expired = true
+			errs[i] = ErrAckTimeout
+		}
+	}
+
+	for i := range msgs {
+		producer.TrackPublish(p.statsClient, statsNATSSection, msgs[i], publishTimer, errs[i])
+	}
+
+	return errs
+}
+
+// natsMessage returns NATS message for the message topic as subject, message headers are NATS headers as is,
+// they require NATS server 2.2.0 or later
+func natsMessage(msg producer.Message) *nats.Msg {
+	natsMsg := &nats.Msg{Subject: msg.Topic, Data: msg.Body}
+	if len(msg.Headers) > 0 {
+		natsMsg.Header = make(nats.Header, len(msg.Headers))
+		for k, v := range msg.Headers {
+			natsMsg.Header[k] = []string{v}
+		}
+	}
+
+	return natsMsg
+}
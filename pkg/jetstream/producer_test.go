@@ -0,0 +1,132 @@
+package jetstream
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockPubAckFuture struct {
+	ok  chan *nats.PubAck
+	err chan error
+}
+
+func (f *mockPubAckFuture) Ok() <-chan *nats.PubAck {
+	return f.ok
+}
+
+func (f *mockPubAckFuture) Err() <-chan error {
+	return f.err
+}
+
+func (f *mockPubAckFuture) Msg() *nats.Msg {
+	return nil
+}
+
+// errStalled is an error JetStream context fails asynchronous publish with once there are too many pending messages
+var errStalled = errors.New("nats: stalled with too many outstanding async published messages")
+
+type mockJetStream struct {
+	nats.JetStreamContext
+
+	// publishErrs are errors messages are not acknowledged with by their subject, messages to "pending" subject
+	// are never acknowledged and messages to "stalled" subject fail to be published
+	publishErrs map[string]error
+	sequence    uint64
+}
+
+func (js *mockJetStream) PublishMsg(msg *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	if err := js.publishErrs[msg.Subject]; err != nil {
+		return nil, err
+	}
+	js.sequence++
+
+	return &nats.PubAck{Stream: "ORDERS", Sequence: js.sequence}, nil
+}
+
+func (js *mockJetStream) PublishMsgAsync(msg *nats.Msg, _ ...nats.PubOpt) (nats.PubAckFuture, error) {
+	if msg.Subject == "stalled" {
+		return nil, errStalled
+	}
+
+	future := &mockPubAckFuture{ok: make(chan *nats.PubAck, 1), err: make(chan error, 1)}
+	if msg.Subject == "pending" {
+		return future, nil
+	}
+	if err := js.publishErrs[msg.Subject]; err != nil {
+		future.err <- err
+		return future, nil
+	}
+	js.sequence++
+	future.ok <- &nats.PubAck{Stream: "ORDERS", Sequence: js.sequence}
+
+	return future, nil
+}
+
+func newTestProducer(js *mockJetStream) (*Producer, *client.Memory) {
+	statsClient, _ := stats.NewClient("memory://")
+
+	return &Producer{js: js, timeout: 50 * time.Millisecond, statsClient: statsClient}, statsClient.(*client.Memory)
+}
+
+func TestProducer_Publish(t *testing.T) {
+	publishErr := errors.New("nats: no response from stream")
+	p, memoryStats := newTestProducer(&mockJetStream{publishErrs: map[string]error{"payments": publishErr}})
+
+	assert.NoError(t, p.Publish(producer.Message{Body: []byte(`{"id":1}`), Topic: "orders"}))
+	assert.Equal(t, publishErr, p.Publish(producer.Message{Body: []byte(`{"id":2}`), Topic: "payments"}))
+
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.publish.orders.-", statsNATSSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-fail.publish.payments.-", statsNATSSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.payments.-", statsNATSSection)])
+}
+
+func TestProducer_PublishBatch(t *testing.T) {
+	publishErr := errors.New("nats: maximum messages exceeded")
+	p, memoryStats := newTestProducer(&mockJetStream{publishErrs: map[string]error{"payments": publishErr}})
+
+	msgs := []producer.Message{
+		{Body: []byte("first"), Topic: "orders"},
+		{Body: []byte("rejected"), Topic: "payments"},
+		{Body: []byte("stalled"), Topic: "stalled"},
+		{Body: []byte("pending"), Topic: "pending"},
+		// messages acknowledged before timeout is over succeed even after it
+		{Body: []byte("second"), Topic: "orders"},
+	}
+	errs := p.PublishBatch(msgs)
+	require.Len(t, errs, len(msgs))
+	assert.NoError(t, errs[0])
+	assert.Equal(t, publishErr, errs[1])
+	assert.Equal(t, errStalled, errs[2])
+	assert.Equal(t, ErrAckTimeout, errs[3])
+	assert.NoError(t, errs[4])
+
+	// acknowledged messages get their stream sequence numbers as offsets
+	assert.Equal(t, int64(1), msgs[0].Offset)
+	assert.Equal(t, int64(2), msgs[4].Offset)
+
+	assert.Equal(t, 2, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.publish.orders.-", statsNATSSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.payments.-", statsNATSSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.stalled.-", statsNATSSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.pending.-", statsNATSSection)])
+}
+
+func TestNatsMessage(t *testing.T) {
+	msg := producer.Message{Body: []byte(`{"id":1}`), Topic: "orders.created"}
+	natsMsg := natsMessage(msg)
+	assert.Equal(t, "orders.created", natsMsg.Subject)
+	assert.Equal(t, []byte(`{"id":1}`), natsMsg.Data)
+	assert.Nil(t, natsMsg.Header)
+
+	// header names are kept as is, instead of being canonicalized
+	msg.Headers = map[string]string{"x-trace-id": "abc"}
+	assert.Equal(t, nats.Header{"x-trace-id": []string{"abc"}}, natsMessage(msg).Header)
+}
@@ -0,0 +1,183 @@
+package kinesis
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"unicode/utf8"
+
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// maxPartitionKeyLength is max number of partition key characters Kinesis accepts
+	maxPartitionKeyLength = 256
+	// maxRequestRecords and maxRequestBytes are max number of records and their size in PutRecords request
+	maxRequestRecords = 500
+	maxRequestBytes   = 5 << 20
+
+	// AggregatedRecord protobuf message fields of KPL aggregated record format, nested Record fields are
+	// partition key index and data
+	fieldPartitionKeyTable = 1
+	fieldRecords           = 3
+	fieldPartitionKeyIndex = 1
+	fieldData              = 3
+)
+
+// kplMagic prefixes KPL aggregated records, so consumers tell them from plain records
+var kplMagic = []byte{0xF3, 0x89, 0x9A, 0xC2}
+
+// record is Kinesis record carrying one or several messages of the batch
+type record struct {
+	partitionKey string
+	data         []byte
+	// indexes are indexes of batch messages record carries
+	indexes []int
+}
+
+// plainRecords returns record per message
+func plainRecords(msgs []producer.Message, indexes []int) []record {
+	records := make([]record, 0, len(indexes))
+	for _, i := range indexes {
+		records = append(records, record{partitionKey: partitionKey(msgs[i]), data: msgs[i].Body, indexes: []int{i}})
+	}
+
+	return records
+}
+
+// aggregatedRecords packs messages into KPL aggregated records up to maxBytes each. Only messages with the same
+// partition key are aggregated together, so aggregated record is routed to the shard its messages would be routed to
+// and their order is kept, messages without partition key are aggregated with each other.
+func aggregatedRecords(msgs []producer.Message, indexes []int, maxBytes int) []record {
+	var keys []string
+	groups := make(map[string][]int)
+	for _, i := range indexes {
+		key := msgs[i].Key
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	var records []record
+	for _, key := range keys {
+		a := newAggregation()
+		for _, i := range groups[key] {
+			if !a.fits(msgs[i], maxBytes) {
+				records = append(records, a.record(msgs))
+				a = newAggregation()
+			}
+			a.add(msgs[i], i)
+		}
+		records = append(records, a.record(msgs))
+	}
+
+	return records
+}
+
+// aggregation is KPL aggregated record being built, it keeps AggregatedRecord partition key table and records encoded
+type aggregation struct {
+	keyIndexes map[string]uint64
+	keyTable   []byte
+	records    []byte
+	indexes    []int
+}
+
+func newAggregation() *aggregation {
+	return &aggregation{keyIndexes: make(map[string]uint64)}
+}
+
+// fits checks if aggregated record with the message added is not larger than maxBytes, any message fits empty one
+func (a *aggregation) fits(msg producer.Message, maxBytes int) bool {
+	if len(a.indexes) == 0 {
+		return true
+	}
+
+	size := len(kplMagic) + len(a.keyTable) + len(a.records) + md5.Size
+	key := partitionKey(msg)
+	index, ok := a.keyIndexes[key]
+	if !ok {
+		index = uint64(len(a.keyIndexes))
+		size += protowire.SizeTag(fieldPartitionKeyTable) + protowire.SizeBytes(len(key))
+	}
+	size += protowire.SizeTag(fieldRecords) + protowire.SizeBytes(recordSize(index, msg.Body))
+
+	return size <= maxBytes
+}
+
+func (a *aggregation) add(msg producer.Message, i int) {
+	key := partitionKey(msg)
+	index, ok := a.keyIndexes[key]
+	if !ok {
+		index = uint64(len(a.keyIndexes))
+		a.keyIndexes[key] = index
+		a.keyTable = protowire.AppendTag(a.keyTable, fieldPartitionKeyTable, protowire.BytesType)
+		a.keyTable = protowire.AppendString(a.keyTable, key)
+	}
+
+	var nested []byte
+	nested = protowire.AppendTag(nested, fieldPartitionKeyIndex, protowire.VarintType)
+	nested = protowire.AppendVarint(nested, index)
+	nested = protowire.AppendTag(nested, fieldData, protowire.BytesType)
+	nested = protowire.AppendBytes(nested, msg.Body)
+
+	a.records = protowire.AppendTag(a.records, fieldRecords, protowire.BytesType)
+	a.records = protowire.AppendBytes(a.records, nested)
+	a.indexes = append(a.indexes, i)
+}
+
+// record returns Kinesis record of the aggregation, single message is published as plain record, as KPL does,
+// so consumers that do not de-aggregate records can read it
+func (a *aggregation) record(msgs []producer.Message) record {
+	first := msgs[a.indexes[0]]
+	if len(a.indexes) == 1 {
+		return record{partitionKey: partitionKey(first), data: first.Body, indexes: a.indexes}
+	}
+
+	message := append(append([]byte{}, a.keyTable...), a.records...)
+	checksum := md5.Sum(message)
+	data := make([]byte, 0, len(kplMagic)+len(message)+md5.Size)
+	data = append(append(append(data, kplMagic...), message...), checksum[:]...)
+
+	return record{partitionKey: partitionKey(first), data: data, indexes: a.indexes}
+}
+
+// requests splits records into PutRecords requests within request limits
+func requests(records []record) [][]record {
+	var result [][]record
+	start, size := 0, 0
+	for i, r := range records {
+		recordBytes := len(r.data) + len(r.partitionKey)
+		if i > start && (i-start == maxRequestRecords || size+recordBytes > maxRequestBytes) {
+			result = append(result, records[start:i])
+			start, size = i, 0
+		}
+		size += recordBytes
+	}
+	if start < len(records) {
+		result = append(result, records[start:])
+	}
+
+	return result
+}
+
+// recordSize returns size of nested Record protobuf message
+func recordSize(index uint64, data []byte) int {
+	return protowire.SizeTag(fieldPartitionKeyIndex) + protowire.SizeVarint(index) +
+		protowire.SizeTag(fieldData) + protowire.SizeBytes(len(data))
+}
+
+// partitionKey returns Kinesis partition key of the message - message key, or message id for messages without key,
+// so they are spread across shards. Keys longer than Kinesis allows are replaced with their hash, that keeps messages
+// with the same key on the same shard.
+func partitionKey(msg producer.Message) string {
+	if msg.Key == "" {
+		return msg.ID.String()
+	}
+	if utf8.RuneCountInString(msg.Key) > maxPartitionKeyLength {
+		sum := md5.Sum([]byte(msg.Key))
+		return hex.EncodeToString(sum[:])
+	}
+
+	return msg.Key
+}
@@ -0,0 +1,168 @@
+package kinesis
+
+import (
+	"bytes"
+	"crypto/md5"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// deaggregate decodes KPL aggregated record the way consumers do, returning partition keys and data of its records
+func deaggregate(t *testing.T, data []byte) ([]string, [][]byte) {
+	require.True(t, bytes.HasPrefix(data, kplMagic))
+	message := data[len(kplMagic) : len(data)-md5.Size]
+	checksum := md5.Sum(message)
+	require.Equal(t, checksum[:], data[len(data)-md5.Size:])
+
+	var keyTable, keys []string
+	var values [][]byte
+	var indexes []uint64
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		require.True(t, n > 0)
+		require.Equal(t, protowire.BytesType, typ)
+		message = message[n:]
+		value, n := protowire.ConsumeBytes(message)
+		require.True(t, n > 0)
+		message = message[n:]
+
+		switch num {
+		case fieldPartitionKeyTable:
+			keyTable = append(keyTable, string(value))
+		case fieldRecords:
+			for len(value) > 0 {
+				num, typ, n := protowire.ConsumeTag(value)
+				require.True(t, n > 0)
+				value = value[n:]
+				if num == fieldPartitionKeyIndex {
+					require.Equal(t, protowire.VarintType, typ)
+					index, n := protowire.ConsumeVarint(value)
+					require.True(t, n > 0)
+					indexes = append(indexes, index)
+					value = value[n:]
+					continue
+				}
+				require.Equal(t, protowire.Number(fieldData), num)
+				body, n := protowire.ConsumeBytes(value)
+				require.True(t, n > 0)
+				values = append(values, body)
+				value = value[n:]
+			}
+		default:
+			t.Fatalf("unexpected field %d", num)
+		}
+	}
+
+	for _, index := range indexes {
+		keys = append(keys, keyTable[index])
+	}
+
+	return keys, values
+}
+
+func newTestMessages() []producer.Message {
+	return []producer.Message{
+		{ID: uuid.Must(uuid.NewV4()), Body: []byte(`{"id":1}`), Topic: "orders", Key: "customer-1"},
+		{ID: uuid.Must(uuid.NewV4()), Body: []byte(`{"id":2}`), Topic: "orders", Key: "customer-2"},
+		{ID: uuid.Must(uuid.NewV4()), Body: []byte(`{"id":3}`), Topic: "orders", Key: "customer-1"},
+		{ID: uuid.Must(uuid.NewV4()), Body: []byte(`{"id":4}`), Topic: "orders"},
+		{ID: uuid.Must(uuid.NewV4()), Body: []byte(`{"id":5}`), Topic: "orders"},
+	}
+}
+
+func TestPlainRecords(t *testing.T) {
+	msgs := newTestMessages()
+
+	records := plainRecords(msgs, []int{0, 3})
+	require.Len(t, records, 2)
+	assert.Equal(t, record{partitionKey: "customer-1", data: msgs[0].Body, indexes: []int{0}}, records[0])
+	assert.Equal(t, record{partitionKey: msgs[3].ID.String(), data: msgs[3].Body, indexes: []int{3}}, records[1])
+}
+
+func TestAggregatedRecords(t *testing.T) {
+	msgs := newTestMessages()
+
+	records := aggregatedRecords(msgs, []int{0, 1, 2, 3, 4}, 51200)
+	require.Len(t, records, 3)
+
+	// messages with the same key are aggregated together in their order
+	assert.Equal(t, "customer-1", records[0].partitionKey)
+	assert.Equal(t, []int{0, 2}, records[0].indexes)
+	keys, values := deaggregate(t, records[0].data)
+	assert.Equal(t, []string{"customer-1", "customer-1"}, keys)
+	assert.Equal(t, [][]byte{msgs[0].Body, msgs[2].Body}, values)
+
+	// single message is published as plain record
+	assert.Equal(t, record{partitionKey: "customer-2", data: msgs[1].Body, indexes: []int{1}}, records[1])
+
+	// messages without key keep their ids as partition keys
+	assert.Equal(t, msgs[3].ID.String(), records[2].partitionKey)
+	assert.Equal(t, []int{3, 4}, records[2].indexes)
+	keys, values = deaggregate(t, records[2].data)
+	assert.Equal(t, []string{msgs[3].ID.String(), msgs[4].ID.String()}, keys)
+	assert.Equal(t, [][]byte{msgs[3].Body, msgs[4].Body}, values)
+}
+
+func TestAggregatedRecords_MaxBytes(t *testing.T) {
+	var msgs []producer.Message
+	var indexes []int
+	for i := 0; i < 10; i++ {
+		msgs = append(msgs, producer.Message{ID: uuid.Must(uuid.NewV4()), Body: bytes.Repeat([]byte("a"), 100), Key: "key"})
+		indexes = append(indexes, i)
+	}
+
+	records := aggregatedRecords(msgs, indexes, 350)
+	require.Len(t, records, 4)
+	for _, r := range records[:3] {
+		assert.Len(t, r.indexes, 3)
+		assert.True(t, len(r.data) <= 350, len(r.data))
+	}
+	// the last message does not fit any other record, so it is published as plain one
+	assert.Equal(t, []int{9}, records[3].indexes)
+	assert.Equal(t, msgs[9].Body, records[3].data)
+
+	// message larger than max size is published on its own
+	records = aggregatedRecords(msgs, indexes[:2], 50)
+	require.Len(t, records, 2)
+	assert.Equal(t, msgs[0].Body, records[0].data)
+}
+
+func TestRequests(t *testing.T) {
+	records := make([]record, 1001)
+	for i := range records {
+		records[i] = record{partitionKey: "key", data: []byte("data")}
+	}
+	result := requests(records)
+	require.Len(t, result, 3)
+	assert.Len(t, result[0], maxRequestRecords)
+	assert.Len(t, result[1], maxRequestRecords)
+	assert.Len(t, result[2], 1)
+
+	large := bytes.Repeat([]byte("a"), 2<<20)
+	records = []record{{data: large}, {data: large}, {data: large}, {data: []byte("data")}}
+	result = requests(records)
+	require.Len(t, result, 2)
+	assert.Len(t, result[0], 2)
+	assert.Len(t, result[1], 2)
+
+	assert.Empty(t, requests(nil))
+}
+
+func TestPartitionKey(t *testing.T) {
+	msg := producer.Message{ID: uuid.Must(uuid.NewV4())}
+	assert.Equal(t, msg.ID.String(), partitionKey(msg))
+
+	msg.Key = "customer-1"
+	assert.Equal(t, "customer-1", partitionKey(msg))
+
+	msg.Key = strings.Repeat("k", 300)
+	key := partitionKey(msg)
+	assert.Len(t, key, 32)
+	assert.Equal(t, key, partitionKey(msg))
+}
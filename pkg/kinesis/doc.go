@@ -0,0 +1,4 @@
+/*
+Package kinesis holds code required for publishing messages to AWS Kinesis Data Streams.
+*/
+package kinesis
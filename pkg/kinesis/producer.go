@@ -0,0 +1,152 @@
+package kinesis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awskinesis "github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/client"
+)
+
+const statsKinesisSection = "kinesis"
+
+// ErrMissingRegion is an error raised when there are pipes with "kinesis" sink, but AWS region is not configured
+var ErrMissingRegion = errors.New("kinesis sink requires AWS region")
+
+// recordError is an error Kinesis rejects single record of PutRecords request with, e.g. when shard throughput
+// is exceeded
+type recordError struct {
+	code    string
+	message string
+}
+
+func (e recordError) Error() string {
+	return fmt.Sprintf("kinesis record failed: %s: %s", e.code, e.message)
+}
+
+// Producer is a producer.Producer implementation for publishing messages to AWS Kinesis Data Streams, message topic
+// is used as stream name and message key as partition key. Message headers are not published, as Kinesis records
+// have none.
+type Producer struct {
+	client              kinesisiface.KinesisAPI
+	timeout             time.Duration
+	aggregation         bool
+	aggregationMaxBytes int
+	statsClient         client.Client
+}
+
+// NewProducer instantiates new Kinesis client, credentials are resolved on the first request
+func NewProducer(kinesisConfig config.KinesisConfig, statsClient client.Client) (*Producer, error) {
+	awsConfig := aws.NewConfig().WithHTTPClient(&http.Client{Timeout: kinesisConfig.Timeout})
+	if kinesisConfig.Region != "" {
+		awsConfig = awsConfig.WithRegion(kinesisConfig.Region)
+	}
+	if kinesisConfig.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(kinesisConfig.Endpoint)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		return nil, ErrMissingRegion
+	}
+
+	return &Producer{
+		client:              awskinesis.New(sess),
+		timeout:             kinesisConfig.Timeout,
+		aggregation:         kinesisConfig.Aggregation,
+		aggregationMaxBytes: kinesisConfig.AggregationMaxBytes,
+		statsClient:         statsClient,
+	}, nil
+}
+
+// Close does nothing, as Kinesis client keeps no connections open besides idle HTTP ones
+func (p *Producer) Close() error {
+	return nil
+}
+
+// Publish publishes message to Kinesis stream as plain record
+func (p *Producer) Publish(msg producer.Message) error {
+	publishTimer := p.statsClient.BuildTimer().Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	_, err := p.client.PutRecordWithContext(ctx, &awskinesis.PutRecordInput{
+		StreamName:   aws.String(msg.Topic),
+		PartitionKey: aws.String(partitionKey(msg)),
+		Data:         msg.Body,
+	})
+	producer.TrackPublish(p.statsClient, statsKinesisSection, msg, publishTimer, err)
+
+	return err
+}
+
+// PublishBatch publishes messages to their Kinesis streams with PutRecords requests, messages are aggregated into
+// KPL aggregated records unless aggregation is disabled. All the messages of aggregated record fail together.
+func (p *Producer) PublishBatch(msgs []producer.Message) []error {
+	publishTimer := p.statsClient.BuildTimer().Start()
+
+	var streams []string
+	indexes := make(map[string][]int)
+	for i := range msgs {
+		if _, ok := indexes[msgs[i].Topic]; !ok {
+			streams = append(streams, msgs[i].Topic)
+		}
+		indexes[msgs[i].Topic] = append(indexes[msgs[i].Topic], i)
+	}
+
+	errs := make([]error, len(msgs))
+	for _, stream := range streams {
+		records := plainRecords(msgs, indexes[stream])
+		if p.aggregation {
+			records = aggregatedRecords(msgs, indexes[stream], p.aggregationMaxBytes)
+		}
+		for _, request := range requests(records) {
+			p.putRecords(stream, request, errs)
+		}
+	}
+
+	for i := range msgs {
+		producer.TrackPublish(p.statsClient, statsKinesisSection, msgs[i], publishTimer, errs[i])
+	}
+
+	return errs
+}
+
+// putRecords publishes records to the stream, request and record errors are set for the messages records carry
+func (p *Producer) putRecords(stream string, records []record, errs []error) {
+	entries := make([]*awskinesis.PutRecordsRequestEntry, 0, len(records))
+	for _, r := range records {
+		entries = append(entries, &awskinesis.PutRecordsRequestEntry{PartitionKey: aws.String(r.partitionKey), Data: r.data})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	output, err := p.client.PutRecordsWithContext(ctx, &awskinesis.PutRecordsInput{
+		StreamName: aws.String(stream),
+		Records:    entries,
+	})
+
+	for i, r := range records {
+		recordErr := err
+		if err == nil && output.Records[i].ErrorCode != nil {
+			recordErr = recordError{code: aws.StringValue(output.Records[i].ErrorCode), message: aws.StringValue(output.Records[i].ErrorMessage)}
+		}
+		for _, index := range r.indexes {
+			errs[index] = recordErr
+		}
+	}
+}
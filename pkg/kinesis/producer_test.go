@@ -0,0 +1,125 @@
+package kinesis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awskinesis "github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockKinesis struct {
+	kinesisiface.KinesisAPI
+
+	putRecordErr error
+	// putRecordsErrs are PutRecords request errors by stream
+	putRecordsErrs map[string]error
+	// failedRecords are error codes of records Kinesis rejects by stream and record index in request
+	failedRecords map[string]map[int]string
+
+	putRecordsInputs []*awskinesis.PutRecordsInput
+}
+
+func (m *mockKinesis) PutRecordWithContext(_ aws.Context, input *awskinesis.PutRecordInput, _ ...request.Option) (*awskinesis.PutRecordOutput, error) {
+	return &awskinesis.PutRecordOutput{}, m.putRecordErr
+}
+
+func (m *mockKinesis) PutRecordsWithContext(_ aws.Context, input *awskinesis.PutRecordsInput, _ ...request.Option) (*awskinesis.PutRecordsOutput, error) {
+	m.putRecordsInputs = append(m.putRecordsInputs, input)
+	stream := aws.StringValue(input.StreamName)
+	if err := m.putRecordsErrs[stream]; err != nil {
+		return nil, err
+	}
+
+	output := &awskinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+	for i := range input.Records {
+		entry := &awskinesis.PutRecordsResultEntry{SequenceNumber: aws.String(fmt.Sprint(i))}
+		if code, ok := m.failedRecords[stream][i]; ok {
+			entry = &awskinesis.PutRecordsResultEntry{ErrorCode: aws.String(code), ErrorMessage: aws.String("rate exceeded")}
+			*output.FailedRecordCount++
+		}
+		output.Records = append(output.Records, entry)
+	}
+
+	return output, nil
+}
+
+func newTestProducer(mock *mockKinesis, aggregation bool) (*Producer, *client.Memory) {
+	statsClient, _ := stats.NewClient("memory://")
+
+	return &Producer{
+		client:              mock,
+		timeout:             time.Second,
+		aggregation:         aggregation,
+		aggregationMaxBytes: 51200,
+		statsClient:         statsClient,
+	}, statsClient.(*client.Memory)
+}
+
+func TestProducer_Publish(t *testing.T) {
+	mock := &mockKinesis{}
+	p, memoryStats := newTestProducer(mock, false)
+	msg := producer.Message{Body: []byte(`{"id":1}`), Topic: "orders", Key: "customer-1"}
+
+	require.NoError(t, p.Publish(msg))
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.publish.orders.-", statsKinesisSection)])
+
+	mock.putRecordErr = errors.New("stream not found")
+	assert.Equal(t, mock.putRecordErr, p.Publish(msg))
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-fail.publish.orders.-", statsKinesisSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.orders.-", statsKinesisSection)])
+}
+
+func TestProducer_PublishBatch(t *testing.T) {
+	requestErr := errors.New("stream not found")
+	mock := &mockKinesis{
+		putRecordsErrs: map[string]error{"payments": requestErr},
+		failedRecords:  map[string]map[int]string{"orders": {1: awskinesis.ErrCodeProvisionedThroughputExceededException}},
+	}
+	p, memoryStats := newTestProducer(mock, false)
+
+	msgs := newTestMessages()
+	msgs[4].Topic = "payments"
+
+	// records are rejected one by one, while failed request fails all its messages
+	errs := p.PublishBatch(msgs)
+	require.Len(t, errs, len(msgs))
+	assert.NoError(t, errs[0])
+	assert.Equal(t, recordError{code: awskinesis.ErrCodeProvisionedThroughputExceededException, message: "rate exceeded"}, errs[1])
+	assert.NoError(t, errs[2])
+	assert.NoError(t, errs[3])
+	assert.Equal(t, requestErr, errs[4])
+
+	// messages are published with a request per stream
+	require.Len(t, mock.putRecordsInputs, 2)
+	assert.Equal(t, "orders", aws.StringValue(mock.putRecordsInputs[0].StreamName))
+	assert.Len(t, mock.putRecordsInputs[0].Records, 4)
+
+	assert.Equal(t, 3, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.publish.orders.-", statsKinesisSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.orders.-", statsKinesisSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.payments.-", statsKinesisSection)])
+}
+
+func TestProducer_PublishBatch_aggregation(t *testing.T) {
+	mock := &mockKinesis{failedRecords: map[string]map[int]string{"orders": {0: awskinesis.ErrCodeInternalFailureException}}}
+	p, _ := newTestProducer(mock, true)
+
+	// the first record aggregates messages of "customer-1" key, so they fail together
+	errs := p.PublishBatch(newTestMessages())
+	require.Len(t, mock.putRecordsInputs, 1)
+	assert.Len(t, mock.putRecordsInputs[0].Records, 3)
+	assert.Error(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.Error(t, errs[2])
+	assert.NoError(t, errs[3])
+	assert.NoError(t, errs[4])
+}
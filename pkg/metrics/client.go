@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+)
+
+const (
+	// schemePrometheus is stats DSN scheme of Prometheus client, e.g. "prometheus://"
+	schemePrometheus = "prometheus"
+	// schemeStatsD is stats DSN scheme of StatsD client, e.g. "statsd://statsd.local:8125/kandalf"
+	schemeStatsD = "statsd"
+	// schemeDogStatsD is stats DSN scheme of DogStatsD client, e.g. "dogstatsd://datadog-agent:8125/kandalf"
+	schemeDogStatsD = "dogstatsd"
+
+	// tagNode is tag DogStatsD metrics get instance id in
+	tagNode = "node"
+)
+
+// NewClient builds and returns stats client for stats config DSN, Prometheus and DogStatsD clients are handled
+// by the package and the rest of them by stats-go. Metrics prefix is taken from DSN path, falling back to
+// configured prefix, and DogStatsD metrics are tagged with node id and configured tags.
+func NewClient(statsConfig config.StatsConfig, nodeID string) (client.Client, error) {
+	dsnURL, err := url.Parse(statsConfig.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(dsnURL.Path, "/")
+	if prefix == "" {
+		prefix = statsConfig.Prefix
+	}
+
+	switch dsnURL.Scheme {
+	case schemePrometheus:
+		return NewPrometheus(), nil
+	case schemeDogStatsD:
+		tags := statsConfig.Tags
+		if nodeID != "" {
+			tags = append([]string{tagNode + ":" + nodeID}, tags...)
+		}
+		return NewDogStatsD(dsnURL.Host, prefix, tags)
+	case schemeStatsD:
+		// do not care about parse error, as default value is set to false that is fine for us
+		unicode, _ := strconv.ParseBool(dsnURL.Query().Get("unicode"))
+		return client.NewStatsD(dsnURL.Host, prefix, unicode)
+	}
+
+	return stats.NewClient(statsConfig.DSN)
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	addr := conn.LocalAddr().String()
+
+	c, err := NewClient(config.StatsConfig{DSN: "prometheus://"}, "kandalf-1")
+	require.NoError(t, err)
+	assert.IsType(t, &Prometheus{}, c)
+
+	// prefix from DSN path takes precedence over configured one
+	c, err = NewClient(config.StatsConfig{DSN: "dogstatsd://" + addr + "/bridge", Prefix: "kandalf", Tags: []string{"env:production"}}, "kandalf-1")
+	require.NoError(t, err)
+	require.IsType(t, &DogStatsD{}, c)
+	c.TrackMetric("amqp", bucket.MetricOperation{"reconnect"})
+	require.NoError(t, c.Close())
+	assert.Equal(t, []string{"bridge.amqp.reconnect:1|c|#node:kandalf-1,env:production"}, readStatsD(t, conn))
+
+	c, err = NewClient(config.StatsConfig{DSN: "statsd://" + addr, Prefix: "kandalf"}, "kandalf-1")
+	require.NoError(t, err)
+	c.TrackMetric("amqp", bucket.MetricOperation{"reconnect"})
+	require.NoError(t, c.Close())
+	assert.Equal(t, []string{"kandalf.amqp.reconnect.-.-:1|c", "kandalf.total.amqp:1|c"}, readStatsD(t, conn))
+
+	_, err = NewClient(config.StatsConfig{DSN: "unknown://"}, "kandalf-1")
+	assert.Error(t, err)
+}
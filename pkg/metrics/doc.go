@@ -0,0 +1,5 @@
+/*
+Package metrics holds stats clients implementations that are not provided by stats-go - Prometheus client collecting
+application metrics to be scraped from admin HTTP server and DogStatsD client pushing them with tags.
+*/
+package metrics
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/hellofresh/stats-go/timer"
+	"gopkg.in/alexcesaro/statsd.v2"
+)
+
+// DogStatsD is stats client implementation that pushes metrics to DogStatsD agent with tags. Metrics are named
+// "<prefix>.<section>.<operation>", e.g. "kandalf.kafka.publish", and the rest of metric operation parts become
+// name and target tags, so "kafka.publish.<topic>" StatsD metric is "kafka.publish" with "name:<topic>" tag.
+// Operations results are tagged with success tag instead of being tracked as separate "ok" and "fail" metrics.
+type DogStatsD struct {
+	sync.Mutex
+
+	client             *statsd.Client
+	httpMetricCallback bucket.HTTPMetricNameAlterCallback
+	httpRequestSection string
+}
+
+// NewDogStatsD builds and returns new DogStatsD instance, tags are "<key>:<value>" pairs added to every metric
+func NewDogStatsD(addr string, prefix string, tags []string) (*DogStatsD, error) {
+	options := []statsd.Option{statsd.TagsFormat(statsd.Datadog), statsd.Tags(splitTags(tags)...)}
+	if prefix != "" {
+		options = append(options, statsd.Prefix(prefix))
+	}
+	if addr != "" {
+		options = append(options, statsd.Address(addr))
+	}
+
+	statsdClient, err := statsd.New(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DogStatsD{client: statsdClient, httpRequestSection: bucket.SectionRequest}, nil
+}
+
+// BuildTimer builds timer to track metric timings
+func (c *DogStatsD) BuildTimer() timer.Timer {
+	return &timer.Memory{}
+}
+
+// Close flushes buffered metrics and closes DogStatsD connection
+func (c *DogStatsD) Close() error {
+	c.client.Close()
+	return nil
+}
+
+// TrackRequest tracks HTTP Request stats
+func (c *DogStatsD) TrackRequest(r *http.Request, t timer.Timer, success bool) client.Client {
+	operation := bucket.BuildHTTPRequestMetricOperation(r, c.GetHTTPMetricCallback())
+
+	c.Lock()
+	section := c.httpRequestSection
+	c.Unlock()
+
+	return c.TrackOperation(section, operation, t, success)
+}
+
+// TrackOperation tracks custom operation
+func (c *DogStatsD) TrackOperation(section string, operation bucket.MetricOperation, t timer.Timer, success bool) client.Client {
+	return c.TrackOperationN(section, operation, t, 1, success)
+}
+
+// TrackOperationN tracks custom operation with n diff
+func (c *DogStatsD) TrackOperationN(section string, operation bucket.MetricOperation, t timer.Timer, n int, success bool) client.Client {
+	tagged := c.tagged(operation, "success", strconv.FormatBool(success))
+	tagged.Count(bucketName(section, operation), n)
+	if t != nil {
+		tagged.Timing(bucketName(section, operation), int(t.Finish()/time.Millisecond))
+	}
+
+	return c
+}
+
+// TrackMetric tracks custom metric, w/out ok/fail additional sections
+func (c *DogStatsD) TrackMetric(section string, operation bucket.MetricOperation) client.Client {
+	return c.TrackMetricN(section, operation, 1)
+}
+
+// TrackMetricN tracks custom metric with n diff, w/out ok/fail additional sections
+func (c *DogStatsD) TrackMetricN(section string, operation bucket.MetricOperation, n int) client.Client {
+	c.tagged(operation).Count(bucketName(section, operation), n)
+
+	return c
+}
+
+// TrackState tracks metric absolute value
+func (c *DogStatsD) TrackState(section string, operation bucket.MetricOperation, value int) client.Client {
+	c.tagged(operation).Gauge(bucketName(section, operation), value)
+
+	return c
+}
+
+// SetHTTPMetricCallback sets callback handler that allows metric operation alteration for HTTP Request
+func (c *DogStatsD) SetHTTPMetricCallback(callback bucket.HTTPMetricNameAlterCallback) client.Client {
+	c.Lock()
+	defer c.Unlock()
+
+	c.httpMetricCallback = callback
+	return c
+}
+
+// GetHTTPMetricCallback gets callback handler that allows metric operation alteration for HTTP Request
+func (c *DogStatsD) GetHTTPMetricCallback() bucket.HTTPMetricNameAlterCallback {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.httpMetricCallback
+}
+
+// SetHTTPRequestSection sets metric section for HTTP Request metrics
+func (c *DogStatsD) SetHTTPRequestSection(section string) client.Client {
+	c.Lock()
+	defer c.Unlock()
+
+	c.httpRequestSection = section
+	return c
+}
+
+// ResetHTTPRequestSection resets metric section for HTTP Request metrics to default value that is "request"
+func (c *DogStatsD) ResetHTTPRequestSection() client.Client {
+	return c.SetHTTPRequestSection(bucket.SectionRequest)
+}
+
+// tagged returns client sharing connection with the main one that adds operation parts tags and extra tags
+// to metrics
+func (c *DogStatsD) tagged(operation bucket.MetricOperation, extra ...string) *statsd.Client {
+	tags := make([]string, 0, 4+len(extra))
+	for i, label := range labels[1:] {
+		if value := operation[i+1]; value != "" {
+			tags = append(tags, label, value)
+		}
+	}
+
+	return c.client.Clone(statsd.Tags(append(tags, extra...)...))
+}
+
+// bucketName returns metric name with operation, but without operation name and target, as they are tags
+func bucketName(section string, operation bucket.MetricOperation) string {
+	return section + "." + operation[0]
+}
+
+// splitTags splits "<key>:<value>" tags to keys and values list, tags without value get empty one
+func splitTags(tags []string) []string {
+	pairs := make([]string, 0, 2*len(tags))
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, ":", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		pairs = append(pairs, parts[0], parts[1])
+	}
+
+	return pairs
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/timer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readStatsD reads metrics lines of a single packet sent to UDP server, skipping empty packets client sends
+// on connect to check the connection
+func readStatsD(t *testing.T, conn net.PacketConn) []string {
+	buf := make([]byte, 4096)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n := 0
+	for n == 0 {
+		var err error
+		n, _, err = conn.ReadFrom(buf)
+		require.NoError(t, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(buf[:n])), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestDogStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	addr := conn.LocalAddr().String()
+	c, err := NewDogStatsD(addr, "kandalf", []string{"node:kandalf-1", "env:production"})
+	require.NoError(t, err)
+
+	c.TrackOperation("kafka", bucket.MetricOperation{"publish", "orders"}, timer.NewDuration(250*time.Millisecond), true)
+	c.TrackMetric("worker", bucket.MetricOperation{"filter", "dropped", "shop"})
+	c.TrackState("worker", bucket.MetricOperation{"buffer", "length"}, 42)
+	require.NoError(t, c.Close())
+
+	assert.Equal(t, []string{
+		"kandalf.kafka.publish:1|c|#node:kandalf-1,env:production,name:orders,success:true",
+		"kandalf.kafka.publish:250|ms|#node:kandalf-1,env:production,name:orders,success:true",
+		"kandalf.worker.buffer:42|g|#node:kandalf-1,env:production,name:length",
+		"kandalf.worker.filter:1|c|#node:kandalf-1,env:production,name:dropped,target:shop",
+	}, readStatsD(t, conn))
+}
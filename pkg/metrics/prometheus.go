@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/hellofresh/stats-go/timer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace is a prefix of all the metrics names
+const namespace = "kandalf"
+
+// labels are metric operation parts labels, operations are [3]string, e.g. {"publish", "fatal", "<topic>"}
+var labels = []string{"operation", "name", "target"}
+
+// invalidNameChars are characters that are not allowed in Prometheus metrics names
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Prometheus is stats client implementation that collects metrics to Prometheus registry, so they are scraped
+// from Handler instead of being pushed. Every section gets its metric families - "kandalf_<section>_total" counter
+// with success label for operations, "kandalf_<section>_state" gauge and "kandalf_<section>_duration_seconds"
+// histogram, and metric operation parts become operation, name and target labels.
+type Prometheus struct {
+	sync.Mutex
+
+	registry           *prometheus.Registry
+	counters           map[string]*prometheus.CounterVec
+	gauges             map[string]*prometheus.GaugeVec
+	histograms         map[string]*prometheus.HistogramVec
+	httpMetricCallback bucket.HTTPMetricNameAlterCallback
+	httpRequestSection string
+}
+
+// NewPrometheus builds and returns new Prometheus instance, registry includes Go runtime and process collectors
+func NewPrometheus() *Prometheus {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	return &Prometheus{
+		registry:           registry,
+		counters:           make(map[string]*prometheus.CounterVec),
+		gauges:             make(map[string]*prometheus.GaugeVec),
+		histograms:         make(map[string]*prometheus.HistogramVec),
+		httpRequestSection: bucket.SectionRequest,
+	}
+}
+
+// Handler returns HTTP handler exposing collected metrics in Prometheus text format
+func (c *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{})
+}
+
+// BuildTimer builds timer to track metric timings
+func (c *Prometheus) BuildTimer() timer.Timer {
+	return &timer.Memory{}
+}
+
+// Close does nothing, as metrics are scraped
+func (c *Prometheus) Close() error {
+	return nil
+}
+
+// TrackRequest tracks HTTP Request stats
+func (c *Prometheus) TrackRequest(r *http.Request, t timer.Timer, success bool) client.Client {
+	operation := bucket.BuildHTTPRequestMetricOperation(r, c.GetHTTPMetricCallback())
+
+	c.Lock()
+	section := c.httpRequestSection
+	c.Unlock()
+
+	return c.TrackOperation(section, operation, t, success)
+}
+
+// TrackOperation tracks custom operation
+func (c *Prometheus) TrackOperation(section string, operation bucket.MetricOperation, t timer.Timer, success bool) client.Client {
+	return c.TrackOperationN(section, operation, t, 1, success)
+}
+
+// TrackOperationN tracks custom operation with n diff
+func (c *Prometheus) TrackOperationN(section string, operation bucket.MetricOperation, t timer.Timer, n int, success bool) client.Client {
+	values := labelValues(operation, strconv.FormatBool(success))
+	c.counter(section).WithLabelValues(values...).Add(float64(n))
+	if t != nil {
+		c.histogram(section).WithLabelValues(values...).Observe(t.Finish().Seconds())
+	}
+
+	return c
+}
+
+// TrackMetric tracks custom metric, w/out ok/fail additional sections
+func (c *Prometheus) TrackMetric(section string, operation bucket.MetricOperation) client.Client {
+	return c.TrackMetricN(section, operation, 1)
+}
+
+// TrackMetricN tracks custom metric with n diff, w/out ok/fail additional sections
+func (c *Prometheus) TrackMetricN(section string, operation bucket.MetricOperation, n int) client.Client {
+	// metrics without success share counter with operations, unset label is the same as empty one
+	c.counter(section).WithLabelValues(labelValues(operation, "")...).Add(float64(n))
+
+	return c
+}
+
+// TrackState tracks metric absolute value
+func (c *Prometheus) TrackState(section string, operation bucket.MetricOperation, value int) client.Client {
+	c.gauge(section).WithLabelValues(labelValues(operation)...).Set(float64(value))
+
+	return c
+}
+
+// SetHTTPMetricCallback sets callback handler that allows metric operation alteration for HTTP Request
+func (c *Prometheus) SetHTTPMetricCallback(callback bucket.HTTPMetricNameAlterCallback) client.Client {
+	c.Lock()
+	defer c.Unlock()
+
+	c.httpMetricCallback = callback
+	return c
+}
+
+// GetHTTPMetricCallback gets callback handler that allows metric operation alteration for HTTP Request
+func (c *Prometheus) GetHTTPMetricCallback() bucket.HTTPMetricNameAlterCallback {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.httpMetricCallback
+}
+
+// SetHTTPRequestSection sets metric section for HTTP Request metrics
+func (c *Prometheus) SetHTTPRequestSection(section string) client.Client {
+	c.Lock()
+	defer c.Unlock()
+
+	c.httpRequestSection = section
+	return c
+}
+
+// ResetHTTPRequestSection resets metric section for HTTP Request metrics to default value that is "request"
+func (c *Prometheus) ResetHTTPRequestSection() client.Client {
+	return c.SetHTTPRequestSection(bucket.SectionRequest)
+}
+
+func (c *Prometheus) counter(section string) *prometheus.CounterVec {
+	c.Lock()
+	defer c.Unlock()
+
+	counter, ok := c.counters[section]
+	if !ok {
+		counter = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      metricName(section) + "_total",
+			Help:      "Number of " + section + " operations and events.",
+		}, append(labels, "success"))
+		c.registry.MustRegister(counter)
+		c.counters[section] = counter
+	}
+
+	return counter
+}
+
+func (c *Prometheus) gauge(section string) *prometheus.GaugeVec {
+	c.Lock()
+	defer c.Unlock()
+
+	gauge, ok := c.gauges[section]
+	if !ok {
+		gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      metricName(section) + "_state",
+			Help:      "Current value of " + section + " state.",
+		}, labels)
+		c.registry.MustRegister(gauge)
+		c.gauges[section] = gauge
+	}
+
+	return gauge
+}
+
+func (c *Prometheus) histogram(section string) *prometheus.HistogramVec {
+	c.Lock()
+	defer c.Unlock()
+
+	histogram, ok := c.histograms[section]
+	if !ok {
+		histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      metricName(section) + "_duration_seconds",
+			Help:      "Duration of " + section + " operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, append(labels, "success"))
+		c.registry.MustRegister(histogram)
+		c.histograms[section] = histogram
+	}
+
+	return histogram
+}
+
+// metricName returns section name that is valid for Prometheus, e.g. "error-log" becomes "error_log"
+func metricName(section string) string {
+	return invalidNameChars.ReplaceAllString(section, "_")
+}
+
+func labelValues(operation bucket.MetricOperation, extra ...string) []string {
+	return append(operation[:], extra...)
+}
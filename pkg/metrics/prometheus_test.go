@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/timer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, c *Prometheus) string {
+	recorder := httptest.NewRecorder()
+	c.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	body, err := ioutil.ReadAll(recorder.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestPrometheus(t *testing.T) {
+	c := NewPrometheus()
+
+	publishTimer := timer.NewDuration(250 * time.Millisecond)
+	c.TrackOperation("kafka", bucket.MetricOperation{"publish", "orders"}, publishTimer, true)
+	c.TrackOperationN("worker", bucket.MetricOperation{"consume", "shop"}, nil, 3, true)
+	c.TrackOperation("worker", bucket.MetricOperation{"consume", "shop"}, nil, false)
+	c.TrackMetric("error-log", bucket.MetricOperation{"error", "kafka"})
+	c.TrackMetricN("amqp", bucket.MetricOperation{"reconnect"}, 2)
+	c.TrackState("worker", bucket.MetricOperation{"buffer", "length"}, 42)
+	c.TrackState("worker", bucket.MetricOperation{"buffer", "length"}, 7)
+
+	body := scrape(t, c)
+	assert.Contains(t, body, `kandalf_kafka_total{name="orders",operation="publish",success="true",target=""} 1`)
+	assert.Contains(t, body, `kandalf_kafka_duration_seconds_bucket{name="orders",operation="publish",success="true",target="",le="0.25"} 1`)
+	assert.Contains(t, body, `kandalf_kafka_duration_seconds_bucket{name="orders",operation="publish",success="true",target="",le="0.1"} 0`)
+	assert.Contains(t, body, `kandalf_kafka_duration_seconds_sum{name="orders",operation="publish",success="true",target=""} 0.25`)
+	assert.Contains(t, body, `kandalf_worker_total{name="shop",operation="consume",success="true",target=""} 3`)
+	assert.Contains(t, body, `kandalf_worker_total{name="shop",operation="consume",success="false",target=""} 1`)
+	assert.Contains(t, body, `kandalf_error_log_total{name="kafka",operation="error",success="",target=""} 1`)
+	assert.Contains(t, body, `kandalf_amqp_total{name="",operation="reconnect",success="",target=""} 2`)
+	assert.Contains(t, body, `kandalf_worker_state{name="length",operation="buffer",target=""} 7`)
+	assert.Contains(t, body, "go_goroutines")
+
+	// operations without timer are not observed
+	assert.NotContains(t, body, "kandalf_worker_duration_seconds")
+}
+
+func TestPrometheus_TrackRequest(t *testing.T) {
+	c := NewPrometheus()
+	c.SetHTTPRequestSection("admin")
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	c.TrackRequest(r, nil, true)
+	assert.Contains(t, scrape(t, c), `kandalf_admin_total{name="metrics",operation="get",success="true",target="-"} 1`)
+
+	c.ResetHTTPRequestSection()
+	c.TrackRequest(r, nil, true)
+	assert.Contains(t, scrape(t, c), `kandalf_request_total{name="metrics",operation="get",success="true",target="-"} 1`)
+}
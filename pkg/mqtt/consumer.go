@@ -0,0 +1,220 @@
+package mqtt
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// reconnectTimeout is max pause between attempts to re-establish broken connection
+	reconnectTimeout = 5 * time.Second
+	// disconnectQuiesce is amount of time client waits for pending work to complete on close
+	disconnectQuiesce = 250 * time.Millisecond
+	// sharedSubscriptionPrefix prefixes shared subscription filters, it is followed by group name
+	sharedSubscriptionPrefix = "$share/"
+	// defaultClientName prefixes client ids together with hostname, unless client id is configured
+	defaultClientName = "kandalf"
+
+	// QoSHeader is delivery header with QoS level MQTT message is delivered with
+	QoSHeader = "mqtt-qos"
+	// RetainedHeader is delivery header that is true for retained MQTT messages
+	RetainedHeader = "mqtt-retained"
+
+	statsMQTTSection = "mqtt"
+	statsOpConnect   = "connect"
+	statsOpSubscribe = "subscribe"
+	statsOpConsume   = "consume"
+)
+
+var (
+	// ErrInvalidQoS is an error raised when subscriptions QoS level is not 0, 1 or 2
+	ErrInvalidQoS = errors.New("invalid QoS, supported levels are 0, 1 and 2")
+
+	errConnectTimeout = errors.New("timed out establishing MQTT connection")
+)
+
+// Consumer reads messages from MQTT broker for pipes with "mqtt" protocol. Every pipe has its own client with
+// persistent session, so messages published while kandalf is down are delivered once it is back,
+// and its messages are acknowledged only once they are handled.
+type Consumer struct {
+	clients     []paho.Client
+	handler     amqp.MessageHandler
+	statsClient client.Client
+}
+
+// NewConsumer instantiates new MQTT consumer, establishes connections and starts receiving messages for given pipes.
+// Connections are re-established and pipes are re-subscribed on any connection error.
+func NewConsumer(dsn string, mqttConfig config.MQTTConfig, pipes []config.Pipe, handler amqp.MessageHandler, statsClient client.Client) (*Consumer, error) {
+	if mqttConfig.QoS < 0 || mqttConfig.QoS > 2 {
+		return nil, ErrInvalidQoS
+	}
+	brokerURL, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Consumer{handler: handler, statsClient: statsClient}
+	for _, pipe := range pipes {
+		pipeClient, err := c.connect(brokerURL, mqttConfig, pipe)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.clients = append(c.clients, pipeClient)
+	}
+
+	return c, nil
+}
+
+// Close stops receiving messages and closes MQTT connections, messages that are not acknowledged yet are
+// redelivered by broker once pipe session is resumed
+func (c *Consumer) Close() error {
+	for _, pipeClient := range c.clients {
+		pipeClient.Disconnect(uint(disconnectQuiesce / time.Millisecond))
+	}
+
+	return nil
+}
+
+func (c *Consumer) connect(brokerURL *url.URL, mqttConfig config.MQTTConfig, pipe config.Pipe) (paho.Client, error) {
+	log.WithField("pipe", pipe.String()).Info("Establishing MQTT connection")
+
+	// every message in flight gets its handler, the rest wait for the free one
+	handlers := make(chan struct{}, pipe.HandlersNumber())
+
+	// credentials are passed in CONNECT packet, so they are stripped from broker URL, websocket path is kept
+	server := *brokerURL
+	server.User = nil
+
+	options := paho.NewClientOptions()
+	options.AddBroker(server.String())
+	if brokerURL.User != nil {
+		options.SetUsername(brokerURL.User.Username())
+		password, _ := brokerURL.User.Password()
+		options.SetPassword(password)
+	}
+	options.SetClientID(clientID(mqttConfig.ClientID, pipe))
+	// session outlives connection, so broker keeps subscriptions and unacknowledged messages until it is resumed
+	options.SetCleanSession(false)
+	options.SetAutoAckDisabled(true)
+	options.SetOrderMatters(false)
+	options.SetKeepAlive(mqttConfig.KeepAlive)
+	options.SetConnectTimeout(mqttConfig.ConnectionTimeout)
+	options.SetAutoReconnect(true)
+	options.SetMaxReconnectInterval(reconnectTimeout)
+	// messages of resumed session may arrive before subscriptions are renewed, so all the messages client receives
+	// are handled by the pipe regardless of subscription they match
+	options.SetDefaultPublishHandler(func(_ paho.Client, msg paho.Message) {
+		handlers <- struct{}{}
+		defer func() { <-handlers }()
+		c.handle(msg, pipe)
+	})
+	options.SetOnConnectHandler(func(pipeClient paho.Client) {
+		c.subscribe(pipeClient, mqttConfig, pipe)
+	})
+	options.SetConnectionLostHandler(func(_ paho.Client, err error) {
+		log.WithError(err).WithField("pipe", pipe.String()).Error("MQTT connection is lost, trying to reconnect")
+	})
+
+	pipeClient := paho.NewClient(options)
+	token := pipeClient.Connect()
+	err := errConnectTimeout
+	if token.WaitTimeout(mqttConfig.ConnectionTimeout) {
+		err = token.Error()
+	}
+
+	operation := bucket.MetricOperation{statsOpConnect, pipe.RabbitQueueName}
+	c.statsClient.TrackOperation(statsMQTTSection, operation, nil, err == nil)
+
+	return pipeClient, err
+}
+
+// subscribe renews pipe subscriptions, it is called on every connect, as broker may have discarded the session
+func (c *Consumer) subscribe(pipeClient paho.Client, mqttConfig config.MQTTConfig, pipe config.Pipe) {
+	filters := make(map[string]byte, len(pipe.RabbitRoutingKey))
+	for _, filter := range subscriptionFilters(pipe, mqttConfig.SharedSubscriptions) {
+		filters[filter] = byte(mqttConfig.QoS)
+	}
+
+	token := pipeClient.SubscribeMultiple(filters, nil)
+	token.Wait()
+	err := token.Error()
+
+	operation := bucket.MetricOperation{statsOpSubscribe, pipe.RabbitQueueName}
+	c.statsClient.TrackOperation(statsMQTTSection, operation, nil, err == nil)
+	if err != nil {
+		log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to subscribe MQTT topic filters")
+	}
+}
+
+func (c *Consumer) handle(msg paho.Message, pipe config.Pipe) {
+	delivery := newDelivery(msg)
+	delivery.Settle = func(err error) {
+		c.settle(msg, pipe, err)
+	}
+
+	if err := c.handler(delivery, pipe); err != amqp.ErrAckDeferred {
+		c.settle(msg, pipe, err)
+	}
+}
+
+// settle acknowledges message according to handling error, MQTT has no negative acknowledgement, so rejected
+// messages are acknowledged and dropped, and failed ones are left unacknowledged to be redelivered by broker
+// once pipe session is resumed
+func (c *Consumer) settle(msg paho.Message, pipe config.Pipe, err error) {
+	operation := bucket.MetricOperation{statsOpConsume, pipe.RabbitQueueName}
+	c.statsClient.TrackOperation(statsMQTTSection, operation, nil, nil == err)
+	if err != nil && err != amqp.ErrRejectMessage {
+		log.WithError(err).WithField("pipe", pipe.String()).
+			Error("Failed to consume MQTT message")
+		return
+	}
+
+	msg.Ack()
+}
+
+// clientID returns pipe client id, clients of different pipes and instances must have different ids,
+// as broker drops existing connection of the client with the same id
+func clientID(prefix string, pipe config.Pipe) string {
+	if prefix == "" {
+		hostname, _ := os.Hostname()
+		prefix = defaultClientName + "-" + hostname
+	}
+
+	return prefix + "-" + pipe.RabbitQueueName
+}
+
+// subscriptionFilters returns topic filters pipe subscribes, shared subscriptions use pipe queue name as group
+func subscriptionFilters(pipe config.Pipe, shared bool) []string {
+	if !shared {
+		return pipe.RabbitRoutingKey
+	}
+
+	filters := make([]string, 0, len(pipe.RabbitRoutingKey))
+	for _, filter := range pipe.RabbitRoutingKey {
+		filters = append(filters, sharedSubscriptionPrefix+pipe.RabbitQueueName+"/"+filter)
+	}
+
+	return filters
+}
+
+func newDelivery(msg paho.Message) amqp.Delivery {
+	return amqp.Delivery{
+		Body: msg.Payload(),
+		// MQTT has no routing keys, message topic is the closest thing to it, so topic templates and routes use it
+		RoutingKey: msg.Topic(),
+		Headers: map[string]interface{}{
+			QoSHeader:      int32(msg.Qos()),
+			RetainedHeader: msg.Retained(),
+		},
+	}
+}
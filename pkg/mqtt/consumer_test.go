@@ -0,0 +1,43 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockMessage struct {
+	topic    string
+	payload  []byte
+	qos      byte
+	retained bool
+	acked    bool
+}
+
+func (m *mockMessage) Duplicate() bool   { return false }
+func (m *mockMessage) Qos() byte         { return m.qos }
+func (m *mockMessage) Retained() bool    { return m.retained }
+func (m *mockMessage) Topic() string     { return m.topic }
+func (m *mockMessage) MessageID() uint16 { return 1 }
+func (m *mockMessage) Payload() []byte   { return m.payload }
+func (m *mockMessage) Ack()              { m.acked = true }
+
+func TestClientID(t *testing.T) {
+	pipe := config.Pipe{RabbitQueueName: "sensors"}
+	assert.Equal(t, "kandalf-1-sensors", clientID("kandalf-1", pipe))
+	assert.Contains(t, clientID("", pipe), defaultClientName+"-")
+}
+
+func TestSubscriptionFilters(t *testing.T) {
+	pipe := config.Pipe{RabbitQueueName: "sensors", RabbitRoutingKey: []string{"sensors/+/temperature", "alerts/#"}}
+	assert.Equal(t, []string{"sensors/+/temperature", "alerts/#"}, subscriptionFilters(pipe, false))
+	assert.Equal(t, []string{"$share/sensors/sensors/+/temperature", "$share/sensors/alerts/#"}, subscriptionFilters(pipe, true))
+}
+
+func TestNewDelivery(t *testing.T) {
+	delivery := newDelivery(&mockMessage{topic: "sensors/1/temperature", payload: []byte(`{"value":21.5}`), qos: 1, retained: true})
+	assert.Equal(t, []byte(`{"value":21.5}`), delivery.Body)
+	assert.Equal(t, "sensors/1/temperature", delivery.RoutingKey)
+	assert.Equal(t, map[string]interface{}{QoSHeader: int32(1), RetainedHeader: true}, delivery.Headers)
+}
@@ -0,0 +1,4 @@
+/*
+Package mqtt holds code required for reading messages from MQTT brokers for pipes with "mqtt" protocol.
+*/
+package mqtt
@@ -0,0 +1,5 @@
+/*
+Package pidfile holds process id file of running instance, so classic init scripts find and signal the process,
+while pid files left by crashed instances are detected as stale and replaced.
+*/
+package pidfile
@@ -0,0 +1,69 @@
+package pidfile
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrRunning is an error returned when pid file belongs to another running process
+var ErrRunning = errors.New("pid file belongs to running process")
+
+// File is pid file of current process
+type File struct {
+	path string
+	pid  int
+}
+
+// Acquire writes current process id to pid file. Pid file of dead process or with invalid content is stale one
+// and is replaced, while pid file of another running process is left intact and ErrRunning is returned.
+func Acquire(path string) (*File, error) {
+	f := &File{path: path, pid: os.Getpid()}
+
+	pid, err := Read(path)
+	switch {
+	case err == nil && pid != f.pid && processAlive(pid):
+		log.WithField("path", path).WithField("pid", pid).Error("Pid file belongs to running process")
+		return nil, ErrRunning
+	case err == nil:
+		// pid may be reused by current process, e.g. in container, where it is always the same one
+		log.WithField("path", path).WithField("pid", pid).Warning("Replacing stale pid file")
+	case !os.IsNotExist(err):
+		log.WithError(err).WithField("path", path).Warning("Replacing invalid pid file")
+	}
+
+	// pid file is replaced at once, so it is never read half-written
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(f.pid)+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Release removes pid file, unless it was replaced by another process meanwhile
+func (f *File) Release() error {
+	if pid, err := Read(f.path); err != nil || pid != f.pid {
+		return nil
+	}
+
+	return os.Remove(f.path)
+}
+
+// Read returns process id from pid file
+func Read(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,58 @@
+package pidfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-pidfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kandalf.pid")
+
+	f, err := Acquire(path)
+	require.NoError(t, err)
+	pid, err := Read(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+
+	require.NoError(t, f.Release())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAcquire_existing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-pidfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kandalf.pid")
+
+	// init process is always running
+	require.NoError(t, ioutil.WriteFile(path, []byte("1\n"), 0644))
+	if os.Getpid() != 1 {
+		_, err = Acquire(path)
+		assert.Equal(t, ErrRunning, err)
+	}
+
+	// stale and invalid pid files are replaced
+	for _, content := range []string{"0", "not a pid"} {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		f, err := Acquire(path)
+		require.NoError(t, err)
+		pid, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.Getpid(), pid)
+
+		// replaced pid file is not removed on release
+		require.NoError(t, ioutil.WriteFile(path, []byte("1"), 0644))
+		require.NoError(t, f.Release())
+		_, err = os.Stat(path)
+		assert.NoError(t, err)
+	}
+}
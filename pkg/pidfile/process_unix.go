@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package pidfile
+
+import "syscall"
+
+// processAlive returns true if process with the given id exists, signal 0 checks process without signalling it,
+// process of another user exists as well, even though it can not be signalled
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
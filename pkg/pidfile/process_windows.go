@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package pidfile
+
+import "os"
+
+// processAlive returns true if process with the given id exists, on windows process is found only if it exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+
+	return true
+}
@@ -0,0 +1,5 @@
+/*
+Package plugins holds interfaces for custom message filters and transformers and loader of their implementations
+from Go plugins, so bespoke enrichment does not require built-in pipe settings.
+*/
+package plugins
@@ -0,0 +1,98 @@
+package plugins
+
+import (
+	"errors"
+	"plugin"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+// ConstructorSymbol is name of the function plugin exports to be instantiated with pipe plugin config,
+// it must be of Constructor type
+const ConstructorSymbol = "NewPlugin"
+
+var (
+	// ErrReject is an error plugin returns for messages that must be rejected, so they are dead-lettered,
+	// other plugin errors requeue the message, as plugins may depend on external services
+	ErrReject = errors.New("message is rejected by plugin")
+	// ErrInvalidConstructor is an error raised when plugin constructor symbol has unexpected type
+	ErrInvalidConstructor = errors.New("plugin " + ConstructorSymbol + " must be func(map[string]string) (interface{}, error)")
+	// ErrNotImplemented is an error raised when plugin implements neither Filter nor Transformer
+	ErrNotImplemented = errors.New("plugin must implement Filter or Transformer interface")
+	// ErrUnsupported is an error raised when there are pipes with plugin, but binary can not open Go plugins
+	ErrUnsupported = errors.New("pipe plugin requires kandalf built with CGO_ENABLED=1 for linux, freebsd or darwin")
+)
+
+// Constructor instantiates plugin with pipe plugin config, returned value must implement Filter, Transformer or both
+type Constructor = func(config map[string]string) (interface{}, error)
+
+// Filter is an interface for custom message filters
+type Filter interface {
+	// Match returns true if message must be forwarded to Kafka, the rest of messages are acknowledged and dropped
+	Match(delivery amqp.Delivery) (bool, error)
+}
+
+// Transformer is an interface for custom message transformers
+type Transformer interface {
+	// Transform returns new message body, delivery body is already transformed with pipe transform template
+	Transform(delivery amqp.Delivery) ([]byte, error)
+}
+
+// Plugin is pipe plugin instance, either its filter or transformer may be nil
+type Plugin struct {
+	Filter      Filter
+	Transformer Transformer
+}
+
+// Validate checks that pipes with plugin can be started by this binary, so bridge fails on start instead of
+// opening plugins later
+func Validate(pipes []config.Pipe) error {
+	for _, pipe := range pipes {
+		if pipe.Plugin != nil && !Supported {
+			return ErrUnsupported
+		}
+	}
+
+	return nil
+}
+
+// Load opens Go plugin and instantiates it with pipe plugin config, plugin is opened only once per path,
+// while every pipe gets its own instance
+func Load(settings config.PluginSettings) (*Plugin, error) {
+	if !Supported {
+		return nil, ErrUnsupported
+	}
+
+	p, err := plugin.Open(settings.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	symbol, err := p.Lookup(ConstructorSymbol)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPlugin(symbol, settings.Config)
+}
+
+func newPlugin(symbol plugin.Symbol, config map[string]string) (*Plugin, error) {
+	constructor, ok := symbol.(Constructor)
+	if !ok {
+		return nil, ErrInvalidConstructor
+	}
+
+	instance, err := constructor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, _ := instance.(Filter)
+	transformer, _ := instance.(Transformer)
+	if filter == nil && transformer == nil {
+		return nil, ErrNotImplemented
+	}
+
+	return &Plugin{Filter: filter, Transformer: transformer}, nil
+}
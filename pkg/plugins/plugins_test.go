@@ -0,0 +1,86 @@
+package plugins
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type upperTransformer struct{}
+
+func (upperTransformer) Transform(delivery amqp.Delivery) ([]byte, error) {
+	return bytes.ToUpper(delivery.Body), nil
+}
+
+type regionFilter struct {
+	upperTransformer
+	region string
+}
+
+func (f regionFilter) Match(delivery amqp.Delivery) (bool, error) {
+	return delivery.Headers["region"] == f.region, nil
+}
+
+func TestNewPlugin(t *testing.T) {
+	var constructor Constructor = func(config map[string]string) (interface{}, error) {
+		switch config["kind"] {
+		case "filter":
+			return regionFilter{region: config["region"]}, nil
+		case "transformer":
+			return upperTransformer{}, nil
+		case "broken":
+			return nil, errors.New("broken config")
+		}
+		return struct{}{}, nil
+	}
+
+	p, err := newPlugin(constructor, map[string]string{"kind": "filter", "region": "eu"})
+	require.NoError(t, err)
+	require.NotNil(t, p.Filter)
+	require.NotNil(t, p.Transformer)
+
+	matches, err := p.Filter.Match(amqp.Delivery{Headers: map[string]interface{}{"region": "eu"}})
+	assert.NoError(t, err)
+	assert.True(t, matches)
+
+	p, err = newPlugin(constructor, map[string]string{"kind": "transformer"})
+	require.NoError(t, err)
+	assert.Nil(t, p.Filter)
+	body, err := p.Transformer.Transform(amqp.Delivery{Body: []byte("body")})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("BODY"), body)
+
+	_, err = newPlugin(constructor, map[string]string{"kind": "broken"})
+	assert.EqualError(t, err, "broken config")
+
+	_, err = newPlugin(constructor, nil)
+	assert.Equal(t, ErrNotImplemented, err)
+
+	_, err = newPlugin(func() {}, nil)
+	assert.Equal(t, ErrInvalidConstructor, err)
+}
+
+func TestLoad(t *testing.T) {
+	_, err := Load(config.PluginSettings{Path: "missing.so"})
+	assert.Error(t, err)
+	if !Supported {
+		assert.Equal(t, ErrUnsupported, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	pipes := []config.Pipe{{RabbitQueueName: "orders"}}
+	assert.NoError(t, Validate(pipes))
+
+	pipes = append(pipes, config.Pipe{RabbitQueueName: "loyalty", Plugin: &config.PluginSettings{Path: "loyalty.so"}})
+	if Supported {
+		assert.NoError(t, Validate(pipes))
+	} else {
+		assert.Equal(t, ErrUnsupported, Validate(pipes))
+	}
+}
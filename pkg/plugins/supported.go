@@ -0,0 +1,8 @@
+//go:build cgo && (linux || darwin || freebsd)
+// +build cgo
+// +build linux darwin freebsd
+
+package plugins
+
+// Supported is true if binary can open Go plugins, that is it is built with cgo for Linux, FreeBSD or macOS
+const Supported = true
@@ -0,0 +1,7 @@
+//go:build !cgo || (!linux && !darwin && !freebsd)
+// +build !cgo !linux,!darwin,!freebsd
+
+package plugins
+
+// Supported is false as Go plugins can not be opened by binary built without cgo or for other platforms
+const Supported = false
@@ -0,0 +1,29 @@
+package producer
+
+import (
+	"errors"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/client"
+)
+
+const (
+	// ClientSarama is the default Kafka client, messages are published with Shopify/sarama
+	ClientSarama = "sarama"
+	// ClientConfluent is Kafka client that publishes messages with confluent-kafka-go backed by librdkafka
+	ClientConfluent = "confluent"
+)
+
+var errUnknownClient = errors.New("unknown Kafka client, supported clients are sarama and confluent")
+
+// NewClientProducer instantiates and establishes new Kafka connection with the client library from config
+func NewClientProducer(kafkaConfig config.KafkaConfig, statsClient client.Client) (Producer, error) {
+	switch kafkaConfig.Client {
+	case "", ClientSarama:
+		return NewKafkaProducer(kafkaConfig, statsClient)
+	case ClientConfluent:
+		return newConfluentProducer(kafkaConfig, statsClient)
+	}
+
+	return nil, errUnknownClient
+}
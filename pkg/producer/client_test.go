@@ -0,0 +1,16 @@
+package producer
+
+import (
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClientProducer_unknownClient(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+
+	_, err := NewClientProducer(config.KafkaConfig{Client: "kafka-go"}, statsClient)
+	assert.Equal(t, errUnknownClient, err)
+}
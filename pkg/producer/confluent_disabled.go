@@ -0,0 +1,17 @@
+//go:build !confluent
+// +build !confluent
+
+package producer
+
+import (
+	"errors"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/client"
+)
+
+var errConfluentDisabled = errors.New("confluent Kafka client requires kandalf to be built with confluent build tag")
+
+func newConfluentProducer(kafkaConfig config.KafkaConfig, statsClient client.Client) (Producer, error) {
+	return nil, errConfluentDisabled
+}
@@ -0,0 +1,466 @@
+//go:build confluent
+// +build confluent
+
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// confluentCloseTimeout is max amount of time to wait for queued messages to be delivered on close
+	confluentCloseTimeout = 10 * time.Second
+	// confluentStatsInterval is interval of librdkafka statistics events brokers throttle time is taken from
+	confluentStatsInterval = time.Second
+	// confluentTransactionTimeout is max amount of time to wait for transactions to be initialized, committed
+	// or aborted
+	confluentTransactionTimeout = 30 * time.Second
+)
+
+var confluentRequiredAcks = map[string]string{
+	"":       "all",
+	"none":   "0",
+	"leader": "1",
+	"all":    "all",
+}
+
+// confluentPartitioners are librdkafka partitioners closest to sarama ones, manual partitioner works the same way
+// with any of them, as messages partitions are set explicitly. Keyed messages land on the same partitions with both
+// clients with murmur2 partitioner only: sarama hash partitioner takes modulo of FNV-1a hash converted to int32
+// and negates negative result, while librdkafka fnv1a takes modulo of unsigned hash, so keys with the highest hash
+// bit set are mapped to other partitions once client is switched.
+var confluentPartitioners = map[string]string{
+	"":                 "fnv1a_random",
+	PartitionerHash:    "fnv1a_random",
+	PartitionerMurmur2: "murmur2_random",
+	PartitionerManual:  "fnv1a_random",
+}
+
+var errConfluentRoundRobin = errors.New("round-robin partitioner is not supported by confluent Kafka client")
+
+// errTransactionAborted is an error for messages of aborted transaction that were delivered, but are not visible
+// to read committed consumers, so they must be published again
+var errTransactionAborted = errors.New("kafka transaction aborted")
+
+var confluentSASLMechanisms = map[string]string{
+	saslMechanismPlain:       "PLAIN",
+	saslMechanismSCRAMSHA256: "SCRAM-SHA-256",
+	saslMechanismSCRAMSHA512: "SCRAM-SHA-512",
+}
+
+// confluentClient is a part of confluent-kafka-go producer API publishing is done with, transactional producer
+// replaces its client once it is fenced or failed
+type confluentClient interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+	Flush(timeoutMs int) int
+	Close()
+	BeginTransaction() error
+	CommitTransaction(ctx context.Context) error
+	AbortTransaction(ctx context.Context) error
+}
+
+// confluentProducer is a Producer implementation for publishing messages to Kafka with confluent-kafka-go
+type confluentProducer struct {
+	kafkaClient      confluentClient
+	statsClient      client.Client
+	deadline         time.Duration
+	manualPartitions bool
+	throttle         *throttle
+
+	// transactional producer publishes every batch within transaction, batches are published one at a time,
+	// as producer has single ongoing transaction
+	transactional bool
+	transaction   sync.Mutex
+	// newClient creates transactional client with the same transactional id, that fences the failed one
+	newClient func() (confluentClient, error)
+}
+
+// confluentStats is a part of librdkafka statistics with brokers throttle time window stats in milliseconds
+type confluentStats struct {
+	Brokers map[string]struct {
+		Throttle struct {
+			Max int64 `json:"max"`
+			Sum int64 `json:"sum"`
+		} `json:"throttle"`
+	} `json:"brokers"`
+}
+
+func newConfluentProducer(kafkaConfig config.KafkaConfig, statsClient client.Client) (Producer, error) {
+	cnf, err := newConfluentConfig(kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &confluentProducer{
+		statsClient:      statsClient,
+		deadline:         kafkaConfig.Retry.Deadline,
+		manualPartitions: kafkaConfig.Partitioner == PartitionerManual,
+		throttle:         &throttle{},
+		transactional:    kafkaConfig.TransactionalID != "",
+	}
+	p.newClient = func() (confluentClient, error) {
+		return p.newKafkaClient(cnf)
+	}
+
+	if p.kafkaClient, err = p.newKafkaClient(cnf); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// newKafkaClient creates confluent Kafka client and starts handling its events
+func (p *confluentProducer) newKafkaClient(cnf *kafka.ConfigMap) (confluentClient, error) {
+	kafkaClient, err := kafka.NewProducer(cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.transactional {
+		// fences previous producer with the same transactional id and aborts its ongoing transaction
+		ctx, cancel := context.WithTimeout(context.Background(), confluentTransactionTimeout)
+		err := kafkaClient.InitTransactions(ctx)
+		cancel()
+		if err != nil {
+			kafkaClient.Close()
+			return nil, err
+		}
+	}
+
+	go func() {
+		// delivery reports are sent to per-publish channels, so only client level events get here
+		for event := range kafkaClient.Events() {
+			switch event := event.(type) {
+			case kafka.Error:
+				log.WithError(event).Error("Got Kafka client error")
+			case *kafka.Stats:
+				p.handleStats(event.String())
+			}
+		}
+	}()
+
+	return kafkaClient, nil
+}
+
+// handleStats delays publishing by the longest throttle time brokers reported within stats interval
+func (p *confluentProducer) handleStats(statsJSON string) {
+	var stats confluentStats
+	if err := json.Unmarshal([]byte(statsJSON), &stats); err != nil {
+		log.WithError(err).Warning("Failed to parse Kafka client statistics")
+		return
+	}
+
+	var maxThrottle, throttled int64
+	for _, broker := range stats.Brokers {
+		if broker.Throttle.Max > maxThrottle {
+			maxThrottle = broker.Throttle.Max
+		}
+		throttled += broker.Throttle.Sum
+	}
+
+	if throttled > 0 {
+		log.WithField("throttle_ms", maxThrottle).Warning("Kafka brokers throttle producer, delaying publishing")
+		p.statsClient.TrackMetricN(statsKafkaSection, bucket.MetricOperation{"throttle", "ms"}, int(throttled))
+		p.throttle.observe(time.Duration(maxThrottle) * time.Millisecond)
+	}
+}
+
+func newConfluentConfig(kafkaConfig config.KafkaConfig) (*kafka.ConfigMap, error) {
+	acks, ok := confluentRequiredAcks[kafkaConfig.RequiredAcks]
+	if !ok {
+		return nil, errUnknownRequiredAcks
+	}
+	if _, ok := compressionCodecs[kafkaConfig.Compression]; !ok {
+		return nil, errUnknownCompression
+	}
+	if kafkaConfig.Partitioner == PartitionerRoundRobin {
+		return nil, errConfluentRoundRobin
+	}
+	partitioner, ok := confluentPartitioners[kafkaConfig.Partitioner]
+	if !ok {
+		return nil, errUnknownPartitioner
+	}
+
+	retryMax := kafkaConfig.MaxRetry
+	if kafkaConfig.Retry.Max != nil {
+		retryMax = *kafkaConfig.Retry.Max
+	}
+
+	cnf := &kafka.ConfigMap{
+		"bootstrap.servers":        strings.Join(kafkaConfig.Brokers, ","),
+		"acks":                     acks,
+		"enable.idempotence":       kafkaConfig.Idempotent || kafkaConfig.TransactionalID != "",
+		"message.send.max.retries": retryMax,
+		"linger.ms":                int(kafkaConfig.FlushFrequency / time.Millisecond),
+		"partitioner":              partitioner,
+		"statistics.interval.ms":   int(confluentStatsInterval / time.Millisecond),
+	}
+	if kafkaConfig.Version != "" {
+		cnf.SetKey("broker.version.fallback", kafkaConfig.Version)
+	}
+	if kafkaConfig.TransactionalID != "" {
+		cnf.SetKey("transactional.id", kafkaConfig.TransactionalID)
+	}
+	if kafkaConfig.Retry.Backoff > 0 {
+		cnf.SetKey("retry.backoff.ms", int(kafkaConfig.Retry.Backoff/time.Millisecond))
+	}
+	if kafkaConfig.FlushMessages > 0 {
+		cnf.SetKey("batch.num.messages", kafkaConfig.FlushMessages)
+	}
+	if kafkaConfig.FlushBytes > 0 {
+		cnf.SetKey("batch.size", kafkaConfig.FlushBytes)
+	}
+	if kafkaConfig.MaxInFlight > 0 {
+		cnf.SetKey("max.in.flight.requests.per.connection", kafkaConfig.MaxInFlight)
+	}
+	if kafkaConfig.Compression != "" {
+		cnf.SetKey("compression.codec", kafkaConfig.Compression)
+	}
+	if kafkaConfig.CompressionLevel != 0 {
+		cnf.SetKey("compression.level", kafkaConfig.CompressionLevel)
+	}
+
+	securityProtocol := "plaintext"
+	if kafkaConfig.TLS.Enabled {
+		securityProtocol = "ssl"
+		if kafkaConfig.TLS.CAFile != "" {
+			cnf.SetKey("ssl.ca.location", kafkaConfig.TLS.CAFile)
+		}
+		if kafkaConfig.TLS.CertFile != "" || kafkaConfig.TLS.KeyFile != "" {
+			if kafkaConfig.TLS.CertFile == "" || kafkaConfig.TLS.KeyFile == "" {
+				return nil, errMissingKeyPair
+			}
+			cnf.SetKey("ssl.certificate.location", kafkaConfig.TLS.CertFile)
+			cnf.SetKey("ssl.key.location", kafkaConfig.TLS.KeyFile)
+		}
+		if kafkaConfig.TLS.InsecureSkipVerify {
+			cnf.SetKey("enable.ssl.certificate.verification", false)
+		}
+	}
+
+	if kafkaConfig.SASL.Mechanism != "" {
+		mechanism, ok := confluentSASLMechanisms[kafkaConfig.SASL.Mechanism]
+		if !ok {
+			return nil, errUnknownSASLMechanism
+		}
+		securityProtocol = "sasl_" + securityProtocol
+		cnf.SetKey("sasl.mechanisms", mechanism)
+		cnf.SetKey("sasl.username", kafkaConfig.SASL.Username)
+		cnf.SetKey("sasl.password", kafkaConfig.SASL.Password)
+	}
+	cnf.SetKey("security.protocol", securityProtocol)
+
+	return cnf, nil
+}
+
+// Close delivers queued messages and closes Kafka connection
+func (p *confluentProducer) Close() error {
+	// transactional client is not replaced while it is closed
+	p.transaction.Lock()
+	defer p.transaction.Unlock()
+
+	if remaining := p.kafkaClient.Flush(int(confluentCloseTimeout / time.Millisecond)); remaining > 0 {
+		log.WithField("remaining", remaining).Warning("Closing Kafka client with undelivered messages")
+	}
+	p.kafkaClient.Close()
+
+	return nil
+}
+
+// Publish publishes message to Kafka
+func (p *confluentProducer) Publish(msg Message) error {
+	return p.PublishBatch([]Message{msg})[0]
+}
+
+// PublishBatch publishes messages to Kafka at once and waits for all of them to be delivered, transactional
+// producer commits them within transaction, so either all of them are published or none
+func (p *confluentProducer) PublishBatch(msgs []Message) []error {
+	p.throttle.wait()
+
+	publishTimer := p.statsClient.BuildTimer().Start()
+	trackBatch(p.statsClient, msgs)
+
+	var errs []error
+	if p.transactional {
+		errs = p.publishTransaction(msgs)
+	} else {
+		errs = p.produce(msgs)
+	}
+
+	for i := range msgs {
+		trackPublish(p.statsClient, msgs[i], publishTimer, errs[i])
+	}
+
+	return errs
+}
+
+// produce sends messages to Kafka at once and waits for their delivery reports
+func (p *confluentProducer) produce(msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	deliveries := make(chan kafka.Event, len(msgs))
+
+	var pending int
+	for i := range msgs {
+		if deadlineExceeded(msgs[i], p.deadline) {
+			errs[i] = ErrPublishDeadlineExceeded
+			continue
+		}
+
+		confluentMessage := newConfluentMessage(msgs[i], i)
+		if p.manualPartitions {
+			partition, err := manualPartition(msgs[i].Key)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			confluentMessage.TopicPartition.Partition = partition
+		}
+
+		if err := p.kafkaClient.Produce(confluentMessage, deliveries); err != nil {
+			errs[i] = confluentError(err)
+			continue
+		}
+		pending++
+	}
+
+	for pending > 0 {
+		delivered, ok := (<-deliveries).(*kafka.Message)
+		if !ok {
+			continue
+		}
+		i := delivered.Opaque.(int)
+		errs[i] = confluentError(delivered.TopicPartition.Error)
+		msgs[i].Partition = delivered.TopicPartition.Partition
+		msgs[i].Offset = int64(delivered.TopicPartition.Offset)
+		pending--
+	}
+
+	return errs
+}
+
+// publishTransaction publishes messages within transaction, that is committed once all of them are delivered
+// and aborted if any of them fails, then the rest of messages fail with errTransactionAborted. Messages of
+// transaction that failed to begin or commit fail with retriable transactionError, fenced or failed client
+// is recreated before the next batch.
+// Messages are acknowledged to source broker after the commit, so the crash between the commit and acknowledgement
+// leads to duplicates of committed messages, that is the remaining window of transactional delivery.
+func (p *confluentProducer) publishTransaction(msgs []Message) []error {
+	p.transaction.Lock()
+	defer p.transaction.Unlock()
+
+	if err := p.kafkaClient.BeginTransaction(); err != nil {
+		// begin fails only if client failed or previous transaction is not completed
+		p.resetClient()
+		return transactionErrors(msgs, transactionError{err: confluentError(err)})
+	}
+
+	errs := p.produce(msgs)
+	for _, err := range errs {
+		if err != nil {
+			p.abortTransaction()
+			for i := range errs {
+				if errs[i] == nil {
+					errs[i] = errTransactionAborted
+				}
+			}
+			return errs
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), confluentTransactionTimeout)
+	defer cancel()
+	for {
+		err := p.kafkaClient.CommitTransaction(ctx)
+		if err == nil {
+			return errs
+		}
+
+		kafkaErr, ok := err.(kafka.Error)
+		if ok && kafkaErr.IsRetriable() && ctx.Err() == nil {
+			continue
+		}
+		if ok && kafkaErr.IsFatal() {
+			log.WithError(err).Error("Kafka transactional producer is fenced or failed, recreating it")
+			p.resetClient()
+		} else {
+			p.abortTransaction()
+		}
+		return transactionErrors(msgs, transactionError{err: confluentError(err)})
+	}
+}
+
+// abortTransaction aborts ongoing transaction, so its messages are not visible to read committed consumers,
+// client that failed to abort is recreated, as its transaction state is unknown
+func (p *confluentProducer) abortTransaction() {
+	ctx, cancel := context.WithTimeout(context.Background(), confluentTransactionTimeout)
+	defer cancel()
+	if err := p.kafkaClient.AbortTransaction(ctx); err != nil {
+		log.WithError(err).Error("Failed to abort Kafka transaction, recreating producer")
+		p.resetClient()
+	}
+}
+
+// resetClient replaces transactional client with a new one, that aborts ongoing transaction and fences the old one,
+// the old client is kept if the new one can not be created, so the next batch fails and resets it again
+func (p *confluentProducer) resetClient() {
+	kafkaClient, err := p.newClient()
+	if err != nil {
+		log.WithError(err).Error("Failed to recreate Kafka transactional producer")
+		return
+	}
+
+	p.kafkaClient.Close()
+	p.kafkaClient = kafkaClient
+}
+
+// transactionErrors returns the same error for every message of failed transaction
+func transactionErrors(msgs []Message, err error) []error {
+	errs := make([]error, len(msgs))
+	for i := range errs {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+func newConfluentMessage(msg Message, index int) *kafka.Message {
+	topic := msg.Topic
+	confluentMessage := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          msg.Body,
+		Opaque:         index,
+	}
+	if msg.Key != "" {
+		confluentMessage.Key = []byte(msg.Key)
+	}
+	if msg.Timestamp > 0 {
+		confluentMessage.Timestamp = time.Unix(0, msg.Timestamp)
+	}
+	for k, v := range msg.Headers {
+		confluentMessage.Headers = append(confluentMessage.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	return confluentMessage
+}
+
+// confluentError converts broker errors to Kafka protocol errors, so they are classified by IsRetriable
+// the same way as sarama ones, librdkafka local errors are returned as is
+func confluentError(err error) error {
+	if kafkaErr, ok := err.(kafka.Error); ok && kafkaErr.Code() > 0 {
+		return sarama.KError(kafkaErr.Code())
+	}
+
+	return err
+}
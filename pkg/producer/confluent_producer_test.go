@@ -0,0 +1,179 @@
+//go:build confluent
+// +build confluent
+
+package producer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertConfigValue(t *testing.T, cnf *kafka.ConfigMap, key string, expected kafka.ConfigValue) {
+	value, err := cnf.Get(key, nil)
+	require.NoError(t, err, key)
+	assert.Equal(t, expected, value, key)
+}
+
+func TestNewConfluentConfig(t *testing.T) {
+	retryMax := 3
+	cnf, err := newConfluentConfig(config.KafkaConfig{
+		Brokers:        []string{"192.0.0.1:9092", "192.0.0.2:9092"},
+		RequiredAcks:   "leader",
+		MaxRetry:       5,
+		Retry:          config.KafkaRetryConfig{Max: &retryMax, Backoff: 250 * time.Millisecond},
+		FlushFrequency: 100 * time.Millisecond,
+		Compression:    "snappy",
+		TLS:            config.KafkaTLSConfig{Enabled: true, CAFile: "/etc/kandalf/tls/ca.pem"},
+		SASL:           config.KafkaSASLConfig{Mechanism: "scram-sha-512", Username: "kandalf", Password: "secret"},
+	})
+	require.NoError(t, err)
+
+	assertConfigValue(t, cnf, "bootstrap.servers", "192.0.0.1:9092,192.0.0.2:9092")
+	assertConfigValue(t, cnf, "acks", "1")
+	assertConfigValue(t, cnf, "message.send.max.retries", 3)
+	assertConfigValue(t, cnf, "retry.backoff.ms", 250)
+	assertConfigValue(t, cnf, "statistics.interval.ms", 1000)
+	assertConfigValue(t, cnf, "linger.ms", 100)
+	assertConfigValue(t, cnf, "compression.codec", "snappy")
+	assertConfigValue(t, cnf, "ssl.ca.location", "/etc/kandalf/tls/ca.pem")
+	assertConfigValue(t, cnf, "security.protocol", "sasl_ssl")
+	assertConfigValue(t, cnf, "sasl.mechanisms", "SCRAM-SHA-512")
+	assertConfigValue(t, cnf, "partitioner", "fnv1a_random")
+
+	cnf, err = newConfluentConfig(config.KafkaConfig{Partitioner: PartitionerMurmur2})
+	require.NoError(t, err)
+	assertConfigValue(t, cnf, "partitioner", "murmur2_random")
+
+	// transactional producer is always idempotent
+	cnf, err = newConfluentConfig(config.KafkaConfig{RequiredAcks: "all", TransactionalID: "kandalf-1-/orders"})
+	require.NoError(t, err)
+	assertConfigValue(t, cnf, "transactional.id", "kandalf-1-/orders")
+	assertConfigValue(t, cnf, "enable.idempotence", true)
+
+	_, err = newConfluentConfig(config.KafkaConfig{Partitioner: PartitionerRoundRobin})
+	assert.Equal(t, errConfluentRoundRobin, err)
+
+	_, err = newConfluentConfig(config.KafkaConfig{RequiredAcks: "some"})
+	assert.Equal(t, errUnknownRequiredAcks, err)
+
+	_, err = newConfluentConfig(config.KafkaConfig{Compression: "brotli"})
+	assert.Equal(t, errUnknownCompression, err)
+
+	_, err = newConfluentConfig(config.KafkaConfig{SASL: config.KafkaSASLConfig{Mechanism: "gssapi"}})
+	assert.Equal(t, errUnknownSASLMechanism, err)
+
+	_, err = newConfluentConfig(config.KafkaConfig{TLS: config.KafkaTLSConfig{Enabled: true, CertFile: "/etc/kandalf/tls/cert.pem"}})
+	assert.Equal(t, errMissingKeyPair, err)
+}
+
+func TestConfluentError(t *testing.T) {
+	assert.Nil(t, confluentError(nil))
+	assert.Equal(t, sarama.ErrNotLeaderForPartition, confluentError(kafka.NewError(kafka.ErrNotLeaderForPartition, "not leader", false)))
+
+	localErr := kafka.NewError(kafka.ErrMsgTimedOut, "timed out", false)
+	assert.Equal(t, localErr, confluentError(localErr))
+}
+
+func TestConfluentProducer_handleStats(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	p := &confluentProducer{statsClient: statsClient, throttle: &throttle{}}
+
+	p.handleStats(`{"brokers":{"kafka1:9092/1":{"throttle":{"max":0,"sum":0}}}}`)
+	assert.Equal(t, time.Duration(0), p.throttle.wait())
+
+	p.handleStats(`{"brokers":{"kafka1:9092/1":{"throttle":{"max":20,"sum":30}},"kafka2:9092/2":{"throttle":{"max":40,"sum":40}}}}`)
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 70, memoryStats.CountMetrics[fmt.Sprintf("%s.throttle.ms.-", statsKafkaSection)])
+	assert.True(t, p.throttle.wait() > 20*time.Millisecond)
+}
+
+type mockConfluentClient struct {
+	confluentClient
+
+	commitErr error
+	aborted   bool
+	closed    bool
+	offset    kafka.Offset
+}
+
+func (c *mockConfluentClient) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	c.offset++
+	deliveryChan <- &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: msg.TopicPartition.Topic, Offset: c.offset}, Opaque: msg.Opaque}
+
+	return nil
+}
+
+func (c *mockConfluentClient) BeginTransaction() error {
+	return nil
+}
+
+func (c *mockConfluentClient) CommitTransaction(context.Context) error {
+	return c.commitErr
+}
+
+func (c *mockConfluentClient) AbortTransaction(context.Context) error {
+	c.aborted = true
+	return nil
+}
+
+func (c *mockConfluentClient) Close() {
+	c.closed = true
+}
+
+func newTestTransactionalProducer(kafkaClient, newClient *mockConfluentClient) *confluentProducer {
+	statsClient, _ := stats.NewClient("memory://")
+
+	return &confluentProducer{
+		kafkaClient:   kafkaClient,
+		statsClient:   statsClient,
+		throttle:      &throttle{},
+		transactional: true,
+		newClient: func() (confluentClient, error) {
+			return newClient, nil
+		},
+	}
+}
+
+func TestConfluentProducer_PublishBatch_fenced(t *testing.T) {
+	fencedErr := kafka.NewError(kafka.ErrFenced, "producer fenced", true)
+	fencedClient := &mockConfluentClient{commitErr: fencedErr}
+	newClient := &mockConfluentClient{}
+	p := newTestTransactionalProducer(fencedClient, newClient)
+
+	// messages of failed commit are retried with the new client
+	msgs := []Message{{Body: []byte("first"), Topic: "orders"}, {Body: []byte("second"), Topic: "orders"}}
+	errs := p.PublishBatch(msgs)
+	require.Len(t, errs, len(msgs))
+	for _, err := range errs {
+		assert.Equal(t, transactionError{err: fencedErr}, err)
+		assert.True(t, IsRetriable(err))
+	}
+	assert.True(t, fencedClient.closed)
+	assert.False(t, fencedClient.aborted)
+	assert.Equal(t, newClient, p.kafkaClient)
+
+	assert.Equal(t, []error{nil, nil}, p.PublishBatch(msgs))
+	assert.Equal(t, int64(2), msgs[1].Offset)
+}
+
+func TestConfluentProducer_PublishBatch_commitFailed(t *testing.T) {
+	kafkaClient := &mockConfluentClient{commitErr: kafka.NewError(kafka.ErrInvalidProducerEpoch, "invalid epoch", false)}
+	p := newTestTransactionalProducer(kafkaClient, &mockConfluentClient{})
+
+	// transaction that is not committed is aborted and its messages are retried with the same client
+	errs := p.PublishBatch([]Message{{Body: []byte("first"), Topic: "orders"}})
+	assert.Equal(t, []error{transactionError{err: sarama.ErrInvalidProducerEpoch}}, errs)
+	assert.True(t, IsRetriable(errs[0]))
+	assert.True(t, kafkaClient.aborted)
+	assert.Equal(t, kafkaClient, p.kafkaClient)
+}
@@ -1,5 +1,5 @@
 /*
 Package producer holds interface for publishing messages to required destinations and
-implementation for sending messages to Kafka.
+implementations for sending messages to Kafka with sarama and confluent-kafka-go clients.
 */
 package producer
@@ -0,0 +1,53 @@
+package producer
+
+import (
+	"errors"
+
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+// ErrPublishDeadlineExceeded is an error for messages that were not published within retry deadline
+var ErrPublishDeadlineExceeded = errors.New("message publish deadline exceeded")
+
+// transactionError is an error of Kafka transaction begin, commit or abort, it fails every message of the batch
+// and is always retriable, as messages of failed transaction are not visible to read committed consumers even if
+// they were delivered, and fenced or failed producer is recreated before the next batch
+type transactionError struct {
+	err error
+}
+
+func (e transactionError) Error() string {
+	return "kafka transaction failed: " + e.err.Error()
+}
+
+// retriableErrors are Kafka errors caused by transient cluster state, they may go away on retry
+var retriableErrors = map[sarama.KError]bool{
+	sarama.ErrUnknownTopicOrPartition:      true,
+	sarama.ErrLeaderNotAvailable:           true,
+	sarama.ErrNotLeaderForPartition:        true,
+	sarama.ErrRequestTimedOut:              true,
+	sarama.ErrBrokerNotAvailable:           true,
+	sarama.ErrReplicaNotAvailable:          true,
+	sarama.ErrNetworkException:             true,
+	sarama.ErrNotEnoughReplicas:            true,
+	sarama.ErrNotEnoughReplicasAfterAppend: true,
+	sarama.ErrNotController:                true,
+	sarama.ErrKafkaStorageError:            true,
+}
+
+// IsRetriable returns true for publish errors that may go away on retry, e.g. leadership change or network
+// failure, and false for fatal errors that fail every retry, e.g. authorization failure or too large message
+func IsRetriable(err error) bool {
+	switch err := err.(type) {
+	case sarama.KError:
+		return retriableErrors[err]
+	case sarama.ConfigurationError:
+		return false
+	case transactionError:
+		return true
+	}
+
+	return err != ErrPublishDeadlineExceeded && err != config.ErrUnknownKafkaCluster && err != config.ErrUnknownSink &&
+		err != ErrInvalidManualPartition
+}
@@ -0,0 +1,27 @@
+package producer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetriable(t *testing.T) {
+	assert.True(t, IsRetriable(sarama.ErrNotLeaderForPartition))
+	assert.True(t, IsRetriable(sarama.ErrLeaderNotAvailable))
+	assert.True(t, IsRetriable(sarama.ErrOutOfBrokers))
+	assert.True(t, IsRetriable(errors.New("connection reset by peer")))
+	// transaction failures are retried whatever the cause is
+	assert.True(t, IsRetriable(transactionError{err: sarama.ErrInvalidProducerEpoch}))
+
+	assert.False(t, IsRetriable(sarama.ErrTopicAuthorizationFailed))
+	assert.False(t, IsRetriable(sarama.ErrMessageSizeTooLarge))
+	assert.False(t, IsRetriable(sarama.ConfigurationError("invalid config")))
+	assert.False(t, IsRetriable(ErrPublishDeadlineExceeded))
+	assert.False(t, IsRetriable(config.ErrUnknownKafkaCluster))
+	assert.False(t, IsRetriable(config.ErrUnknownSink))
+	assert.False(t, IsRetriable(ErrInvalidManualPartition))
+}
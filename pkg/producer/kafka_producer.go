@@ -1,58 +1,259 @@
 package producer
 
 import (
+	"errors"
+	"time"
+
 	"github.com/Shopify/sarama"
 	"github.com/hellofresh/kandalf/pkg/config"
-	"github.com/hellofresh/stats-go/bucket"
 	"github.com/hellofresh/stats-go/client"
-	log "github.com/sirupsen/logrus"
 )
 
 const (
 	statsKafkaSection = "kafka"
 )
 
+var errUnknownCompression = errors.New("unknown compression codec, supported codecs are none, gzip, snappy, lz4 and zstd")
+
+var errZstdVersion = errors.New("zstd compression requires Kafka version 2.1.0 or later")
+
+var errUnknownRequiredAcks = errors.New("unknown required acks, supported values are none, leader and all")
+
+var requiredAcks = map[string]sarama.RequiredAcks{
+	"":       sarama.WaitForAll,
+	"none":   sarama.NoResponse,
+	"leader": sarama.WaitForLocal,
+	"all":    sarama.WaitForAll,
+}
+
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	"":       sarama.CompressionNone,
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
 // KafkaProducer is a Producer implementation for publishing messages to Kafka
 type KafkaProducer struct {
 	kafkaClient sarama.SyncProducer
 	statsClient client.Client
+	// deadline is max amount of time since message creation it may be published within, 0 means no deadline
+	deadline time.Duration
+	// stopMetrics stops sarama client metrics reporting
+	stopMetrics chan struct{}
+	// manualPartitions is true when messages are published to partitions taken from their keys
+	manualPartitions bool
 }
 
 // NewKafkaProducer instantiates and establishes new Kafka connection
 func NewKafkaProducer(kafkaConfig config.KafkaConfig, statsClient client.Client) (Producer, error) {
+	cnf, err := NewSaramaConfig(kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	kafkaClient, err := sarama.NewSyncProducer(kafkaConfig.Brokers, cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	stopMetrics := make(chan struct{})
+	go reportSaramaMetrics(cnf.MetricRegistry, statsClient, stopMetrics)
+
+	return &KafkaProducer{
+		kafkaClient:      kafkaClient,
+		statsClient:      statsClient,
+		deadline:         kafkaConfig.Retry.Deadline,
+		stopMetrics:      stopMetrics,
+		manualPartitions: kafkaConfig.Partitioner == PartitionerManual,
+	}, nil
+}
+
+// NewSaramaConfig returns Kafka client configuration, it is shared by producers, admin client and reverse pipes
+// consumer groups, so all of them connect to brokers the same way
+func NewSaramaConfig(kafkaConfig config.KafkaConfig) (*sarama.Config, error) {
 	cnf := sarama.NewConfig()
-	cnf.Producer.RequiredAcks = sarama.WaitForAll
+	if kafkaConfig.Version != "" {
+		version, err := sarama.ParseKafkaVersion(kafkaConfig.Version)
+		if err != nil {
+			return nil, err
+		}
+		cnf.Version = version
+	}
+	acks, ok := requiredAcks[kafkaConfig.RequiredAcks]
+	if !ok {
+		return nil, errUnknownRequiredAcks
+	}
+	cnf.Producer.RequiredAcks = acks
 	cnf.Producer.Retry.Max = kafkaConfig.MaxRetry
+	if kafkaConfig.Retry.Max != nil {
+		cnf.Producer.Retry.Max = *kafkaConfig.Retry.Max
+	}
+	if kafkaConfig.Retry.Backoff > 0 {
+		cnf.Producer.Retry.Backoff = kafkaConfig.Retry.Backoff
+	}
+	cnf.Producer.Flush.Messages = kafkaConfig.FlushMessages
+	cnf.Producer.Flush.Bytes = kafkaConfig.FlushBytes
+	cnf.Producer.Flush.Frequency = kafkaConfig.FlushFrequency
+	if kafkaConfig.MaxInFlight > 0 {
+		cnf.Net.MaxOpenRequests = kafkaConfig.MaxInFlight
+	}
 	// Producer.Return.Successes must be true to be used in a SyncProducer
 	cnf.Producer.Return.Successes = true
 
-	kafkaClient, err := sarama.NewSyncProducer(kafkaConfig.Brokers, cnf)
-	if err != nil {
+	if kafkaConfig.Idempotent {
+		cnf.Producer.Idempotent = true
+		// idempotent producer guarantees ordering and deduplication only with single in-flight request per broker
+		cnf.Net.MaxOpenRequests = 1
+	}
+
+	partitioner, ok := partitioners[kafkaConfig.Partitioner]
+	if !ok {
+		return nil, errUnknownPartitioner
+	}
+	cnf.Producer.Partitioner = partitioner
+
+	codec, ok := compressionCodecs[kafkaConfig.Compression]
+	if !ok {
+		return nil, errUnknownCompression
+	}
+	if codec == sarama.CompressionZSTD && !cnf.Version.IsAtLeast(sarama.V2_1_0_0) {
+		return nil, errZstdVersion
+	}
+	cnf.Producer.Compression = codec
+	if kafkaConfig.CompressionLevel != 0 {
+		cnf.Producer.CompressionLevel = kafkaConfig.CompressionLevel
+	}
+
+	if kafkaConfig.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(kafkaConfig.TLS)
+		if err != nil {
+			return nil, err
+		}
+		cnf.Net.TLS.Enable = true
+		cnf.Net.TLS.Config = tlsConfig
+	}
+
+	if err := configureSASL(cnf, kafkaConfig.SASL); err != nil {
 		return nil, err
 	}
 
-	return &KafkaProducer{kafkaClient: kafkaClient, statsClient: statsClient}, nil
+	return cnf, cnf.Validate()
 }
 
 // Close closes Kafka connection
 func (p *KafkaProducer) Close() error {
+	if p.stopMetrics != nil {
+		close(p.stopMetrics)
+	}
+
 	return p.kafkaClient.Close()
 }
 
 // Publish publishes message to Kafka
 func (p *KafkaProducer) Publish(msg Message) error {
-	_, _, err := p.kafkaClient.SendMessage(&sarama.ProducerMessage{
-		Topic: msg.Topic,
-		Value: sarama.ByteEncoder(msg.Body),
-	})
-
-	if err == nil {
-		log.WithField("msg", msg.String()).Debug("Successfully sent message to kafka")
-	} else {
-		log.WithError(err).WithField("msg", msg.String()).Error("Failed to publish message to kafka")
+	publishTimer := p.statsClient.BuildTimer().Start()
+	err := ErrPublishDeadlineExceeded
+	if !deadlineExceeded(msg, p.deadline) {
+		var producerMessage *sarama.ProducerMessage
+		if producerMessage, err = p.newProducerMessage(msg); err == nil {
+			_, _, err = p.kafkaClient.SendMessage(producerMessage)
+		}
 	}
-	operation := bucket.MetricOperation{"publish", msg.Topic}
-	p.statsClient.TrackOperation(statsKafkaSection, operation, nil, err == nil)
+	trackPublish(p.statsClient, msg, publishTimer, err)
 
 	return err
 }
+
+// PublishBatch publishes messages to Kafka at once, so they are batched according to producer flush settings
+func (p *KafkaProducer) PublishBatch(msgs []Message) []error {
+	publishTimer := p.statsClient.BuildTimer().Start()
+	trackBatch(p.statsClient, msgs)
+
+	errs := make([]error, len(msgs))
+	producerMessages := make([]*sarama.ProducerMessage, 0, len(msgs))
+	for i := range msgs {
+		if deadlineExceeded(msgs[i], p.deadline) {
+			errs[i] = ErrPublishDeadlineExceeded
+			continue
+		}
+
+		producerMessage, err := p.newProducerMessage(msgs[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		producerMessage.Metadata = i
+		producerMessages = append(producerMessages, producerMessage)
+	}
+
+	if len(producerMessages) > 0 {
+		err := p.kafkaClient.SendMessages(producerMessages)
+		for _, producerMessage := range producerMessages {
+			msgs[producerMessage.Metadata.(int)].Partition = producerMessage.Partition
+			msgs[producerMessage.Metadata.(int)].Offset = producerMessage.Offset
+		}
+		if err != nil {
+			if producerErrors, ok := err.(sarama.ProducerErrors); ok {
+				for _, producerError := range producerErrors {
+					errs[producerError.Msg.Metadata.(int)] = producerError.Err
+				}
+			} else {
+				for _, producerMessage := range producerMessages {
+					errs[producerMessage.Metadata.(int)] = err
+				}
+			}
+		}
+	}
+
+	for i := range msgs {
+		trackPublish(p.statsClient, msgs[i], publishTimer, errs[i])
+	}
+
+	return errs
+}
+
+// deadlineExceeded checks if message can not be published anymore as it was created longer than deadline ago,
+// messages without creation time never expire
+func deadlineExceeded(msg Message, deadline time.Duration) bool {
+	return deadline > 0 && msg.CreatedAt > 0 && time.Since(time.Unix(0, msg.CreatedAt)) > deadline
+}
+
+func (p *KafkaProducer) newProducerMessage(msg Message) (*sarama.ProducerMessage, error) {
+	producerMessage := &sarama.ProducerMessage{
+		Topic:   msg.Topic,
+		Value:   sarama.ByteEncoder(msg.Body),
+		Headers: recordHeaders(msg.Headers),
+	}
+	if msg.Key != "" {
+		producerMessage.Key = sarama.StringEncoder(msg.Key)
+	}
+	if msg.Timestamp > 0 {
+		producerMessage.Timestamp = time.Unix(0, msg.Timestamp)
+	}
+
+	if p.manualPartitions {
+		partition, err := manualPartition(msg.Key)
+		if err != nil {
+			return nil, err
+		}
+		producerMessage.Partition = partition
+	}
+
+	return producerMessage, nil
+}
+
+func recordHeaders(headers map[string]string) []sarama.RecordHeader {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	result := make([]sarama.RecordHeader, 0, len(headers))
+	for k, v := range headers {
+		result = append(result, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+
+	return result
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
 	"github.com/hellofresh/stats-go"
 	"github.com/hellofresh/stats-go/bucket"
 	"github.com/hellofresh/stats-go/client"
@@ -34,6 +36,10 @@ func (p *mockSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition i
 
 func (p *mockSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
 	p.lastSendMessagesParams = msgs
+	for i, msg := range msgs {
+		msg.Partition = p.sendMessageResult.partition
+		msg.Offset = p.sendMessageResult.offset + int64(i)
+	}
 	return p.sendMessagesResult
 }
 
@@ -47,7 +53,7 @@ func TestKafkaProducer_Close(t *testing.T) {
 	mockProducer := &mockSyncProducer{closeResult: closeError}
 	statsClient, _ := stats.NewClient("memory://")
 
-	kafkaProducer := &KafkaProducer{mockProducer, statsClient}
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient}
 
 	err := kafkaProducer.Close()
 	assert.Error(t, err)
@@ -62,7 +68,7 @@ func TestKafkaProducer_Publish(t *testing.T) {
 	topic := "some topic"
 	msg := NewMessage([]byte(body), topic)
 
-	kafkaProducer := &KafkaProducer{mockProducer, statsClient}
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient}
 
 	err := kafkaProducer.Publish(*msg)
 	assert.NoError(t, err)
@@ -78,6 +84,54 @@ func TestKafkaProducer_Publish(t *testing.T) {
 	assert.Equal(t, topic, mockProducer.lastSendMessageParams.Topic)
 }
 
+func TestKafkaProducer_Publish_headers(t *testing.T) {
+	mockProducer := &mockSyncProducer{}
+	statsClient, _ := stats.NewClient("memory://")
+
+	msg := NewMessage([]byte("hello message body!"), "some topic")
+	msg.Headers = map[string]string{"x-kandalf-original-size": "42"}
+
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient}
+
+	err := kafkaProducer.Publish(*msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []sarama.RecordHeader{{Key: []byte("x-kandalf-original-size"), Value: []byte("42")}}, mockProducer.lastSendMessageParams.Headers)
+	assert.Nil(t, mockProducer.lastSendMessageParams.Key)
+}
+
+func TestKafkaProducer_Publish_key(t *testing.T) {
+	mockProducer := &mockSyncProducer{}
+	statsClient, _ := stats.NewClient("memory://")
+
+	msg := NewMessage([]byte("hello message body!"), "some topic")
+	msg.Key = "customer-1"
+
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient}
+
+	err := kafkaProducer.Publish(*msg)
+	assert.NoError(t, err)
+
+	key, err := mockProducer.lastSendMessageParams.Key.Encode()
+	assert.NoError(t, err)
+	assert.Equal(t, "customer-1", string(key))
+}
+
+func TestKafkaProducer_Publish_timestamp(t *testing.T) {
+	mockProducer := &mockSyncProducer{}
+	statsClient, _ := stats.NewClient("memory://")
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient}
+
+	msg := NewMessage([]byte("hello message body!"), "some topic")
+	assert.NoError(t, kafkaProducer.Publish(*msg))
+	// sarama sets publish time for messages without timestamp
+	assert.True(t, mockProducer.lastSendMessageParams.Timestamp.IsZero())
+
+	occurredAt := time.Date(2018, 7, 1, 12, 30, 0, 0, time.UTC)
+	msg.Timestamp = occurredAt.UnixNano()
+	assert.NoError(t, kafkaProducer.Publish(*msg))
+	assert.True(t, occurredAt.Equal(mockProducer.lastSendMessageParams.Timestamp))
+}
+
 func TestKafkaProducer_Publish_error(t *testing.T) {
 	sendMessageError := errors.New("send message error")
 	sendMessageResult := sendMessageResult{0, 0, sendMessageError}
@@ -89,7 +143,7 @@ func TestKafkaProducer_Publish_error(t *testing.T) {
 	topic := "some topic"
 	msg := NewMessage([]byte(body), topic)
 
-	kafkaProducer := &KafkaProducer{mockProducer, statsClient}
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient}
 
 	err := kafkaProducer.Publish(*msg)
 	assert.Error(t, err)
@@ -100,3 +154,193 @@ func TestKafkaProducer_Publish_error(t *testing.T) {
 	assert.Equal(t, 0, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.publish.%s.-", statsKafkaSection, bucket.SanitizeMetricName(topic, false))])
 	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-fail.publish.%s.-", statsKafkaSection, bucket.SanitizeMetricName(topic, false))])
 }
+
+func TestKafkaProducer_Publish_deadline(t *testing.T) {
+	mockProducer := &mockSyncProducer{}
+	statsClient, _ := stats.NewClient("memory://")
+
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient, deadline: time.Minute}
+
+	msg := NewMessage([]byte("hello message body!"), "some topic")
+	assert.NoError(t, kafkaProducer.Publish(*msg))
+	assert.NotNil(t, mockProducer.lastSendMessageParams)
+
+	mockProducer.lastSendMessageParams = nil
+	msg.CreatedAt = time.Now().Add(-2 * time.Minute).UnixNano()
+	assert.Equal(t, ErrPublishDeadlineExceeded, kafkaProducer.Publish(*msg))
+	assert.Nil(t, mockProducer.lastSendMessageParams)
+
+	// messages stored before creation time was introduced never expire
+	msg.CreatedAt = 0
+	assert.NoError(t, kafkaProducer.Publish(*msg))
+	assert.NotNil(t, mockProducer.lastSendMessageParams)
+}
+
+func TestKafkaProducer_Publish_manualPartition(t *testing.T) {
+	mockProducer := &mockSyncProducer{}
+	statsClient, _ := stats.NewClient("memory://")
+
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient, manualPartitions: true}
+
+	msg := NewMessage([]byte("hello message body!"), "some topic")
+	msg.Key = "3"
+	assert.NoError(t, kafkaProducer.Publish(*msg))
+	assert.Equal(t, int32(3), mockProducer.lastSendMessageParams.Partition)
+
+	msg.Key = "customer-1"
+	assert.Equal(t, ErrInvalidManualPartition, kafkaProducer.Publish(*msg))
+}
+
+func TestNewSaramaConfig(t *testing.T) {
+	cnf, err := NewSaramaConfig(config.KafkaConfig{MaxRetry: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.WaitForAll, cnf.Producer.RequiredAcks)
+	assert.Equal(t, 3, cnf.Producer.Retry.Max)
+	assert.True(t, cnf.Producer.Return.Successes)
+	assert.False(t, cnf.Net.TLS.Enable)
+	assert.Equal(t, 5, cnf.Net.MaxOpenRequests)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{FlushMessages: 100, FlushBytes: 65536, FlushFrequency: 50 * time.Millisecond, MaxInFlight: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, cnf.Producer.Flush.Messages)
+	assert.Equal(t, 65536, cnf.Producer.Flush.Bytes)
+	assert.Equal(t, 50*time.Millisecond, cnf.Producer.Flush.Frequency)
+	assert.Equal(t, 10, cnf.Net.MaxOpenRequests)
+	assert.Equal(t, sarama.CompressionNone, cnf.Producer.Compression)
+	assert.Equal(t, sarama.CompressionLevelDefault, cnf.Producer.CompressionLevel)
+	assert.False(t, cnf.Producer.Idempotent)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{RequiredAcks: "leader"})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.WaitForLocal, cnf.Producer.RequiredAcks)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{RequiredAcks: "none"})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.NoResponse, cnf.Producer.RequiredAcks)
+
+	_, err = NewSaramaConfig(config.KafkaConfig{RequiredAcks: "quorum"})
+	assert.Equal(t, errUnknownRequiredAcks, err)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{Version: "1.0.0", RequiredAcks: "all", MaxRetry: 5, Idempotent: true})
+	assert.NoError(t, err)
+	assert.True(t, cnf.Producer.Idempotent)
+	assert.Equal(t, 1, cnf.Net.MaxOpenRequests)
+
+	// idempotent producer requires all replicas acks and Kafka 0.11 or later
+	_, err = NewSaramaConfig(config.KafkaConfig{Version: "1.0.0", RequiredAcks: "leader", MaxRetry: 5, Idempotent: true})
+	assert.Error(t, err)
+	_, err = NewSaramaConfig(config.KafkaConfig{RequiredAcks: "all", MaxRetry: 5, Idempotent: true})
+	assert.Error(t, err)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{Compression: "gzip", CompressionLevel: 9})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.CompressionGZIP, cnf.Producer.Compression)
+	assert.Equal(t, 9, cnf.Producer.CompressionLevel)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{Version: "2.1.0", Compression: "zstd"})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.CompressionZSTD, cnf.Producer.Compression)
+
+	// zstd is supported since Kafka 2.1.0 only
+	_, err = NewSaramaConfig(config.KafkaConfig{Compression: "zstd"})
+	assert.Equal(t, errZstdVersion, err)
+
+	_, err = NewSaramaConfig(config.KafkaConfig{Compression: "brotli"})
+	assert.Equal(t, errUnknownCompression, err)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{Version: "1.0.0", TLS: config.KafkaTLSConfig{Enabled: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V1_0_0_0, cnf.Version)
+	assert.True(t, cnf.Net.TLS.Enable)
+	assert.NotNil(t, cnf.Net.TLS.Config)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{SASL: config.KafkaSASLConfig{Mechanism: "scram-sha-256", Username: "user", Password: "secret"}})
+	assert.NoError(t, err)
+	assert.True(t, cnf.Net.SASL.Enable)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{MaxRetry: 5, Retry: config.KafkaRetryConfig{Backoff: time.Second}})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, cnf.Producer.Retry.Max)
+	assert.Equal(t, time.Second, cnf.Producer.Retry.Backoff)
+
+	retryMax := 0
+	cnf, err = NewSaramaConfig(config.KafkaConfig{MaxRetry: 5, Retry: config.KafkaRetryConfig{Max: &retryMax}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cnf.Producer.Retry.Max)
+	assert.Equal(t, 100*time.Millisecond, cnf.Producer.Retry.Backoff)
+
+	_, err = NewSaramaConfig(config.KafkaConfig{Partitioner: "sticky"})
+	assert.Equal(t, errUnknownPartitioner, err)
+
+	cnf, err = NewSaramaConfig(config.KafkaConfig{Partitioner: PartitionerMurmur2})
+	assert.NoError(t, err)
+	assert.IsType(t, &murmur2Partitioner{}, cnf.Producer.Partitioner("topic"))
+
+	_, err = NewSaramaConfig(config.KafkaConfig{Version: "not-a-version"})
+	assert.Error(t, err)
+
+	_, err = NewSaramaConfig(config.KafkaConfig{SASL: config.KafkaSASLConfig{Mechanism: "gssapi"}})
+	assert.Equal(t, errUnknownSASLMechanism, err)
+}
+
+func TestKafkaProducer_PublishBatch(t *testing.T) {
+	mockProducer := &mockSyncProducer{sendMessageResult: sendMessageResult{partition: 3, offset: 100}}
+	statsClient, _ := stats.NewClient("memory://")
+
+	msgs := []Message{*NewMessage([]byte("body 1"), "topic"), *NewMessage([]byte("body 2"), "topic")}
+
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient}
+
+	errs := kafkaProducer.PublishBatch(msgs)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Len(t, mockProducer.lastSendMessagesParams, 2)
+	assert.Equal(t, int32(3), msgs[1].Partition)
+	assert.Equal(t, int64(101), msgs[1].Offset)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 2, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.publish.topic.-", statsKafkaSection)])
+}
+
+func TestKafkaProducer_PublishBatch_error(t *testing.T) {
+	sendError := errors.New("send message error")
+	mockProducer := &mockSyncProducer{}
+	statsClient, _ := stats.NewClient("memory://")
+
+	msgs := []Message{*NewMessage([]byte("body 1"), "topic"), *NewMessage([]byte("body 2"), "topic")}
+
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient}
+
+	// only the second message failed
+	mockProducer.sendMessagesResult = sarama.ProducerErrors{&sarama.ProducerError{Msg: &sarama.ProducerMessage{Metadata: 1}, Err: sendError}}
+	errs := kafkaProducer.PublishBatch(msgs)
+	assert.Equal(t, []error{nil, sendError}, errs)
+
+	// the whole batch failed
+	mockProducer.sendMessagesResult = sendError
+	errs = kafkaProducer.PublishBatch(msgs)
+	assert.Equal(t, []error{sendError, sendError}, errs)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.publish.topic.-", statsKafkaSection)])
+	assert.Equal(t, 3, memoryStats.CountMetrics[fmt.Sprintf("%s-fail.publish.topic.-", statsKafkaSection)])
+}
+
+func TestKafkaProducer_PublishBatch_deadline(t *testing.T) {
+	mockProducer := &mockSyncProducer{}
+	statsClient, _ := stats.NewClient("memory://")
+
+	msgs := []Message{*NewMessage([]byte("body 1"), "topic"), *NewMessage([]byte("body 2"), "topic")}
+	msgs[0].CreatedAt = time.Now().Add(-2 * time.Minute).UnixNano()
+
+	kafkaProducer := &KafkaProducer{kafkaClient: mockProducer, statsClient: statsClient, deadline: time.Minute}
+
+	errs := kafkaProducer.PublishBatch(msgs)
+	assert.Equal(t, []error{ErrPublishDeadlineExceeded, nil}, errs)
+	assert.Len(t, mockProducer.lastSendMessagesParams, 1)
+
+	// errors are mapped to the original messages positions
+	sendError := errors.New("send message error")
+	mockProducer.sendMessagesResult = sendError
+	errs = kafkaProducer.PublishBatch(msgs)
+	assert.Equal(t, []error{ErrPublishDeadlineExceeded, sendError}, errs)
+}
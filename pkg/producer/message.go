@@ -2,6 +2,7 @@ package producer
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/gofrs/uuid"
 )
@@ -11,11 +12,44 @@ type Message struct {
 	ID    uuid.UUID `json:"id"`
 	Body  []byte    `json:"body"`
 	Topic string    `json:"topic"`
+	// Key is Kafka message key, messages with the same key are published to the same partition
+	Key string `json:"key,omitempty"`
+	// Cluster is name of Kafka cluster message is published to, empty for the main cluster
+	Cluster string `json:"cluster,omitempty"`
+	// Sink is messaging system message is published to, empty for Kafka
+	Sink string `json:"sink,omitempty"`
+	// ErrorTopic is topic message is written to if it can never be published to its topic, empty to drop it
+	ErrorTopic string `json:"errorTopic,omitempty"`
+	// Delivery is delivery class of the pipe message is consumed by, empty for the default one
+	Delivery string `json:"delivery,omitempty"`
+	// Headers are Kafka record headers, they require Kafka version 0.11.0.0 or later
+	Headers map[string]string `json:"headers,omitempty"`
+	// Attempts is number of failed publish attempts message was moved to storage after
+	Attempts int `json:"attempts,omitempty"`
+	// Timestamp is Kafka record timestamp as Unix time in nanoseconds, 0 means publish time
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// OrderKey is topic scoped key of messages that are published in consume order, empty for unordered messages
+	OrderKey string `json:"orderKey,omitempty"`
+	// DedupKey is pipe scoped message dedup key, empty for messages that are not deduplicated
+	DedupKey string `json:"dedupKey,omitempty"`
+	// Replicated is true for messages of pipes with replication enabled
+	Replicated bool `json:"replicated,omitempty"`
+	// CreatedAt is message creation time as Unix time in nanoseconds, publish deadline is counted from it
+	CreatedAt int64 `json:"createdAt,omitempty"`
+	// Pipe is key of the pipe message is consumed by, pipe throughput is accounted by it
+	Pipe string `json:"pipe,omitempty"`
+	// SentAt is AMQP message timestamp as Unix time in nanoseconds, 0 if publisher has not set it
+	SentAt int64 `json:"sentAt,omitempty"`
+	// Sampled is true for messages logged at every stage for debugging
+	Sampled bool `json:"sampled,omitempty"`
+	// Partition and Offset are set by producer once message is published
+	Partition int32 `json:"-"`
+	Offset    int64 `json:"-"`
 }
 
 // NewMessage initializes and instantiates new Message
 func NewMessage(body []byte, topic string) *Message {
-	return &Message{uuid.Must(uuid.NewV4()), body, topic}
+	return &Message{ID: uuid.Must(uuid.NewV4()), Body: body, Topic: topic, CreatedAt: time.Now().UnixNano()}
 }
 
 // String represents message as simple string value
@@ -0,0 +1,122 @@
+package producer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/hellofresh/stats-go/timer"
+	"github.com/rcrowley/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// saramaMetricsInterval is interval of reporting sarama client metrics
+const saramaMetricsInterval = 10 * time.Second
+
+// saramaTopicMetrics are per-topic sarama client histograms prefixes mapped to reported metric operations
+var saramaTopicMetrics = map[string]string{
+	"batch-size-for-topic-":          "batch-bytes",
+	"compression-ratio-for-topic-":   "compression-ratio",
+	"records-per-request-for-topic-": "records-per-request",
+}
+
+// trackPublish tracks message publish result, latency, size, errors by type and retries of the messages
+// that were moved to storage before
+func trackPublish(statsClient client.Client, msg Message, publishTimer timer.Timer, err error) {
+	var errorOperation bucket.MetricOperation
+	if err != nil {
+		errorOperation = bucket.MetricOperation{"error", errorType(err), msg.Topic}
+	}
+	trackSectionPublish(statsClient, statsKafkaSection, msg, publishTimer, err, errorOperation)
+}
+
+// TrackPublish tracks message publish result, latency, size, errors and retries of the messages that were moved
+// to storage before in the given stats section, so sink producers report the same metrics as Kafka producer does
+func TrackPublish(statsClient client.Client, section string, msg Message, publishTimer timer.Timer, err error) {
+	trackSectionPublish(statsClient, section, msg, publishTimer, err, bucket.MetricOperation{"error", msg.Topic})
+}
+
+// trackSectionPublish tracks message publish result in the stats section, section is used as sink name in logs
+func trackSectionPublish(statsClient client.Client, section string, msg Message, publishTimer timer.Timer, err error, errorOperation bucket.MetricOperation) {
+	if err == nil {
+		log.WithField("msg", msg.String()).Debug("Successfully sent message to " + section)
+	} else {
+		log.WithError(err).WithField("msg", msg.String()).Error("Failed to publish message to " + section)
+	}
+	statsClient.TrackOperation(section, bucket.MetricOperation{"publish", msg.Topic}, publishTimer, err == nil)
+
+	if msg.Attempts > 0 {
+		statsClient.TrackMetric(section, bucket.MetricOperation{"retry", msg.Topic})
+	}
+	if err != nil {
+		statsClient.TrackMetric(section, errorOperation)
+		return
+	}
+	statsClient.TrackMetricN(section, bucket.MetricOperation{"bytes", msg.Topic}, len(msg.Body))
+}
+
+// trackBatch tracks number of messages per topic in published batch
+func trackBatch(statsClient client.Client, msgs []Message) {
+	sizes := make(map[string]int)
+	for i := range msgs {
+		sizes[msgs[i].Topic]++
+	}
+
+	for topic, size := range sizes {
+		statsClient.TrackState(statsKafkaSection, bucket.MetricOperation{"batch-size", topic}, size)
+	}
+}
+
+// errorType returns short publish error type for metrics, Kafka protocol errors are reported by their codes
+func errorType(err error) string {
+	switch err {
+	case ErrPublishDeadlineExceeded:
+		return "deadline"
+	case config.ErrUnknownKafkaCluster:
+		return "unknown-cluster"
+	case config.ErrUnknownSink:
+		return "unknown-sink"
+	case ErrInvalidManualPartition:
+		return "manual-partition"
+	}
+
+	switch err := err.(type) {
+	case sarama.KError:
+		return fmt.Sprintf("kafka-%d", int16(err))
+	case sarama.ConfigurationError:
+		return "configuration"
+	}
+
+	return "client"
+}
+
+// reportSaramaMetrics periodically reports per-topic sarama client histograms means until stop is closed
+func reportSaramaMetrics(registry metrics.Registry, statsClient client.Client, stop <-chan struct{}) {
+	ticker := time.NewTicker(saramaMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			registry.Each(func(name string, metric interface{}) {
+				histogram, ok := metric.(metrics.Histogram)
+				if !ok {
+					return
+				}
+
+				for prefix, operation := range saramaTopicMetrics {
+					if strings.HasPrefix(name, prefix) {
+						value := int(histogram.Snapshot().Mean())
+						statsClient.TrackState(statsKafkaSection, bucket.MetricOperation{operation, strings.TrimPrefix(name, prefix)}, value)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package producer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackPublish(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+
+	msg := Message{Topic: "topic", Body: []byte("hello")}
+	trackPublish(statsClient, msg, statsClient.BuildTimer().Start(), nil)
+
+	msg.Attempts = 1
+	trackPublish(statsClient, msg, statsClient.BuildTimer().Start(), sarama.ErrNotLeaderForPartition)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 5, memoryStats.CountMetrics[fmt.Sprintf("%s.bytes.topic.-", statsKafkaSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.retry.topic.-", statsKafkaSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.kafka-6.topic", statsKafkaSection)])
+}
+
+func TestTrackPublish_section(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+
+	msg := Message{Topic: "orders", Body: []byte("hello")}
+	TrackPublish(statsClient, "pulsar", msg, statsClient.BuildTimer().Start(), nil)
+
+	msg.Attempts = 1
+	TrackPublish(statsClient, "pulsar", msg, statsClient.BuildTimer().Start(), errors.New("topic not found"))
+
+	// sink errors are not typed, as Kafka error types are meaningless for them
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics["pulsar-ok.publish.orders.-"])
+	assert.Equal(t, 1, memoryStats.CountMetrics["pulsar-fail.publish.orders.-"])
+	assert.Equal(t, 5, memoryStats.CountMetrics["pulsar.bytes.orders.-"])
+	assert.Equal(t, 1, memoryStats.CountMetrics["pulsar.retry.orders.-"])
+	assert.Equal(t, 1, memoryStats.CountMetrics["pulsar.error.orders.-"])
+	assert.Empty(t, memoryStats.CountMetrics[fmt.Sprintf("%s.bytes.orders.-", statsKafkaSection)])
+}
+
+func TestErrorType(t *testing.T) {
+	assert.Equal(t, "deadline", errorType(ErrPublishDeadlineExceeded))
+	assert.Equal(t, "unknown-cluster", errorType(config.ErrUnknownKafkaCluster))
+	assert.Equal(t, "unknown-sink", errorType(config.ErrUnknownSink))
+	assert.Equal(t, "kafka-29", errorType(sarama.ErrTopicAuthorizationFailed))
+	assert.Equal(t, "configuration", errorType(sarama.ConfigurationError("invalid config")))
+	assert.Equal(t, "client", errorType(errors.New("connection reset by peer")))
+}
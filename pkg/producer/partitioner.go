@@ -0,0 +1,115 @@
+package producer
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	// PartitionerHash hashes message key with FNV-1a, messages without key are distributed randomly.
+	// It is the default partitioner.
+	PartitionerHash = "hash"
+	// PartitionerMurmur2 hashes message key with murmur2 the same way Java client default partitioner does,
+	// so keys land on the same partitions as with Java producers, messages without key are distributed
+	// in round-robin manner
+	PartitionerMurmur2 = "murmur2"
+	// PartitionerRoundRobin distributes messages in round-robin manner regardless of their key
+	PartitionerRoundRobin = "round-robin"
+	// PartitionerManual publishes messages to partition number taken from message key
+	PartitionerManual = "manual"
+)
+
+var errUnknownPartitioner = errors.New("unknown partitioner, supported partitioners are hash, murmur2, round-robin and manual")
+
+// ErrInvalidManualPartition is an error for messages published with manual partitioner, but without valid
+// partition number key
+var ErrInvalidManualPartition = errors.New("manual partitioner requires message key to be partition number")
+
+var partitioners = map[string]sarama.PartitionerConstructor{
+	"":                    sarama.NewHashPartitioner,
+	PartitionerHash:       sarama.NewHashPartitioner,
+	PartitionerMurmur2:    newMurmur2Partitioner,
+	PartitionerRoundRobin: sarama.NewRoundRobinPartitioner,
+	PartitionerManual:     sarama.NewManualPartitioner,
+}
+
+// murmur2Partitioner is sarama.Partitioner compatible with Java client default partitioner
+type murmur2Partitioner struct {
+	keyless sarama.Partitioner
+}
+
+func newMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &murmur2Partitioner{keyless: sarama.NewRoundRobinPartitioner(topic)}
+}
+
+// Partition chooses partition for the message the same way Java client does - positive murmur2 key hash
+// modulo partitions number
+func (p *murmur2Partitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key == nil {
+		return p.keyless.Partition(message, numPartitions)
+	}
+
+	key, err := message.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+
+	return int32(murmur2(key)&0x7fffffff) % numPartitions, nil
+}
+
+// RequiresConsistency is true as messages with the same key must always land on the same partition
+func (p *murmur2Partitioner) RequiresConsistency() bool {
+	return true
+}
+
+// murmur2 is 32-bit murmur2 hash implementation of Kafka Java client
+func murmur2(data []byte) uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return h
+}
+
+// manualPartition returns partition number from message key for manual partitioner
+func manualPartition(key string) (int32, error) {
+	partition, err := strconv.ParseInt(key, 10, 32)
+	if err != nil || partition < 0 {
+		return -1, ErrInvalidManualPartition
+	}
+
+	return int32(partition), nil
+}
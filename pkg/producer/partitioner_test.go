@@ -0,0 +1,51 @@
+package producer
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMurmur2(t *testing.T) {
+	// values are taken from Kafka Java client UtilsTest
+	for key, expected := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		assert.Equal(t, expected, int32(murmur2([]byte(key))), key)
+	}
+}
+
+func TestMurmur2Partitioner(t *testing.T) {
+	partitioner := newMurmur2Partitioner("topic")
+	assert.True(t, partitioner.RequiresConsistency())
+
+	partition, err := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder("foobar")}, 12)
+	require.NoError(t, err)
+	// (-790332482 & 0x7fffffff) % 12
+	assert.Equal(t, int32(1357151166%12), partition)
+
+	first, err := partitioner.Partition(&sarama.ProducerMessage{}, 12)
+	require.NoError(t, err)
+	second, err := partitioner.Partition(&sarama.ProducerMessage{}, 12)
+	require.NoError(t, err)
+	assert.Equal(t, (first+1)%12, second)
+}
+
+func TestManualPartition(t *testing.T) {
+	partition, err := manualPartition("7")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(7), partition)
+
+	_, err = manualPartition("")
+	assert.Equal(t, ErrInvalidManualPartition, err)
+
+	_, err = manualPartition("-1")
+	assert.Equal(t, ErrInvalidManualPartition, err)
+}
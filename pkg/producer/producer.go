@@ -3,5 +3,8 @@ package producer
 // Producer is an interface for publishing messages service
 type Producer interface {
 	Publish(msg Message) error
+	// PublishBatch publishes messages at once and returns publishing error for every message,
+	// errors are nil for successfully published messages, which get their partition and offset set
+	PublishBatch(msgs []Message) []error
 	Close() error
 }
@@ -0,0 +1,201 @@
+package producer
+
+import (
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// route is a Kafka cluster and delivery class pair messages are published with, transactional route belongs
+// to single pipe, as every transactional pipe has its own producer with its own transactional id
+type route struct {
+	cluster  string
+	delivery string
+	pipe     string
+}
+
+// sinkPrefix prefixes names of producers publishing to messaging systems other than Kafka
+const sinkPrefix = "sink:"
+
+// Router is a Producer implementation that publishes messages to Kafka clusters they belong to,
+// or to other sinks, e.g. NATS JetStream, messages with sink set are published to
+type Router struct {
+	producers map[string]Producer
+}
+
+// NewRouter instantiates new producers router, producers are mapped by cluster name, empty name is the main cluster.
+// Producers for messages of specific delivery class are mapped by "<cluster>/<delivery>" name, messages
+// fall back to cluster producer if there is no producer for their delivery class. Producers of transactional
+// pipes are mapped by "<cluster>/transactional/<pipe key>" name.
+func NewRouter(producers map[string]Producer) *Router {
+	return &Router{producers: producers}
+}
+
+// NewKafkaRouter instantiates and establishes Kafka connections for the main and all named clusters,
+// and for every cluster and delivery class combination pipes use. Every transactional pipe gets its own
+// transactional producer, that requires confluent client.
+func NewKafkaRouter(kafkaConfig config.KafkaConfig, pipes []config.Pipe, statsClient client.Client) (*Router, error) {
+	producers := make(map[string]Producer, len(kafkaConfig.Clusters)+1)
+	router := NewRouter(producers)
+
+	routes := make(map[string]route)
+	for _, name := range append([]string{""}, clusterNames(kafkaConfig)...) {
+		routes[name] = route{cluster: name}
+	}
+	for _, pipe := range pipes {
+		if pipe.Sink != "" && pipe.Sink != config.SinkKafka {
+			continue
+		}
+		if pipe.KafkaDelivery == config.DeliveryTransactional {
+			// sarama has no transactional producer
+			if kafkaConfig.Client != ClientConfluent {
+				return nil, config.ErrTransactionalDelivery
+			}
+			routes[transactionalRouteName(pipe.KafkaCluster, pipe.Key())] = route{cluster: pipe.KafkaCluster, delivery: pipe.KafkaDelivery, pipe: pipe.Key()}
+			continue
+		}
+		routes[routeName(pipe.KafkaCluster, pipe.KafkaDelivery)] = route{cluster: pipe.KafkaCluster, delivery: pipe.KafkaDelivery}
+	}
+
+	for name, target := range routes {
+		clusterConfig, err := kafkaConfig.ForCluster(target.cluster)
+		if err != nil {
+			router.Close()
+			return nil, err
+		}
+
+		producerConfig := clusterConfig.ForDelivery(target.delivery)
+		if target.pipe != "" {
+			producerConfig = producerConfig.ForTransactionalPipe(target.pipe)
+		}
+
+		producer, err := NewClientProducer(producerConfig, statsClient)
+		if err != nil {
+			router.Close()
+			return nil, err
+		}
+		producers[name] = producer
+	}
+
+	return router, nil
+}
+
+// Publish publishes message to its Kafka cluster
+func (r *Router) Publish(msg Message) error {
+	name, err := r.route(msg)
+	if err != nil {
+		return err
+	}
+
+	return r.producers[name].Publish(msg)
+}
+
+// PublishBatch publishes messages to their Kafka clusters, messages of every producer are published at once
+func (r *Router) PublishBatch(msgs []Message) []error {
+	errs := make([]error, len(msgs))
+
+	indexes := make(map[string][]int)
+	for i, msg := range msgs {
+		name, err := r.route(msg)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		indexes[name] = append(indexes[name], i)
+	}
+
+	for name, routeIndexes := range indexes {
+		batch := make([]Message, len(routeIndexes))
+		for j, i := range routeIndexes {
+			batch[j] = msgs[i]
+		}
+		for j, err := range r.producers[name].PublishBatch(batch) {
+			errs[routeIndexes[j]] = err
+			msgs[routeIndexes[j]].Partition = batch[j].Partition
+			msgs[routeIndexes[j]].Offset = batch[j].Offset
+		}
+	}
+
+	return errs
+}
+
+// Close closes all Kafka connections
+func (r *Router) Close() error {
+	var result error
+	for cluster, producer := range r.producers {
+		if err := producer.Close(); err != nil {
+			log.WithError(err).WithField("cluster", cluster).Error("Failed to close Kafka producer")
+			result = err
+		}
+	}
+
+	return result
+}
+
+// AddSink adds producer messages of the sink are published with, it must be called before router is used
+func (r *Router) AddSink(sink string, producer Producer) {
+	r.producers[sinkPrefix+sink] = producer
+}
+
+// HasSink checks if there is a producer for messages of the sink
+func (r *Router) HasSink(sink string) bool {
+	_, ok := r.producers[sinkPrefix+sink]
+	return ok
+}
+
+// HasRoute checks if there is a producer for messages of the cluster and delivery class, messages of delivery
+// classes without their own producer fall back to cluster producer with its durability settings
+func (r *Router) HasRoute(cluster string, delivery string) bool {
+	_, ok := r.producers[routeName(cluster, delivery)]
+	return ok
+}
+
+// HasPipeRoute checks if there is a transactional producer for messages of the pipe
+func (r *Router) HasPipeRoute(pipe config.Pipe) bool {
+	_, ok := r.producers[transactionalRouteName(pipe.KafkaCluster, pipe.Key())]
+	return ok
+}
+
+// route returns name of the producer message is published with
+func (r *Router) route(msg Message) (string, error) {
+	if msg.Sink != "" && msg.Sink != config.SinkKafka {
+		if _, ok := r.producers[sinkPrefix+msg.Sink]; !ok {
+			return "", config.ErrUnknownSink
+		}
+		return sinkPrefix + msg.Sink, nil
+	}
+
+	name := routeName(msg.Cluster, msg.Delivery)
+	if msg.Delivery == config.DeliveryTransactional && msg.Pipe != "" {
+		name = transactionalRouteName(msg.Cluster, msg.Pipe)
+	}
+	if _, ok := r.producers[name]; ok {
+		return name, nil
+	}
+
+	if _, ok := r.producers[msg.Cluster]; !ok {
+		return "", config.ErrUnknownKafkaCluster
+	}
+	return msg.Cluster, nil
+}
+
+func routeName(cluster, delivery string) string {
+	if delivery == "" {
+		return cluster
+	}
+
+	return cluster + "/" + delivery
+}
+
+func transactionalRouteName(cluster, pipe string) string {
+	return routeName(cluster, config.DeliveryTransactional) + "/" + pipe
+}
+
+func clusterNames(kafkaConfig config.KafkaConfig) []string {
+	names := make([]string, 0, len(kafkaConfig.Clusters))
+	for name := range kafkaConfig.Clusters {
+		names = append(names, name)
+	}
+
+	return names
+}
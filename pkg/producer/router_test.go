@@ -0,0 +1,151 @@
+package producer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockClusterProducer struct {
+	published   []Message
+	publishErr  error
+	closeResult error
+}
+
+func (p *mockClusterProducer) Publish(msg Message) error {
+	p.published = append(p.published, msg)
+	return p.publishErr
+}
+
+func (p *mockClusterProducer) PublishBatch(msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = p.Publish(msg)
+		if errs[i] == nil {
+			msgs[i].Offset = int64(len(p.published))
+		}
+	}
+	return errs
+}
+
+func (p *mockClusterProducer) Close() error {
+	return p.closeResult
+}
+
+func TestRouter_Publish(t *testing.T) {
+	mainProducer := &mockClusterProducer{}
+	dc2Producer := &mockClusterProducer{}
+	router := NewRouter(map[string]Producer{"": mainProducer, "dc2": dc2Producer})
+
+	assert.NoError(t, router.Publish(Message{Topic: "main"}))
+	assert.NoError(t, router.Publish(Message{Topic: "dc2", Cluster: "dc2"}))
+	assert.Equal(t, config.ErrUnknownKafkaCluster, router.Publish(Message{Topic: "dc3", Cluster: "dc3"}))
+
+	assert.Equal(t, []Message{{Topic: "main"}}, mainProducer.published)
+	assert.Equal(t, []Message{{Topic: "dc2", Cluster: "dc2"}}, dc2Producer.published)
+}
+
+func TestRouter_PublishBatch(t *testing.T) {
+	publishErr := errors.New("publish error")
+	mainProducer := &mockClusterProducer{}
+	dc2Producer := &mockClusterProducer{publishErr: publishErr}
+	router := NewRouter(map[string]Producer{"": mainProducer, "dc2": dc2Producer})
+
+	msgs := []Message{
+		{Topic: "main-1"},
+		{Topic: "dc2-1", Cluster: "dc2"},
+		{Topic: "dc3-1", Cluster: "dc3"},
+		{Topic: "main-2"},
+	}
+	errs := router.PublishBatch(msgs)
+	assert.Equal(t, []error{nil, publishErr, config.ErrUnknownKafkaCluster, nil}, errs)
+	// published messages offsets are passed back from cluster producers
+	assert.Equal(t, int64(2), msgs[3].Offset)
+
+	// messages order is kept within cluster
+	assert.Equal(t, []Message{{Topic: "main-1"}, {Topic: "main-2"}}, mainProducer.published)
+	assert.Equal(t, []Message{{Topic: "dc2-1", Cluster: "dc2"}}, dc2Producer.published)
+}
+
+func TestRouter_delivery(t *testing.T) {
+	mainProducer := &mockClusterProducer{}
+	fireAndForgetProducer := &mockClusterProducer{}
+	router := NewRouter(map[string]Producer{"": mainProducer, "/fire-and-forget": fireAndForgetProducer})
+
+	errs := router.PublishBatch([]Message{
+		{Topic: "metrics", Delivery: config.DeliveryFireAndForget},
+		{Topic: "billing", Delivery: config.DeliveryAtLeastOnce},
+		{Topic: "dc2", Cluster: "dc2", Delivery: config.DeliveryFireAndForget},
+	})
+	assert.Equal(t, []error{nil, nil, config.ErrUnknownKafkaCluster}, errs)
+
+	assert.Equal(t, []Message{{Topic: "metrics", Delivery: config.DeliveryFireAndForget}}, fireAndForgetProducer.published)
+	// messages fall back to cluster producer when there is no producer for their delivery class
+	assert.Equal(t, []Message{{Topic: "billing", Delivery: config.DeliveryAtLeastOnce}}, mainProducer.published)
+
+	assert.True(t, router.HasRoute("", ""))
+	assert.True(t, router.HasRoute("", config.DeliveryFireAndForget))
+	assert.False(t, router.HasRoute("", config.DeliveryAtLeastOnce))
+	assert.False(t, router.HasRoute("dc2", ""))
+}
+
+func TestRouter_transactional(t *testing.T) {
+	mainProducer := &mockClusterProducer{}
+	ordersProducer := &mockClusterProducer{}
+	router := NewRouter(map[string]Producer{"": mainProducer, "/transactional//orders": ordersProducer})
+
+	errs := router.PublishBatch([]Message{
+		{Topic: "orders", Delivery: config.DeliveryTransactional, Pipe: "/orders"},
+		// error topic messages carry no pipe, so they fall back to cluster producer
+		{Topic: "orders-errors", Delivery: config.DeliveryTransactional},
+	})
+	assert.Equal(t, []error{nil, nil}, errs)
+
+	assert.Equal(t, []Message{{Topic: "orders", Delivery: config.DeliveryTransactional, Pipe: "/orders"}}, ordersProducer.published)
+	assert.Equal(t, []Message{{Topic: "orders-errors", Delivery: config.DeliveryTransactional}}, mainProducer.published)
+
+	assert.True(t, router.HasPipeRoute(config.Pipe{RabbitQueueName: "orders", KafkaDelivery: config.DeliveryTransactional}))
+	assert.False(t, router.HasPipeRoute(config.Pipe{RabbitQueueName: "payments", KafkaDelivery: config.DeliveryTransactional}))
+}
+
+func TestNewKafkaRouter_transactional(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	pipes := []config.Pipe{{RabbitQueueName: "orders", KafkaDelivery: config.DeliveryTransactional}}
+
+	// sarama has no transactional producer
+	_, err := NewKafkaRouter(config.KafkaConfig{Brokers: []string{"127.0.0.1:1"}}, pipes, statsClient)
+	assert.Equal(t, config.ErrTransactionalDelivery, err)
+}
+
+func TestRouter_sink(t *testing.T) {
+	mainProducer := &mockClusterProducer{}
+	natsProducer := &mockClusterProducer{}
+	router := NewRouter(map[string]Producer{"": mainProducer})
+	assert.False(t, router.HasSink(config.SinkNATS))
+
+	assert.Equal(t, config.ErrUnknownSink, router.Publish(Message{Topic: "orders.created", Sink: config.SinkNATS}))
+
+	router.AddSink(config.SinkNATS, natsProducer)
+	assert.True(t, router.HasSink(config.SinkNATS))
+
+	errs := router.PublishBatch([]Message{
+		{Topic: "orders", Sink: config.SinkKafka},
+		{Topic: "orders.created", Sink: config.SinkNATS, Delivery: config.DeliveryAtLeastOnce},
+		{Topic: "payments"},
+	})
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+
+	assert.Equal(t, []Message{{Topic: "orders", Sink: config.SinkKafka}, {Topic: "payments"}}, mainProducer.published)
+	// sink messages are published by sink producer regardless of their delivery class
+	assert.Equal(t, []Message{{Topic: "orders.created", Sink: config.SinkNATS, Delivery: config.DeliveryAtLeastOnce}}, natsProducer.published)
+}
+
+func TestRouter_Close(t *testing.T) {
+	closeErr := errors.New("close error")
+	router := NewRouter(map[string]Producer{"": &mockClusterProducer{}, "dc2": &mockClusterProducer{closeResult: closeErr}})
+
+	assert.Equal(t, closeErr, router.Close())
+}
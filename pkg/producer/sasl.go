@@ -0,0 +1,73 @@
+package producer
+
+import (
+	"errors"
+
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/xdg-go/scram"
+)
+
+const (
+	saslMechanismPlain       = "plain"
+	saslMechanismSCRAMSHA256 = "scram-sha-256"
+	saslMechanismSCRAMSHA512 = "scram-sha-512"
+)
+
+var errUnknownSASLMechanism = errors.New("unknown SASL mechanism, supported mechanisms are plain, scram-sha-256 and scram-sha-512")
+
+// configureSASL enables SASL authentication in sarama config according to application configuration values
+func configureSASL(cnf *sarama.Config, saslConfig config.KafkaSASLConfig) error {
+	switch saslConfig.Mechanism {
+	case "":
+		return nil
+	case saslMechanismPlain:
+		cnf.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case saslMechanismSCRAMSHA256:
+		cnf.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		cnf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGenerator: scram.SHA256}
+		}
+	case saslMechanismSCRAMSHA512:
+		cnf.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		cnf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGenerator: scram.SHA512}
+		}
+	default:
+		return errUnknownSASLMechanism
+	}
+
+	cnf.Net.SASL.Enable = true
+	cnf.Net.SASL.Handshake = true
+	cnf.Net.SASL.User = saslConfig.Username
+	cnf.Net.SASL.Password = saslConfig.Password
+
+	return nil
+}
+
+// scramClient is sarama.SCRAMClient implementation for SCRAM-SHA-256 and SCRAM-SHA-512 mechanisms
+type scramClient struct {
+	hashGenerator scram.HashGeneratorFcn
+	conversation  *scram.ClientConversation
+}
+
+// Begin prepares the client for the SCRAM exchange with the server with a user name and a password
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+// Step steps client through the SCRAM exchange, it is called repeatedly until it errors or Done returns true
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+// Done returns true if the SCRAM conversation is completed or has errored
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -0,0 +1,51 @@
+package producer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureSASL(t *testing.T) {
+	cnf := sarama.NewConfig()
+	require.NoError(t, configureSASL(cnf, config.KafkaSASLConfig{}))
+	assert.False(t, cnf.Net.SASL.Enable)
+
+	cnf = sarama.NewConfig()
+	require.NoError(t, configureSASL(cnf, config.KafkaSASLConfig{Mechanism: saslMechanismPlain, Username: "user", Password: "secret"}))
+	assert.True(t, cnf.Net.SASL.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypePlaintext), cnf.Net.SASL.Mechanism)
+	assert.Equal(t, "user", cnf.Net.SASL.User)
+	assert.Equal(t, "secret", cnf.Net.SASL.Password)
+	assert.NoError(t, cnf.Validate())
+
+	for mechanism, expected := range map[string]sarama.SASLMechanism{
+		saslMechanismSCRAMSHA256: sarama.SASLTypeSCRAMSHA256,
+		saslMechanismSCRAMSHA512: sarama.SASLTypeSCRAMSHA512,
+	} {
+		cnf = sarama.NewConfig()
+		require.NoError(t, configureSASL(cnf, config.KafkaSASLConfig{Mechanism: mechanism, Username: "user", Password: "secret"}))
+		assert.Equal(t, expected, cnf.Net.SASL.Mechanism)
+		require.NotNil(t, cnf.Net.SASL.SCRAMClientGeneratorFunc)
+		assert.NoError(t, cnf.Validate())
+	}
+
+	assert.Equal(t, errUnknownSASLMechanism, configureSASL(sarama.NewConfig(), config.KafkaSASLConfig{Mechanism: "gssapi"}))
+}
+
+func TestScramClient(t *testing.T) {
+	cnf := sarama.NewConfig()
+	require.NoError(t, configureSASL(cnf, config.KafkaSASLConfig{Mechanism: saslMechanismSCRAMSHA512}))
+
+	client := cnf.Net.SASL.SCRAMClientGeneratorFunc()
+	require.NoError(t, client.Begin("user", "secret", ""))
+
+	clientFirstMessage, err := client.Step("")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(clientFirstMessage, "n,,n=user,r="))
+	assert.False(t, client.Done())
+}
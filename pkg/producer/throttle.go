@@ -0,0 +1,49 @@
+package producer
+
+import (
+	"sync"
+	"time"
+)
+
+// maxThrottleDelay is max amount of time publishing is delayed for on single throttle report,
+// so bogus throttle time does not stall publishing
+const maxThrottleDelay = 30 * time.Second
+
+// throttle delays publishing while brokers throttle producer because of exceeded produce quota,
+// so messages are held back in worker buffer instead of being sent to brokers that would delay them anyway
+type throttle struct {
+	sync.Mutex
+
+	until time.Time
+}
+
+// observe extends publishing delay by throttle time reported by brokers
+func (t *throttle) observe(throttleTime time.Duration) {
+	if throttleTime <= 0 {
+		return
+	}
+	if throttleTime > maxThrottleDelay {
+		throttleTime = maxThrottleDelay
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if until := time.Now().Add(throttleTime); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// wait blocks until publishing delay is over and returns amount of time it waited for
+func (t *throttle) wait() time.Duration {
+	t.Lock()
+	delay := time.Until(t.until)
+	t.Unlock()
+
+	if delay <= 0 {
+		return 0
+	}
+
+	time.Sleep(delay)
+	return delay
+}
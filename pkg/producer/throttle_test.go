@@ -0,0 +1,27 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottle(t *testing.T) {
+	var th throttle
+	assert.Equal(t, time.Duration(0), th.wait())
+
+	th.observe(50 * time.Millisecond)
+	// shorter throttle time does not shorten the delay
+	th.observe(time.Millisecond)
+
+	start := time.Now()
+	assert.True(t, th.wait() > 0)
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+	assert.Equal(t, time.Duration(0), th.wait())
+
+	th.observe(time.Hour)
+	th.Lock()
+	assert.True(t, time.Until(th.until) <= maxThrottleDelay)
+	th.Unlock()
+}
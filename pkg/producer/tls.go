@@ -0,0 +1,46 @@
+package producer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+var (
+	errInvalidCAFile  = errors.New("failed to parse any CA certificate from file")
+	errMissingKeyPair = errors.New("both client certificate and key files are required")
+)
+
+// newTLSConfig builds TLS config for Kafka brokers connection from application configuration values
+func newTLSConfig(tlsConfig config.KafkaTLSConfig) (*tls.Config, error) {
+	result := &tls.Config{InsecureSkipVerify: tlsConfig.InsecureSkipVerify}
+
+	if tlsConfig.CAFile != "" {
+		caCert, err := ioutil.ReadFile(tlsConfig.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		result.RootCAs = x509.NewCertPool()
+		if !result.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, errInvalidCAFile
+		}
+	}
+
+	if tlsConfig.CertFile != "" || tlsConfig.KeyFile != "" {
+		if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+			return nil, errMissingKeyPair
+		}
+
+		cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+
+	return result, nil
+}
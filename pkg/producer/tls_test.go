@@ -0,0 +1,93 @@
+package producer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyPair generates self-signed certificate and writes it with its private key to PEM files in dir
+func writeKeyPair(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kandalf"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeKeyPair(t, dir)
+
+	tlsConfig, err := newTLSConfig(config.KafkaTLSConfig{Enabled: true})
+	require.NoError(t, err)
+	assert.Nil(t, tlsConfig.RootCAs)
+	assert.Empty(t, tlsConfig.Certificates)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+
+	tlsConfig, err = newTLSConfig(config.KafkaTLSConfig{
+		Enabled:            true,
+		CAFile:             certFile,
+		CertFile:           certFile,
+		KeyFile:            keyFile,
+		InsecureSkipVerify: true,
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+}
+
+func TestNewTLSConfig_error(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeKeyPair(t, dir)
+
+	_, err = newTLSConfig(config.KafkaTLSConfig{CAFile: filepath.Join(dir, "does-not-exist.pem")})
+	assert.Error(t, err)
+
+	_, err = newTLSConfig(config.KafkaTLSConfig{CAFile: keyFile})
+	assert.Equal(t, errInvalidCAFile, err)
+
+	_, err = newTLSConfig(config.KafkaTLSConfig{CertFile: certFile})
+	assert.Equal(t, errMissingKeyPair, err)
+
+	_, err = newTLSConfig(config.KafkaTLSConfig{CertFile: keyFile, KeyFile: certFile})
+	assert.Error(t, err)
+}
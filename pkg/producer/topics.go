@@ -0,0 +1,81 @@
+package producer
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// CreateTopics creates destination topics for pipes with topic settings, including topics of pipe routes,
+// topics that already exist are left intact
+func CreateTopics(kafkaConfig config.KafkaConfig, pipes []config.Pipe) error {
+	clusterTopics := make(map[string]map[string]config.TopicSettings)
+	for _, pipe := range pipes {
+		// dry run pipes never publish, so they leave Kafka untouched
+		if pipe.KafkaCreateTopic == nil || pipe.DryRun {
+			continue
+		}
+		if clusterTopics[pipe.KafkaCluster] == nil {
+			clusterTopics[pipe.KafkaCluster] = make(map[string]config.TopicSettings)
+		}
+		for _, topic := range pipe.Topics() {
+			clusterTopics[pipe.KafkaCluster][topic] = *pipe.KafkaCreateTopic
+		}
+	}
+
+	for cluster, topics := range clusterTopics {
+		if err := createClusterTopics(kafkaConfig, cluster, topics); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createClusterTopics(kafkaConfig config.KafkaConfig, cluster string, topics map[string]config.TopicSettings) error {
+	clusterConfig, err := kafkaConfig.ForCluster(cluster)
+	if err != nil {
+		return err
+	}
+
+	cnf, err := NewSaramaConfig(clusterConfig)
+	if err != nil {
+		return err
+	}
+
+	admin, err := sarama.NewClusterAdmin(clusterConfig.Brokers, cnf)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	return createTopics(admin, topics)
+}
+
+func createTopics(admin sarama.ClusterAdmin, topics map[string]config.TopicSettings) error {
+	for topic, settings := range topics {
+		configEntries := make(map[string]*string, len(settings.Configs))
+		for name := range settings.Configs {
+			value := settings.Configs[name]
+			configEntries[name] = &value
+		}
+
+		err := admin.CreateTopic(topic, &sarama.TopicDetail{
+			NumPartitions:     int32(settings.Partitions),
+			ReplicationFactor: int16(settings.ReplicationFactor),
+			ConfigEntries:     configEntries,
+		}, false)
+		if topicErr, ok := err.(*sarama.TopicError); ok && topicErr.Err == sarama.ErrTopicAlreadyExists {
+			log.WithField("topic", topic).Debug("Kafka topic already exists")
+			continue
+		}
+		if err != nil {
+			log.WithError(err).WithField("topic", topic).Error("Failed to create Kafka topic")
+			return err
+		}
+
+		log.WithField("topic", topic).Info("Kafka topic created")
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package producer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockClusterAdmin struct {
+	sarama.ClusterAdmin
+
+	createTopicResult map[string]error
+	createdTopics     map[string]*sarama.TopicDetail
+}
+
+func (a *mockClusterAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	if a.createdTopics == nil {
+		a.createdTopics = make(map[string]*sarama.TopicDetail)
+	}
+	a.createdTopics[topic] = detail
+
+	return a.createTopicResult[topic]
+}
+
+func TestCreateTopics(t *testing.T) {
+	// no admin connection is required when there are no topics to create
+	assert.NoError(t, CreateTopics(config.KafkaConfig{}, []config.Pipe{{KafkaTopic: "topic"}}))
+}
+
+func TestCreateTopics_admin(t *testing.T) {
+	admin := &mockClusterAdmin{createTopicResult: map[string]error{
+		"existing": &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists},
+	}}
+
+	err := createTopics(admin, map[string]config.TopicSettings{
+		"orders":   {Partitions: 12, ReplicationFactor: 3, Configs: map[string]string{"retention.ms": "604800000"}},
+		"existing": {Partitions: 1, ReplicationFactor: 1},
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, admin.createdTopics, 2)
+	assert.Equal(t, int32(12), admin.createdTopics["orders"].NumPartitions)
+	assert.Equal(t, int16(3), admin.createdTopics["orders"].ReplicationFactor)
+	assert.Equal(t, "604800000", *admin.createdTopics["orders"].ConfigEntries["retention.ms"])
+
+	createError := errors.New("not enough brokers")
+	admin = &mockClusterAdmin{createTopicResult: map[string]error{"orders": createError}}
+	err = createTopics(admin, map[string]config.TopicSettings{"orders": {Partitions: 12, ReplicationFactor: 3}})
+	assert.Equal(t, createError, err)
+}
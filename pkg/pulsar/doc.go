@@ -0,0 +1,4 @@
+/*
+Package pulsar holds code required for publishing messages to Apache Pulsar.
+*/
+package pulsar
@@ -0,0 +1,187 @@
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	pulsarclient "github.com/apache/pulsar-client-go/pulsar"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+)
+
+const statsPulsarSection = "pulsar"
+
+// ErrMissingURL is an error raised when there are pipes with "pulsar" sink, but Pulsar service URL is not configured
+var ErrMissingURL = errors.New("pulsar sink requires Pulsar service URL")
+
+// Producer is a producer.Producer implementation for publishing messages to Apache Pulsar, message topic is used as
+// Pulsar topic, message key as Pulsar message key and message headers as message properties. Every topic gets its
+// own Pulsar producer on its first message.
+type Producer struct {
+	sync.Mutex
+
+	client      pulsarclient.Client
+	config      config.PulsarConfig
+	producers   map[string]pulsarclient.Producer
+	statsClient client.Client
+}
+
+// NewProducer instantiates new Pulsar client, client authenticates with TLS certificate, if it is configured,
+// or with token otherwise. Connections to brokers are established on the first message of topic they serve.
+func NewProducer(pulsarConfig config.PulsarConfig, statsClient client.Client) (*Producer, error) {
+	if pulsarConfig.URL == "" {
+		return nil, ErrMissingURL
+	}
+
+	options := pulsarclient.ClientOptions{
+		URL:                   pulsarConfig.URL,
+		ConnectionTimeout:     pulsarConfig.Timeout,
+		OperationTimeout:      pulsarConfig.Timeout,
+		TLSTrustCertsFilePath: pulsarConfig.CAFile,
+	}
+	switch {
+	case pulsarConfig.CertFile != "":
+		options.Authentication = pulsarclient.NewAuthenticationTLS(pulsarConfig.CertFile, pulsarConfig.KeyFile)
+	case pulsarConfig.TokenFile != "":
+		options.Authentication = pulsarclient.NewAuthenticationTokenFromFile(pulsarConfig.TokenFile)
+	case pulsarConfig.Token != "":
+		options.Authentication = pulsarclient.NewAuthenticationToken(pulsarConfig.Token)
+	}
+
+	pulsarClient, err := pulsarclient.NewClient(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Producer{
+		client:      pulsarClient,
+		config:      pulsarConfig,
+		producers:   make(map[string]pulsarclient.Producer),
+		statsClient: statsClient,
+	}, nil
+}
+
+// Close flushes batched messages, closes topics producers and Pulsar client
+func (p *Producer) Close() error {
+	p.Lock()
+	defer p.Unlock()
+
+	var result error
+	for _, topicProducer := range p.producers {
+		if err := topicProducer.Flush(); err != nil {
+			result = err
+		}
+		topicProducer.Close()
+	}
+	p.client.Close()
+
+	return result
+}
+
+// Publish publishes message to Pulsar and waits for its acknowledgement, fire-and-forget messages are published
+// without waiting for it
+func (p *Producer) Publish(msg producer.Message) error {
+	publishTimer := p.statsClient.BuildTimer().Start()
+
+	topicProducer, err := p.producer(msg.Topic)
+	if err == nil {
+		if msg.Delivery == config.DeliveryFireAndForget {
+			p.sendAsync(topicProducer, msg)
+		} else {
+			_, err = topicProducer.Send(context.Background(), pulsarMessage(msg))
+		}
+	}
+	producer.TrackPublish(p.statsClient, statsPulsarSection, msg, publishTimer, err)
+
+	return err
+}
+
+// PublishBatch publishes messages to Pulsar at once, so they are batched by topic producers, and waits for all their
+// acknowledgements. Messages that are not acknowledged within timeout fail.
+func (p *Producer) PublishBatch(msgs []producer.Message) []error {
+	publishTimer := p.statsClient.BuildTimer().Start()
+
+	errs := make([]error, len(msgs))
+	var wg sync.WaitGroup
+	for i := range msgs {
+		topicProducer, err := p.producer(msgs[i].Topic)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if msgs[i].Delivery == config.DeliveryFireAndForget {
+			p.sendAsync(topicProducer, msgs[i])
+			continue
+		}
+
+		wg.Add(1)
+		i := i
+		// callback is called for every message, either once it is acknowledged or once send timeout is over
+		topicProducer.SendAsync(context.Background(), pulsarMessage(msgs[i]), func(_ pulsarclient.MessageID, _ *pulsarclient.ProducerMessage, err error) {
+			errs[i] = err
+			wg.Done()
+		})
+	}
+	wg.Wait()
+
+	for i := range msgs {
+		producer.TrackPublish(p.statsClient, statsPulsarSection, msgs[i], publishTimer, errs[i])
+	}
+
+	return errs
+}
+
+// producer returns producer of the topic, it is created on the first message of the topic
+func (p *Producer) producer(topic string) (pulsarclient.Producer, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if topicProducer, ok := p.producers[topic]; ok {
+		return topicProducer, nil
+	}
+
+	options := pulsarclient.ProducerOptions{
+		Topic:                   topic,
+		SendTimeout:             p.config.Timeout,
+		BatchingMaxMessages:     uint(p.config.BatchingMaxMessages),
+		BatchingMaxPublishDelay: p.config.BatchingMaxDelay,
+	}
+	if p.config.KeyBasedBatching {
+		// batches carry messages of the same key only, so Key_Shared subscriptions dispatch them to key consumer
+		options.BatcherBuilderType = pulsarclient.KeyBasedBatchBuilder
+	}
+
+	log.WithField("topic", topic).Info("Creating Pulsar producer")
+	topicProducer, err := p.client.CreateProducer(options)
+	p.statsClient.TrackOperation(statsPulsarSection, bucket.MetricOperation{"connect", topic}, nil, err == nil)
+	if err != nil {
+		return nil, err
+	}
+	p.producers[topic] = topicProducer
+
+	return topicProducer, nil
+}
+
+// sendAsync publishes fire-and-forget message, its failure is logged only
+func (p *Producer) sendAsync(topicProducer pulsarclient.Producer, msg producer.Message) {
+	topicProducer.SendAsync(context.Background(), pulsarMessage(msg), func(_ pulsarclient.MessageID, _ *pulsarclient.ProducerMessage, err error) {
+		if err != nil {
+			log.WithError(err).WithField("msg", msg.String()).Error("Failed to publish fire-and-forget message to pulsar")
+		}
+	})
+}
+
+// pulsarMessage returns Pulsar message with message key and headers as properties, message timestamp is event time
+func pulsarMessage(msg producer.Message) *pulsarclient.ProducerMessage {
+	pulsarMsg := &pulsarclient.ProducerMessage{Payload: msg.Body, Key: msg.Key, Properties: msg.Headers}
+	if msg.Timestamp > 0 {
+		pulsarMsg.EventTime = time.Unix(0, msg.Timestamp)
+	}
+
+	return pulsarMsg
+}
@@ -0,0 +1,122 @@
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	pulsarclient "github.com/apache/pulsar-client-go/pulsar"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockClient struct {
+	pulsarclient.Client
+
+	createProducerErr error
+}
+
+func (c *mockClient) CreateProducer(options pulsarclient.ProducerOptions) (pulsarclient.Producer, error) {
+	return nil, c.createProducerErr
+}
+
+type mockProducer struct {
+	pulsarclient.Producer
+
+	sync.Mutex
+	// sendErrs are errors messages are not acknowledged with by their payload
+	sendErrs map[string]error
+	sent     []string
+}
+
+func (p *mockProducer) Send(_ context.Context, msg *pulsarclient.ProducerMessage) (pulsarclient.MessageID, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.sent = append(p.sent, string(msg.Payload))
+
+	return nil, p.sendErrs[string(msg.Payload)]
+}
+
+func (p *mockProducer) SendAsync(_ context.Context, msg *pulsarclient.ProducerMessage, callback func(pulsarclient.MessageID, *pulsarclient.ProducerMessage, error)) {
+	p.Lock()
+	defer p.Unlock()
+	p.sent = append(p.sent, string(msg.Payload))
+
+	// callback is called from producer event loop, after SendAsync returns
+	err := p.sendErrs[string(msg.Payload)]
+	go callback(nil, msg, err)
+}
+
+func newTestProducer(topicProducer *mockProducer, createProducerErr error) (*Producer, *client.Memory) {
+	statsClient, _ := stats.NewClient("memory://")
+
+	return &Producer{
+		client:      &mockClient{createProducerErr: createProducerErr},
+		producers:   map[string]pulsarclient.Producer{"orders": topicProducer},
+		statsClient: statsClient,
+	}, statsClient.(*client.Memory)
+}
+
+func TestProducer_Publish(t *testing.T) {
+	sendErr := errors.New("topic is terminated")
+	topicProducer := &mockProducer{sendErrs: map[string]error{"failed": sendErr}}
+	p, memoryStats := newTestProducer(topicProducer, nil)
+
+	assert.NoError(t, p.Publish(producer.Message{Body: []byte("ok"), Topic: "orders"}))
+	assert.Equal(t, sendErr, p.Publish(producer.Message{Body: []byte("failed"), Topic: "orders"}))
+	assert.Equal(t, []string{"ok", "failed"}, topicProducer.sent)
+
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.publish.orders.-", statsPulsarSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-fail.publish.orders.-", statsPulsarSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.orders.-", statsPulsarSection)])
+}
+
+func TestProducer_PublishBatch(t *testing.T) {
+	sendErr := errors.New("message send timeout")
+	topicProducer := &mockProducer{sendErrs: map[string]error{"timeout": sendErr, "lost": sendErr}}
+	createProducerErr := errors.New("topic not found")
+	p, memoryStats := newTestProducer(topicProducer, createProducerErr)
+
+	errs := p.PublishBatch([]producer.Message{
+		{Body: []byte("first"), Topic: "orders"},
+		{Body: []byte("timeout"), Topic: "orders"},
+		{Body: []byte("missing"), Topic: "payments"},
+		// fire-and-forget failures are logged only
+		{Body: []byte("lost"), Topic: "orders", Delivery: config.DeliveryFireAndForget},
+		{Body: []byte("second"), Topic: "orders"},
+	})
+	require.Len(t, errs, 5)
+	assert.NoError(t, errs[0])
+	assert.Equal(t, sendErr, errs[1])
+	assert.Equal(t, createProducerErr, errs[2])
+	assert.NoError(t, errs[3])
+	assert.NoError(t, errs[4])
+
+	assert.Equal(t, 3, memoryStats.CountMetrics[fmt.Sprintf("%s-ok.publish.orders.-", statsPulsarSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.orders.-", statsPulsarSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.error.payments.-", statsPulsarSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s-fail.connect.payments.-", statsPulsarSection)])
+}
+
+func TestPulsarMessage(t *testing.T) {
+	msg := producer.Message{Body: []byte(`{"id":1}`), Topic: "orders", Key: "customer-1"}
+	pulsarMsg := pulsarMessage(msg)
+	assert.Equal(t, []byte(`{"id":1}`), pulsarMsg.Payload)
+	assert.Equal(t, "customer-1", pulsarMsg.Key)
+	assert.Nil(t, pulsarMsg.Properties)
+	assert.True(t, pulsarMsg.EventTime.IsZero())
+
+	timestamp := time.Date(2021, 5, 4, 12, 0, 0, 0, time.UTC)
+	msg.Headers = map[string]string{"x-trace-id": "abc"}
+	msg.Timestamp = timestamp.UnixNano()
+	pulsarMsg = pulsarMessage(msg)
+	assert.Equal(t, map[string]string{"x-trace-id": "abc"}, pulsarMsg.Properties)
+	assert.True(t, timestamp.Equal(pulsarMsg.EventTime))
+}
@@ -0,0 +1,314 @@
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/dedup"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// logFile is name of BoltDB file with replication log in replication directory
+	logFile = "raft.db"
+	// snapshotsRetain is number of log snapshots kept in replication directory
+	snapshotsRetain = 2
+	// transportMaxPool is max number of pooled connections to every other instance
+	transportMaxPool = 3
+	// transportTimeout is replication transport IO timeout
+	transportTimeout = 10 * time.Second
+	// leaderChSize is size of leadership changes channel, raft blocks while it is full
+	leaderChSize = 8
+	// followerRequeueDelay delays rejection of messages consumed by follower, so they are not redelivered
+	// to it in a tight loop, but rather consumed by the leader
+	followerRequeueDelay = time.Second
+)
+
+var (
+	// ErrNotLeader is returned when message is appended to or removed from buffer of instance
+	// that is not replication leader
+	ErrNotLeader = errors.New("instance is not replication leader")
+
+	errMissingNodeID = errors.New("replication node id is required")
+	errMissingDir    = errors.New("replication directory is required")
+	errInvalidPeer   = errors.New("replication peer must be in <node id>@<address> format")
+)
+
+// Buffer is a storage.Buffer interface implementation that replicates data via raft before it is considered written.
+// Data is appended and removed by the leader only, while every instance holds replicated data, so it is replayed
+// by the new leader. Buffer is a dedup.Store interface implementation as well, with replicated dedup window.
+type Buffer struct {
+	raft      *raft.Raft
+	fsm       *fsm
+	store     *logStore
+	transport *raft.NetworkTransport
+	timeout   time.Duration
+	leaderCh  chan bool
+}
+
+// NewBuffer starts replication instance and joins replication cluster, cluster is bootstrapped
+// from peers list on the first start, published messages dedup keys are kept in the given window
+func NewBuffer(cfg config.ReplicationConfig, window *dedup.Window) (*Buffer, error) {
+	if cfg.NodeID == "" {
+		return nil, errMissingNodeID
+	}
+	if cfg.Dir == "" {
+		return nil, errMissingDir
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	logOutput := log.StandardLogger().Writer()
+
+	var advertise net.Addr
+	if cfg.AdvertiseAddr != "" {
+		addr, err := net.ResolveTCPAddr("tcp", cfg.AdvertiseAddr)
+		if err != nil {
+			return nil, err
+		}
+		advertise = addr
+	}
+	transport, err := raft.NewTCPTransport(cfg.BindAddr, advertise, transportMaxPool, transportTimeout, logOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	servers, err := clusterServers(cfg, transport.LocalAddr())
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(cfg.Dir, snapshotsRetain, logOutput)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	store, err := newLogStore(filepath.Join(cfg.Dir, logFile))
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LocalID = raft.ServerID(cfg.NodeID)
+	raftConfig.LogOutput = logOutput
+
+	b := &Buffer{fsm: newFSM(window), store: store, transport: transport, timeout: cfg.ApplyTimeout, leaderCh: make(chan bool, leaderChSize)}
+	raftConfig.NotifyCh = b.leaderCh
+	if b.raft, err = raft.NewRaft(raftConfig, b.fsm, store, store, snapshots, transport); err != nil {
+		store.Close()
+		transport.Close()
+		return nil, err
+	}
+
+	hasState, err := raft.HasExistingState(store, store, snapshots)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	if !hasState {
+		// every instance bootstraps cluster with the same configuration, that is safe
+		// as long as peers list is the same on all of them
+		log.WithField("servers", len(servers)).Info("Bootstrapping replication cluster")
+		if err := b.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// clusterServers parses cluster peers list, instance is the only cluster server if list is empty
+func clusterServers(cfg config.ReplicationConfig, localAddr raft.ServerAddress) ([]raft.Server, error) {
+	if len(cfg.Peers) == 0 {
+		return []raft.Server{{Suffrage: raft.Voter, ID: raft.ServerID(cfg.NodeID), Address: localAddr}}, nil
+	}
+
+	servers := make([]raft.Server, 0, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		parts := strings.SplitN(peer, "@", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errInvalidPeer
+		}
+		servers = append(servers, raft.Server{Suffrage: raft.Voter, ID: raft.ServerID(parts[0]), Address: raft.ServerAddress(parts[1])})
+	}
+
+	return servers, nil
+}
+
+// Append replicates data to cluster majority and returns its sequence number, data can be appended by leader only
+func (b *Buffer) Append(data []byte) (uint64, error) {
+	if !b.IsLeader() {
+		time.Sleep(followerRequeueDelay)
+		return 0, ErrNotLeader
+	}
+
+	result, err := b.apply(command{Op: opAppend, Data: data})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.(uint64), nil
+}
+
+// Remove removes data from replicated buffer, data can be removed by leader only
+func (b *Buffer) Remove(seqs ...uint64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	_, err := b.apply(command{Op: opRemove, Seqs: seqs})
+	return err
+}
+
+// Each iterates over data replicated to the instance, on the leader it waits for all committed data
+// to be applied first, as the new leader learns what is committed only after it is elected
+func (b *Buffer) Each(fn func(seq uint64, data []byte) error) error {
+	if b.IsLeader() {
+		if err := b.raft.Barrier(b.timeout).Error(); err != nil {
+			return err
+		}
+	}
+
+	return b.fsm.each(fn)
+}
+
+// Seen checks if dedup key is in window replicated to the instance
+func (b *Buffer) Seen(key string) bool {
+	return b.fsm.seen(key, time.Now())
+}
+
+// Add replicates dedup keys of published messages to cluster majority, keys can be added by leader only
+func (b *Buffer) Add(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := b.apply(command{Op: opDedup, Keys: keys, At: time.Now().UnixNano()})
+	return err
+}
+
+// IsLeader checks if instance is replication leader at the moment
+func (b *Buffer) IsLeader() bool {
+	return b.raft.State() == raft.Leader
+}
+
+// HasLeader returns true if replication cluster has elected leader, so replicated messages can be accepted
+func (b *Buffer) HasLeader() bool {
+	return b.raft.Leader() != ""
+}
+
+// Peer is replication cluster server
+type Peer struct {
+	// ID is peer node id
+	ID string `json:"id"`
+	// Address is peer replication transport address
+	Address string `json:"address"`
+	// Leader is true for the current cluster leader
+	Leader bool `json:"leader"`
+}
+
+// Status is replication cluster status as seen by the instance
+type Status struct {
+	// State is instance raft state - "Leader", "Follower", "Candidate" or "Shutdown"
+	State string `json:"state"`
+	// Leader is address of the current cluster leader, empty if there is no leader
+	Leader string `json:"leader"`
+	// Peers are all cluster servers, including the instance
+	Peers []Peer `json:"peers"`
+}
+
+// Status returns replication cluster status
+func (b *Buffer) Status() (Status, error) {
+	future := b.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return Status{}, err
+	}
+
+	leader := b.raft.Leader()
+	status := Status{State: b.raft.State().String(), Leader: string(leader)}
+	for _, server := range future.Configuration().Servers {
+		status.Peers = append(status.Peers, Peer{
+			ID:      string(server.ID),
+			Address: string(server.Address),
+			Leader:  leader != "" && server.Address == leader,
+		})
+	}
+
+	return status, nil
+}
+
+// Stats returns raft internal stats, e.g. its state, term, last log and applied indexes, for troubleshooting
+func (b *Buffer) Stats() map[string]string {
+	return b.raft.Stats()
+}
+
+// TransferLeadership hands replication leadership over to the most up-to-date follower on shutdown, so replicated
+// pipes are taken over without waiting for leader failure to be detected, it does nothing if instance is not
+// the leader or cluster has no other servers
+func (b *Buffer) TransferLeadership() error {
+	if !b.IsLeader() {
+		return nil
+	}
+
+	future := b.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if len(future.Configuration().Servers) < 2 {
+		return nil
+	}
+
+	return b.raft.LeadershipTransfer().Error()
+}
+
+// LeaderCh returns channel that receives true when instance becomes replication leader and false when it
+// loses leadership, it must be consumed, as replication is blocked while channel is full
+func (b *Buffer) LeaderCh() <-chan bool {
+	return b.leaderCh
+}
+
+// Close stops replication instance, so remaining instances elect the new leader, and closes replication log
+func (b *Buffer) Close() error {
+	err := b.raft.Shutdown().Error()
+	if transportErr := b.transport.Close(); err == nil {
+		err = transportErr
+	}
+	if storeErr := b.store.Close(); err == nil {
+		err = storeErr
+	}
+
+	return err
+}
+
+func (b *Buffer) apply(cmd command) (interface{}, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	future := b.raft.Apply(data, b.timeout)
+	if err := future.Error(); err != nil {
+		if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+			return nil, ErrNotLeader
+		}
+		return nil, err
+	}
+
+	if err, ok := future.Response().(error); ok {
+		return nil, err
+	}
+
+	return future.Response(), nil
+}
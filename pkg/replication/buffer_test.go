@@ -0,0 +1,96 @@
+package replication
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitLeader(t *testing.T, buffer *Buffer) {
+	select {
+	case leader := <-buffer.LeaderCh():
+		require.True(t, leader)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Instance did not become replication leader")
+	}
+}
+
+func TestNewBuffer_errors(t *testing.T) {
+	_, err := NewBuffer(config.ReplicationConfig{Dir: "/tmp"}, nil)
+	assert.Equal(t, errMissingNodeID, err)
+
+	_, err = NewBuffer(config.ReplicationConfig{NodeID: "kandalf-1"}, nil)
+	assert.Equal(t, errMissingDir, err)
+}
+
+func TestClusterServers(t *testing.T) {
+	cfg := config.ReplicationConfig{NodeID: "kandalf-1"}
+	servers, err := clusterServers(cfg, "127.0.0.1:7400")
+	require.NoError(t, err)
+	require.Len(t, servers, 1)
+	assert.Equal(t, "kandalf-1", string(servers[0].ID))
+	assert.Equal(t, "127.0.0.1:7400", string(servers[0].Address))
+
+	cfg.Peers = []string{"kandalf-1@10.0.0.1:7400", "kandalf-2@10.0.0.2:7400"}
+	servers, err = clusterServers(cfg, "127.0.0.1:7400")
+	require.NoError(t, err)
+	require.Len(t, servers, 2)
+	assert.Equal(t, "kandalf-2", string(servers[1].ID))
+	assert.Equal(t, "10.0.0.2:7400", string(servers[1].Address))
+
+	cfg.Peers = []string{"10.0.0.1:7400"}
+	_, err = clusterServers(cfg, "127.0.0.1:7400")
+	assert.Equal(t, errInvalidPeer, err)
+}
+
+func TestBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-replication")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := config.ReplicationConfig{NodeID: "kandalf-1", BindAddr: "127.0.0.1:0", Dir: dir, ApplyTimeout: 5 * time.Second}
+	buffer, err := NewBuffer(cfg, nil)
+	require.NoError(t, err)
+	waitLeader(t, buffer)
+	assert.True(t, buffer.HasLeader())
+
+	status, err := buffer.Status()
+	require.NoError(t, err)
+	assert.Equal(t, "Leader", status.State)
+	assert.Equal(t, "Leader", buffer.Stats()["state"])
+	// single instance cluster has nobody to hand leadership over to
+	require.NoError(t, buffer.TransferLeadership())
+	assert.True(t, buffer.IsLeader())
+	require.Len(t, status.Peers, 1)
+	assert.Equal(t, Peer{ID: "kandalf-1", Address: status.Leader, Leader: true}, status.Peers[0])
+
+	var seqs []uint64
+	for _, data := range []string{"first", "second", "third"} {
+		seq, err := buffer.Append([]byte(data))
+		require.NoError(t, err)
+		seqs = append(seqs, seq)
+	}
+	assert.True(t, seqs[0] < seqs[1] && seqs[1] < seqs[2])
+
+	require.NoError(t, buffer.Remove(seqs[1]))
+	require.NoError(t, buffer.Close())
+
+	// replicated data is restored from replication log on restart
+	buffer, err = NewBuffer(cfg, nil)
+	require.NoError(t, err)
+	defer buffer.Close()
+	waitLeader(t, buffer)
+
+	var data []string
+	err = buffer.Each(func(seq uint64, value []byte) error {
+		data = append(data, string(value))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "third"}, data)
+}
@@ -0,0 +1,5 @@
+/*
+Package replication holds durable buffer implementation that replicates buffered messages to other instances
+via raft, so messages accepted by the leader survive its crash and are replayed by the new leader.
+*/
+package replication
@@ -0,0 +1,190 @@
+package replication
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/hellofresh/kandalf/pkg/dedup"
+)
+
+const (
+	opAppend = "append"
+	opRemove = "remove"
+	opDedup  = "dedup"
+)
+
+// command is replication log entry that changes buffered messages
+type command struct {
+	Op   string   `json:"op"`
+	Data []byte   `json:"data,omitempty"`
+	Seqs []uint64 `json:"seqs,omitempty"`
+	Keys []string `json:"keys,omitempty"`
+	// At is time dedup keys are added at, it is taken from the leader, so windows are pruned the same way everywhere
+	At int64 `json:"at,omitempty"`
+}
+
+// fsm is raft state machine holding replicated messages that are not published yet,
+// messages are identified by log index they were appended with, so the index is the same on every instance.
+// It holds dedup window of published messages keys as well, so duplicates are dropped by the new leader.
+type fsm struct {
+	sync.RWMutex
+
+	messages map[uint64][]byte
+	window   *dedup.Window
+}
+
+// snapshotData is fsm snapshot format
+type snapshotData struct {
+	Messages map[uint64][]byte `json:"messages"`
+	Dedup    []dedup.Entry     `json:"dedup,omitempty"`
+}
+
+func newFSM(window *dedup.Window) *fsm {
+	if window == nil {
+		window = dedup.NewWindow(0, 0)
+	}
+
+	return &fsm{messages: make(map[uint64][]byte), window: window}
+}
+
+// Apply applies committed command, it returns sequence number of appended message or command error
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var cmd command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	switch cmd.Op {
+	case opAppend:
+		f.messages[l.Index] = cmd.Data
+		return l.Index
+	case opRemove:
+		for _, seq := range cmd.Seqs {
+			delete(f.messages, seq)
+		}
+	case opDedup:
+		at := time.Unix(0, cmd.At)
+		for _, key := range cmd.Keys {
+			f.window.Add(key, at)
+		}
+	}
+
+	return nil
+}
+
+// Snapshot returns snapshot of messages, so the log before it can be compacted
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	messages := make(map[uint64][]byte, len(f.messages))
+	for seq, data := range f.messages {
+		messages[seq] = data
+	}
+
+	return &fsmSnapshot{data: snapshotData{Messages: messages, Dedup: f.window.Entries()}}, nil
+}
+
+// Restore replaces messages and dedup window with the ones from snapshot
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(rc).Decode(&raw); err != nil {
+		return err
+	}
+
+	data, err := decodeSnapshot(raw)
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.messages = data.Messages
+	f.window.Restore(data.Dedup)
+	return nil
+}
+
+// decodeSnapshot decodes snapshot, snapshots taken before dedup window was replicated hold messages map only,
+// its keys are sequence numbers, so they never clash with snapshot fields
+func decodeSnapshot(raw map[string]json.RawMessage) (snapshotData, error) {
+	data := snapshotData{Messages: make(map[uint64][]byte)}
+
+	messages, ok := raw["messages"]
+	if !ok {
+		encoded, err := json.Marshal(raw)
+		if err != nil {
+			return data, err
+		}
+		return data, json.Unmarshal(encoded, &data.Messages)
+	}
+
+	if err := json.Unmarshal(messages, &data.Messages); err != nil {
+		return data, err
+	}
+	if dedupEntries, ok := raw["dedup"]; ok {
+		if err := json.Unmarshal(dedupEntries, &data.Dedup); err != nil {
+			return data, err
+		}
+	}
+
+	return data, nil
+}
+
+// seen checks if dedup key is in window
+func (f *fsm) seen(key string, now time.Time) bool {
+	f.RLock()
+	defer f.RUnlock()
+
+	return f.window.Contains(key, now)
+}
+
+// each calls fn for every message in order it was appended, fn is called without lock,
+// so it may take its time
+func (f *fsm) each(fn func(seq uint64, data []byte) error) error {
+	f.RLock()
+	seqs := make([]uint64, 0, len(f.messages))
+	for seq := range f.messages {
+		seqs = append(seqs, seq)
+	}
+	messages := make([][]byte, 0, len(seqs))
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs {
+		messages = append(messages, f.messages[seq])
+	}
+	f.RUnlock()
+
+	for i, seq := range seqs {
+		if err := fn(seq, messages[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type fsmSnapshot struct {
+	data snapshotData
+}
+
+// Persist writes snapshot messages and dedup window to sink
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+// Release is no-op, as snapshot holds a copy of messages and dedup window
+func (s *fsmSnapshot) Release() {}
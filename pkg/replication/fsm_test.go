@@ -0,0 +1,112 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/hellofresh/kandalf/pkg/dedup"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSnapshotSink struct {
+	bytes.Buffer
+	cancelled bool
+}
+
+func (s *mockSnapshotSink) ID() string {
+	return "mock"
+}
+
+func (s *mockSnapshotSink) Cancel() error {
+	s.cancelled = true
+	return nil
+}
+
+func (s *mockSnapshotSink) Close() error {
+	return nil
+}
+
+func applyCommand(t *testing.T, f *fsm, index uint64, cmd command) interface{} {
+	data, err := json.Marshal(cmd)
+	require.NoError(t, err)
+
+	return f.Apply(&raft.Log{Index: index, Data: data})
+}
+
+func TestFSM(t *testing.T) {
+	f := newFSM(nil)
+
+	assert.Equal(t, uint64(3), applyCommand(t, f, 3, command{Op: opAppend, Data: []byte("first")}))
+	assert.Equal(t, uint64(4), applyCommand(t, f, 4, command{Op: opAppend, Data: []byte("second")}))
+	assert.Equal(t, uint64(5), applyCommand(t, f, 5, command{Op: opAppend, Data: []byte("third")}))
+	assert.Nil(t, applyCommand(t, f, 6, command{Op: opRemove, Seqs: []uint64{4}}))
+
+	_, ok := f.Apply(&raft.Log{Index: 7, Data: []byte("corrupted")}).(error)
+	assert.True(t, ok)
+
+	var seqs []uint64
+	var data []string
+	err := f.each(func(seq uint64, value []byte) error {
+		seqs = append(seqs, seq)
+		data = append(data, string(value))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 5}, seqs)
+	assert.Equal(t, []string{"first", "third"}, data)
+}
+
+func TestFSM_Snapshot(t *testing.T) {
+	f := newFSM(nil)
+	applyCommand(t, f, 1, command{Op: opAppend, Data: []byte("first")})
+
+	snapshot, err := f.Snapshot()
+	require.NoError(t, err)
+
+	// snapshot is not affected by commands applied after it is taken
+	applyCommand(t, f, 2, command{Op: opAppend, Data: []byte("second")})
+
+	sink := &mockSnapshotSink{}
+	require.NoError(t, snapshot.Persist(sink))
+	snapshot.Release()
+	assert.False(t, sink.cancelled)
+
+	restored := newFSM(nil)
+	require.NoError(t, restored.Restore(ioutil.NopCloser(&sink.Buffer)))
+	assert.Equal(t, map[uint64][]byte{1: []byte("first")}, restored.messages)
+}
+
+func TestFSM_dedup(t *testing.T) {
+	f := newFSM(dedup.NewWindow(time.Minute, 0))
+	at := time.Now()
+
+	assert.Nil(t, applyCommand(t, f, 1, command{Op: opDedup, Keys: []string{"q:first", "q:second"}, At: at.UnixNano()}))
+	assert.True(t, f.seen("q:first", at))
+	assert.True(t, f.seen("q:second", at.Add(time.Minute)))
+	assert.False(t, f.seen("q:second", at.Add(2*time.Minute)))
+	assert.False(t, f.seen("q:third", at))
+
+	snapshot, err := f.Snapshot()
+	require.NoError(t, err)
+	sink := &mockSnapshotSink{}
+	require.NoError(t, snapshot.Persist(sink))
+
+	restored := newFSM(dedup.NewWindow(time.Minute, 0))
+	require.NoError(t, restored.Restore(ioutil.NopCloser(&sink.Buffer)))
+	assert.True(t, restored.seen("q:first", at))
+	assert.True(t, restored.seen("q:second", at))
+}
+
+func TestFSM_Restore_messagesOnlySnapshot(t *testing.T) {
+	data, err := json.Marshal(map[uint64][]byte{1: []byte("first")})
+	require.NoError(t, err)
+
+	restored := newFSM(nil)
+	require.NoError(t, restored.Restore(ioutil.NopCloser(bytes.NewReader(data))))
+	assert.Equal(t, map[uint64][]byte{1: []byte("first")}, restored.messages)
+}
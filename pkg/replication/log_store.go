@@ -0,0 +1,163 @@
+package replication
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/raft"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltOpenTimeout is max amount of time to wait for log file lock, it is held by another process
+// that uses the same directory otherwise
+const boltOpenTimeout = 5 * time.Second
+
+var (
+	logsBucket   = []byte("logs")
+	stableBucket = []byte("stable")
+)
+
+// logStore is raft LogStore and StableStore implementation backed by BoltDB file
+type logStore struct {
+	db *bolt.DB
+}
+
+func newLogStore(path string) (*logStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(logsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(stableBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &logStore{db: db}, nil
+}
+
+// FirstIndex returns the first stored log index, 0 for no logs
+func (s *logStore) FirstIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(logsBucket).Cursor().First(); k != nil {
+			index = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+
+	return index, err
+}
+
+// LastIndex returns the last stored log index, 0 for no logs
+func (s *logStore) LastIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(logsBucket).Cursor().Last(); k != nil {
+			index = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+
+	return index, err
+}
+
+// GetLog reads log with the given index
+func (s *logStore) GetLog(index uint64, log *raft.Log) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(logsBucket).Get(uint64Key(index))
+		if data == nil {
+			return raft.ErrLogNotFound
+		}
+		return json.Unmarshal(data, log)
+	})
+}
+
+// StoreLog stores log
+func (s *logStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores logs in single transaction
+func (s *logStore) StoreLogs(logs []*raft.Log) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(logsBucket)
+		for _, log := range logs {
+			data, err := json.Marshal(log)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(uint64Key(log.Index), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteRange deletes logs with indexes from min to max inclusive
+func (s *logStore) DeleteRange(min, max uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(logsBucket).Cursor()
+		for k, _ := cursor.Seek(uint64Key(min)); k != nil && binary.BigEndian.Uint64(k) <= max; k, _ = cursor.Next() {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Set stores value for the key
+func (s *logStore) Set(key []byte, val []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(stableBucket).Put(key, val)
+	})
+}
+
+// Get returns value for the key or empty value if key is not found
+func (s *logStore) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// value is valid only during transaction
+		val = append([]byte{}, tx.Bucket(stableBucket).Get(key)...)
+		return nil
+	})
+
+	return val, err
+}
+
+// SetUint64 stores number for the key
+func (s *logStore) SetUint64(key []byte, val uint64) error {
+	return s.Set(key, uint64Key(val))
+}
+
+// GetUint64 returns number for the key or 0 if key is not found
+func (s *logStore) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil || len(val) != 8 {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// Close closes log file
+func (s *logStore) Close() error {
+	return s.db.Close()
+}
+
+// uint64Key encodes number big-endian, so log keys are iterated in index order
+func uint64Key(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
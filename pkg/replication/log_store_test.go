@@ -0,0 +1,76 @@
+package replication
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-replication")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := newLogStore(filepath.Join(dir, logFile))
+	require.NoError(t, err)
+	defer store.Close()
+
+	index, err := store.FirstIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), index)
+
+	require.NoError(t, store.StoreLog(&raft.Log{Index: 1, Term: 1, Data: []byte("first")}))
+	require.NoError(t, store.StoreLogs([]*raft.Log{
+		{Index: 2, Term: 1, Data: []byte("second")},
+		{Index: 3, Term: 2, Data: []byte("third")},
+	}))
+
+	index, err = store.FirstIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), index)
+	index, err = store.LastIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), index)
+
+	var log raft.Log
+	require.NoError(t, store.GetLog(3, &log))
+	assert.Equal(t, raft.Log{Index: 3, Term: 2, Data: []byte("third")}, log)
+
+	require.NoError(t, store.DeleteRange(1, 2))
+	assert.Equal(t, raft.ErrLogNotFound, store.GetLog(2, &log))
+	index, err = store.FirstIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), index)
+}
+
+func TestLogStore_stable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-replication")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := newLogStore(filepath.Join(dir, logFile))
+	require.NoError(t, err)
+	defer store.Close()
+
+	val, err := store.Get([]byte("missing"))
+	require.NoError(t, err)
+	assert.Empty(t, val)
+	n, err := store.GetUint64([]byte("missing"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), n)
+
+	require.NoError(t, store.Set([]byte("key"), []byte("value")))
+	val, err = store.Get([]byte("key"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	require.NoError(t, store.SetUint64([]byte("term"), 42))
+	n, err = store.GetUint64([]byte("term"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), n)
+}
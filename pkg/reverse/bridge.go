@@ -0,0 +1,424 @@
+package reverse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+	rabbitmq "github.com/streadway/amqp"
+)
+
+const (
+	// clientID is client id reverse pipes consumer groups connect to Kafka brokers with
+	clientID = "kandalf"
+	// retryTimeout is pause between attempts to publish message that failed to be published, and to rejoin
+	// consumer group after failure
+	retryTimeout = 5 * time.Second
+	// confirmTimeout is max amount of time to wait for broker to confirm published message
+	confirmTimeout = 10 * time.Second
+
+	statsReverseSection = "reverse"
+)
+
+var (
+	// ErrConsumerGroupVersion is an error raised when Kafka version does not support consumer groups
+	ErrConsumerGroupVersion = errors.New("reverse pipes require KAFKA_VERSION 0.10.2.0 or later")
+
+	errChannelClosed  = errors.New("AMQP channel is closed")
+	errNack           = errors.New("AMQP broker did not confirm published message")
+	errConfirmTimeout = errors.New("timed out waiting for AMQP publisher confirmation")
+)
+
+// Bridge consumes messages of reverse pipes from Kafka and publishes them to RabbitMQ. Every pipe consumes its topics
+// with its own consumer group, message offset is marked to be committed only once broker confirms message is
+// published, so messages are published at least once and in order within topic partition.
+type Bridge struct {
+	conn        *amqp.Connection
+	pipes       []config.ReversePipe
+	groups      []sarama.ConsumerGroup
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	statsClient client.Client
+}
+
+// NewBridge instantiates new reverse pipes bridge, establishes AMQP connection, declares pipes exchanges and starts
+// consuming pipes topics. AMQP connection is re-established and consumer groups are re-joined on any error.
+func NewBridge(rabbitDSN string, rabbitConfig config.RabbitMQConfig, kafkaConfig config.KafkaConfig, pipes []config.ReversePipe, statsClient client.Client) (*Bridge, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{pipes: pipes, ctx: ctx, cancel: cancel, statsClient: statsClient}
+
+	conn, err := amqp.NewConnection(rabbitDSN, rabbitConfig, b.declareExchanges, statsClient)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	b.conn = conn
+
+	handlers := make([]*groupHandler, 0, len(pipes))
+	for _, pipe := range pipes {
+		handler, group, err := b.newGroup(kafkaConfig, pipe)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		b.groups = append(b.groups, group)
+		handlers = append(handlers, handler)
+	}
+
+	for i, group := range b.groups {
+		b.wg.Add(1)
+		go b.consume(group, handlers[i])
+	}
+
+	return b, nil
+}
+
+// Close stops consuming pipes topics, commits offsets of published messages and closes AMQP connection
+func (b *Bridge) Close() error {
+	b.cancel()
+	b.wg.Wait()
+
+	var result error
+	for _, group := range b.groups {
+		if err := group.Close(); err != nil {
+			result = err
+		}
+	}
+	if err := b.conn.Close(); err != nil {
+		result = err
+	}
+
+	return result
+}
+
+func (b *Bridge) newGroup(kafkaConfig config.KafkaConfig, pipe config.ReversePipe) (*groupHandler, sarama.ConsumerGroup, error) {
+	routingKey, err := config.ParseTemplate("routingKey", pipe.RabbitRoutingKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clusterConfig, err := kafkaConfig.ForCluster(pipe.KafkaCluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	cnf, err := newGroupConfig(clusterConfig, pipe)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.WithField("pipe", pipe.String()).Info("Joining Kafka consumer group")
+	group, err := sarama.NewConsumerGroup(clusterConfig.Brokers, pipe.KafkaGroup, cnf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &groupHandler{bridge: b, pipe: pipe, routingKey: routingKey}, group, nil
+}
+
+// consume consumes pipe topics until bridge is closed, consumer group session ends on every rebalance,
+// so it is re-joined until then
+func (b *Bridge) consume(group sarama.ConsumerGroup, handler *groupHandler) {
+	defer b.wg.Done()
+
+	go func() {
+		// errors channel is closed once group is closed
+		for err := range group.Errors() {
+			log.WithError(err).WithField("group", handler.pipe.KafkaGroup).Error("Got reverse pipe consumer group error")
+		}
+	}()
+
+	for {
+		err := group.Consume(b.ctx, handler.pipe.KafkaTopics, handler)
+		if b.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		b.statsClient.TrackOperation(statsReverseSection, bucket.MetricOperation{"join", handler.pipe.KafkaGroup}, nil, false)
+		log.WithError(err).WithField("pipe", handler.pipe.String()).
+			Error("Failed to consume reverse pipe topics, trying to re-join consumer group")
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-time.After(retryTimeout):
+		}
+	}
+}
+
+// declareExchanges declares exchanges of the pipes, it is called on every AMQP connection, exchanges are not declared
+// for pipes publishing to default exchange
+func (b *Bridge) declareExchanges(conn *rabbitmq.Connection) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	for _, pipe := range b.pipes {
+		if pipe.RabbitExchangeName == "" {
+			continue
+		}
+
+		err := channel.ExchangeDeclare(
+			pipe.RabbitExchangeName,
+			pipe.RabbitExchangeType,
+			!pipe.RabbitTransientExchange,
+			false,
+			false,
+			false,
+			nil,
+		)
+		b.statsClient.TrackOperation(statsReverseSection, bucket.MetricOperation{"exchange", pipe.RabbitExchangeName}, nil, nil == err)
+		if err != nil {
+			log.WithError(err).WithField("pipe", pipe.String()).Error("Failed to declare reverse pipe exchange")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// groupHandler publishes messages of topic partitions claimed by pipe consumer group member
+type groupHandler struct {
+	bridge     *Bridge
+	pipe       config.ReversePipe
+	routingKey *template.Template
+}
+
+// Setup is run at the beginning of new consumer group session, before claims are consumed
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	log.WithField("group", h.pipe.KafkaGroup).WithField("claims", session.Claims()).Info("Joined Kafka consumer group")
+	h.bridge.statsClient.TrackOperation(statsReverseSection, bucket.MetricOperation{"join", h.pipe.KafkaGroup}, nil, true)
+	return nil
+}
+
+// Cleanup is run at the end of consumer group session, once all claims are consumed
+func (h *groupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim publishes messages of claimed topic partition one by one, every claim publishes messages on its own
+// confirm mode channel. Message that failed to be published is retried until it is published or partition is
+// revoked, so later messages of partition do not overtake it.
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	var pub *publisher
+	defer func() {
+		if pub != nil {
+			pub.close()
+		}
+	}()
+
+	for msg := range claim.Messages() {
+		routingKey, err := h.routingKeyFor(msg)
+		if err != nil {
+			// message with routing key template failure would fail on every attempt, so it is skipped
+			log.WithError(err).WithField("pipe", h.pipe.String()).WithField("offset", msg.Offset).
+				Error("Failed to build reverse pipe message routing key, message is dropped")
+			h.bridge.statsClient.TrackMetric(statsReverseSection, bucket.MetricOperation{"drop", msg.Topic})
+			session.MarkMessage(msg, "")
+			continue
+		}
+
+		for {
+			// publishing to connection blocked by broker hangs, so message waits until it is unblocked
+			select {
+			case <-h.bridge.conn.Unblocked():
+			case <-session.Context().Done():
+				return nil
+			}
+
+			if pub == nil {
+				pub, err = h.bridge.newPublisher()
+			}
+			if err == nil {
+				publishTimer := h.bridge.statsClient.BuildTimer().Start()
+				err = pub.publish(h.pipe.RabbitExchangeName, routingKey, newPublishing(msg, h.pipe))
+				h.bridge.statsClient.TrackOperation(statsReverseSection, bucket.MetricOperation{"publish", msg.Topic}, publishTimer, nil == err)
+			}
+			if err == nil {
+				session.MarkMessage(msg, "")
+				break
+			}
+
+			log.WithError(err).WithField("pipe", h.pipe.String()).WithField("offset", msg.Offset).
+				Error("Failed to publish reverse pipe message, will try later")
+			// channel state is unknown after failure, e.g. confirmation may still arrive, so channel is reopened
+			if pub != nil {
+				pub.close()
+				pub = nil
+			}
+			select {
+			case <-session.Context().Done():
+				return nil
+			case <-time.After(retryTimeout):
+			}
+		}
+	}
+
+	return nil
+}
+
+// routingKeyFor returns routing key message is published with, that is message topic if pipe has no routing key
+func (h *groupHandler) routingKeyFor(msg *sarama.ConsumerMessage) (string, error) {
+	if h.pipe.RabbitRoutingKey == "" {
+		return msg.Topic, nil
+	}
+	if !config.IsTopicTemplate(h.pipe.RabbitRoutingKey) {
+		return h.pipe.RabbitRoutingKey, nil
+	}
+
+	var result strings.Builder
+	if err := h.routingKey.Execute(&result, recordTemplateData{msg: msg}); err != nil {
+		return "", err
+	}
+
+	return result.String(), nil
+}
+
+func (b *Bridge) newPublisher() (*publisher, error) {
+	channel, err := b.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	return &publisher{channel: channel, confirms: channel.NotifyPublish(make(chan rabbitmq.Confirmation, 1))}, nil
+}
+
+// publisher publishes messages on confirm mode channel and waits for every message confirmation
+type publisher struct {
+	channel  *rabbitmq.Channel
+	confirms chan rabbitmq.Confirmation
+}
+
+func (p *publisher) publish(exchange, routingKey string, msg rabbitmq.Publishing) error {
+	if err := p.channel.Publish(exchange, routingKey, false, false, msg); err != nil {
+		return err
+	}
+
+	deadline := time.NewTimer(confirmTimeout)
+	defer deadline.Stop()
+	select {
+	case confirmation, ok := <-p.confirms:
+		if !ok {
+			return errChannelClosed
+		}
+		if !confirmation.Ack {
+			return errNack
+		}
+		return nil
+	case <-deadline.C:
+		return errConfirmTimeout
+	}
+}
+
+func (p *publisher) close() {
+	p.channel.Close()
+}
+
+// newPublishing returns AMQP message for Kafka record, message id identifies record, so consumers may deduplicate
+// messages published more than once
+func newPublishing(msg *sarama.ConsumerMessage, pipe config.ReversePipe) rabbitmq.Publishing {
+	publishing := rabbitmq.Publishing{
+		Body:         msg.Value,
+		MessageId:    fmt.Sprintf("%s-%d-%d", msg.Topic, msg.Partition, msg.Offset),
+		Timestamp:    msg.Timestamp,
+		DeliveryMode: rabbitmq.Persistent,
+	}
+	if pipe.RabbitTransientMessages {
+		publishing.DeliveryMode = rabbitmq.Transient
+	}
+	if pipe.RabbitHeaders && len(msg.Headers) > 0 {
+		publishing.Headers = make(rabbitmq.Table, len(msg.Headers))
+		for _, header := range msg.Headers {
+			publishing.Headers[string(header.Key)] = string(header.Value)
+		}
+	}
+
+	return publishing
+}
+
+// newGroupConfig returns consumer group configuration, it connects to brokers the same way producers do
+func newGroupConfig(kafkaConfig config.KafkaConfig, pipe config.ReversePipe) (*sarama.Config, error) {
+	cnf, err := producer.NewSaramaConfig(kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+	if !cnf.Version.IsAtLeast(sarama.V0_10_2_0) {
+		return nil, ErrConsumerGroupVersion
+	}
+
+	cnf.ClientID = clientID
+	cnf.Consumer.Return.Errors = true
+	cnf.Consumer.Offsets.Initial = sarama.OffsetNewest
+	if pipe.KafkaInitialOffset == config.InitialOffsetOldest {
+		cnf.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+
+	return cnf, cnf.Validate()
+}
+
+// recordTemplateData is data available in reverse pipe routing key templates, e.g. '{{.Topic}}.{{.Header "type"}}'
+type recordTemplateData struct {
+	msg *sarama.ConsumerMessage
+}
+
+// Topic returns Kafka topic message is consumed from
+func (d recordTemplateData) Topic() string {
+	return d.msg.Topic
+}
+
+// Key returns Kafka record key
+func (d recordTemplateData) Key() string {
+	return string(d.msg.Key)
+}
+
+// Partition returns topic partition message is consumed from
+func (d recordTemplateData) Partition() int32 {
+	return d.msg.Partition
+}
+
+// Header returns Kafka record header value, empty string if record has no such header
+func (d recordTemplateData) Header(name string) string {
+	for _, header := range d.msg.Headers {
+		if string(header.Key) == name {
+			return string(header.Value)
+		}
+	}
+
+	return ""
+}
+
+// Body returns raw message body
+func (d recordTemplateData) Body() string {
+	return string(d.msg.Value)
+}
+
+// Data returns decoded JSON message body, numbers are kept as is, so big integer ids are not turned into floats
+func (d recordTemplateData) Data() (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(d.msg.Value))
+	decoder.UseNumber()
+
+	var data interface{}
+	err := decoder.Decode(&data)
+	return data, err
+}
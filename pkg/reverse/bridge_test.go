@@ -0,0 +1,104 @@
+package reverse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/hellofresh/kandalf/pkg/config"
+	rabbitmq "github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMessage() *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     "order-status",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("order-1"),
+		Value:     []byte(`{"id":12345678901234567890,"status":"shipped"}`),
+		Timestamp: time.Date(2021, 5, 4, 12, 0, 0, 0, time.UTC),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("type"), Value: []byte("order.shipped")},
+			{Key: []byte("tenant"), Value: []byte("de")},
+		},
+	}
+}
+
+func TestGroupHandler_routingKeyFor(t *testing.T) {
+	msg := newTestMessage()
+
+	for _, tc := range []struct {
+		routingKey string
+		expected   string
+	}{
+		{"", "order-status"},
+		{"orders", "orders"},
+		{"{{.Topic}}.{{.Data.status}}", "order-status.shipped"},
+		{`{{.Header "tenant"}}.{{.Header "type"}}`, "de.order.shipped"},
+		{`{{.Header "missing"}}`, ""},
+		{"{{.Key}}.{{.Partition}}", "order-1.3"},
+		{"{{.Data.id}}", "12345678901234567890"},
+		{`{{if eq .Body ""}}empty{{else}}full{{end}}`, "full"},
+	} {
+		pipe := config.ReversePipe{RabbitRoutingKey: tc.routingKey}
+		routingKey, err := config.ParseTemplate("routingKey", pipe.RabbitRoutingKey)
+		require.NoError(t, err)
+
+		handler := &groupHandler{pipe: pipe, routingKey: routingKey}
+		result, err := handler.routingKeyFor(msg)
+		require.NoError(t, err, tc.routingKey)
+		assert.Equal(t, tc.expected, result, tc.routingKey)
+	}
+
+	pipe := config.ReversePipe{RabbitRoutingKey: "{{.Data.status}}"}
+	routingKey, err := config.ParseTemplate("routingKey", pipe.RabbitRoutingKey)
+	require.NoError(t, err)
+	msg.Value = []byte("not json")
+
+	handler := &groupHandler{pipe: pipe, routingKey: routingKey}
+	_, err = handler.routingKeyFor(msg)
+	assert.Error(t, err)
+}
+
+func TestNewPublishing(t *testing.T) {
+	msg := newTestMessage()
+
+	publishing := newPublishing(msg, config.ReversePipe{})
+	assert.Equal(t, msg.Value, publishing.Body)
+	assert.Equal(t, "order-status-3-42", publishing.MessageId)
+	assert.Equal(t, msg.Timestamp, publishing.Timestamp)
+	assert.Equal(t, rabbitmq.Persistent, publishing.DeliveryMode)
+	assert.Nil(t, publishing.Headers)
+
+	publishing = newPublishing(msg, config.ReversePipe{RabbitHeaders: true, RabbitTransientMessages: true})
+	assert.Equal(t, rabbitmq.Transient, publishing.DeliveryMode)
+	assert.Equal(t, rabbitmq.Table{"type": "order.shipped", "tenant": "de"}, publishing.Headers)
+
+	msg.Headers = nil
+	publishing = newPublishing(msg, config.ReversePipe{RabbitHeaders: true})
+	assert.Nil(t, publishing.Headers)
+}
+
+func TestNewGroupConfig(t *testing.T) {
+	_, err := newGroupConfig(config.KafkaConfig{}, config.ReversePipe{})
+	assert.Equal(t, ErrConsumerGroupVersion, err)
+
+	_, err = newGroupConfig(config.KafkaConfig{Version: "0.10.1.0"}, config.ReversePipe{})
+	assert.Equal(t, ErrConsumerGroupVersion, err)
+
+	cnf, err := newGroupConfig(config.KafkaConfig{Version: "1.0.0"}, config.ReversePipe{})
+	require.NoError(t, err)
+	assert.Equal(t, clientID, cnf.ClientID)
+	assert.True(t, cnf.Consumer.Return.Errors)
+	assert.Equal(t, sarama.OffsetNewest, cnf.Consumer.Offsets.Initial)
+
+	cnf, err = newGroupConfig(config.KafkaConfig{Version: "1.0.0"}, config.ReversePipe{KafkaInitialOffset: config.InitialOffsetOldest})
+	require.NoError(t, err)
+	assert.Equal(t, sarama.OffsetOldest, cnf.Consumer.Offsets.Initial)
+
+	cnf, err = newGroupConfig(config.KafkaConfig{Version: "1.0.0", TLS: config.KafkaTLSConfig{Enabled: true}}, config.ReversePipe{})
+	require.NoError(t, err)
+	assert.True(t, cnf.Net.TLS.Enable)
+}
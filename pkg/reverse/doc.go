@@ -0,0 +1,5 @@
+/*
+Package reverse holds code required for reverse pipes - consuming messages from Kafka topics with consumer groups
+and publishing them to RabbitMQ exchanges.
+*/
+package reverse
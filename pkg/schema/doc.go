@@ -0,0 +1,6 @@
+/*
+Package schema holds interface for serializing outgoing messages with pipe schemas and implementations
+that take schemas from Confluent Schema Registry and frame messages in Confluent wire format, or take schemas
+from local files and publish messages in plain schema binary encoding.
+*/
+package schema
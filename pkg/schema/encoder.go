@@ -0,0 +1,66 @@
+package schema
+
+import (
+	"encoding/binary"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+// magicByte is the first byte of Confluent wire format payload, it is followed by 4 bytes big-endian schema ID
+const magicByte byte = 0
+
+// Encoder is an interface for serializing message bodies with pipe schema
+type Encoder interface {
+	// Encode serializes message body with the schema and frames it with schema ID,
+	// topic is the resolved message topic used for default subject name
+	Encode(settings config.SchemaSettings, topic string, body []byte) ([]byte, error)
+}
+
+// NewEncoder returns Encoder that serializes messages of pipes with schema file with files encoder and messages
+// of other pipes with Schema Registry, registry may be nil if there are no pipes with registry schemas
+func NewEncoder(registry *Registry, files *FileEncoder) Encoder {
+	return &encoder{registry: registry, files: files}
+}
+
+type encoder struct {
+	registry *Registry
+	files    *FileEncoder
+}
+
+func (e *encoder) Encode(settings config.SchemaSettings, topic string, body []byte) ([]byte, error) {
+	if settings.File != "" {
+		return e.files.Encode(settings, topic, body)
+	}
+	if e.registry == nil {
+		return nil, errMissingRegistryURL
+	}
+
+	return e.registry.Encode(settings, topic, body)
+}
+
+// PayloadError is an error returned when message body does not conform to schema, so it can never be encoded
+type PayloadError struct {
+	Err error
+}
+
+func (e *PayloadError) Error() string {
+	return "message does not conform to schema: " + e.Err.Error()
+}
+
+// Subject returns registry subject of the schema, default is "<topic>-value" as in Confluent TopicNameStrategy
+func Subject(settings config.SchemaSettings, topic string) string {
+	if settings.Subject != "" {
+		return settings.Subject
+	}
+
+	return topic + "-value"
+}
+
+// frame prepends serialized payload with Confluent wire format header
+func frame(schemaID int, payload []byte) []byte {
+	framed := make([]byte, 5, 5+len(payload))
+	framed[0] = magicByte
+	binary.BigEndian.PutUint32(framed[1:], uint32(schemaID))
+
+	return append(framed, payload...)
+}
@@ -0,0 +1,78 @@
+package schema
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+// FileEncoder is an Encoder implementation that takes schemas from local files. Messages are published
+// in schema binary encoding without Schema Registry wire format framing, so topics are typed without registry.
+// Schema files are read once per file and message type and cached.
+type FileEncoder struct {
+	sync.Mutex
+
+	// serializers are loaded schemas serializers mapped by schema type, file and message type
+	serializers map[string]serializer
+}
+
+// NewFileEncoder instantiates new schema files encoder
+func NewFileEncoder() *FileEncoder {
+	return &FileEncoder{serializers: make(map[string]serializer)}
+}
+
+// Load reads schema files of pipes, so schemas misconfiguration is found on start
+func (e *FileEncoder) Load(pipes []config.Pipe) error {
+	for _, pipe := range pipes {
+		if pipe.KafkaSchema == nil || pipe.KafkaSchema.File == "" {
+			continue
+		}
+
+		if _, err := e.serializer(*pipe.KafkaSchema); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encode serializes message body with the schema from file, topic is not used as schema file is set per pipe
+func (e *FileEncoder) Encode(settings config.SchemaSettings, topic string, body []byte) ([]byte, error) {
+	serialize, err := e.serializer(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return serialize(body)
+}
+
+func (e *FileEncoder) serializer(settings config.SchemaSettings) (serializer, error) {
+	cacheKey := strings.Join([]string{settings.Type, settings.File, settings.Message}, "\x00")
+
+	e.Lock()
+	defer e.Unlock()
+
+	if serialize, ok := e.serializers[cacheKey]; ok {
+		return serialize, nil
+	}
+
+	data, err := ioutil.ReadFile(settings.File)
+	if err != nil {
+		return nil, err
+	}
+
+	var serialize serializer
+	if settings.Type == config.SchemaTypeProtobuf {
+		serialize, err = newProtobufSerializer(data, settings.Message)
+	} else {
+		serialize, err = newSerializer(settings.Type, string(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.serializers[cacheKey] = serialize
+	return serialize, nil
+}
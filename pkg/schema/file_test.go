@@ -0,0 +1,111 @@
+package schema
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func writeSchemaFile(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	return path
+}
+
+func paymentDescriptorSet(t *testing.T) []byte {
+	fileSet := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("payment.proto"),
+		Package: proto.String("billing"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Payment"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("id"), JsonName: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				{Name: proto.String("amount"), JsonName: proto.String("amount"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			},
+		}},
+	}}}
+
+	data, err := proto.Marshal(fileSet)
+	require.NoError(t, err)
+	return data
+}
+
+func TestFileEncoder_Encode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-schema")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	encoder := NewFileEncoder()
+
+	avroSettings := config.SchemaSettings{Type: config.SchemaTypeAvro, File: writeSchemaFile(t, dir, "payment.avsc", []byte(avroSchema))}
+	payload, err := encoder.Encode(avroSettings, "payments", []byte(`{"id":"a","amount":5}`))
+	require.NoError(t, err)
+	// payload is not framed with schema ID
+	assert.Equal(t, []byte{2, 'a', 10}, payload)
+
+	protobufSettings := config.SchemaSettings{
+		Type:    config.SchemaTypeProtobuf,
+		File:    writeSchemaFile(t, dir, "payment.desc", paymentDescriptorSet(t)),
+		Message: "billing.Payment",
+	}
+	payload, err = encoder.Encode(protobufSettings, "payments", []byte(`{"id":"a","amount":"5"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0a, 1, 'a', 0x10, 5}, payload)
+
+	_, err = encoder.Encode(protobufSettings, "payments", []byte(`{"id":"a","currency":"EUR"}`))
+	assert.IsType(t, &PayloadError{}, err)
+
+	_, err = encoder.Encode(avroSettings, "payments", []byte(`{"id":"a"}`))
+	assert.IsType(t, &PayloadError{}, err)
+
+	// schema files are cached, so they are read once
+	require.NoError(t, os.Remove(avroSettings.File))
+	_, err = encoder.Encode(avroSettings, "payments", []byte(`{"id":"a","amount":5}`))
+	assert.NoError(t, err)
+}
+
+func TestFileEncoder_Load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-schema")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	descriptorSet := writeSchemaFile(t, dir, "payment.desc", paymentDescriptorSet(t))
+
+	encoder := NewFileEncoder()
+	assert.NoError(t, encoder.Load([]config.Pipe{
+		{KafkaTopic: "payments", KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeProtobuf, File: descriptorSet, Message: "billing.Payment"}},
+		// registry schemas are not loaded from files
+		{KafkaTopic: "orders", KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeAvro, Schema: avroSchema}},
+		{KafkaTopic: "users"},
+	}))
+
+	err = encoder.Load([]config.Pipe{{KafkaTopic: "payments", KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeProtobuf, File: descriptorSet, Message: "billing.Refund"}}})
+	assert.Error(t, err)
+
+	err = encoder.Load([]config.Pipe{{KafkaTopic: "payments", KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeAvro, File: filepath.Join(dir, "missing.avsc")}}})
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNewEncoder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-schema")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	encoder := NewEncoder(nil, NewFileEncoder())
+
+	settings := config.SchemaSettings{Type: config.SchemaTypeJSON, File: writeSchemaFile(t, dir, "payment.json", []byte(jsonSchema))}
+	payload, err := encoder.Encode(settings, "payments", []byte(`{"id":"a"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []byte(`{"id":"a"}`), payload)
+
+	_, err = encoder.Encode(config.SchemaSettings{Type: config.SchemaTypeJSON, Schema: jsonSchema}, "payments", []byte(`{"id":"a"}`))
+	assert.Equal(t, errMissingRegistryURL, err)
+}
@@ -0,0 +1,284 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/client"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	statsSchemaSection = "schema"
+
+	registryContentType = "application/vnd.schemaregistry.v1+json"
+)
+
+var errMissingRegistryURL = errors.New("schema registry URL is required for pipes with schema")
+
+// registryTypeAvro is registry name of Avro schema type, it is the registry default, so it is omitted
+// in requests to keep compatibility with registries that do not support other types
+const registryTypeAvro = "AVRO"
+
+// registrySchemaTypes are registry names of schema types
+var registrySchemaTypes = map[string]string{
+	config.SchemaTypeAvro:     registryTypeAvro,
+	config.SchemaTypeJSON:     "JSON",
+	config.SchemaTypeProtobuf: "PROTOBUF",
+}
+
+// Registry is an Encoder implementation that takes schemas from Confluent Schema Registry.
+// Schemas are registered or fetched once per subject and cached together with their IDs.
+type Registry struct {
+	sync.Mutex
+
+	statsClient client.Client
+	apiURL      *url.URL
+	httpClient  *http.Client
+
+	// schemas are loaded schemas mapped by subject, type, definition and message type
+	schemas map[string]*registeredSchema
+}
+
+type registeredSchema struct {
+	id        int
+	serialize serializer
+}
+
+type registrySchema struct {
+	ID         int                 `json:"id,omitempty"`
+	Schema     string              `json:"schema,omitempty"`
+	SchemaType string              `json:"schemaType,omitempty"`
+	References []registryReference `json:"references,omitempty"`
+}
+
+// registryReference is a schema of other subject version that Protobuf schema imports by reference name
+type registryReference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// NewRegistry instantiates new Schema Registry client
+func NewRegistry(registryConfig config.SchemaRegistryConfig, statsClient client.Client) (*Registry, error) {
+	if registryConfig.URL == "" {
+		return nil, errMissingRegistryURL
+	}
+
+	apiURL, err := url.Parse(registryConfig.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Registry{
+		statsClient: statsClient,
+		apiURL:      apiURL,
+		httpClient:  &http.Client{Timeout: registryConfig.Timeout},
+		schemas:     make(map[string]*registeredSchema),
+	}, nil
+}
+
+// Load registers or fetches schemas of pipes static topics and route topics, so schemas misconfiguration is found
+// on start. Schemas of templated topics pipes without explicit subject are loaded on first message, pipes with
+// schema file are skipped.
+func (r *Registry) Load(pipes []config.Pipe) error {
+	for _, pipe := range pipes {
+		if pipe.KafkaSchema == nil || pipe.KafkaSchema.File != "" {
+			continue
+		}
+
+		for _, topic := range pipe.Topics() {
+			if config.IsTopicTemplate(topic) && pipe.KafkaSchema.Subject == "" {
+				continue
+			}
+			if _, err := r.schema(*pipe.KafkaSchema, topic); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Encode serializes message body with the schema and frames it with schema ID
+func (r *Registry) Encode(settings config.SchemaSettings, topic string, body []byte) ([]byte, error) {
+	s, err := r.schema(settings, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := s.serialize(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return frame(s.id, payload), nil
+}
+
+func (r *Registry) schema(settings config.SchemaSettings, topic string) (*registeredSchema, error) {
+	subject := Subject(settings, topic)
+	cacheKey := strings.Join([]string{subject, settings.Type, settings.Schema, settings.Message}, "\x00")
+
+	r.Lock()
+	s, ok := r.schemas[cacheKey]
+	r.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	// registry is requested without lock, so slow registry does not block messages of already known subjects,
+	// concurrent requests for the same subject are harmless as registration is idempotent
+	var remote *registrySchema
+	var err error
+	if settings.Schema == "" {
+		remote, err = r.latest(subject)
+	} else {
+		remote, err = r.register(subject, settings)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remoteType := remote.SchemaType
+	if remoteType == "" {
+		remoteType = registryTypeAvro
+	}
+	if remoteType != registrySchemaTypes[settings.Type] {
+		return nil, fmt.Errorf("subject %s has %s schema, but pipe requires %s", subject, remoteType, settings.Type)
+	}
+
+	var serialize serializer
+	if settings.Type == config.SchemaTypeProtobuf {
+		imports := make(map[string]string)
+		if err := r.references(remote.References, imports); err != nil {
+			return nil, err
+		}
+		serialize, err = newRegistryProtobufSerializer(remote.Schema, imports, settings.Message)
+	} else {
+		serialize, err = newSerializer(settings.Type, remote.Schema)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{"subject": subject, "id": remote.ID}).Info("Loaded schema from registry")
+
+	s = &registeredSchema{id: remote.ID, serialize: serialize}
+	r.Lock()
+	r.schemas[cacheKey] = s
+	r.Unlock()
+
+	return s, nil
+}
+
+// register registers schema under subject, registry returns ID of existing schema if it is already registered
+func (r *Registry) register(subject string, settings config.SchemaSettings) (*registrySchema, error) {
+	request := registrySchema{Schema: settings.Schema}
+	if schemaType := registrySchemaTypes[settings.Type]; schemaType != registryTypeAvro {
+		request.SchemaType = schemaType
+	}
+
+	var response registrySchema
+	err := r.do(http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", request, &response)
+
+	operation := bucket.MetricOperation{"registry", "register", subject}
+	r.statsClient.TrackOperation(statsSchemaSection, operation, nil, err == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// register response contains schema ID only
+	request.ID = response.ID
+	return &request, nil
+}
+
+// latest fetches the latest schema version of subject
+func (r *Registry) latest(subject string) (*registrySchema, error) {
+	return r.version(subject, "latest")
+}
+
+// references fetches schemas of references and of their own references, they are mapped by reference name
+func (r *Registry) references(references []registryReference, imports map[string]string) error {
+	for _, reference := range references {
+		if _, ok := imports[reference.Name]; ok {
+			continue
+		}
+
+		remote, err := r.version(reference.Subject, strconv.Itoa(reference.Version))
+		if err != nil {
+			return err
+		}
+		imports[reference.Name] = remote.Schema
+
+		if err := r.references(remote.References, imports); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// version fetches schema version of subject
+func (r *Registry) version(subject, version string) (*registrySchema, error) {
+	var response registrySchema
+	err := r.do(http.MethodGet, "/subjects/"+url.PathEscape(subject)+"/versions/"+version, nil, &response)
+
+	operation := bucket.MetricOperation{"registry", "fetch", subject}
+	r.statsClient.TrackOperation(statsSchemaSection, operation, nil, err == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+func (r *Registry) do(method, path string, request interface{}, response interface{}) error {
+	endpoint := *r.apiURL
+	endpoint.User = nil
+
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(endpoint.String(), "/")+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", registryContentType)
+	if request != nil {
+		req.Header.Set("Content-Type", registryContentType)
+	}
+	if r.apiURL.User != nil {
+		password, _ := r.apiURL.User.Password()
+		req.SetBasicAuth(r.apiURL.User.Username(), password)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var registryError struct {
+			Message string `json:"message"`
+		}
+		// error body is optional, status is enough to report the failure
+		json.NewDecoder(resp.Body).Decode(&registryError)
+		return fmt.Errorf("schema registry responded with status %d: %s", resp.StatusCode, registryError.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(response)
+}
@@ -0,0 +1,212 @@
+package schema
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	avroSchema = `{"type":"record","name":"Payment","fields":[{"name":"id","type":"string"},{"name":"amount","type":"long"}]}`
+	jsonSchema = `{"type":"object","properties":{"id":{"type":"string"}},"required":["id"]}`
+)
+
+func TestNewRegistry_error(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+
+	_, err := NewRegistry(config.SchemaRegistryConfig{}, statsClient)
+	assert.Equal(t, errMissingRegistryURL, err)
+}
+
+func TestRegistry_Encode_register(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/subjects/payments-value/versions", r.URL.EscapedPath())
+		assert.Equal(t, registryContentType, r.Header.Get("Content-Type"))
+
+		user, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "kandalf", user)
+		assert.Equal(t, "secret", password)
+
+		var request registrySchema
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, avroSchema, request.Schema)
+		assert.Empty(t, request.SchemaType)
+
+		w.Write([]byte(`{"id":42}`))
+	}))
+	defer server.Close()
+
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	registryURL.User = url.UserPassword("kandalf", "secret")
+
+	statsClient, _ := stats.NewClient("memory://")
+	registry, err := NewRegistry(config.SchemaRegistryConfig{URL: registryURL.String()}, statsClient)
+	require.NoError(t, err)
+
+	settings := config.SchemaSettings{Type: config.SchemaTypeAvro, Schema: avroSchema}
+	for i := 0; i < 2; i++ {
+		payload, err := registry.Encode(settings, "payments", []byte(`{"id":"p1","amount":10}`))
+		require.NoError(t, err)
+		// magic byte, schema ID 42, string "p1" with zig-zag length 2, long 10 zig-zag encoded
+		assert.Equal(t, []byte{0, 0, 0, 0, 42, 4, 'p', '1', 20}, payload)
+	}
+	assert.Equal(t, 1, requests)
+
+	_, err = registry.Encode(settings, "payments", []byte(`{"id":"p1"}`))
+	require.Error(t, err)
+	assert.IsType(t, &PayloadError{}, err)
+}
+
+func TestRegistry_Encode_latest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/subjects/orders/versions/latest", r.URL.EscapedPath())
+
+		json.NewEncoder(w).Encode(registrySchema{ID: 7, Schema: jsonSchema, SchemaType: "JSON"})
+	}))
+	defer server.Close()
+
+	statsClient, _ := stats.NewClient("memory://")
+	registry, err := NewRegistry(config.SchemaRegistryConfig{URL: server.URL}, statsClient)
+	require.NoError(t, err)
+
+	settings := config.SchemaSettings{Type: config.SchemaTypeJSON, Subject: "orders"}
+	payload, err := registry.Encode(settings, "new-orders", []byte(`{"id":"o1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0, 0, 0, 0, 7}, `{"id":"o1"}`...), payload)
+
+	_, err = registry.Encode(settings, "new-orders", []byte(`{"name":"o1"}`))
+	require.Error(t, err)
+	assert.IsType(t, &PayloadError{}, err)
+
+	_, err = registry.Encode(settings, "new-orders", []byte(`not json`))
+	require.Error(t, err)
+	assert.IsType(t, &PayloadError{}, err)
+}
+
+func TestRegistry_Encode_protobuf(t *testing.T) {
+	const orderSchema = `syntax = "proto3";
+package shop;
+import "shop/money.proto";
+import "google/protobuf/timestamp.proto";
+message Order {
+  string id = 1;
+  message Line {
+    string sku = 1;
+    Money price = 2;
+  }
+}
+message OrderPlaced {
+  string id = 1;
+  google.protobuf.Timestamp placed_at = 2;
+}`
+	const moneySchema = `syntax = "proto3";
+package shop;
+message Money {
+  int64 cents = 1;
+}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/subjects/orders-value/versions/latest":
+			json.NewEncoder(w).Encode(registrySchema{
+				ID:         3,
+				Schema:     orderSchema,
+				SchemaType: "PROTOBUF",
+				References: []registryReference{{Name: "shop/money.proto", Subject: "money", Version: 2}},
+			})
+		case "/subjects/money/versions/2":
+			json.NewEncoder(w).Encode(registrySchema{ID: 2, Schema: moneySchema, SchemaType: "PROTOBUF"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	statsClient, _ := stats.NewClient("memory://")
+	registry, err := NewRegistry(config.SchemaRegistryConfig{URL: server.URL}, statsClient)
+	require.NoError(t, err)
+
+	// the first message type has single zero index, string "o1" is field 1 with length 2
+	payload, err := registry.Encode(config.SchemaSettings{Type: config.SchemaTypeProtobuf}, "orders", []byte(`{"id":"o1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 3, 0, 0x0a, 2, 'o', '1'}, payload)
+
+	// path of the second top-level message is [1], zig-zag encoded with its length
+	settings := config.SchemaSettings{Type: config.SchemaTypeProtobuf, Message: "shop.OrderPlaced"}
+	payload, err = registry.Encode(settings, "orders", []byte(`{"id":"o1","placedAt":"1970-01-01T00:00:01Z"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 3, 2, 2, 0x0a, 2, 'o', '1', 0x12, 2, 0x08, 1}, payload)
+
+	// path of nested message is [0, 0], its field of referenced type is serialized as embedded message
+	settings = config.SchemaSettings{Type: config.SchemaTypeProtobuf, Message: "shop.Order.Line"}
+	payload, err = registry.Encode(settings, "orders", []byte(`{"sku":"a","price":{"cents":"5"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 3, 4, 0, 0, 0x0a, 1, 'a', 0x12, 2, 0x08, 5}, payload)
+
+	_, err = registry.Encode(settings, "orders", []byte(`{"sku":1}`))
+	require.Error(t, err)
+	assert.IsType(t, &PayloadError{}, err)
+
+	// referenced message types are not a part of subject schema
+	_, err = registry.Encode(config.SchemaSettings{Type: config.SchemaTypeProtobuf, Message: "shop.Money"}, "orders", []byte(`{}`))
+	assert.Equal(t, errProtobufMessage, err)
+}
+
+func TestRegistry_Load(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/subjects/payments-value/versions/latest":
+			json.NewEncoder(w).Encode(registrySchema{ID: 1, Schema: avroSchema})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40401,"message":"Subject not found."}`))
+		}
+	}))
+	defer server.Close()
+
+	statsClient, _ := stats.NewClient("memory://")
+	registry, err := NewRegistry(config.SchemaRegistryConfig{URL: server.URL}, statsClient)
+	require.NoError(t, err)
+
+	pipes := []config.Pipe{
+		{KafkaTopic: "payments", KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeAvro}},
+		// templated topic subject is known only per message
+		{KafkaTopic: "{{.RoutingKey}}", KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeAvro}},
+		{KafkaTopic: "orders"},
+	}
+	assert.NoError(t, registry.Load(pipes))
+
+	// schemas of route topics are loaded either
+	routes := []config.Route{{Key: "payment.received", Topic: "payments"}, {Key: "order.created", Topic: "orders"}}
+	routedPipes := []config.Pipe{{KafkaRouteKey: "routingKey", KafkaRoutes: routes, KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeAvro}}}
+	err = registry.Load(routedPipes)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Subject not found.")
+
+	// subject has Avro schema
+	pipes = append(pipes, config.Pipe{KafkaTopic: "payments", KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeJSON}})
+	assert.Error(t, registry.Load(pipes))
+
+	pipes = []config.Pipe{{KafkaTopic: "orders", KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeJSON}}}
+	err = registry.Load(pipes)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Subject not found.")
+}
+
+func TestSubject(t *testing.T) {
+	assert.Equal(t, "payments-value", Subject(config.SchemaSettings{}, "payments"))
+	assert.Equal(t, "payments", Subject(config.SchemaSettings{Subject: "payments"}, "eu.payments"))
+}
@@ -0,0 +1,227 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/linkedin/goavro"
+	"github.com/xeipuuv/gojsonschema"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var errProtobufMessage = errors.New("message type is not found in protobuf descriptor set")
+
+// registryProtobufFile is file name registry Protobuf schema is parsed as, imports of referenced schemas are
+// resolved by their reference names
+const registryProtobufFile = "schema.proto"
+
+// serializer converts JSON message body to schema payload
+type serializer func(body []byte) ([]byte, error)
+
+func newSerializer(schemaType, schema string) (serializer, error) {
+	switch schemaType {
+	case config.SchemaTypeAvro:
+		return newAvroSerializer(schema)
+	case config.SchemaTypeJSON:
+		return newJSONSerializer(schema)
+	}
+
+	return nil, config.ErrUnknownSchemaType
+}
+
+// newAvroSerializer builds serializer that converts body in Avro JSON encoding to Avro binary encoding
+func newAvroSerializer(schema string) (serializer, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(body []byte) ([]byte, error) {
+		native, _, err := codec.NativeFromTextual(body)
+		if err != nil {
+			return nil, &PayloadError{Err: err}
+		}
+
+		payload, err := codec.BinaryFromNative(nil, native)
+		if err != nil {
+			return nil, &PayloadError{Err: err}
+		}
+
+		return payload, nil
+	}, nil
+}
+
+// newJSONSerializer builds serializer that validates body against JSON Schema and leaves it as is
+func newJSONSerializer(schema string) (serializer, error) {
+	jsonSchema, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(body []byte) ([]byte, error) {
+		result, err := jsonSchema.Validate(gojsonschema.NewBytesLoader(body))
+		if err != nil {
+			return nil, &PayloadError{Err: err}
+		}
+
+		if !result.Valid() {
+			violations := make([]string, 0, len(result.Errors()))
+			for _, violation := range result.Errors() {
+				violations = append(violations, violation.String())
+			}
+			return nil, &PayloadError{Err: errors.New(strings.Join(violations, "; "))}
+		}
+
+		return body, nil
+	}, nil
+}
+
+// newProtobufSerializer builds serializer that converts body in Protobuf JSON mapping to Protobuf binary encoding,
+// descriptorSet is serialized FileDescriptorSet with all the message type dependencies,
+// e.g. produced by "protoc --include_imports --descriptor_set_out"
+func newProtobufSerializer(descriptorSet []byte, messageName string) (serializer, error) {
+	var fileSet descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &fileSet); err != nil {
+		return nil, err
+	}
+
+	messageDescriptor, err := findProtobufMessage(&fileSet, messageName)
+	if err != nil {
+		return nil, err
+	}
+
+	return protobufSerializer(messageDescriptor), nil
+}
+
+// newRegistryProtobufSerializer builds serializer like newProtobufSerializer from registry .proto schema, imports are
+// schemas of subject references mapped by reference name. Message type must be defined by the schema itself,
+// default is the first one. Payload is prefixed with message indexes of Confluent wire format, so Confluent
+// deserializers find message type in the schema.
+func newRegistryProtobufSerializer(schema string, imports map[string]string, messageName string) (serializer, error) {
+	files := map[string]string{registryProtobufFile: schema}
+	for name, importSchema := range imports {
+		files[name] = importSchema
+	}
+
+	// well-known imports, e.g. "google/protobuf/timestamp.proto", are resolved by parser itself
+	parser := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(files)}
+	parsed, err := parser.ParseFiles(registryProtobufFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if messageName == "" {
+		if len(parsed[0].GetMessageTypes()) == 0 {
+			return nil, errProtobufMessage
+		}
+		messageName = parsed[0].GetMessageTypes()[0].GetFullyQualifiedName()
+	}
+
+	var fileSet descriptorpb.FileDescriptorSet
+	appendProtobufFile(&fileSet, parsed[0], make(map[string]bool))
+	messageDescriptor, err := findProtobufMessage(&fileSet, messageName)
+	if err != nil {
+		return nil, err
+	}
+	if messageDescriptor.ParentFile().Path() != registryProtobufFile {
+		return nil, errProtobufMessage
+	}
+
+	indexes := messageIndexes(messageDescriptor)
+	serialize := protobufSerializer(messageDescriptor)
+
+	return func(body []byte) ([]byte, error) {
+		payload, err := serialize(body)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(append(make([]byte, 0, len(indexes)+len(payload)), indexes...), payload...), nil
+	}, nil
+}
+
+// appendProtobufFile appends file with its dependencies to the set, dependencies go first
+func appendProtobufFile(fileSet *descriptorpb.FileDescriptorSet, file *desc.FileDescriptor, added map[string]bool) {
+	if added[file.GetName()] {
+		return
+	}
+	added[file.GetName()] = true
+
+	for _, dependency := range file.GetDependencies() {
+		appendProtobufFile(fileSet, dependency, added)
+	}
+	fileSet.File = append(fileSet.File, file.AsFileDescriptorProto())
+}
+
+// findProtobufMessage returns descriptor of message type with the full name from the set
+func findProtobufMessage(fileSet *descriptorpb.FileDescriptorSet, messageName string) (protoreflect.MessageDescriptor, error) {
+	files, err := protodesc.NewFiles(fileSet)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, err
+	}
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errProtobufMessage
+	}
+
+	return messageDescriptor, nil
+}
+
+// messageIndexes returns Confluent wire format message indexes of message type - path to it from the schema top-level
+// messages through nested ones, zig-zag varint encoded and prefixed with path length. The first top-level message
+// path is encoded as single zero byte.
+func messageIndexes(messageDescriptor protoreflect.MessageDescriptor) []byte {
+	var path []int
+	var descriptor protoreflect.Descriptor = messageDescriptor
+	for {
+		path = append([]int{descriptor.Index()}, path...)
+		parent, ok := descriptor.Parent().(protoreflect.MessageDescriptor)
+		if !ok {
+			break
+		}
+		descriptor = parent
+	}
+
+	if len(path) == 1 && path[0] == 0 {
+		return []byte{0}
+	}
+
+	indexes := protowire.AppendVarint(nil, protowire.EncodeZigZag(int64(len(path))))
+	for _, index := range path {
+		indexes = protowire.AppendVarint(indexes, protowire.EncodeZigZag(int64(index)))
+	}
+
+	return indexes
+}
+
+// protobufSerializer returns serializer that converts body in Protobuf JSON mapping to message type binary encoding
+func protobufSerializer(messageDescriptor protoreflect.MessageDescriptor) serializer {
+	return func(body []byte) ([]byte, error) {
+		msg := dynamicpb.NewMessage(messageDescriptor)
+		if err := protojson.Unmarshal(body, msg); err != nil {
+			return nil, &PayloadError{Err: err}
+		}
+
+		// dynamic message fields are ranged in random order, deterministic marshalling sorts them by field number
+		payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return nil, &PayloadError{Err: err}
+		}
+
+		return payload, nil
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// boltBufferFile is name of BoltDB file in buffer directory
+	boltBufferFile = "buffer.db"
+	// boltOpenTimeout is max amount of time to wait for buffer file lock, it is held by another process
+	// that uses the same directory otherwise
+	boltOpenTimeout = 5 * time.Second
+)
+
+var boltBufferBucket = []byte("messages")
+
+// BoltBuffer is a Buffer interface implementation backed by BoltDB file
+type BoltBuffer struct {
+	db *bolt.DB
+}
+
+// NewBoltBuffer opens or creates buffer file in the given directory
+func NewBoltBuffer(dir string) (*BoltBuffer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, boltBufferFile), 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBufferBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltBuffer{db: db}, nil
+}
+
+// Append writes data to buffer file, concurrent appends are committed together, so they share single fsync
+func (b *BoltBuffer) Append(data []byte) (uint64, error) {
+	var seq uint64
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBufferBucket)
+
+		var err error
+		if seq, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		return bucket.Put(boltKey(seq), data)
+	})
+
+	return seq, err
+}
+
+// Remove removes data from buffer file
+func (b *BoltBuffer) Remove(seqs ...uint64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBufferBucket)
+		for _, seq := range seqs {
+			if err := bucket.Delete(boltKey(seq)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Each iterates over data in buffer file
+func (b *BoltBuffer) Each(fn func(seq uint64, data []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBufferBucket).ForEach(func(k, v []byte) error {
+			return fn(binary.BigEndian.Uint64(k), v)
+		})
+	})
+}
+
+// Close closes buffer file
+func (b *BoltBuffer) Close() error {
+	return b.db.Close()
+}
+
+// boltKey encodes sequence number big-endian, so keys are iterated in sequence order
+func boltKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-buffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buffer, err := NewBoltBuffer(dir)
+	require.NoError(t, err)
+
+	var seqs []uint64
+	for _, data := range []string{"first", "second", "third"} {
+		seq, err := buffer.Append([]byte(data))
+		require.NoError(t, err)
+		seqs = append(seqs, seq)
+	}
+	assert.True(t, seqs[0] > 0)
+	assert.True(t, seqs[0] < seqs[1] && seqs[1] < seqs[2])
+
+	require.NoError(t, buffer.Remove(seqs[1]))
+	require.NoError(t, buffer.Remove())
+	require.NoError(t, buffer.Close())
+
+	// buffer content survives reopening
+	buffer, err = NewBoltBuffer(dir)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	var data []string
+	err = buffer.Each(func(seq uint64, value []byte) error {
+		data = append(data, string(value))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "third"}, data)
+
+	seq, err := buffer.Append([]byte("fourth"))
+	require.NoError(t, err)
+	assert.True(t, seq > seqs[2])
+}
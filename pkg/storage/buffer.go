@@ -0,0 +1,14 @@
+package storage
+
+// Buffer is an interface for durable buffer of messages accepted by worker, but not yet published or moved
+// to persistent storage, so they survive process crash
+type Buffer interface {
+	// Append durably writes data to buffer and returns its sequence number, sequence numbers are positive
+	Append(data []byte) (uint64, error)
+	// Remove removes data with given sequence numbers from buffer
+	Remove(seqs ...uint64) error
+	// Each calls fn for every data in buffer in order it was appended, data is valid only during fn call
+	Each(fn func(seq uint64, data []byte) error) error
+	// Close closes buffer
+	Close() error
+}
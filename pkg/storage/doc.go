@@ -1,4 +1,5 @@
 /*
-Package storage holds interface and Redis implementation for messages storage in case producer is not currently available.
+Package storage holds interface and Redis implementation for messages storage in case producer is not currently available,
+and interface and write-ahead log implementation for durable buffer of messages waiting to be published.
 */
 package storage
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-buffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, walBufferFile)
+	first, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	require.NoError(t, err)
+	second, err := os.OpenFile(path, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	defer second.Close()
+
+	require.NoError(t, lockFile(first, time.Second))
+	assert.Equal(t, ErrLockTimeout, lockFile(second, 2*lockRetryInterval))
+
+	// locked file data stays readable
+	_, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	require.NoError(t, first.Close())
+	assert.NoError(t, lockFile(second, time.Second))
+}
@@ -0,0 +1,34 @@
+//go:build windows
+// +build windows
+
+package storage
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRetryInterval is interval between attempts to lock buffer file
+const lockRetryInterval = 50 * time.Millisecond
+
+// lockFile takes exclusive lock on file, it is released when file is closed. Windows locks are mandatory, so lock
+// is taken on the byte at the maximum offset, the same way BoltDB does, and file data stays readable by others.
+func lockFile(file *os.File, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		overlapped := &windows.Overlapped{Offset: ^uint32(0), OffsetHigh: ^uint32(0)}
+		err := windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, overlapped)
+		if err == nil {
+			return nil
+		}
+		if err != windows.ERROR_LOCK_VIOLATION {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
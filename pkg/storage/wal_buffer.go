@@ -0,0 +1,601 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// walBufferFile is name of write-ahead log file in buffer directory
+	walBufferFile = "buffer.wal"
+	// walMagic is write-ahead log file header, it identifies file format and its version
+	walMagic = "KNDLWAL1"
+	// walRecordHeaderSize is size of record header - payload length and payload CRC
+	walRecordHeaderSize = 8
+	// walPayloadHeaderSize is size of payload header - record type and sequence number
+	walPayloadHeaderSize = 9
+	// walMaxPayloadSize is sanity limit for payload length, larger length means corrupted record header
+	walMaxPayloadSize = 1 << 30
+	// walCompactMinDead is min number of dead records log is compacted at
+	walCompactMinDead = 1024
+
+	walRecordAppend byte = 1
+	walRecordRemove byte = 2
+)
+
+var (
+	// ErrNotWAL is returned when buffer file is not a kandalf write-ahead log
+	ErrNotWAL = errors.New("buffer file is not a kandalf write-ahead log")
+	// ErrLockTimeout is returned when buffer file is locked by another process for longer than lock timeout
+	ErrLockTimeout = errors.New("timeout waiting for buffer file lock")
+
+	walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errTornRecord      = errors.New("record is not written completely")
+	errCorruptedRecord = errors.New("record checksum does not match")
+)
+
+// walPosition is location of appended data in log file
+type walPosition struct {
+	offset int64
+	size   int
+}
+
+// WALBuffer is a Buffer interface implementation backed by append-only write-ahead log file. Every record has
+// CRC32C checksum, so record torn by power loss is detected and truncated on open together with everything after
+// it, while records before it are kept. Removals are logged as records either, and log is compacted once most
+// of its records are dead.
+type WALBuffer struct {
+	sync.Mutex
+	// syncMu serialises fsyncs, appends that wait for it are synced together by the first of them
+	syncMu sync.Mutex
+
+	path   string
+	file   *os.File
+	size   int64
+	synced int64
+	seq    uint64
+	index  map[uint64]walPosition
+	// dead is number of removed appends and remove records in log file
+	dead int
+	// epoch is incremented whenever unsynced records are discarded after failed fsync, so appends that wait
+	// for sync find out whether their records are gone
+	epoch uint64
+	// discarded are ended epochs mapped to offset log file was truncated back to and error of failed fsync
+	discarded map[uint64]walDiscard
+}
+
+// walDiscard is the end of epoch - synced offset records after which were discarded and error of failed fsync
+type walDiscard struct {
+	synced int64
+	err    error
+}
+
+// WALReport is result of write-ahead log file verification
+type WALReport struct {
+	// Records is number of valid records
+	Records int
+	// Live is number of appended messages that are not removed
+	Live int
+	// ValidBytes is size of valid part of the file
+	ValidBytes int64
+	// InvalidBytes is size of torn or corrupted tail, it is truncated on open
+	InvalidBytes int64
+	// TailError describes why tail is invalid, empty if the whole file is valid
+	TailError string
+}
+
+// NewWALBuffer opens or creates write-ahead log buffer file in the given directory, torn tail is truncated.
+// Messages of BoltDB buffer file left in the directory by previous versions are moved to the log.
+func NewWALBuffer(dir string) (*WALBuffer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, walBufferFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file, boltOpenTimeout); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	b := &WALBuffer{path: path, file: file, index: make(map[uint64]walPosition)}
+	if err := b.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := b.importBolt(dir); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// VerifyWALBuffer checks write-ahead log buffer file in the given directory without modifying it
+func VerifyWALBuffer(dir string) (WALReport, error) {
+	var report WALReport
+
+	file, err := os.Open(filepath.Join(dir, walBufferFile))
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return report, err
+	}
+	if err := readWALMagic(file); err != nil {
+		return report, err
+	}
+
+	live := make(map[uint64]bool)
+	report.ValidBytes, err = scanWAL(file, func(typ byte, seq uint64, data []byte, offset int64) {
+		report.Records++
+		if typ == walRecordAppend {
+			live[seq] = true
+			return
+		}
+		for _, removed := range decodeSeqs(data) {
+			delete(live, removed)
+		}
+	})
+	report.Live = len(live)
+	report.InvalidBytes = info.Size() - report.ValidBytes
+	if err == errTornRecord || err == errCorruptedRecord {
+		report.TailError = err.Error()
+		return report, nil
+	}
+
+	return report, err
+}
+
+// Append writes data to log file and waits for it to be synced, concurrent appends share single fsync
+func (b *WALBuffer) Append(data []byte) (uint64, error) {
+	b.Lock()
+	seq := b.seq + 1
+	offset := b.size
+	if err := b.write(walRecordAppend, seq, data); err != nil {
+		b.Unlock()
+		return 0, err
+	}
+	b.seq = seq
+	b.index[seq] = walPosition{offset: offset + walRecordHeaderSize + walPayloadHeaderSize, size: len(data)}
+	end, epoch := b.size, b.epoch
+	b.Unlock()
+
+	if err := b.sync(end, epoch); err != nil {
+		return 0, err
+	}
+
+	return seq, nil
+}
+
+// Remove logs removal of data and compacts log file once most of its records are dead
+func (b *WALBuffer) Remove(seqs ...uint64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+	b.Lock()
+	defer b.Unlock()
+
+	data := make([]byte, 0, 8*len(seqs))
+	for _, seq := range seqs {
+		if _, ok := b.index[seq]; ok {
+			delete(b.index, seq)
+			b.dead++
+			data = append(data, boltKey(seq)...)
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	// remove record carries last sequence number, so it does not go back after compaction removes all appends
+	if err := b.write(walRecordRemove, b.seq, data); err != nil {
+		return err
+	}
+	b.dead++
+
+	if b.dead >= walCompactMinDead && b.dead > 2*len(b.index) {
+		return b.compact()
+	}
+
+	if err := b.file.Sync(); err != nil {
+		b.discardUnsynced(err)
+		return err
+	}
+	b.synced = b.size
+
+	return nil
+}
+
+// Each iterates over data in log file in order it was appended, buffer is locked during iteration,
+// so fn must not call buffer methods
+func (b *WALBuffer) Each(fn func(seq uint64, data []byte) error) error {
+	b.Lock()
+	defer b.Unlock()
+
+	var data []byte
+	for _, seq := range b.sortedSeqs() {
+		position := b.index[seq]
+		if cap(data) < position.size {
+			data = make([]byte, position.size)
+		}
+		data = data[:position.size]
+		if _, err := b.file.ReadAt(data, position.offset); err != nil {
+			return err
+		}
+		if err := fn(seq, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes log file
+func (b *WALBuffer) Close() error {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.file.Close()
+}
+
+// load reads log file index and truncates invalid tail, must be called before buffer is used
+func (b *WALBuffer) load() error {
+	info, err := b.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		if _, err := b.file.WriteAt([]byte(walMagic), 0); err != nil {
+			return err
+		}
+		b.size = int64(len(walMagic))
+		b.synced = b.size
+		return b.file.Sync()
+	}
+
+	if err := readWALMagic(b.file); err != nil {
+		return err
+	}
+
+	valid, err := scanWAL(b.file, func(typ byte, seq uint64, data []byte, offset int64) {
+		if seq > b.seq {
+			b.seq = seq
+		}
+		if typ == walRecordAppend {
+			b.index[seq] = walPosition{offset: offset, size: len(data)}
+			return
+		}
+
+		for _, removed := range decodeSeqs(data) {
+			if _, ok := b.index[removed]; ok {
+				delete(b.index, removed)
+				b.dead++
+			}
+		}
+		b.dead++
+	})
+	if err != nil && err != errTornRecord && err != errCorruptedRecord {
+		return err
+	}
+
+	b.size = valid
+	if valid < info.Size() {
+		log.WithError(err).WithField("path", b.path).WithField("offset", valid).WithField("bytes", info.Size()-valid).
+			Warning("Disk buffer has invalid tail, truncating it")
+		if err := b.file.Truncate(valid); err != nil {
+			return err
+		}
+		if err := b.file.Sync(); err != nil {
+			return err
+		}
+	}
+	b.synced = b.size
+
+	return nil
+}
+
+// importBolt moves messages from BoltDB buffer file of previous versions to log file and removes BoltDB file
+func (b *WALBuffer) importBolt(dir string) error {
+	boltPath := filepath.Join(dir, boltBufferFile)
+	if _, err := os.Stat(boltPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	boltBuffer, err := NewBoltBuffer(dir)
+	if err != nil {
+		return err
+	}
+
+	imported := 0
+	err = boltBuffer.Each(func(_ uint64, data []byte) error {
+		b.seq++
+		offset := b.size
+		if err := b.write(walRecordAppend, b.seq, data); err != nil {
+			return err
+		}
+		b.index[b.seq] = walPosition{offset: offset + walRecordHeaderSize + walPayloadHeaderSize, size: len(data)}
+		imported++
+		return nil
+	})
+	if closeErr := boltBuffer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := b.file.Sync(); err != nil {
+		return err
+	}
+	b.synced = b.size
+
+	log.WithField("len", imported).Info("Moved messages from BoltDB disk buffer to write-ahead log")
+	return os.Remove(boltPath)
+}
+
+// write appends record to log file without syncing it, file is truncated back if write fails,
+// must be called with buffer locked
+func (b *WALBuffer) write(typ byte, seq uint64, data []byte) error {
+	record := encodeWALRecord(typ, seq, data)
+	if _, err := b.file.WriteAt(record, b.size); err != nil {
+		// partially written record would be truncated on open anyway, but next records must not follow it
+		b.file.Truncate(b.size)
+		return err
+	}
+	b.size += int64(len(record))
+
+	return nil
+}
+
+// sync waits until log file is synced up to the given offset of the record written in the given epoch, it fails
+// if the record is discarded after failed fsync
+func (b *WALBuffer) sync(offset int64, epoch uint64) error {
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+
+	b.Lock()
+	if b.epoch != epoch {
+		discard := b.discarded[epoch]
+		b.Unlock()
+		// record may be synced by another append before fsync of later records failed
+		if offset <= discard.synced {
+			return nil
+		}
+		return discard.err
+	}
+	if b.synced >= offset {
+		b.Unlock()
+		return nil
+	}
+	// everything written so far is synced at once, including appends that wait for syncMu
+	size := b.size
+	b.Unlock()
+
+	if err := b.file.Sync(); err != nil {
+		b.Lock()
+		b.discardUnsynced(err)
+		b.Unlock()
+		return err
+	}
+
+	b.Lock()
+	b.synced = size
+	b.Unlock()
+
+	return nil
+}
+
+// discardUnsynced truncates log file back to the last synced offset after failed fsync, as records written after it
+// are not durable, but page cache may still write them back, so they would be replayed on the next open. Appends
+// of discarded records fail, removals of synced records are lost, so those messages may be published twice.
+// It must be called with buffer and syncMu locked.
+func (b *WALBuffer) discardUnsynced(err error) {
+	for seq, position := range b.index {
+		if position.offset >= b.synced {
+			delete(b.index, seq)
+		}
+	}
+	if truncateErr := b.file.Truncate(b.synced); truncateErr != nil {
+		log.WithError(truncateErr).WithField("path", b.path).Error("Failed to truncate disk buffer after failed sync")
+	}
+	b.size = b.synced
+	if b.discarded == nil {
+		b.discarded = make(map[uint64]walDiscard)
+	}
+	b.discarded[b.epoch] = walDiscard{synced: b.synced, err: err}
+	b.epoch++
+}
+
+// compact rewrites log file with live records only and replaces it, must be called with buffer and syncMu locked
+func (b *WALBuffer) compact() error {
+	tmpPath := b.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[uint64]walPosition, len(b.index))
+	size, err := b.writeLive(tmp, index)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// old file is closed before rename, as open file can not be replaced on windows
+	b.file.Close()
+	renameErr := os.Rename(tmpPath, b.path)
+	if renameErr == nil {
+		syncDir(filepath.Dir(b.path))
+	} else {
+		os.Remove(tmpPath)
+	}
+
+	if b.file, err = os.OpenFile(b.path, os.O_RDWR, 0600); err != nil {
+		return err
+	}
+	if err := lockFile(b.file, boltOpenTimeout); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	// the only dead record is the one carrying last sequence number
+	b.index, b.size, b.synced, b.dead = index, size, size, 1
+
+	return nil
+}
+
+// writeLive writes log header and live records to file and returns written size
+func (b *WALBuffer) writeLive(file *os.File, index map[uint64]walPosition) (int64, error) {
+	w := bufio.NewWriter(file)
+	if _, err := w.WriteString(walMagic); err != nil {
+		return 0, err
+	}
+
+	size := int64(len(walMagic))
+	for _, seq := range b.sortedSeqs() {
+		position := b.index[seq]
+		data := make([]byte, position.size)
+		if _, err := b.file.ReadAt(data, position.offset); err != nil {
+			return 0, err
+		}
+
+		record := encodeWALRecord(walRecordAppend, seq, data)
+		if _, err := w.Write(record); err != nil {
+			return 0, err
+		}
+		index[seq] = walPosition{offset: size + walRecordHeaderSize + walPayloadHeaderSize, size: len(data)}
+		size += int64(len(record))
+	}
+
+	record := encodeWALRecord(walRecordRemove, b.seq, nil)
+	if _, err := w.Write(record); err != nil {
+		return 0, err
+	}
+	size += int64(len(record))
+
+	return size, w.Flush()
+}
+
+// sortedSeqs returns sequence numbers of live records in order they were appended, must be called with buffer locked
+func (b *WALBuffer) sortedSeqs() []uint64 {
+	seqs := make([]uint64, 0, len(b.index))
+	for seq := range b.index {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	return seqs
+}
+
+func readWALMagic(file *os.File) error {
+	magic := make([]byte, len(walMagic))
+	if _, err := file.ReadAt(magic, 0); err != nil || string(magic) != walMagic {
+		return ErrNotWAL
+	}
+
+	return nil
+}
+
+// scanWAL reads records following log header and calls fn for every valid one with offset of its data,
+// it returns size of valid part of the file and errTornRecord or errCorruptedRecord if the tail is invalid
+func scanWAL(file *os.File, fn func(typ byte, seq uint64, data []byte, offset int64)) (int64, error) {
+	offset := int64(len(walMagic))
+	r := bufio.NewReader(io.NewSectionReader(file, offset, 1<<62))
+
+	header := make([]byte, walRecordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return offset, errTornRecord
+			}
+			return offset, err
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		if length < walPayloadHeaderSize || length > walMaxPayloadSize {
+			return offset, errCorruptedRecord
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, errTornRecord
+			}
+			return offset, err
+		}
+		if crc32.Checksum(payload, walCRCTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return offset, errCorruptedRecord
+		}
+
+		typ := payload[0]
+		if typ != walRecordAppend && typ != walRecordRemove {
+			return offset, errCorruptedRecord
+		}
+
+		fn(typ, binary.BigEndian.Uint64(payload[1:9]), payload[walPayloadHeaderSize:], offset+walRecordHeaderSize+walPayloadHeaderSize)
+		offset += walRecordHeaderSize + int64(length)
+	}
+}
+
+// encodeWALRecord encodes record as payload length, payload CRC32C and payload - record type, sequence number and data
+func encodeWALRecord(typ byte, seq uint64, data []byte) []byte {
+	record := make([]byte, walRecordHeaderSize+walPayloadHeaderSize+len(data))
+	payload := record[walRecordHeaderSize:]
+	payload[0] = typ
+	binary.BigEndian.PutUint64(payload[1:9], seq)
+	copy(payload[walPayloadHeaderSize:], data)
+
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, walCRCTable))
+
+	return record
+}
+
+func decodeSeqs(data []byte) []uint64 {
+	seqs := make([]uint64, 0, len(data)/8)
+	for i := 0; i+8 <= len(data); i += 8 {
+		seqs = append(seqs, binary.BigEndian.Uint64(data[i:i+8]))
+	}
+
+	return seqs
+}
+
+// syncDir syncs directory, so file rename in it is durable, errors are ignored as not every platform supports it
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
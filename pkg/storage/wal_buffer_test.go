@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readWALBuffer(t *testing.T, buffer *WALBuffer) []string {
+	var data []string
+	err := buffer.Each(func(seq uint64, value []byte) error {
+		data = append(data, string(value))
+		return nil
+	})
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestWALBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-buffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buffer, err := NewWALBuffer(dir)
+	require.NoError(t, err)
+
+	var seqs []uint64
+	for _, data := range []string{"first", "second", "third"} {
+		seq, err := buffer.Append([]byte(data))
+		require.NoError(t, err)
+		seqs = append(seqs, seq)
+	}
+	assert.True(t, seqs[0] > 0)
+	assert.True(t, seqs[0] < seqs[1] && seqs[1] < seqs[2])
+
+	require.NoError(t, buffer.Remove(seqs[1]))
+	require.NoError(t, buffer.Remove())
+	assert.Equal(t, []string{"first", "third"}, readWALBuffer(t, buffer))
+	require.NoError(t, buffer.Close())
+
+	// buffer content survives reopening
+	buffer, err = NewWALBuffer(dir)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	assert.Equal(t, []string{"first", "third"}, readWALBuffer(t, buffer))
+
+	seq, err := buffer.Append([]byte("fourth"))
+	require.NoError(t, err)
+	assert.True(t, seq > seqs[2])
+}
+
+func TestWALBuffer_tornTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-buffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buffer, err := NewWALBuffer(dir)
+	require.NoError(t, err)
+	_, err = buffer.Append([]byte("first"))
+	require.NoError(t, err)
+	_, err = buffer.Append([]byte("second"))
+	require.NoError(t, err)
+	require.NoError(t, buffer.Close())
+
+	// power loss in the middle of the last record write
+	path := filepath.Join(dir, walBufferFile)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	report, err := VerifyWALBuffer(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Records)
+	assert.Equal(t, 1, report.Live)
+	assert.Equal(t, int64(walRecordHeaderSize+walPayloadHeaderSize+len("second")-3), report.InvalidBytes)
+	assert.Equal(t, errTornRecord.Error(), report.TailError)
+
+	buffer, err = NewWALBuffer(dir)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	assert.Equal(t, []string{"first"}, readWALBuffer(t, buffer))
+
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, report.ValidBytes, info.Size())
+
+	_, err = buffer.Append([]byte("third"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "third"}, readWALBuffer(t, buffer))
+}
+
+func TestWALBuffer_corruptedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-buffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buffer, err := NewWALBuffer(dir)
+	require.NoError(t, err)
+	for _, data := range []string{"first", "second", "third"} {
+		_, err := buffer.Append([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, buffer.Close())
+
+	// flip a byte in data of the second record, it is dropped together with records after it
+	path := filepath.Join(dir, walBufferFile)
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	recordSize := walRecordHeaderSize + walPayloadHeaderSize + len("first")
+	content[len(walMagic)+recordSize+walRecordHeaderSize+walPayloadHeaderSize] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(path, content, 0600))
+
+	report, err := VerifyWALBuffer(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Records)
+	assert.Equal(t, int64(len(walMagic)+recordSize), report.ValidBytes)
+	assert.Equal(t, errCorruptedRecord.Error(), report.TailError)
+
+	buffer, err = NewWALBuffer(dir)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	assert.Equal(t, []string{"first"}, readWALBuffer(t, buffer))
+}
+
+func TestWALBuffer_failedSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-buffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buffer, err := NewWALBuffer(dir)
+	require.NoError(t, err)
+	_, err = buffer.Append([]byte("first"))
+	require.NoError(t, err)
+
+	write := func(data string) (int64, uint64) {
+		require.NoError(t, buffer.write(walRecordAppend, buffer.seq+1, []byte(data)))
+		buffer.seq++
+		buffer.index[buffer.seq] = walPosition{offset: buffer.size - int64(len(data)), size: len(data)}
+		return buffer.size, buffer.epoch
+	}
+
+	// second record is synced by concurrent append before its own append waits for sync, while fsync of the third
+	// record fails
+	buffer.Lock()
+	syncedEnd, syncedEpoch := write("second")
+	require.NoError(t, buffer.file.Sync())
+	buffer.synced = buffer.size
+	end, epoch := write("third")
+	syncErr := errors.New("input/output error")
+	buffer.discardUnsynced(syncErr)
+	buffer.Unlock()
+
+	assert.NoError(t, buffer.sync(syncedEnd, syncedEpoch))
+	assert.Equal(t, syncErr, buffer.sync(end, epoch))
+	assert.Equal(t, []string{"first", "second"}, readWALBuffer(t, buffer))
+
+	_, err = buffer.Append([]byte("fourth"))
+	require.NoError(t, err)
+	require.NoError(t, buffer.Close())
+
+	// record that was not synced is not replayed
+	buffer, err = NewWALBuffer(dir)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	assert.Equal(t, []string{"first", "second", "fourth"}, readWALBuffer(t, buffer))
+}
+
+func TestWALBuffer_compact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-buffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buffer, err := NewWALBuffer(dir)
+	require.NoError(t, err)
+
+	kept, err := buffer.Append([]byte("kept"))
+	require.NoError(t, err)
+
+	var last uint64
+	for i := 0; i < walCompactMinDead; i++ {
+		last, err = buffer.Append([]byte("removed"))
+		require.NoError(t, err)
+		require.NoError(t, buffer.Remove(last))
+	}
+	assert.True(t, buffer.dead < walCompactMinDead)
+
+	info, err := os.Stat(filepath.Join(dir, walBufferFile))
+	require.NoError(t, err)
+	assert.Equal(t, buffer.size, info.Size())
+	assert.Equal(t, []string{"kept"}, readWALBuffer(t, buffer))
+
+	require.NoError(t, buffer.Remove(kept))
+	require.NoError(t, buffer.Close())
+
+	// sequence numbers do not go back after compaction
+	buffer, err = NewWALBuffer(dir)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	assert.Empty(t, readWALBuffer(t, buffer))
+	seq, err := buffer.Append([]byte("next"))
+	require.NoError(t, err)
+	assert.True(t, seq > last)
+}
+
+func TestWALBuffer_importBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-buffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	boltBuffer, err := NewBoltBuffer(dir)
+	require.NoError(t, err)
+	for _, data := range []string{"first", "second"} {
+		_, err := boltBuffer.Append([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, boltBuffer.Close())
+
+	buffer, err := NewWALBuffer(dir)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	assert.Equal(t, []string{"first", "second"}, readWALBuffer(t, buffer))
+
+	_, err = os.Stat(filepath.Join(dir, boltBufferFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestVerifyWALBuffer_notWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kandalf-buffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, walBufferFile), []byte("garbage"), 0600))
+
+	_, err = VerifyWALBuffer(dir)
+	assert.Equal(t, ErrNotWAL, err)
+
+	_, err = NewWALBuffer(dir)
+	assert.Equal(t, ErrNotWAL, err)
+}
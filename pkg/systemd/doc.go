@@ -0,0 +1,5 @@
+/*
+Package systemd holds systemd service manager notifications for services with "Type=notify", so systemd knows when
+kandalf is ready, reloading or stopping, and restarts it once it stops responding to the watchdog.
+*/
+package systemd
@@ -0,0 +1,87 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Service states notified to systemd, see sd_notify(3)
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to systemd over socket from NOTIFY_SOCKET environment variable, it returns false without
+// error if the variable is not set, i.e. service is not started by systemd or its type is not "notify"
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if socket[0] == '@' {
+		// abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// NotifyState sends state to systemd and logs failure, as notifications are best effort
+func NotifyState(state string) {
+	if _, err := Notify(state); err != nil {
+		log.WithError(err).WithField("state", state).Warning("Failed to notify systemd")
+	}
+}
+
+// WatchdogInterval returns watchdog timeout from WATCHDOG_USEC environment variable set by systemd for services
+// with "WatchdogSec=", it returns 0 if watchdog is not enabled or it is enabled for another process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// GoWatchdog pings systemd watchdog every half of its timeout in background as long as alive check passes,
+// so service that hangs misses pings and gets restarted by systemd
+func GoWatchdog(timeout time.Duration, alive func() error, interrupt chan bool) {
+	ticker := time.NewTicker(timeout / 2)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-interrupt:
+				return
+			case <-ticker.C:
+				if err := alive(); err != nil {
+					log.WithError(err).Warning("Service is not alive, skipping systemd watchdog ping")
+					continue
+				}
+				NotifyState(Watchdog)
+			}
+		}
+	}()
+}
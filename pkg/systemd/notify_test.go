@@ -0,0 +1,101 @@
+package systemd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errHung = errors.New("service is hung")
+
+// listen listens notify socket set to NOTIFY_SOCKET, returned func closes it
+func listen(t *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "kandalf-systemd")
+	require.NoError(t, err)
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	os.Setenv("NOTIFY_SOCKET", socket)
+
+	return conn, func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func read(t *testing.T, conn *net.UnixConn) string {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	sent, err := Notify(Ready)
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	conn, closeSocket := listen(t)
+	defer closeSocket()
+	sent, err = Notify(Ready)
+	assert.NoError(t, err)
+	assert.True(t, sent)
+	assert.Equal(t, Ready, read(t, conn))
+
+	os.Setenv("NOTIFY_SOCKET", "/nonexistent/notify.sock")
+	_, err = Notify(Stopping)
+	assert.Error(t, err)
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	assert.Zero(t, WatchdogInterval())
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	assert.Equal(t, 30*time.Second, WatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	assert.Equal(t, 30*time.Second, WatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", "1")
+	assert.Zero(t, WatchdogInterval())
+}
+
+func TestGoWatchdog(t *testing.T) {
+	conn, closeSocket := listen(t)
+	defer closeSocket()
+	interrupt := make(chan bool)
+	defer close(interrupt)
+
+	alive := make(chan error, 1)
+	alive <- nil
+	GoWatchdog(20*time.Millisecond, func() error {
+		select {
+		case err := <-alive:
+			return err
+		default:
+			return errHung
+		}
+	}, interrupt)
+
+	assert.Equal(t, Watchdog, read(t, conn))
+
+	// no pings are sent while service is not alive
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err := conn.Read(make([]byte, 64))
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/propagators"
+)
+
+// propagator propagates trace context in W3C Trace Context and Baggage formats
+var propagator = otel.NewCompositeTextMapPropagator(propagators.TraceContext{}, propagators.Baggage{})
+
+// AMQPHeaders is trace context carrier of AMQP message headers, only string and bytes values are read
+type AMQPHeaders map[string]interface{}
+
+// Get returns header value as string, empty for missing and non-string headers
+func (h AMQPHeaders) Get(key string) string {
+	switch v := h[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+
+	return ""
+}
+
+// Set sets header value
+func (h AMQPHeaders) Set(key, value string) {
+	h[key] = value
+}
+
+// KafkaHeaders is trace context carrier of Kafka record headers
+type KafkaHeaders map[string]string
+
+// Get returns header value, empty for missing header
+func (h KafkaHeaders) Get(key string) string {
+	return h[key]
+}
+
+// Set sets header value
+func (h KafkaHeaders) Set(key, value string) {
+	h[key] = value
+}
+
+// Extract returns context with trace context from carrier headers
+func Extract(ctx context.Context, carrier otel.TextMapCarrier) context.Context {
+	return propagator.Extract(ctx, carrier)
+}
+
+// Inject returns copy of Kafka headers with trace context of span from ctx, headers are not modified, so they may be
+// shared with messages being published. Headers are returned as is if ctx has no valid span, e.g. tracing is disabled.
+func Inject(ctx context.Context, headers map[string]string) map[string]string {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return headers
+	}
+
+	carrier := make(KafkaHeaders, len(headers)+2)
+	for k, v := range headers {
+		carrier[k] = v
+	}
+	propagator.Inject(ctx, carrier)
+
+	return carrier
+}
@@ -0,0 +1,6 @@
+/*
+Package tracing holds OpenTelemetry tracing of messages bridged from RabbitMQ to Kafka. Trace context is extracted
+from AMQP message headers, carried with buffered message in its Kafka headers and injected into published Kafka
+record headers, so consumers continue traces started by publishers.
+*/
+package tracing
@@ -0,0 +1,141 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+const (
+	instrumentationName = "github.com/hellofresh/kandalf"
+
+	// shutdownTimeout is max amount of time to wait for exporter to send remaining spans on shutdown
+	shutdownTimeout = 5 * time.Second
+
+	// attemptsKey is span attribute with number of failed publish attempts of the message
+	attemptsKey = label.Key("kandalf.attempts")
+)
+
+func init() {
+	// global default provider lazily delegates tracers it has created to the provider set later without
+	// synchronisation with spans being started, so noop provider is set up front and replaced atomically by Init
+	global.SetTracerProvider(trace.NoopTracerProvider())
+}
+
+// Init sets global tracer provider exporting spans to OTLP collector up, it returns function that exports remaining
+// spans and closes collector connection. Spans are not recorded and trace context is not propagated
+// if tracing is disabled.
+func Init(tracingConfig config.TracingConfig, nodeID string) (func(), error) {
+	if !tracingConfig.Enabled {
+		return func() {}, nil
+	}
+
+	options := []otlp.ExporterOption{otlp.WithAddress(tracingConfig.OTLPEndpoint)}
+	if tracingConfig.OTLPInsecure {
+		options = append(options, otlp.WithInsecure())
+	}
+	exporter, err := otlp.NewExporter(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	processor := sdktrace.NewBatchSpanProcessor(exporter)
+	global.SetTracerProvider(sdktrace.NewTracerProvider(
+		// traces started by publishers keep their sampling decision, so they are either complete or not recorded at all
+		sdktrace.WithConfig(sdktrace.Config{
+			DefaultSampler: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tracingConfig.SampleRatio)),
+		}),
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(resource.New(
+			semconv.ServiceNameKey.String(tracingConfig.ServiceName),
+			semconv.ServiceInstanceIDKey.String(nodeID),
+		)),
+	))
+	global.SetTextMapPropagator(propagator)
+
+	return func() {
+		processor.Shutdown()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := exporter.Shutdown(ctx); err != nil {
+			log.WithError(err).Error("Got error on shutting tracing exporter down")
+		}
+	}, nil
+}
+
+func tracer() trace.Tracer {
+	return global.Tracer(instrumentationName)
+}
+
+// StartConsume starts span of handling AMQP message consumed from queue,
+// it is child of trace context from message headers, if there is any
+func StartConsume(delivery amqp.Delivery, queue string) (context.Context, trace.Span) {
+	ctx := Extract(context.Background(), AMQPHeaders(delivery.Headers))
+	return tracer().Start(ctx, "consume",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("rabbitmq"),
+			semconv.MessagingDestinationKey.String(queue),
+			semconv.MessagingDestinationKindKeyQueue,
+			semconv.MessagingOperationProcess,
+			semconv.MessagingRabbitMQRoutingKeyKey.String(delivery.RoutingKey),
+			semconv.MessagingMessageIDKey.String(delivery.MessageID),
+		),
+	)
+}
+
+// Start starts span of message handling stage, it is child of span from ctx
+func Start(ctx context.Context, stage string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, stage)
+}
+
+// Buffered records span of time message spent in buffer since it has been created till now, when it is taken to be
+// published. Span is child of trace context from message headers, so buffered messages traces survive restarts.
+func Buffered(msg *producer.Message) {
+	options := []trace.SpanOption{trace.WithAttributes(attemptsKey.Int(msg.Attempts))}
+	if msg.CreatedAt > 0 {
+		options = append(options, trace.WithTimestamp(time.Unix(0, msg.CreatedAt)))
+	}
+
+	_, span := tracer().Start(messageContext(msg), "buffer", options...)
+	span.End()
+}
+
+// StartPublish starts span of publishing message to Kafka, it is child of trace context from message headers
+func StartPublish(msg *producer.Message) (context.Context, trace.Span) {
+	return tracer().Start(messageContext(msg), "publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("kafka"),
+			semconv.MessagingDestinationKey.String(msg.Topic),
+			semconv.MessagingDestinationKindKeyTopic,
+			semconv.MessagingMessageIDKey.String(msg.ID.String()),
+			attemptsKey.Int(msg.Attempts),
+		),
+	)
+}
+
+// End ends span, marking it as failed if err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(context.Background(), err, trace.WithErrorStatus(codes.Error))
+	}
+	span.End()
+}
+
+func messageContext(msg *producer.Message) context.Context {
+	return Extract(context.Background(), KafkaHeaders(msg.Headers))
+}
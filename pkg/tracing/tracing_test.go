@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+)
+
+// setUpTracer sets global tracer provider exporting spans to returned exporter synchronously, returned function
+// restores noop provider
+func setUpTracer() (*tracetest.InMemoryExporter, func()) {
+	exporter := tracetest.NewInMemoryExporter()
+	global.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithSyncer(exporter),
+	))
+
+	return exporter, func() { global.SetTracerProvider(trace.NoopTracerProvider()) }
+}
+
+func TestAMQPHeaders(t *testing.T) {
+	headers := AMQPHeaders{"traceparent": []byte(traceparent), "tracestate": "vendor=value", "retries": int32(3)}
+
+	assert.Equal(t, traceparent, headers.Get("traceparent"))
+	assert.Equal(t, "vendor=value", headers.Get("tracestate"))
+	assert.Equal(t, "", headers.Get("retries"))
+	assert.Equal(t, "", headers.Get("baggage"))
+	assert.Equal(t, "", AMQPHeaders(nil).Get("traceparent"))
+}
+
+func TestInject(t *testing.T) {
+	headers := map[string]string{"customer-id": "c-1"}
+	assert.Equal(t, headers, Inject(context.Background(), headers))
+	assert.Nil(t, Inject(context.Background(), nil))
+
+	// remote trace context is continued only by started span
+	ctx := Extract(context.Background(), KafkaHeaders{"traceparent": traceparent})
+	assert.Nil(t, Inject(ctx, nil))
+
+	_, tearDown := setUpTracer()
+	defer tearDown()
+
+	ctx, span := Start(ctx, "transform")
+	defer span.End()
+	expected := "00-" + traceID + "-" + span.SpanContext().SpanID.String() + "-01"
+	assert.Equal(t, map[string]string{"traceparent": expected}, Inject(ctx, nil))
+	assert.Equal(t, map[string]string{"customer-id": "c-1", "traceparent": expected}, Inject(ctx, headers))
+	// headers may be shared with published messages, so they are copied
+	assert.Equal(t, map[string]string{"customer-id": "c-1"}, headers)
+}
+
+func TestInit_disabled(t *testing.T) {
+	shutdown, err := Init(config.TracingConfig{}, "kandalf-1")
+	require.NoError(t, err)
+	shutdown()
+
+	_, span := StartConsume(amqp.Delivery{Headers: map[string]interface{}{"traceparent": traceparent}}, "orders")
+	assert.False(t, span.SpanContext().IsValid())
+}
+
+func TestSpans(t *testing.T) {
+	exporter, tearDown := setUpTracer()
+	defer tearDown()
+
+	delivery := amqp.Delivery{
+		RoutingKey: "order.created",
+		MessageID:  "m-1",
+		Headers:    map[string]interface{}{"traceparent": traceparent},
+	}
+	ctx, consumeSpan := StartConsume(delivery, "orders")
+	_, transformSpan := Start(ctx, "transform")
+	End(transformSpan, nil)
+
+	msg := producer.NewMessage([]byte("body"), "orders")
+	msg.CreatedAt = time.Now().Add(-time.Second).UnixNano()
+	msg.Headers = Inject(ctx, msg.Headers)
+	End(consumeSpan, nil)
+
+	Buffered(msg)
+	_, publishSpan := StartPublish(msg)
+	End(publishSpan, errors.New("kafka is down"))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+		assert.Equal(t, traceID, span.SpanContext.TraceID.String())
+	}
+	assert.Equal(t, []string{"transform", "consume", "buffer", "publish"}, names)
+
+	transform, consume, buffer, publish := spans[0], spans[1], spans[2], spans[3]
+	assert.Equal(t, "00f067aa0ba902b7", consume.ParentSpanID.String())
+	assert.Equal(t, trace.SpanKindConsumer, consume.SpanKind)
+	assert.Equal(t, consume.SpanContext.SpanID, transform.ParentSpanID)
+	assert.Equal(t, consume.SpanContext.SpanID, buffer.ParentSpanID)
+	assert.Equal(t, time.Unix(0, msg.CreatedAt), buffer.StartTime)
+	assert.Equal(t, consume.SpanContext.SpanID, publish.ParentSpanID)
+	assert.Equal(t, trace.SpanKindProducer, publish.SpanKind)
+	assert.Equal(t, codes.Error, publish.StatusCode)
+	assert.NotEqual(t, codes.Error, consume.StatusCode)
+}
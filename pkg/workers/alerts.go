@@ -0,0 +1,51 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/alerts"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetAlerter sets alerter notified when circuit breaker opens, publishes fail for WorkerConfig.PublishFailureAlert
+// and buffer alert threshold is breached
+func (w *BridgeWorker) SetAlerter(alerter alerts.Alerter) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.alerter = alerter
+}
+
+// alert notifies alerter about event if it is set, must be called with worker locked
+func (w *BridgeWorker) alert(event alerts.Event) {
+	if w.alerter != nil {
+		w.alerter.Alert(event)
+	}
+}
+
+// alertPublishFailures alerts once all publishes fail for longer than alert threshold, alert is fired again only
+// after publishing recovers and fails for the threshold again, must be called with worker locked
+func (w *BridgeWorker) alertPublishFailures(now time.Time) {
+	if !w.kafkaFailing {
+		w.kafkaFailingSince = time.Time{}
+		w.publishFailureAlerted = false
+		return
+	}
+	if w.kafkaFailingSince.IsZero() {
+		w.kafkaFailingSince = now
+	}
+
+	failing := now.Sub(w.kafkaFailingSince)
+	if w.config.PublishFailureAlert <= 0 || w.publishFailureAlerted || failing < w.config.PublishFailureAlert {
+		return
+	}
+	w.publishFailureAlerted = true
+
+	log.WithField("failing", failing.String()).Warning("Kafka publishes have been failing for alert threshold")
+	w.alert(alerts.Event{
+		Type:     alerts.EventPublishFailing,
+		Severity: alerts.SeverityCritical,
+		Summary:  "Kafka publishes are failing for " + failing.Round(time.Second).String(),
+		Details:  map[string]interface{}{"buffered": len(w.cache) + w.inFlight},
+	})
+}
@@ -0,0 +1,60 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/alerts"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingAlerter records types of events it is alerted about
+type recordingAlerter struct {
+	events []string
+}
+
+func (a *recordingAlerter) Alert(event alerts.Event) {
+	a.events = append(a.events, event.Type)
+}
+
+func TestBridgeWorker_alerts(t *testing.T) {
+	workerConfig := config.WorkerConfig{
+		CircuitBreakerThreshold:     2,
+		CircuitBreakerProbeInterval: time.Hour,
+		BufferAlertMessages:         1,
+		PublishFailureAlert:         time.Minute,
+	}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	alerter := &recordingAlerter{}
+	worker.SetAlerter(alerter)
+
+	outage := errors.New("kafka is not available")
+	worker.recordPublishResults([]error{outage})
+	assert.Empty(t, alerter.events)
+
+	worker.recordPublishResults([]error{outage})
+	assert.Equal(t, []string{alerts.EventCircuitOpen}, alerter.events)
+
+	// failing for longer than threshold is alerted once
+	worker.kafkaFailingSince = time.Now().Add(-2 * time.Minute)
+	worker.recordPublishResults([]error{outage})
+	worker.recordPublishResults([]error{outage})
+	assert.Equal(t, []string{alerts.EventCircuitOpen, alerts.EventPublishFailing}, alerter.events)
+
+	// recovery resets failure alert
+	worker.recordPublishResults([]error{nil})
+	assert.True(t, worker.kafkaFailingSince.IsZero())
+	assert.False(t, worker.publishFailureAlerted)
+
+	worker.cache = generateRandomMessages(1)
+	worker.reportBuffer()
+	worker.reportBuffer()
+	assert.Equal(t, []string{alerts.EventCircuitOpen, alerts.EventPublishFailing, alerts.EventBufferAlert}, alerter.events)
+}
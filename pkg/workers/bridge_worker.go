@@ -1,46 +1,177 @@
 package workers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/hellofresh/kandalf/pkg/alerts"
+	"github.com/hellofresh/kandalf/pkg/amqp"
 	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/dedup"
 	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/kandalf/pkg/replication"
+	"github.com/hellofresh/kandalf/pkg/schema"
 	"github.com/hellofresh/kandalf/pkg/storage"
+	"github.com/hellofresh/kandalf/pkg/tracing"
 	"github.com/hellofresh/stats-go/bucket"
 	"github.com/hellofresh/stats-go/client"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/api/trace"
 )
 
 const (
 	statsWorkerSection = "worker"
+
+	// originalSizeHeader is a header with original body size of truncated message
+	originalSizeHeader = "x-kandalf-original-size"
+
+	// errorHeader is error topic message header with publish error
+	errorHeader = "x-kandalf-error"
+	// originalTopicHeader is error topic message header with topic message failed to be published to
+	originalTopicHeader = "x-kandalf-original-topic"
+	// failedAtHeader is error topic message header with time of failed publish, formatted as RFC 3339
+	failedAtHeader = "x-kandalf-failed-at"
+
+	// drainCheckInterval is a pause between checks if buffered messages are published on drain
+	drainCheckInterval = 100 * time.Millisecond
 )
 
 var (
-	errMarshalMessage = errors.New("failed to marshal message")
-	errPutToStorage   = errors.New("failed to put message to storage")
+	errMarshalMessage        = errors.New("failed to marshal message")
+	errPutToStorage          = errors.New("failed to put message to storage")
+	errUnknownOverflowPolicy = errors.New("unknown buffer overflow policy, supported policies are block, drop-oldest, drop-newest and spill-to-disk")
 )
 
+// bufferSeqs are durable buffer sequence numbers of messages that are either cached or being published
+type bufferSeqs map[*producer.Message]uint64
+
+// messageSet is a set of messages
+type messageSet map[*producer.Message]struct{}
+
 // BridgeWorker contains data for bridge worker that does the actual job - handles messages transfer
 // from RabbitMQ to Kafka
 type BridgeWorker struct {
+	// cycledAt is time of the last worker loop cycle as Unix time in nanoseconds, it is accessed atomically,
+	// so it goes first to be 64-bit aligned
+	cycledAt int64
+
 	sync.Mutex
 
 	config      config.WorkerConfig
 	storage     storage.PersistentStorage
+	buffer      storage.Buffer
+	replica     storage.Buffer
 	producer    producer.Producer
+	encoder     schema.Encoder
 	statsClient client.Client
 
 	cache             []*producer.Message
 	lastFlush         time.Time
 	readStorageTicker *time.Ticker
+
+	// inFlight is number of messages taken from cache and being published
+	inFlight int
+	// bufferedBytes is total body size of cached and in-flight messages
+	bufferedBytes int
+	// publishing are in-flight messages, they are tracked for buffer age metric
+	publishing messageSet
+	// bufferAlerting is true while buffer alert threshold is breached, so breach is reported once
+	bufferAlerting bool
+	// resumed is closed when paused consumption is resumed, it is nil when consumption is not paused
+	resumed chan struct{}
+	// templates are parsed pipe topic and transform templates mapped by template text
+	templates sync.Map
+	// filters are compiled pipe filter expressions mapped by expression text
+	filters sync.Map
+	// routePatterns are compiled pipe route patterns mapped by pattern text
+	routePatterns sync.Map
+	// buffered are disk buffer sequence numbers of messages of pipes without replication
+	buffered bufferSeqs
+	// replicated are replica sequence numbers of messages of pipes with replication enabled
+	replicated bufferSeqs
+	// pending are deferred AMQP deliveries settlements of end-to-end messages that are either cached or being published
+	pending settlements
+	// circuit is circuit breaker state, it is opened when consecutive publish failures reach threshold
+	circuit CircuitState
+	// circuitOpenedAt is time circuit was opened at, Kafka is probed once probe interval passes since then
+	circuitOpenedAt time.Time
+	// publishFailures is number of consecutive retriable publish failures
+	publishFailures int
+	// kafkaFailing is true while the last published batch saying anything about Kafka availability failed entirely
+	kafkaFailing bool
+	// kafkaFailingSince is time Kafka started failing at, zero while it is not failing
+	kafkaFailingSince time.Time
+	// publishFailureAlerted is true once Kafka failing is alerted, so it is alerted once until publishing recovers
+	publishFailureAlerted bool
+	// draining is true once worker stops accepting new messages to publish buffered ones before exit
+	draining bool
+	// blockedConnections are DSNs of AMQP connections blocked by broker, consumption is paused while there are any,
+	// as messages settlements, e.g. retry queues publishes, hang on blocked connection
+	blockedConnections map[string]bool
+	// rateLimiters are pipes consumption rate limiters mapped by pipe queue
+	rateLimiters sync.Map
+	// dedupKeys is dedup window of published messages of pipes without replication
+	dedupKeys dedup.Store
+	// orderKeys are order keys of ordered messages being published, other messages with these keys are held in cache
+	orderKeys map[string]struct{}
+	// pipeStates are pipes consumption states mapped by pipe queue
+	pipeStates map[string]*pipeState
+	// plugins are pipes plugins instances mapped by pipe queue
+	plugins sync.Map
+	// topics are publishing traffic counters mapped by topic
+	topics map[string]*traffic
+	// sampler picks messages of pipes sampled for debugging
+	sampler *sampler
+	// alerter is notified about critical events, nil if alerts are not configured
+	alerter alerts.Alerter
+}
+
+// NewBridgeWorker creates instance of BridgeWorker, buffer, replica and encoder may be nil if disk buffer is disabled
+// and there are no pipes with replication or schema. Messages left in buffer by previous run are recovered to cache,
+// while replicated messages are recovered with RecoverReplica once instance becomes replication leader.
+func NewBridgeWorker(config config.WorkerConfig, storage storage.PersistentStorage, buffer storage.Buffer, replica storage.Buffer, producer producer.Producer, encoder schema.Encoder, statsClient client.Client) (*BridgeWorker, error) {
+	if err := ValidateConfig(config); err != nil {
+		return nil, err
+	}
+
+	w := &BridgeWorker{
+		config:      config,
+		storage:     storage,
+		buffer:      buffer,
+		replica:     replica,
+		producer:    producer,
+		encoder:     encoder,
+		statsClient: statsClient,
+		replicated:  make(bufferSeqs),
+		pending:     make(settlements),
+		publishing:  make(messageSet),
+		dedupKeys:   dedup.NewMemoryStore(config.DedupWindow, config.DedupMaxKeys),
+		orderKeys:   make(map[string]struct{}),
+		pipeStates:  make(map[string]*pipeState),
+		topics:      make(map[string]*traffic),
+		sampler:     newSampler(config.SampleMaxRate),
+	}
+
+	if buffer != nil {
+		if err := w.recoverBuffer(); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
 }
 
-// NewBridgeWorker creates instance of BridgeWorker
-func NewBridgeWorker(config config.WorkerConfig, storage storage.PersistentStorage, producer producer.Producer, statsClient client.Client) (*BridgeWorker, error) {
-	return &BridgeWorker{config: config, storage: storage, producer: producer, statsClient: statsClient}, nil
+// ValidateConfig checks worker configuration values that can not be checked on configuration load
+func ValidateConfig(config config.WorkerConfig) error {
+	if !validOverflowPolicy(config.BufferOverflowPolicy) {
+		return errUnknownOverflowPolicy
+	}
+
+	return nil
 }
 
 // Execute runs the service logic once in sync way
@@ -48,34 +179,89 @@ func (w *BridgeWorker) Execute() {
 	w.Lock()
 	defer w.Unlock()
 
+	if !w.canPublish(time.Now()) {
+		// messages are kept in cache until Kafka recovers
+		return
+	}
+
 	if len(w.cache) >= w.config.CacheSize || time.Now().Sub(w.lastFlush) >= w.config.CacheFlushTimeout {
 		log.WithFields(log.Fields{"len": len(w.cache), "last_flush": w.lastFlush}).
 			Debug("Flushing worker cache to Kafka")
 
-		if len(w.cache) > 0 {
-			// copy workers cache to local cache to avoid long locking for worker cache,
-			// as all incoming messages will be waiting for network communication with kafka/storage
-			messages := make([]*producer.Message, len(w.cache))
-			copy(messages, w.cache)
-			w.cache = []*producer.Message{}
+		w.flushCache()
+		w.lastFlush = time.Now()
+	}
+}
+
+// flushCache publishes all cached messages in go-routine, must be called with worker locked
+func (w *BridgeWorker) flushCache() {
+	if len(w.cache) == 0 {
+		return
+	}
+
+	// take messages from workers cache to local cache to avoid long locking for worker cache,
+	// as all incoming messages will be waiting for network communication with kafka/storage
+	messages := w.takeBatch()
+	if len(messages) == 0 {
+		// all cached messages are held until messages with their keys are published
+		return
+	}
+	w.inFlight += len(messages)
+	for _, msg := range messages {
+		w.publishing[msg] = struct{}{}
+	}
+
+	go w.publishMessages(messages)
+}
+
+// Drain stops accepting new messages and publishes buffered ones, it returns false if some messages are still
+// buffered once timeout passes, they are moved to storage or requeued on Close. Worker loop must be stopped
+// before draining, so messages are flushed regardless of cache size and circuit breaker.
+func (w *BridgeWorker) Drain(timeout time.Duration) bool {
+	w.Lock()
+	log.WithField("len", len(w.cache)+w.inFlight).WithField("timeout", timeout).Info("Draining bridge worker")
+	w.draining = true
+	w.applyBackpressure()
+	w.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		w.Lock()
+		w.flushCache()
+		buffered := len(w.cache) + w.inFlight
+		w.Unlock()
 
-			go w.publishMessages(messages)
+		if buffered == 0 {
+			log.Info("Bridge worker drained")
+			return true
 		}
-		w.lastFlush = time.Now()
+		if !time.Now().Before(deadline) {
+			log.WithField("len", buffered).Warning("Failed to drain bridge worker within timeout")
+			return false
+		}
+
+		time.Sleep(drainCheckInterval)
 	}
 }
 
 // Go runs the service forever in async way in go-routine
 func (w *BridgeWorker) Go(interrupt chan bool) {
 	w.readStorageTicker = time.NewTicker(w.config.StorageReadTimeout)
+	reportTicker := time.NewTicker(bufferReportInterval)
+	w.markCycle()
 
 	go func() {
+		defer reportTicker.Stop()
+
 		for {
+			w.markCycle()
 			select {
 			case <-interrupt:
 				return
 			case <-w.readStorageTicker.C:
 				w.populateCacheFromStorage()
+			case <-reportTicker.C:
+				w.reportBuffer()
 			default:
 				w.Execute()
 			}
@@ -98,36 +284,577 @@ func (w *BridgeWorker) Close() error {
 	// do not unlock cache anymore as we're closing everything
 	w.Lock()
 	log.WithField("len", len(w.cache)).Info("Storing unhandled messages to storage")
+	stored := make([]*producer.Message, 0, len(w.cache))
 	for _, msg := range w.cache {
-		// do not handle errors here as there is nothing we can do with errors at this point
-		w.storeMessage(msg)
+		if settle, ok := w.pending[msg]; ok {
+			// end-to-end message is not acknowledged yet, so it is redelivered by AMQP broker
+			delete(w.pending, msg)
+			settle(errWorkerClosed)
+			continue
+		}
+
+		// do not handle errors here as there is nothing we can do with errors at this point,
+		// messages that failed to be stored are kept in disk buffer, if it is enabled
+		if w.storeMessage(msg) == nil {
+			stored = append(stored, msg)
+		}
+	}
+
+	w.removeBuffered(w.bufferedSeqs(stored))
+	if w.buffer != nil {
+		if err := w.buffer.Close(); err != nil {
+			log.WithError(err).Error("Got error on closing disk buffer")
+		}
+	}
+	if w.replica != nil {
+		if err := w.replica.Close(); err != nil {
+			log.WithError(err).Error("Got error on closing replica")
+		}
 	}
 
 	return w.storage.Close()
 }
 
 // MessageHandler is a handler function for new messages from AMQP
-func (w *BridgeWorker) MessageHandler(body []byte, pipe config.Pipe) error {
-	return w.cacheMessage(producer.NewMessage(body, pipe.KafkaTopic))
+func (w *BridgeWorker) MessageHandler(delivery amqp.Delivery, pipe config.Pipe) error {
+	ctx, span := tracing.StartConsume(delivery, pipe.RabbitQueueName)
+	err := w.handleDelivery(ctx, delivery, pipe)
+	w.recordPipeTraffic(pipe, delivery, err)
+	if err == amqp.ErrAckDeferred {
+		tracing.End(span, nil)
+	} else {
+		tracing.End(span, err)
+	}
+
+	return err
+}
+
+// handleDelivery converts AMQP message to Kafka one and handles it, message carries trace context of ctx span
+// in its headers, so it is continued by buffer and publish spans
+func (w *BridgeWorker) handleDelivery(ctx context.Context, delivery amqp.Delivery, pipe config.Pipe) error {
+	w.waitResumed()
+	w.waitPipeResumed(pipe)
+
+	sampled := w.sampler.sample(rateLimitKey(pipe), time.Now())
+	if sampled {
+		logConsumed(pipe, delivery)
+	}
+
+	matches, err := w.filterMatches(pipe, delivery)
+	if err != nil {
+		// message can not be filtered on redelivery either
+		log.WithError(err).WithField("pipe", pipe.String()).
+			Warning("Failed to evaluate pipe filter, rejecting message")
+		return amqp.ErrRejectMessage
+	}
+	if matches {
+		if matches, err = w.pluginMatches(pipe, delivery); err != nil {
+			log.WithError(err).WithField("pipe", pipe.String()).Warning("Failed to filter message with pipe plugin")
+			return pluginResult(err)
+		}
+	}
+	if !matches {
+		// message is acknowledged, so it is dropped by broker
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"filter", "dropped", pipe.RabbitQueueName})
+		return nil
+	}
+
+	topic, err := w.topic(pipe, delivery)
+	if err != nil {
+		// message can not be routed on redelivery either
+		log.WithError(err).WithField("pipe", pipe.String()).
+			Warning("Failed to evaluate pipe topic template, rejecting message")
+		return amqp.ErrRejectMessage
+	}
+
+	w.throttle(pipe, topic)
+
+	body, err := w.transform(ctx, pipe, delivery)
+	if err != nil {
+		return err
+	}
+
+	msg := producer.NewMessage(body, topic)
+	msg.Pipe = rateLimitKey(pipe)
+	if !delivery.Timestamp.IsZero() {
+		msg.SentAt = delivery.Timestamp.UnixNano()
+	}
+	msg.Cluster = pipe.KafkaCluster
+	msg.Sink = pipe.Sink
+	msg.Delivery = pipe.KafkaDelivery
+	msg.ErrorTopic = pipe.KafkaErrorTopic
+	msg.Replicated = pipe.Replicated
+
+	key, err := partitionKey(pipe.KafkaPartitionKey, delivery)
+	if err != nil {
+		// message is still published, but without key, as it can not be fixed by redelivery
+		log.WithError(err).WithField("msg", msg.String()).WithField("partition_key", pipe.KafkaPartitionKey).
+			Warning("Failed to evaluate partition key, publishing message without key")
+	}
+	msg.Key = key
+	msg.OrderKey = orderKey(pipe, msg, delivery)
+
+	dedupKey, err := messageDedupKey(pipe.DedupKey, delivery)
+	if err != nil {
+		log.WithError(err).WithField("msg", msg.String()).WithField("dedup_key", pipe.DedupKey).
+			Warning("Failed to evaluate dedup key, publishing message without deduplication")
+	}
+	if dedupKey != "" {
+		// keys are scoped by queue, so equal ids of messages from different sources do not clash
+		msg.DedupKey = pipe.RabbitQueueName + ":" + dedupKey
+	}
+
+	timestamp, err := messageTimestamp(pipe.KafkaTimestamp, delivery)
+	if err != nil {
+		log.WithError(err).WithField("msg", msg.String()).WithField("timestamp", pipe.KafkaTimestamp).
+			Warning("Failed to evaluate message timestamp, publishing message with publish time")
+	}
+	if !timestamp.IsZero() {
+		msg.Timestamp = timestamp.UnixNano()
+	}
+
+	if pipe.KafkaHeaders != nil {
+		msg.Headers = recordHeaders(*pipe.KafkaHeaders, delivery)
+	}
+	msg.Headers = tracing.Inject(ctx, msg.Headers)
+	if sampled {
+		msg.Sampled = true
+		logTransformed(pipe, msg)
+	}
+
+	// without deferred settlement support from consumer end-to-end message is acknowledged once it is accepted,
+	// transactional message is acknowledged once its transaction is committed
+	var settle func(err error)
+	if pipe.KafkaDelivery == config.DeliveryEndToEnd || pipe.KafkaDelivery == config.DeliveryTransactional {
+		settle = delivery.Settle
+	}
+
+	if len(pipe.KafkaSinks) > 0 {
+		return w.fanOut(msg, pipe, delivery, settle)
+	}
+
+	return w.handleMessage(msg, pipe, delivery, settle)
+}
+
+// transform returns message body transformed with pipe transform template and plugin transformer
+func (w *BridgeWorker) transform(ctx context.Context, pipe config.Pipe, delivery amqp.Delivery) ([]byte, error) {
+	_, span := tracing.Start(ctx, "transform")
+	body, err := w.transformBody(pipe, delivery)
+	if err != nil {
+		tracing.End(span, err)
+		// message can not be transformed on redelivery either
+		log.WithError(err).WithField("pipe", pipe.String()).
+			Warning("Failed to evaluate pipe transform template, rejecting message")
+		return nil, amqp.ErrRejectMessage
+	}
+	if body, err = w.pluginTransform(pipe, delivery, body); err != nil {
+		tracing.End(span, err)
+		log.WithError(err).WithField("pipe", pipe.String()).Warning("Failed to transform message with pipe plugin")
+		return nil, pluginResult(err)
+	}
+	tracing.End(span, nil)
+
+	return body, nil
+}
+
+// handleMessage encodes message for its topic and accepts it, if it is not a duplicate of already published one,
+// dry run pipe message is logged instead
+func (w *BridgeWorker) handleMessage(msg *producer.Message, pipe config.Pipe, delivery amqp.Delivery, settle func(err error)) error {
+	if w.isDuplicate(msg) {
+		return nil
+	}
+
+	if pipe.KafkaEnvelope != "" {
+		body, err := envelopeBody(pipe.KafkaEnvelope, msg, delivery, w.config.NodeID)
+		if err != nil {
+			log.WithError(err).WithField("msg", msg.String()).Error("Failed to wrap message into envelope")
+			return err
+		}
+		msg.Body = body
+	}
+
+	if pipe.KafkaSchema != nil {
+		body, err := w.encoder.Encode(*pipe.KafkaSchema, msg.Topic, msg.Body)
+		if err != nil {
+			if _, ok := err.(*schema.PayloadError); ok {
+				// message can not be encoded on redelivery either
+				log.WithError(err).WithField("msg", msg.String()).Warning("Message does not conform to pipe schema, rejecting it")
+				w.statsClient.TrackOperation(statsWorkerSection, bucket.MetricOperation{"schema", "invalid", msg.Topic}, nil, true)
+				return amqp.ErrRejectMessage
+			}
+
+			log.WithError(err).WithField("msg", msg.String()).Error("Failed to encode message with pipe schema")
+			return err
+		}
+		msg.Body = body
+	}
+
+	if pipe.DryRun {
+		w.dryRunMessage(msg, pipe)
+		return nil
+	}
+
+	if pipe.KafkaMaxMessageBytes > 0 && len(msg.Body) > pipe.KafkaMaxMessageBytes {
+		return w.handleOversizeMessage(msg, pipe, settle)
+	}
+
+	return w.acceptMessage(msg, settle)
+}
+
+func (w *BridgeWorker) handleOversizeMessage(msg *producer.Message, pipe config.Pipe, settle func(err error)) error {
+	policy := pipe.KafkaOversizePolicy
+	if policy == "" {
+		policy = config.OversizePolicyDeadLetter
+	}
+
+	operation := bucket.MetricOperation{"oversize", policy, msg.Topic}
+	w.statsClient.TrackOperation(statsWorkerSection, operation, nil, true)
+
+	logger := log.WithFields(log.Fields{"msg": msg.String(), "size": len(msg.Body), "max_size": pipe.KafkaMaxMessageBytes})
+	switch policy {
+	case config.OversizePolicyDrop:
+		logger.Warning("Message exceeds max size, dropping it")
+		return nil
+	case config.OversizePolicyTruncate:
+		logger.Warning("Message exceeds max size, truncating it")
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string)
+		}
+		msg.Headers[originalSizeHeader] = strconv.Itoa(len(msg.Body))
+		msg.Body = msg.Body[:pipe.KafkaMaxMessageBytes]
+		return w.acceptMessage(msg, settle)
+	default:
+		logger.Warning("Message exceeds max size, rejecting it")
+		return amqp.ErrRejectMessage
+	}
+}
+
+// acceptMessage caches message consumed from AMQP according to buffer overflow policy. With disk buffer or replication
+// enabled message is requeued if it can not be written to buffer, so it is not acknowledged and lost on crash.
+// Messages with deferred settlement are acknowledged only once they are published.
+func (w *BridgeWorker) acceptMessage(msg *producer.Message, settle func(err error)) error {
+	if settle != nil {
+		return w.acceptEndToEnd(msg, settle)
+	}
+
+	if accept, err := w.handleOverflow(msg); !accept {
+		return err
+	}
+
+	if err := w.bufferMessage(msg); err != nil {
+		if err == replication.ErrNotLeader {
+			// message is redelivered to the leader consumer
+			log.WithField("msg", msg.String()).Debug("Instance is not replication leader, requeueing replicated message")
+			w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"replica", "follower", msg.Topic})
+			return err
+		}
+		log.WithError(err).WithField("msg", msg.String()).Error("Failed to write message to durable buffer")
+		return err
+	}
+
+	return w.cacheMessage(msg)
 }
 
 func (w *BridgeWorker) cacheMessage(msg *producer.Message) error {
+	if err := w.bufferMessage(msg); err != nil {
+		// message is not acknowledged by anyone at this point, so it is kept in memory at least
+		log.WithError(err).WithField("msg", msg.String()).
+			Error("Failed to write message to durable buffer, keeping it in memory only")
+	}
+
 	w.Lock()
 	defer w.Unlock()
 
 	w.cache = append(w.cache, msg)
+	w.bufferedBytes += len(msg.Body)
 
 	operation := bucket.MetricOperation{"cache", "add", msg.Topic}
 	w.statsClient.TrackOperation(statsWorkerSection, operation, nil, true)
 
+	w.applyBackpressure()
+
 	return nil
 }
 
+// bufferFor returns durable buffer message is written to with its metric name and sequence numbers,
+// replicated messages are written to replica, the rest to disk buffer, must be called with worker locked
+func (w *BridgeWorker) bufferFor(msg *producer.Message) (storage.Buffer, string, bufferSeqs) {
+	if msg.Replicated && w.replica != nil {
+		return w.replica, "replica", w.replicated
+	}
+
+	return w.buffer, "buffer", w.buffered
+}
+
+// bufferMessage writes message to durable buffer, if it is enabled and message is not there yet
+func (w *BridgeWorker) bufferMessage(msg *producer.Message) error {
+	w.Lock()
+	buffer, name, seqs := w.bufferFor(msg)
+	_, ok := seqs[msg]
+	// end-to-end message is kept by AMQP broker until it is published
+	_, pending := w.pending[msg]
+	w.Unlock()
+	if buffer == nil || ok || pending {
+		return nil
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// buffer is written without lock, so concurrent consumers appends are committed together
+	seq, err := buffer.Append(data)
+
+	operation := bucket.MetricOperation{name, "append"}
+	w.statsClient.TrackOperation(statsWorkerSection, operation, nil, err == nil)
+	if err != nil {
+		return err
+	}
+
+	w.Lock()
+	seqs[msg] = seq
+	w.Unlock()
+
+	return nil
+}
+
+// bufferedSeqs returns and forgets disk buffer and replica sequence numbers of messages,
+// must be called with worker locked
+func (w *BridgeWorker) bufferedSeqs(messages []*producer.Message) (seqs []uint64, replicaSeqs []uint64) {
+	for _, msg := range messages {
+		if seq, ok := w.buffered[msg]; ok {
+			seqs = append(seqs, seq)
+			delete(w.buffered, msg)
+		}
+		if seq, ok := w.replicated[msg]; ok {
+			replicaSeqs = append(replicaSeqs, seq)
+			delete(w.replicated, msg)
+		}
+	}
+
+	return seqs, replicaSeqs
+}
+
+// removeBuffered removes messages that are published, dropped or moved to storage from disk buffer and replica
+func (w *BridgeWorker) removeBuffered(seqs []uint64, replicaSeqs []uint64) {
+	w.removeFromBuffer(w.buffer, "buffer", seqs)
+	w.removeFromBuffer(w.replica, "replica", replicaSeqs)
+}
+
+func (w *BridgeWorker) removeFromBuffer(buffer storage.Buffer, name string, seqs []uint64) {
+	if len(seqs) == 0 {
+		return
+	}
+
+	err := buffer.Remove(seqs...)
+
+	operation := bucket.MetricOperation{name, "remove"}
+	w.statsClient.TrackOperation(statsWorkerSection, operation, nil, err == nil)
+	if err != nil {
+		// messages are published once again after restart or by the new replication leader,
+		// that is a duplicate, but not a loss
+		log.WithError(err).WithField("len", len(seqs)).WithField("buffer", name).Error("Failed to remove messages from durable buffer")
+	}
+}
+
+// recoverBuffer puts messages left in disk buffer by previous run to cache
+func (w *BridgeWorker) recoverBuffer() error {
+	w.buffered = make(bufferSeqs)
+
+	recovered, err := w.recoverMessages(w.buffer, "buffer", w.buffered)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("len", recovered).Info("Recovered messages from disk buffer")
+
+	return nil
+}
+
+// RecoverReplica puts replicated messages that are not published yet to cache, it must be called when instance
+// becomes replication leader, so messages accepted by the previous leader are published
+func (w *BridgeWorker) RecoverReplica() error {
+	recovered, err := w.recoverMessages(w.replica, "replica", w.replicated)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("len", recovered).Info("Recovered messages from replica")
+	w.statsClient.TrackMetricN(statsWorkerSection, bucket.MetricOperation{"replica", "recover"}, recovered)
+
+	return nil
+}
+
+// ReleaseReplica drops cached replicated messages that are not being published yet, it must be called when instance
+// hands replication leadership over, as the new leader recovers them from replica and publishes them itself. Messages
+// are kept in replica, so they are recovered once again if instance becomes the leader back.
+func (w *BridgeWorker) ReleaseReplica() int {
+	w.Lock()
+	defer w.Unlock()
+
+	cache := make([]*producer.Message, 0, len(w.cache))
+	var released int
+	for _, msg := range w.cache {
+		if _, ok := w.replicated[msg]; !ok {
+			cache = append(cache, msg)
+			continue
+		}
+
+		delete(w.replicated, msg)
+		w.bufferedBytes -= len(msg.Body)
+		released++
+	}
+	w.cache = cache
+	w.applyBackpressure()
+
+	log.WithField("len", released).Info("Released replicated messages to the new replication leader")
+	w.statsClient.TrackMetricN(statsWorkerSection, bucket.MetricOperation{"replica", "release"}, released)
+
+	return released
+}
+
+// recoverMessages puts messages from durable buffer to cache, except the ones that are already cached
+// or being published, and returns number of recovered messages. Messages that were published already,
+// but not removed from buffer, are dropped as duplicates.
+func (w *BridgeWorker) recoverMessages(buffer storage.Buffer, name string, seqs bufferSeqs) (int, error) {
+	w.Lock()
+	known := make(map[uint64]bool, len(seqs))
+	for _, seq := range seqs {
+		known[seq] = true
+	}
+	w.Unlock()
+
+	var recovered []*producer.Message
+	var recoveredSeqs, dropped []uint64
+	err := buffer.Each(func(seq uint64, data []byte) error {
+		if known[seq] {
+			return nil
+		}
+
+		var msg *producer.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.WithError(err).WithField("seq", seq).WithField("buffer", name).Error("Failed to unmarshal message from durable buffer, dropping it")
+			dropped = append(dropped, seq)
+			return nil
+		}
+		if w.isDuplicate(msg) {
+			dropped = append(dropped, seq)
+			return nil
+		}
+
+		recovered = append(recovered, msg)
+		recoveredSeqs = append(recoveredSeqs, seq)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	w.Lock()
+	for i, msg := range recovered {
+		w.cache = append(w.cache, msg)
+		w.bufferedBytes += len(msg.Body)
+		seqs[msg] = recoveredSeqs[i]
+	}
+	w.applyBackpressure()
+	w.Unlock()
+
+	w.removeFromBuffer(buffer, name, dropped)
+
+	return len(recovered), nil
+}
+
+// SetConnectionBlocked pauses messages consumption while broker blocks AMQP connection with the DSN and resumes
+// it once all the connections are unblocked, it is amqp.Connection.NotifyBlocked handler
+func (w *BridgeWorker) SetConnectionBlocked(dsn string, blocked bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	if blocked {
+		if w.blockedConnections == nil {
+			w.blockedConnections = make(map[string]bool)
+		}
+		w.blockedConnections[dsn] = true
+	} else {
+		delete(w.blockedConnections, dsn)
+	}
+	w.applyBackpressure()
+}
+
+// waitResumed blocks while messages consumption is paused, so AMQP server stops delivering new messages
+// once consumers prefetch count is reached
+func (w *BridgeWorker) waitResumed() {
+	w.Lock()
+	resumed := w.resumed
+	w.Unlock()
+
+	if resumed != nil {
+		<-resumed
+	}
+}
+
+// applyBackpressure pauses messages consumption when number of buffered messages reaches high water mark,
+// buffer limits are reached with block overflow policy, circuit breaker is open, worker is draining or AMQP connection
+// is blocked, and resumes it when the number goes down to low water mark, buffer has room again, circuit is closed
+// and connections are unblocked, must be called with worker locked
+func (w *BridgeWorker) applyBackpressure() {
+	buffered := len(w.cache) + w.inFlight
+	blocked := (w.blockOverflow() && w.full()) || w.circuitPaused() || w.draining || len(w.blockedConnections) > 0
+	highWater := w.config.CacheHighWaterMark > 0 && buffered >= w.config.CacheHighWaterMark
+	lowWater := w.config.CacheHighWaterMark <= 0 || buffered <= w.lowWaterMark()
+
+	if w.resumed == nil && (highWater || blocked) {
+		log.WithField("buffered", buffered).WithField("circuit", w.circuit.String()).Warning("Worker buffer reached high water mark, pausing messages consumption")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"backpressure", "pause"})
+		w.resumed = make(chan struct{})
+	} else if w.resumed != nil && lowWater && !blocked {
+		log.WithField("buffered", buffered).Info("Worker buffer reached low water mark, resuming messages consumption")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"backpressure", "resume"})
+		close(w.resumed)
+		w.resumed = nil
+	}
+}
+
+func (w *BridgeWorker) lowWaterMark() int {
+	if w.config.CacheLowWaterMark <= 0 || w.config.CacheLowWaterMark >= w.config.CacheHighWaterMark {
+		return w.config.CacheHighWaterMark / 2
+	}
+
+	return w.config.CacheLowWaterMark
+}
+
+// messageHandled is called when in-flight message is either published or moved to storage or back to cache
+func (w *BridgeWorker) messageHandled(msg *producer.Message) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.inFlight--
+	delete(w.publishing, msg)
+	w.bufferedBytes -= len(msg.Body)
+	w.applyBackpressure()
+}
+
 func (w *BridgeWorker) populateCacheFromStorage() {
 	var errorsCount int
 
 	log.Debug("Populating cache from storage")
 	for {
+		w.Lock()
+		full := w.overflows(0)
+		circuitClosed := w.circuit == CircuitClosed
+		w.Unlock()
+		if !circuitClosed {
+			// messages are kept in storage until Kafka recovers
+			log.Debug("Circuit breaker is not closed, stopping reading from storage")
+			break
+		}
+		if full {
+			// the rest of messages is read on next storage read cycle, when there is room in buffer
+			log.Debug("Worker buffer is full, stopping reading from storage")
+			break
+		}
+
 		if errorsCount >= w.config.StorageMaxErrors {
 			log.WithField("errors_count", errorsCount).
 				Error("Got several errors in a row while reading from storage, stopping reading")
@@ -163,24 +890,124 @@ func (w *BridgeWorker) populateCacheFromStorage() {
 }
 
 func (w *BridgeWorker) publishMessages(messages []*producer.Message) {
-	for _, msg := range messages {
-		err := w.producer.Publish(*msg)
-		if err != nil {
+	batch := make([]producer.Message, len(messages))
+	spans := make([]trace.Span, len(messages))
+	for i, msg := range messages {
+		tracing.Buffered(msg)
+		ctx, span := tracing.StartPublish(msg)
+		spans[i] = span
+
+		// published record continues publish span, while buffered message keeps consume span trace context,
+		// so publish retries are its siblings
+		batch[i] = *msg
+		batch[i].Headers = tracing.Inject(ctx, msg.Headers)
+	}
+
+	errs := w.producer.PublishBatch(batch)
+	for i, span := range spans {
+		tracing.End(span, errs[i])
+		if messages[i].Sampled {
+			logPublished(messages[i], batch[i], errs[i])
+		}
+	}
+	w.recordPublishResults(errs)
+	w.recordPublishTraffic(messages, errs)
+
+	handled := make([]*producer.Message, 0, len(messages))
+	published := make([]*producer.Message, 0, len(messages))
+	var retried []*producer.Message
+	for i, msg := range messages {
+		if errs[i] == nil {
+			published = append(published, msg)
+		} else if retryInPlace(msg, errs[i]) {
+			// message stays in durable buffer and its end-to-end delivery stays unsettled until it is published
+			msg.Attempts++
+			retried = append(retried, msg)
+			continue
+		}
+		if w.settleMessage(msg, errs[i]) || errs[i] == nil || !w.handlePublishError(msg, errs[i]) {
+			handled = append(handled, msg)
+		}
+		w.messageHandled(msg)
+	}
+	w.releaseOrderKeys(messages, retried)
+
+	// keys are remembered before messages are removed from durable buffer, so messages are either replayed
+	// after crash or dropped as duplicates
+	w.rememberPublished(published)
+
+	w.Lock()
+	seqs, replicaSeqs := w.bufferedSeqs(handled)
+	w.Unlock()
+	w.removeBuffered(seqs, replicaSeqs)
+}
+
+// handlePublishError moves failed message to storage, error topic or drops it depending on error,
+// it returns true if message is returned to cache to be published again
+func (w *BridgeWorker) handlePublishError(msg *producer.Message, err error) bool {
+	if !producer.IsRetriable(err) {
+		// storing message would only make it fail over and over again on every storage read
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"publish", "fatal", msg.Topic})
+		if msg.ErrorTopic == "" {
 			log.WithError(err).WithField("msg", msg.String()).
-				Warning("Failed to publish messages to Kafka, moving to storage")
-
-			if err = w.storeMessage(msg); err != nil {
-				if err == errMarshalMessage {
-					continue
-				} else if err == errPutToStorage {
-					w.cacheMessage(msg)
-				} else {
-					log.WithError(err).WithField("msg", msg.String()).
-						Error("Unhandled storage error")
-				}
-			}
+				Error("Failed to publish message to Kafka with non-retriable error, dropping it")
+			return false
 		}
+
+		log.WithError(err).WithField("msg", msg.String()).WithField("error_topic", msg.ErrorTopic).
+			Error("Failed to publish message to Kafka with non-retriable error, moving it to error topic")
+		w.cacheMessage(errorTopicMessage(msg, err))
+		return false
+	}
+
+	if msg.Delivery == config.DeliveryFireAndForget {
+		log.WithError(err).WithField("msg", msg.String()).
+			Warning("Failed to publish fire-and-forget message to Kafka, dropping it")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"publish", "dropped", msg.Topic})
+		return false
 	}
+
+	log.WithError(err).WithField("msg", msg.String()).
+		Warning("Failed to publish messages to Kafka, moving to storage")
+
+	msg.Attempts++
+
+	if err = w.storeMessage(msg); err != nil {
+		if err == errMarshalMessage {
+			return false
+		} else if err == errPutToStorage {
+			w.cacheMessage(msg)
+			return true
+		}
+		log.WithError(err).WithField("msg", msg.String()).
+			Error("Unhandled storage error")
+	}
+
+	return false
+}
+
+// errorTopicMessage returns copy of the message for error topic with failure metadata headers
+func errorTopicMessage(msg *producer.Message, err error) *producer.Message {
+	errorMsg := producer.NewMessage(msg.Body, msg.ErrorTopic)
+	// original message id is kept, so failed message can be traced by it
+	errorMsg.ID = msg.ID
+	errorMsg.Key = msg.Key
+	errorMsg.Timestamp = msg.Timestamp
+	errorMsg.Cluster = msg.Cluster
+	errorMsg.Sink = msg.Sink
+	errorMsg.Delivery = msg.Delivery
+	errorMsg.Replicated = msg.Replicated
+	// error topic message has no error topic itself, so it is dropped if it fails either
+
+	errorMsg.Headers = make(map[string]string, len(msg.Headers)+3)
+	for k, v := range msg.Headers {
+		errorMsg.Headers[k] = v
+	}
+	errorMsg.Headers[errorHeader] = err.Error()
+	errorMsg.Headers[originalTopicHeader] = msg.Topic
+	errorMsg.Headers[failedAtHeader] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	return errorMsg
 }
 
 func (w *BridgeWorker) storeMessage(msg *producer.Message) error {
@@ -7,13 +7,22 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/gofrs/uuid"
+	"github.com/hellofresh/kandalf/pkg/amqp"
 	"github.com/hellofresh/kandalf/pkg/config"
 	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/kandalf/pkg/replication"
+	"github.com/hellofresh/kandalf/pkg/schema"
 	"github.com/hellofresh/kandalf/pkg/storage"
 	"github.com/hellofresh/stats-go"
 	"github.com/hellofresh/stats-go/client"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 type mockGetResult struct {
@@ -75,10 +84,71 @@ func (p *mockProducer) Publish(msg producer.Message) error {
 	return p.publishResult[methodCall]
 }
 
+func (p *mockProducer) PublishBatch(msgs []producer.Message) []error {
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = p.Publish(msg)
+	}
+
+	return errs
+}
+
 func (p *mockProducer) Close() error {
 	return nil
 }
 
+type mockBuffer struct {
+	data      map[uint64][]byte
+	seq       uint64
+	appendErr error
+	removed   []uint64
+}
+
+func (b *mockBuffer) Append(data []byte) (uint64, error) {
+	if b.appendErr != nil {
+		return 0, b.appendErr
+	}
+
+	b.seq++
+	b.data[b.seq] = data
+	return b.seq, nil
+}
+
+func (b *mockBuffer) Remove(seqs ...uint64) error {
+	for _, seq := range seqs {
+		delete(b.data, seq)
+	}
+	b.removed = append(b.removed, seqs...)
+	return nil
+}
+
+func (b *mockBuffer) Each(fn func(seq uint64, data []byte) error) error {
+	for seq := uint64(1); seq <= b.seq; seq++ {
+		if data, ok := b.data[seq]; ok {
+			if err := fn(seq, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *mockBuffer) Close() error {
+	return nil
+}
+
+type mockEncoder struct {
+	err error
+}
+
+func (e *mockEncoder) Encode(settings config.SchemaSettings, topic string, body []byte) ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return append([]byte(topic+":"), body...), nil
+}
+
 func generateRandomMessages(n int) []*producer.Message {
 	result := make([]*producer.Message, n)
 	for i := 0; i < n; i++ {
@@ -96,9 +166,9 @@ func TestBridgeWorker_MessageHandler(t *testing.T) {
 	messagesToPublish := 5
 
 	messages := generateRandomMessages(messagesToPublish)
-	worker, _ := NewBridgeWorker(workerConfig, mockStorage, mockProducer, statsClient)
+	worker, _ := NewBridgeWorker(workerConfig, mockStorage, nil, nil, mockProducer, nil, statsClient)
 	for _, msg := range messages {
-		worker.MessageHandler(msg.Body, config.Pipe{KafkaTopic: msg.Topic})
+		worker.MessageHandler(amqp.Delivery{Body: msg.Body}, config.Pipe{KafkaTopic: msg.Topic})
 	}
 
 	memoryStats, _ := statsClient.(*client.Memory)
@@ -118,6 +188,512 @@ func TestBridgeWorker_MessageHandler(t *testing.T) {
 	}
 }
 
+func TestBridgeWorker_MessageHandler_partitionKey(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, _ := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+
+	delivery := amqp.Delivery{Body: []byte(`{"customer":{"id":"c-1"}}`), RoutingKey: "order.created"}
+	assert.NoError(t, worker.MessageHandler(delivery, config.Pipe{KafkaTopic: "topic", KafkaPartitionKey: "json:customer.id"}))
+	assert.NoError(t, worker.MessageHandler(delivery, config.Pipe{KafkaTopic: "topic", KafkaPartitionKey: "routingKey"}))
+	assert.NoError(t, worker.MessageHandler(delivery, config.Pipe{KafkaTopic: "topic"}))
+
+	// message that failed key evaluation is still published
+	delivery.Body = []byte("not a json")
+	assert.NoError(t, worker.MessageHandler(delivery, config.Pipe{KafkaTopic: "topic", KafkaPartitionKey: "json:customer.id"}))
+
+	require.Len(t, worker.cache, 4)
+	assert.Equal(t, "c-1", worker.cache[0].Key)
+	assert.Equal(t, "order.created", worker.cache[1].Key)
+	assert.Empty(t, worker.cache[2].Key)
+	assert.Empty(t, worker.cache[3].Key)
+}
+
+func TestBridgeWorker_MessageHandler_dedup(t *testing.T) {
+	workerConfig := config.WorkerConfig{DedupWindow: time.Minute, DedupMaxKeys: 10}
+	statsClient, _ := stats.NewClient("memory://")
+	mockProducer := &mockProducer{t: t}
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, mockProducer, nil, statsClient)
+	require.NoError(t, err)
+
+	pipe := config.Pipe{KafkaTopic: "topic", RabbitQueueName: "orders", DedupKey: "messageId"}
+	delivery := amqp.Delivery{Body: []byte("body"), MessageID: "m-1"}
+	require.NoError(t, worker.MessageHandler(delivery, pipe))
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, "orders:m-1", worker.cache[0].DedupKey)
+
+	// message is not a duplicate until it is published
+	require.NoError(t, worker.MessageHandler(delivery, pipe))
+	require.Len(t, worker.cache, 2)
+
+	messages := worker.cache[:1]
+	worker.cache = nil
+	mockProducer.publishAssertParam = []producer.Message{*messages[0]}
+	mockProducer.publishResult = []error{nil}
+	worker.inFlight++
+	worker.publishMessages(messages)
+
+	// redelivered message is dropped, while the same id from another queue or without id is not
+	require.NoError(t, worker.MessageHandler(delivery, pipe))
+	assert.Empty(t, worker.cache)
+
+	anotherPipe := pipe
+	anotherPipe.RabbitQueueName = "payments"
+	require.NoError(t, worker.MessageHandler(delivery, anotherPipe))
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+	require.Len(t, worker.cache, 2)
+	assert.Equal(t, "payments:m-1", worker.cache[0].DedupKey)
+	assert.Empty(t, worker.cache[1].DedupKey)
+}
+
+func TestBridgeWorker_MessageHandler_oversize(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, _ := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+
+	body := []byte("0123456789")
+	pipe := config.Pipe{KafkaTopic: "topic", KafkaMaxMessageBytes: 10}
+
+	// message fits max size
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: body}, pipe))
+	assert.Len(t, worker.cache, 1)
+
+	body = append(body, 'a')
+
+	pipe.KafkaOversizePolicy = config.OversizePolicyDeadLetter
+	assert.Equal(t, amqp.ErrRejectMessage, worker.MessageHandler(amqp.Delivery{Body: body}, pipe))
+	assert.Len(t, worker.cache, 1)
+
+	pipe.KafkaOversizePolicy = config.OversizePolicyDrop
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: body}, pipe))
+	assert.Len(t, worker.cache, 1)
+
+	pipe.KafkaOversizePolicy = config.OversizePolicyTruncate
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: body}, pipe))
+	require.Len(t, worker.cache, 2)
+	assert.Equal(t, []byte("0123456789"), worker.cache[1].Body)
+	assert.Equal(t, map[string]string{originalSizeHeader: "11"}, worker.cache[1].Headers)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	for _, policy := range []string{config.OversizePolicyDeadLetter, config.OversizePolicyDrop, config.OversizePolicyTruncate} {
+		assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.oversize.%s.topic", statsWorkerSection, policy)])
+	}
+}
+
+func TestBridgeWorker_MessageHandler_dryRun(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, _ := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+
+	pipe := config.Pipe{KafkaTopic: "topic", KafkaMaxMessageBytes: 2, DryRun: true}
+
+	// dry run message is acknowledged, but it is neither cached nor rejected for its size
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+	pipe.KafkaDelivery = config.DeliveryEndToEnd
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+	assert.Empty(t, worker.cache)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 2, memoryStats.CountMetrics[statsWorkerSection+".dryrun.logged.topic"])
+}
+
+func TestBridgeWorker_buffer(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	mockStorage := &mockStorage{t: t, putResult: []error{errors.New("storage is not available")}}
+	mockProducer := &mockProducer{t: t}
+
+	left := producer.NewMessage([]byte("left by previous run"), "topic")
+	leftData, err := json.Marshal(left)
+	require.NoError(t, err)
+	buffer := &mockBuffer{data: map[uint64][]byte{1: leftData, 2: []byte("corrupted")}, seq: 2}
+
+	worker, err := NewBridgeWorker(workerConfig, mockStorage, buffer, nil, mockProducer, nil, statsClient)
+	require.NoError(t, err)
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, *left, *worker.cache[0])
+	assert.Equal(t, []uint64{2}, buffer.removed)
+
+	// accepted message is written to buffer
+	pipe := config.Pipe{KafkaTopic: "topic"}
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("accepted")}, pipe))
+	require.Len(t, worker.cache, 2)
+	assert.Len(t, buffer.data, 2)
+
+	// message is not accepted if it can not be written to buffer
+	buffer.appendErr = errors.New("disk is full")
+	assert.Equal(t, buffer.appendErr, worker.MessageHandler(amqp.Delivery{Body: []byte("rejected")}, pipe))
+	assert.Len(t, worker.cache, 2)
+	buffer.appendErr = nil
+
+	messages := worker.cache
+	worker.cache = nil
+	mockProducer.publishAssertParam = []producer.Message{*messages[0], *messages[1]}
+	mockProducer.publishResult = []error{nil, errors.New("leader not available")}
+	worker.publishMessages(messages)
+
+	// published message is removed from buffer, message returned to cache stays there
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, messages[1], worker.cache[0])
+	assert.Equal(t, []uint64{2, 1}, buffer.removed)
+	assert.Len(t, buffer.data, 1)
+	assert.Contains(t, buffer.data, uint64(3))
+}
+
+func TestBridgeWorker_replica(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	mockProducer := &mockProducer{t: t}
+
+	replicated := producer.NewMessage([]byte("replicated by previous leader"), "topic")
+	replicated.Replicated = true
+	replicatedData, err := json.Marshal(replicated)
+	require.NoError(t, err)
+	buffer := &mockBuffer{data: map[uint64][]byte{}}
+	replica := &mockBuffer{data: map[uint64][]byte{1: replicatedData}, seq: 1}
+
+	// replicated messages are recovered only when instance becomes leader
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, buffer, replica, mockProducer, nil, statsClient)
+	require.NoError(t, err)
+	assert.Empty(t, worker.cache)
+
+	// messages of replicated pipes are written to replica, the rest to disk buffer
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("replicated")}, config.Pipe{KafkaTopic: "topic", Replicated: true}))
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("buffered")}, config.Pipe{KafkaTopic: "topic"}))
+	assert.Len(t, replica.data, 2)
+	assert.Len(t, buffer.data, 1)
+
+	// message is requeued by follower
+	replica.appendErr = replication.ErrNotLeader
+	assert.Equal(t, replication.ErrNotLeader, worker.MessageHandler(amqp.Delivery{Body: []byte("follower")}, config.Pipe{KafkaTopic: "topic", Replicated: true}))
+	replica.appendErr = nil
+
+	// messages that are already cached are not recovered twice
+	require.NoError(t, worker.RecoverReplica())
+	require.NoError(t, worker.RecoverReplica())
+	require.Len(t, worker.cache, 3)
+	assert.Equal(t, *replicated, *worker.cache[2])
+
+	messages := worker.cache
+	worker.cache = nil
+	mockProducer.publishAssertParam = []producer.Message{*messages[0], *messages[1], *messages[2]}
+	mockProducer.publishResult = []error{nil, nil, nil}
+	worker.publishMessages(messages)
+
+	assert.Empty(t, replica.data)
+	assert.Empty(t, buffer.data)
+	assert.ElementsMatch(t, []uint64{1, 2}, replica.removed)
+}
+
+func TestBridgeWorker_ReleaseReplica(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	buffer := &mockBuffer{data: map[uint64][]byte{}}
+	replica := &mockBuffer{data: map[uint64][]byte{}}
+
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, buffer, replica, &mockProducer{t: t}, nil, statsClient)
+	require.NoError(t, err)
+
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("replicated")}, config.Pipe{KafkaTopic: "topic", Replicated: true}))
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("buffered")}, config.Pipe{KafkaTopic: "topic"}))
+	require.Len(t, worker.cache, 2)
+
+	// replicated messages are left to the new leader, but kept in replica
+	assert.Equal(t, 1, worker.ReleaseReplica())
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, []byte("buffered"), worker.cache[0].Body)
+	assert.Equal(t, len("buffered"), worker.bufferedBytes)
+	assert.Len(t, replica.data, 1)
+	assert.Empty(t, replica.removed)
+
+	// released messages are recovered if instance becomes the leader back
+	require.NoError(t, worker.RecoverReplica())
+	assert.Len(t, worker.cache, 2)
+}
+
+func TestBridgeWorker_MessageHandler_endToEnd(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	mockProducer := &mockProducer{t: t}
+	buffer := &mockBuffer{data: map[uint64][]byte{}}
+
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, buffer, nil, mockProducer, nil, statsClient)
+	require.NoError(t, err)
+
+	settled := make(map[string]error)
+	pipe := config.Pipe{KafkaTopic: "topic", KafkaDelivery: config.DeliveryEndToEnd}
+	for _, body := range []string{"published", "requeued", "rejected", "error-topic"} {
+		body := body
+		delivery := amqp.Delivery{Body: []byte(body), Settle: func(err error) { settled[body] = err }}
+		if body == "error-topic" {
+			pipe.KafkaErrorTopic = "errors"
+		}
+		assert.Equal(t, amqp.ErrAckDeferred, worker.MessageHandler(delivery, pipe))
+	}
+
+	// message is acknowledged on accept if consumer does not support deferred settlement
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("acknowledged")}, pipe))
+
+	// AMQP broker keeps end-to-end messages, so they are not written to disk buffer
+	require.Len(t, worker.cache, 5)
+	assert.Len(t, buffer.data, 1)
+	assert.Empty(t, settled)
+
+	messages := worker.cache[:4]
+	worker.cache = worker.cache[4:]
+	mockProducer.publishAssertParam = []producer.Message{*messages[0], *messages[1], *messages[2], *messages[3]}
+	mockProducer.publishResult = []error{nil, errors.New("leader not available"), producer.ErrPublishDeadlineExceeded, producer.ErrPublishDeadlineExceeded}
+	worker.publishMessages(messages)
+
+	assert.Len(t, settled, 3)
+	assert.NoError(t, settled["published"])
+	assert.Equal(t, mockProducer.publishResult[1], settled["requeued"])
+	assert.Equal(t, amqp.ErrRejectMessage, settled["rejected"])
+
+	// message moved to error topic is acknowledged once error topic message is published
+	require.Len(t, worker.cache, 2)
+	errorMsg := worker.cache[1]
+	assert.Equal(t, "errors", errorMsg.Topic)
+	worker.cache = worker.cache[:1]
+	mockProducer.publishAssertParam = append(mockProducer.publishAssertParam, *errorMsg)
+	mockProducer.publishResult = append(mockProducer.publishResult, nil)
+	worker.publishMessages([]*producer.Message{errorMsg})
+
+	assert.Len(t, settled, 4)
+	assert.NoError(t, settled["error-topic"])
+	assert.Empty(t, worker.pending)
+}
+
+func TestBridgeWorker_MessageHandler_transactional(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	mockProducer := &mockProducer{t: t}
+	worker, err := NewBridgeWorker(config.WorkerConfig{}, &mockStorage{}, nil, nil, mockProducer, nil, statsClient)
+	require.NoError(t, err)
+
+	var settled []error
+	pipe := config.Pipe{KafkaTopic: "topic", KafkaDelivery: config.DeliveryTransactional}
+	delivery := amqp.Delivery{Body: []byte("committed"), Settle: func(err error) { settled = append(settled, err) }}
+	assert.Equal(t, amqp.ErrAckDeferred, worker.MessageHandler(delivery, pipe))
+	assert.Empty(t, settled)
+
+	// message is acknowledged once its transaction is committed
+	require.Len(t, worker.cache, 1)
+	messages := worker.cache
+	worker.cache = nil
+	mockProducer.publishAssertParam = []producer.Message{*messages[0]}
+	mockProducer.publishResult = []error{nil}
+	worker.publishMessages(messages)
+
+	assert.Equal(t, []error{nil}, settled)
+	assert.Empty(t, worker.pending)
+}
+
+func TestBridgeWorker_MessageHandler_timestamp(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, _ := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+
+	occurredAt := time.Date(2018, 7, 1, 12, 30, 0, 0, time.UTC)
+	pipe := config.Pipe{KafkaTopic: "topic", KafkaTimestamp: config.TimestampProperty}
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("body"), Timestamp: occurredAt}, pipe))
+	// messages without timestamp are published with publish time
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+
+	require.Len(t, worker.cache, 2)
+	assert.Equal(t, occurredAt.UnixNano(), worker.cache[0].Timestamp)
+	assert.Equal(t, int64(0), worker.cache[1].Timestamp)
+}
+
+func TestBridgeWorker_MessageHandler_schema(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	encoder := &mockEncoder{}
+	worker, _ := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, encoder, statsClient)
+
+	pipe := config.Pipe{KafkaTopic: "topic", KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeJSON}}
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, []byte("topic:body"), worker.cache[0].Body)
+
+	encoder.err = &schema.PayloadError{Err: errors.New("missing field")}
+	assert.Equal(t, amqp.ErrRejectMessage, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+
+	// registry failures are requeued
+	encoder.err = errors.New("registry is not available")
+	assert.Equal(t, encoder.err, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+	assert.Len(t, worker.cache, 1)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics[statsWorkerSection+".schema.invalid.topic"])
+}
+
+func TestBridgeWorker_MessageHandler_backpressure(t *testing.T) {
+	workerConfig := config.WorkerConfig{CacheHighWaterMark: 2, CacheLowWaterMark: 1}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, _ := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+
+	pipe := config.Pipe{KafkaTopic: "topic"}
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("1")}, pipe))
+	assert.Nil(t, worker.resumed)
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("2")}, pipe))
+	require.NotNil(t, worker.resumed)
+
+	handled := make(chan error)
+	go func() {
+		handled <- worker.MessageHandler(amqp.Delivery{Body: []byte("3")}, pipe)
+	}()
+
+	select {
+	case <-handled:
+		t.Fatal("Message must not be handled while consumption is paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// messages taken from cache for publishing are still buffered until they are handled
+	worker.Lock()
+	worker.inFlight, worker.cache = len(worker.cache), nil
+	worker.applyBackpressure()
+	worker.Unlock()
+	assert.NotNil(t, worker.resumed)
+
+	worker.messageHandled(&producer.Message{Body: []byte("1")})
+	worker.messageHandled(&producer.Message{Body: []byte("2")})
+	assert.NoError(t, <-handled)
+	assert.Nil(t, worker.resumed)
+	assert.Len(t, worker.cache, 1)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.backpressure.pause.-", statsWorkerSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.backpressure.resume.-", statsWorkerSection)])
+}
+
+func TestBridgeWorker_SetConnectionBlocked(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	worker, _ := NewBridgeWorker(config.WorkerConfig{}, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+
+	// consumption is paused while any of connections is blocked
+	worker.SetConnectionBlocked("amqp://rmq/orders", true)
+	worker.SetConnectionBlocked("amqp://rmq/payments", true)
+	require.NotNil(t, worker.resumed)
+	worker.SetConnectionBlocked("amqp://rmq/orders", false)
+	assert.NotNil(t, worker.resumed)
+
+	handled := make(chan error)
+	go func() {
+		handled <- worker.MessageHandler(amqp.Delivery{Body: []byte("1")}, config.Pipe{KafkaTopic: "topic"})
+	}()
+	select {
+	case <-handled:
+		t.Fatal("Message must not be handled while AMQP connection is blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	worker.SetConnectionBlocked("amqp://rmq/payments", false)
+	assert.NoError(t, <-handled)
+	assert.Nil(t, worker.resumed)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.backpressure.pause.-", statsWorkerSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.backpressure.resume.-", statsWorkerSection)])
+}
+
+func TestNewBridgeWorker_unknownOverflowPolicy(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	_, err := NewBridgeWorker(config.WorkerConfig{BufferOverflowPolicy: "unknown"}, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	assert.Equal(t, errUnknownOverflowPolicy, err)
+	assert.NoError(t, ValidateConfig(config.WorkerConfig{BufferOverflowPolicy: config.OverflowPolicySpill}))
+	assert.NoError(t, ValidateConfig(config.WorkerConfig{BufferOverflowPolicy: config.OverflowPolicySpillToStorage}))
+}
+
+func TestBridgeWorker_MessageHandler_overflowBlock(t *testing.T) {
+	workerConfig := config.WorkerConfig{BufferMaxBytes: 2, BufferOverflowPolicy: config.OverflowPolicyBlock}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, _ := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+
+	pipe := config.Pipe{KafkaTopic: "topic"}
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("1")}, pipe))
+	assert.Nil(t, worker.resumed)
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("2")}, pipe))
+	require.NotNil(t, worker.resumed)
+
+	worker.Lock()
+	worker.inFlight, worker.cache = len(worker.cache), nil
+	worker.Unlock()
+
+	worker.messageHandled(&producer.Message{Body: []byte("1")})
+	assert.Nil(t, worker.resumed)
+	assert.Equal(t, 1, worker.bufferedBytes)
+}
+
+func TestBridgeWorker_MessageHandler_overflowDropNewest(t *testing.T) {
+	workerConfig := config.WorkerConfig{BufferMaxMessages: 1, BufferOverflowPolicy: config.OverflowPolicyDropNewest}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, _ := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+
+	pipe := config.Pipe{KafkaTopic: "topic"}
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("1")}, pipe))
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("2")}, pipe))
+	assert.Nil(t, worker.resumed)
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, []byte("1"), worker.cache[0].Body)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.overflow.drop-newest.topic", statsWorkerSection)])
+}
+
+func TestBridgeWorker_MessageHandler_overflowDropOldest(t *testing.T) {
+	workerConfig := config.WorkerConfig{BufferMaxBytes: 4, BufferOverflowPolicy: config.OverflowPolicyDropOldest}
+	statsClient, _ := stats.NewClient("memory://")
+	buffer := &mockBuffer{data: map[uint64][]byte{}}
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, buffer, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	pipe := config.Pipe{KafkaTopic: "topic"}
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("11")}, pipe))
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("22")}, pipe))
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("333")}, pipe))
+
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, []byte("333"), worker.cache[0].Body)
+	assert.Equal(t, 3, worker.bufferedBytes)
+	assert.Len(t, buffer.data, 1)
+
+	// in-flight messages can not be evicted, so new message is dropped
+	worker.Lock()
+	worker.inFlight, worker.cache = len(worker.cache), nil
+	worker.Unlock()
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("44")}, pipe))
+	assert.Empty(t, worker.cache)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 2, memoryStats.CountMetrics[fmt.Sprintf("%s.overflow.drop-oldest.topic", statsWorkerSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.overflow.drop-newest.topic", statsWorkerSection)])
+}
+
+func TestBridgeWorker_MessageHandler_overflowSpill(t *testing.T) {
+	for _, policy := range []string{config.OverflowPolicySpill, config.OverflowPolicySpillToStorage} {
+		workerConfig := config.WorkerConfig{BufferMaxMessages: 1, BufferOverflowPolicy: policy}
+		statsClient, _ := stats.NewClient("memory://")
+		storageMock := &mockStorage{putResult: []error{nil, errors.New("storage is down")}}
+		worker, _ := NewBridgeWorker(workerConfig, storageMock, nil, nil, &mockProducer{}, nil, statsClient)
+
+		pipe := config.Pipe{KafkaTopic: "topic"}
+		assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("1")}, pipe), policy)
+		assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("2")}, pipe), policy)
+		assert.Error(t, worker.MessageHandler(amqp.Delivery{Body: []byte("3")}, pipe), policy)
+		assert.Len(t, worker.cache, 1, policy)
+
+		require.Len(t, storageMock.putData, 2, policy)
+		var stored producer.Message
+		require.NoError(t, json.Unmarshal(storageMock.putData[0], &stored))
+		assert.Equal(t, []byte("2"), stored.Body, policy)
+
+		// alias is reported with the same metric
+		memoryStats, _ := statsClient.(*client.Memory)
+		assert.Equal(t, 2, memoryStats.CountMetrics[fmt.Sprintf("%s.overflow.spill-to-disk.topic", statsWorkerSection)], policy)
+	}
+}
+
 func TestBridgeWorker_cacheMessage(t *testing.T) {
 	workerConfig := config.WorkerConfig{}
 	statsClient, _ := stats.NewClient("memory://")
@@ -127,7 +703,7 @@ func TestBridgeWorker_cacheMessage(t *testing.T) {
 	messagesToPublish := 5
 
 	messages := generateRandomMessages(messagesToPublish)
-	worker, _ := NewBridgeWorker(workerConfig, mockStorage, mockProducer, statsClient)
+	worker, _ := NewBridgeWorker(workerConfig, mockStorage, nil, nil, mockProducer, nil, statsClient)
 	for _, msg := range messages {
 		worker.cacheMessage(msg)
 	}
@@ -159,7 +735,7 @@ func getDefaultBridgeWorker(t *testing.T) *BridgeWorker {
 	mockStorage := &mockStorage{t: t}
 	mockProducer := &mockProducer{t: t}
 
-	worker, _ := NewBridgeWorker(workerConfig, mockStorage, mockProducer, statsClient)
+	worker, _ := NewBridgeWorker(workerConfig, mockStorage, nil, nil, mockProducer, nil, statsClient)
 	return worker
 }
 
@@ -262,6 +838,87 @@ func TestNewBridgeWorker_publishMessages(t *testing.T) {
 	assert.Equal(t, 1, len(worker.cache))
 }
 
+func TestNewBridgeWorker_publishMessages_fatalError(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+
+	mockProducer := &mockProducer{t: t}
+	mockStorage := &mockStorage{t: t}
+
+	worker.producer = mockProducer
+	worker.storage = mockStorage
+
+	messages := generateRandomMessages(3)
+	for _, msg := range messages {
+		msg.Topic = "topic"
+		mockProducer.publishAssertParam = append(mockProducer.publishAssertParam, *msg)
+	}
+	mockProducer.publishResult = []error{sarama.ErrTopicAuthorizationFailed, producer.ErrPublishDeadlineExceeded, sarama.ErrNotLeaderForPartition}
+	mockStorage.putResult = []error{nil}
+
+	worker.publishMessages(messages)
+
+	// only retriable error message is moved to storage, fatal error messages are dropped
+	assert.Equal(t, 1, mockStorage.putCalled)
+	assert.Equal(t, 0, len(worker.cache))
+
+	memoryStats, _ := worker.statsClient.(*client.Memory)
+	assert.Equal(t, 2, memoryStats.CountMetrics[fmt.Sprintf("%s.publish.fatal.topic", statsWorkerSection)])
+}
+
+func TestNewBridgeWorker_publishMessages_errorTopic(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+
+	mockProducer := &mockProducer{t: t}
+	worker.producer = mockProducer
+
+	msg := producer.NewMessage([]byte("body"), "invoices")
+	msg.Key = "customer-1"
+	msg.Headers = map[string]string{"content-type": "application/json"}
+	msg.ErrorTopic = "invoices-errors"
+	mockProducer.publishAssertParam = []producer.Message{*msg}
+	mockProducer.publishResult = []error{sarama.ErrTopicAuthorizationFailed}
+
+	worker.publishMessages([]*producer.Message{msg})
+
+	require.Len(t, worker.cache, 1)
+	errorMsg := worker.cache[0]
+	assert.Equal(t, "invoices-errors", errorMsg.Topic)
+	assert.Equal(t, msg.Body, errorMsg.Body)
+	assert.Equal(t, "customer-1", errorMsg.Key)
+	assert.Empty(t, errorMsg.ErrorTopic)
+	assert.Equal(t, msg.ID, errorMsg.ID)
+	assert.Equal(t, "application/json", errorMsg.Headers["content-type"])
+	assert.Equal(t, sarama.ErrTopicAuthorizationFailed.Error(), errorMsg.Headers[errorHeader])
+	assert.Equal(t, "invoices", errorMsg.Headers[originalTopicHeader])
+	assert.NotEmpty(t, errorMsg.Headers[failedAtHeader])
+	// original message headers are left intact
+	assert.Len(t, msg.Headers, 1)
+}
+
+func TestNewBridgeWorker_publishMessages_fireAndForget(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+
+	mockProducer := &mockProducer{t: t}
+	mockStorage := &mockStorage{t: t}
+
+	worker.producer = mockProducer
+	worker.storage = mockStorage
+
+	messages := generateRandomMessages(2)
+	messages[0].Delivery = config.DeliveryFireAndForget
+	for _, msg := range messages {
+		mockProducer.publishAssertParam = append(mockProducer.publishAssertParam, *msg)
+	}
+	mockProducer.publishResult = []error{sarama.ErrNotLeaderForPartition, sarama.ErrNotLeaderForPartition}
+	mockStorage.putResult = []error{nil}
+
+	worker.publishMessages(messages)
+
+	// fire-and-forget message is dropped even on retriable error
+	assert.Equal(t, 1, mockStorage.putCalled)
+	assert.Equal(t, 0, len(worker.cache))
+}
+
 func TestBridgeWorker_populateCacheFromStorage(t *testing.T) {
 	worker := getDefaultBridgeWorker(t)
 
@@ -321,6 +978,32 @@ func TestBridgeWorker_populateCacheFromStorage_maxErrors(t *testing.T) {
 	assert.Equal(t, normalMessages[:2], worker.cache)
 }
 
+func TestBridgeWorker_Drain(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+	mockProducer := &mockProducer{t: t}
+	worker.producer = mockProducer
+
+	messages := generateRandomMessages(3)
+	for _, msg := range messages {
+		mockProducer.publishAssertParam = append(mockProducer.publishAssertParam, *msg)
+		mockProducer.publishResult = append(mockProducer.publishResult, nil)
+		require.NoError(t, worker.cacheMessage(msg))
+	}
+
+	// messages are published regardless of cache size and flush timeout
+	assert.True(t, worker.Drain(time.Second))
+	assert.Equal(t, 3, mockProducer.publishCalled)
+	assert.Empty(t, worker.cache)
+	// new messages are not accepted anymore
+	assert.NotNil(t, worker.resumed)
+
+	// message that is still being published once timeout passes is left for Close
+	worker.Lock()
+	worker.inFlight = 1
+	worker.Unlock()
+	assert.False(t, worker.Drain(10*time.Millisecond))
+}
+
 func TestBridgeWorker_Close(t *testing.T) {
 	worker := getDefaultBridgeWorker(t)
 
@@ -380,3 +1063,64 @@ func TestBridgeWorker_storeMessage_errors(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, msg2, msg2Json)
 }
+
+type recordingProducer struct {
+	published []producer.Message
+}
+
+func (p *recordingProducer) Publish(msg producer.Message) error {
+	p.published = append(p.published, msg)
+	return nil
+}
+
+func (p *recordingProducer) PublishBatch(msgs []producer.Message) []error {
+	p.published = append(p.published, msgs...)
+	return make([]error, len(msgs))
+}
+
+func (p *recordingProducer) Close() error {
+	return nil
+}
+
+func TestBridgeWorker_tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	global.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithSyncer(exporter),
+	))
+	defer global.SetTracerProvider(trace.NoopTracerProvider())
+
+	statsClient, _ := stats.NewClient("memory://")
+	producerMock := &recordingProducer{}
+	worker, err := NewBridgeWorker(config.WorkerConfig{}, &mockStorage{}, nil, nil, producerMock, nil, statsClient)
+	require.NoError(t, err)
+
+	delivery := amqp.Delivery{
+		Body:    []byte("body"),
+		Headers: map[string]interface{}{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}
+	require.NoError(t, worker.MessageHandler(delivery, config.Pipe{KafkaTopic: "orders", RabbitQueueName: "orders"}))
+	require.Len(t, worker.cache, 1)
+	// buffered message carries consume span trace context, so it survives storage
+	buffered := worker.cache[0].Headers["traceparent"]
+	worker.publishMessages(worker.cache)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+	transform, consume, buffer, publish := spans[0], spans[1], spans[2], spans[3]
+	assert.Equal(t, "transform", transform.Name)
+	assert.Equal(t, "consume", consume.Name)
+	assert.Equal(t, "00f067aa0ba902b7", consume.ParentSpanID.String())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+consume.SpanContext.SpanID.String()+"-01", buffered)
+	assert.Equal(t, consume.SpanContext.SpanID, transform.ParentSpanID)
+	assert.Equal(t, "buffer", buffer.Name)
+	assert.Equal(t, consume.SpanContext.SpanID, buffer.ParentSpanID)
+	assert.Equal(t, "publish", publish.Name)
+	assert.Equal(t, consume.SpanContext.SpanID, publish.ParentSpanID)
+
+	// published record continues publish span
+	require.Len(t, producerMock.published, 1)
+	assert.Equal(t, map[string]string{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-" + publish.SpanContext.SpanID.String() + "-01",
+	}, producerMock.published[0].Headers)
+}
@@ -0,0 +1,80 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/alerts"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+// bufferReportInterval is interval buffer depth and age metrics are reported with
+const bufferReportInterval = 10 * time.Second
+
+// bufferStats is snapshot of worker buffer depth and age
+type bufferStats struct {
+	messages int
+	bytes    int
+	// age is age of the oldest unpublished message, 0 if buffer is empty
+	age time.Duration
+}
+
+// bufferStats returns current buffer depth and age of the oldest cached or in-flight message,
+// must be called with worker locked
+func (w *BridgeWorker) bufferStats(now time.Time) bufferStats {
+	stats := bufferStats{messages: len(w.cache) + w.inFlight, bytes: w.bufferedBytes}
+
+	var oldest int64
+	track := func(msg *producer.Message) {
+		if msg.CreatedAt > 0 && (oldest == 0 || msg.CreatedAt < oldest) {
+			oldest = msg.CreatedAt
+		}
+	}
+	for _, msg := range w.cache {
+		track(msg)
+	}
+	for msg := range w.publishing {
+		track(msg)
+	}
+	if oldest > 0 {
+		stats.age = now.Sub(time.Unix(0, oldest))
+	}
+
+	return stats
+}
+
+// reportBuffer reports buffer depth and age metrics and warns once buffer alert thresholds are breached
+func (w *BridgeWorker) reportBuffer() {
+	w.Lock()
+	defer w.Unlock()
+
+	now := time.Now()
+	stats := w.bufferStats(now)
+	w.statsClient.TrackState(statsWorkerSection, bucket.MetricOperation{"buffer", "length"}, stats.messages)
+	w.statsClient.TrackState(statsWorkerSection, bucket.MetricOperation{"buffer", "bytes"}, stats.bytes)
+	w.statsClient.TrackState(statsWorkerSection, bucket.MetricOperation{"buffer", "age-ms"}, int(stats.age/time.Millisecond))
+
+	breached := w.bufferAlertBreached(stats)
+	fields := log.Fields{"len": stats.messages, "bytes": stats.bytes, "age": stats.age.String()}
+	if breached && !w.bufferAlerting {
+		log.WithFields(fields).Warning("Worker buffer reached alert threshold, kandalf is falling behind")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"buffer", "alert"})
+		w.alert(alerts.Event{
+			Type:     alerts.EventBufferAlert,
+			Severity: alerts.SeverityWarning,
+			Summary:  "Worker buffer reached alert threshold, kandalf is falling behind",
+			Details:  fields,
+		})
+	} else if !breached && w.bufferAlerting {
+		log.WithFields(fields).Info("Worker buffer is back below alert threshold")
+	}
+	w.bufferAlerting = breached
+}
+
+// bufferAlertBreached checks if any of configured buffer alert thresholds is reached
+func (w *BridgeWorker) bufferAlertBreached(stats bufferStats) bool {
+	return (w.config.BufferAlertMessages > 0 && stats.messages >= w.config.BufferAlertMessages) ||
+		(w.config.BufferAlertBytes > 0 && stats.bytes >= w.config.BufferAlertBytes) ||
+		(w.config.BufferAlertAge > 0 && stats.age >= w.config.BufferAlertAge)
+}
@@ -0,0 +1,65 @@
+package workers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeWorker_bufferStats(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(config.WorkerConfig{CacheSize: 10}, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	now := time.Now()
+	assert.Equal(t, bufferStats{}, worker.bufferStats(now))
+
+	inFlight := producer.NewMessage([]byte("in-flight"), "topic")
+	inFlight.CreatedAt = now.Add(-time.Minute).UnixNano()
+	cached := producer.NewMessage([]byte("cached"), "topic")
+	cached.CreatedAt = now.Add(-time.Second).UnixNano()
+	// messages stored by older versions have no creation time
+	stored := producer.NewMessage([]byte("stored"), "topic")
+	stored.CreatedAt = 0
+
+	for _, msg := range []*producer.Message{inFlight, cached, stored} {
+		require.NoError(t, worker.cacheMessage(msg))
+	}
+	worker.Lock()
+	worker.cache = worker.cache[1:]
+	worker.inFlight = 1
+	worker.publishing[inFlight] = struct{}{}
+	worker.Unlock()
+
+	assert.Equal(t, bufferStats{messages: 3, bytes: 21, age: time.Minute}, worker.bufferStats(now))
+
+	worker.messageHandled(inFlight)
+	assert.Equal(t, bufferStats{messages: 2, bytes: 12, age: time.Second}, worker.bufferStats(now))
+}
+
+func TestBridgeWorker_reportBuffer(t *testing.T) {
+	workerConfig := config.WorkerConfig{BufferAlertMessages: 2, BufferAlertAge: time.Hour}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	worker.cache = generateRandomMessages(2)
+	worker.reportBuffer()
+	worker.reportBuffer()
+	assert.True(t, worker.bufferAlerting)
+
+	worker.cache = worker.cache[:1]
+	worker.reportBuffer()
+	assert.False(t, worker.bufferAlerting)
+
+	// breach is reported once while it lasts
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.buffer.alert.-", statsWorkerSection)])
+}
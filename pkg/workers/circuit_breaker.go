@@ -0,0 +1,145 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/alerts"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+// CircuitState is state of circuit breaker that pauses messages consumption during Kafka outages
+type CircuitState int
+
+const (
+	// CircuitClosed is normal operation state, messages are consumed and published
+	CircuitClosed CircuitState = iota
+	// CircuitOpen is Kafka outage state, consumption is paused and buffered messages are not published,
+	// so RabbitMQ buffers messages instead
+	CircuitOpen
+	// CircuitHalfOpen is probing state, buffered messages are published once to check if Kafka recovered
+	CircuitHalfOpen
+)
+
+// String returns circuit state name used in logs
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+
+	return "closed"
+}
+
+// CircuitState returns current state of worker circuit breaker
+func (w *BridgeWorker) CircuitState() CircuitState {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.circuit
+}
+
+// circuitBreakerEnabled checks if circuit breaker is configured
+func (w *BridgeWorker) circuitBreakerEnabled() bool {
+	return w.config.CircuitBreakerThreshold > 0
+}
+
+// circuitPaused checks if consumption is paused by circuit breaker, half-open circuit resumes consumption
+// only when there are no buffered messages to probe Kafka with, must be called with worker locked
+func (w *BridgeWorker) circuitPaused() bool {
+	switch w.circuit {
+	case CircuitOpen:
+		return true
+	case CircuitHalfOpen:
+		return len(w.cache)+w.inFlight > 0
+	}
+
+	return false
+}
+
+// canPublish checks if buffered messages may be published, open circuit publishes nothing, while half-open
+// circuit publishes single batch at a time, must be called with worker locked
+func (w *BridgeWorker) canPublish(now time.Time) bool {
+	if w.circuit == CircuitOpen && now.Sub(w.circuitOpenedAt) >= w.config.CircuitBreakerProbeInterval {
+		w.setCircuit(CircuitHalfOpen)
+	}
+
+	switch w.circuit {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return w.inFlight == 0
+	}
+
+	return true
+}
+
+// recordPublishResults counts consecutive retriable publish failures and opens circuit when they reach threshold,
+// successful publish closes it, messages that can never be published do not indicate Kafka outage.
+// Kafka is reported unavailable by Ready while the last batch saying anything about it has no published messages.
+func (w *BridgeWorker) recordPublishResults(errs []error) {
+	failed, published := 0, 0
+	for _, err := range errs {
+		if err == nil {
+			published++
+		} else if producer.IsRetriable(err) {
+			failed++
+		}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if failed > 0 || published > 0 {
+		w.kafkaFailing = published == 0
+		w.alertPublishFailures(time.Now())
+	}
+	if !w.circuitBreakerEnabled() {
+		return
+	}
+
+	switch {
+	case failed == 0 && published == 0:
+		// batch of messages that can never be published says nothing about Kafka availability
+	case failed == 0:
+		w.publishFailures = 0
+		if w.circuit != CircuitClosed {
+			log.Info("Kafka publish succeeded, closing circuit breaker")
+			w.setCircuit(CircuitClosed)
+		}
+	case w.circuit == CircuitHalfOpen:
+		log.WithField("failed", failed).Warning("Kafka probe failed, opening circuit breaker again")
+		w.setCircuit(CircuitOpen)
+	default:
+		w.publishFailures += failed
+		if w.circuit == CircuitClosed && w.publishFailures >= w.config.CircuitBreakerThreshold {
+			log.WithField("failures", w.publishFailures).Warning("Kafka publish failures reached threshold, opening circuit breaker")
+			w.setCircuit(CircuitOpen)
+			w.alert(alerts.Event{
+				Type:     alerts.EventCircuitOpen,
+				Severity: alerts.SeverityCritical,
+				Summary:  "Circuit breaker is open, messages consumption is paused until Kafka recovers",
+				Details:  map[string]interface{}{"failures": w.config.CircuitBreakerThreshold},
+			})
+		}
+	}
+}
+
+// setCircuit switches circuit breaker state and pauses or resumes consumption, must be called with worker locked
+func (w *BridgeWorker) setCircuit(state CircuitState) {
+	w.circuit = state
+	if state == CircuitOpen {
+		w.circuitOpenedAt = time.Now()
+	}
+	if state == CircuitClosed {
+		w.publishFailures = 0
+	}
+
+	w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"circuit", state.String()})
+	w.statsClient.TrackState(statsWorkerSection, bucket.MetricOperation{"circuit", "state"}, int(state))
+
+	w.applyBackpressure()
+}
@@ -0,0 +1,84 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeWorker_circuitBreaker(t *testing.T) {
+	workerConfig := config.WorkerConfig{CacheSize: 1, CircuitBreakerThreshold: 2, CircuitBreakerProbeInterval: time.Hour}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	outage := errors.New("kafka is not available")
+	worker.recordPublishResults([]error{outage})
+	assert.Equal(t, CircuitClosed, worker.CircuitState())
+
+	// non-retriable failures do not indicate outage
+	worker.recordPublishResults([]error{producer.ErrPublishDeadlineExceeded})
+	assert.Equal(t, CircuitClosed, worker.CircuitState())
+
+	worker.recordPublishResults([]error{outage})
+	assert.Equal(t, CircuitOpen, worker.CircuitState())
+	assert.NotNil(t, worker.resumed)
+
+	// nothing is published while circuit is open
+	worker.cache = generateRandomMessages(1)
+	worker.Execute()
+	assert.Len(t, worker.cache, 1)
+	assert.Zero(t, worker.inFlight)
+
+	// probe interval passed, single probe batch is published while consumption is still paused
+	worker.circuitOpenedAt = time.Now().Add(-2 * time.Hour)
+	worker.Lock()
+	assert.True(t, worker.canPublish(time.Now()))
+	assert.Equal(t, CircuitHalfOpen, worker.circuit)
+	worker.inFlight = 1
+	assert.False(t, worker.canPublish(time.Now()))
+	worker.inFlight = 0
+	worker.Unlock()
+	assert.NotNil(t, worker.resumed)
+
+	worker.recordPublishResults([]error{outage})
+	assert.Equal(t, CircuitOpen, worker.CircuitState())
+
+	worker.circuitOpenedAt = time.Now().Add(-2 * time.Hour)
+	worker.Lock()
+	assert.True(t, worker.canPublish(time.Now()))
+	worker.Unlock()
+	worker.recordPublishResults([]error{nil})
+	assert.Equal(t, CircuitClosed, worker.CircuitState())
+	assert.Nil(t, worker.resumed)
+	assert.Zero(t, worker.publishFailures)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 2, memoryStats.CountMetrics[fmt.Sprintf("%s.circuit.open.-", statsWorkerSection)])
+	assert.Equal(t, 2, memoryStats.CountMetrics[fmt.Sprintf("%s.circuit.half-open.-", statsWorkerSection)])
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.circuit.closed.-", statsWorkerSection)])
+}
+
+func TestBridgeWorker_circuitBreaker_halfOpenWithoutMessages(t *testing.T) {
+	workerConfig := config.WorkerConfig{CircuitBreakerThreshold: 1}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	worker.recordPublishResults([]error{errors.New("kafka is not available")})
+	require.NotNil(t, worker.resumed)
+
+	// there are no buffered messages to probe Kafka with, so consumption is resumed to get some
+	worker.Lock()
+	worker.setCircuit(CircuitHalfOpen)
+	worker.Unlock()
+	assert.Nil(t, worker.resumed)
+}
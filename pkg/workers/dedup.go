@@ -0,0 +1,71 @@
+package workers
+
+import (
+	"strings"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/dedup"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+// messageDedupKey evaluates pipe dedup key expression for the message, empty key is returned
+// if message has no value for the expression, so it is not deduplicated
+func messageDedupKey(expression string, msg amqp.Delivery) (string, error) {
+	switch {
+	case expression == "":
+		return "", nil
+	case expression == config.DedupMessageID:
+		return msg.MessageID, nil
+	case strings.HasPrefix(expression, config.PartitionKeyHeaderPrefix):
+		return keyString(msg.Headers[strings.TrimPrefix(expression, config.PartitionKeyHeaderPrefix)]), nil
+	case strings.HasPrefix(expression, config.PartitionKeyJSONPrefix):
+		return jsonFieldKey(msg.Body, strings.TrimPrefix(expression, config.PartitionKeyJSONPrefix))
+	}
+
+	return "", config.ErrInvalidDedupKey
+}
+
+// dedupStoreFor returns dedup store message key is looked up in, replicated messages keys are kept in replica,
+// so the new leader drops messages published by the previous one
+func (w *BridgeWorker) dedupStoreFor(msg *producer.Message) dedup.Store {
+	if msg.Replicated {
+		if store, ok := w.replica.(dedup.Store); ok {
+			return store
+		}
+	}
+
+	return w.dedupKeys
+}
+
+// isDuplicate checks if message with the same dedup key was published within dedup window
+func (w *BridgeWorker) isDuplicate(msg *producer.Message) bool {
+	if msg.DedupKey == "" || !w.dedupStoreFor(msg).Seen(msg.DedupKey) {
+		return false
+	}
+
+	log.WithField("msg", msg.String()).WithField("dedup_key", msg.DedupKey).Debug("Message was already published, dropping duplicate")
+	w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"dedup", "dropped", msg.Topic})
+
+	return true
+}
+
+// rememberPublished adds dedup keys of published messages to dedup window
+func (w *BridgeWorker) rememberPublished(messages []*producer.Message) {
+	keys := make(map[dedup.Store][]string)
+	for _, msg := range messages {
+		if msg.DedupKey != "" {
+			store := w.dedupStoreFor(msg)
+			keys[store] = append(keys[store], msg.DedupKey)
+		}
+	}
+
+	for store, storeKeys := range keys {
+		if err := store.Add(storeKeys...); err != nil {
+			// messages are published once already, so the worst case is a duplicate on redelivery
+			log.WithError(err).WithField("len", len(storeKeys)).Error("Failed to add published messages to dedup window")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package workers
+
+import (
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageDedupKey(t *testing.T) {
+	msg := amqp.Delivery{
+		Body:       []byte(`{"event":{"id":"e-1"}}`),
+		MessageID:  "m-1",
+		RoutingKey: "order.created",
+		Headers:    map[string]interface{}{"idempotency-key": "i-1"},
+	}
+
+	for expression, expected := range map[string]string{
+		"":                       "",
+		"messageId":              "m-1",
+		"header:idempotency-key": "i-1",
+		"header:missing":         "",
+		"json:event.id":          "e-1",
+		"json:missing":           "",
+	} {
+		key, err := messageDedupKey(expression, msg)
+		assert.NoError(t, err, expression)
+		assert.Equal(t, expected, key, expression)
+	}
+
+	_, err := messageDedupKey("routingKey", msg)
+	assert.Equal(t, config.ErrInvalidDedupKey, err)
+}
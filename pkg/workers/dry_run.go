@@ -0,0 +1,32 @@
+package workers
+
+import (
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+// dryRunMessage logs what would be published for dry run pipe message instead of accepting it, message is
+// acknowledged, as dry run pipe consumes copies of pipe messages
+func (w *BridgeWorker) dryRunMessage(msg *producer.Message, pipe config.Pipe) {
+	fields := log.Fields{
+		"pipe":    pipe.String(),
+		"msg":     msg.String(),
+		"topic":   msg.Topic,
+		"key":     msg.Key,
+		"size":    len(msg.Body),
+		"cluster": msg.Cluster,
+	}
+	if pipe.KafkaMaxMessageBytes > 0 && len(msg.Body) > pipe.KafkaMaxMessageBytes {
+		policy := pipe.KafkaOversizePolicy
+		if policy == "" {
+			policy = config.OversizePolicyDeadLetter
+		}
+		fields["max_size"] = pipe.KafkaMaxMessageBytes
+		fields["oversize_policy"] = policy
+	}
+
+	log.WithFields(fields).Info("Dry run, message would be published")
+	w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"dryrun", "logged", msg.Topic})
+}
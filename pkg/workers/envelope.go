@@ -0,0 +1,75 @@
+package workers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+)
+
+// envelope is message body wrapped with bridge metadata, so downstream consumers know where message came from
+type envelope struct {
+	// Body is JSON body embedded as is in JSON envelope, or body in Avro JSON encoding in avro envelope
+	Body json.RawMessage `json:"body,omitempty"`
+	// BodyBase64 is body that is not JSON in JSON envelope, it is encoded with base64
+	BodyBase64    []byte            `json:"bodyBase64,omitempty"`
+	Exchange      string            `json:"exchange"`
+	RoutingKey    string            `json:"routingKey"`
+	Headers       map[string]string `json:"headers"`
+	ContentType   string            `json:"contentType"`
+	CorrelationID string            `json:"correlationId"`
+	MessageID     string            `json:"messageId"`
+	// Timestamp is AMQP timestamp property as Unix time in milliseconds, 0 if message has no timestamp
+	Timestamp int64 `json:"timestamp"`
+	// ConsumedAt is time message was consumed at as Unix time in milliseconds
+	ConsumedAt int64 `json:"consumedAt"`
+	// Node is id of kandalf instance that consumed message
+	Node string `json:"node"`
+}
+
+// envelopeBody returns message body wrapped into envelope of the given format with AMQP delivery metadata
+func envelopeBody(format string, msg *producer.Message, delivery amqp.Delivery, node string) ([]byte, error) {
+	e := envelope{
+		Exchange:      delivery.Exchange,
+		RoutingKey:    delivery.RoutingKey,
+		Headers:       make(map[string]string, len(delivery.Headers)),
+		ContentType:   delivery.ContentType,
+		CorrelationID: delivery.CorrelationID,
+		MessageID:     delivery.MessageID,
+		ConsumedAt:    msg.CreatedAt / int64(time.Millisecond),
+		Node:          node,
+	}
+	for name, value := range delivery.Headers {
+		e.Headers[name] = keyString(value)
+	}
+	if !delivery.Timestamp.IsZero() {
+		e.Timestamp = delivery.Timestamp.UnixNano() / int64(time.Millisecond)
+	}
+
+	switch {
+	case format == config.EnvelopeAvro:
+		body, err := json.Marshal(avroBytes(msg.Body))
+		if err != nil {
+			return nil, err
+		}
+		e.Body = body
+	case json.Valid(msg.Body):
+		e.Body = msg.Body
+	default:
+		e.BodyBase64 = msg.Body
+	}
+
+	return json.Marshal(e)
+}
+
+// avroBytes returns bytes in Avro JSON encoding, that is a string with every byte as code point from U+0000 to U+00FF
+func avroBytes(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+
+	return string(runes)
+}
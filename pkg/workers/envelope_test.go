@@ -0,0 +1,80 @@
+package workers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeBody(t *testing.T) {
+	consumedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	delivery := amqp.Delivery{
+		Exchange:      "customers",
+		RoutingKey:    "order.created",
+		Headers:       map[string]interface{}{"tenant": "acme", "attempt": int32(2)},
+		ContentType:   "application/json",
+		CorrelationID: "correlation-1",
+		MessageID:     "message-1",
+		Timestamp:     consumedAt.Add(-time.Second),
+	}
+	msg := &producer.Message{Body: []byte(`{"id": 1}`), CreatedAt: consumedAt.UnixNano()}
+
+	body, err := envelopeBody(config.EnvelopeJSON, msg, delivery, "kandalf-1")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"body": {"id": 1},
+		"exchange": "customers",
+		"routingKey": "order.created",
+		"headers": {"tenant": "acme", "attempt": "2"},
+		"contentType": "application/json",
+		"correlationId": "correlation-1",
+		"messageId": "message-1",
+		"timestamp": 1577934244000,
+		"consumedAt": 1577934245000,
+		"node": "kandalf-1"
+	}`, string(body))
+
+	// body that is not JSON is encoded with base64
+	msg.Body = []byte{0xff, 'a'}
+	body, err = envelopeBody(config.EnvelopeJSON, msg, amqp.Delivery{}, "kandalf-1")
+	require.NoError(t, err)
+
+	var e envelope
+	require.NoError(t, json.Unmarshal(body, &e))
+	assert.Empty(t, e.Body)
+	assert.Equal(t, msg.Body, e.BodyBase64)
+	assert.Zero(t, e.Timestamp)
+	assert.NotNil(t, e.Headers)
+
+	// avro envelope body is bytes in Avro JSON encoding, every byte is a code point
+	body, err = envelopeBody(config.EnvelopeAvro, msg, delivery, "kandalf-1")
+	require.NoError(t, err)
+
+	var avroEnvelope map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &avroEnvelope))
+	assert.Equal(t, "ÿa", avroEnvelope["body"])
+	assert.NotContains(t, avroEnvelope, "bodyBase64")
+	assert.Equal(t, "kandalf-1", avroEnvelope["node"])
+}
+
+func TestBridgeWorker_MessageHandler_envelope(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+	worker.config.NodeID = "kandalf-1"
+	pipe := config.Pipe{KafkaTopic: "orders", KafkaEnvelope: config.EnvelopeJSON}
+
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte(`{"id":1}`), RoutingKey: "order.created"}, pipe))
+	require.Len(t, worker.cache, 1)
+
+	var e envelope
+	require.NoError(t, json.Unmarshal(worker.cache[0].Body, &e))
+	assert.JSONEq(t, `{"id":1}`, string(e.Body))
+	assert.Equal(t, "order.created", e.RoutingKey)
+	assert.Equal(t, "kandalf-1", e.Node)
+	assert.Equal(t, worker.cache[0].CreatedAt/int64(time.Millisecond), e.ConsumedAt)
+}
@@ -0,0 +1,118 @@
+package workers
+
+import (
+	"sync"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+// fanOut publishes message to its destination topic and its copies to pipe sinks. Copies are buffered, published
+// and retried independently, while AMQP delivery result depends on pipe sink policy - with "all" policy delivery is
+// requeued or rejected if any copy fails to be accepted or, for end-to-end delivery, published, with "primary"
+// policy only destination topic message counts.
+func (w *BridgeWorker) fanOut(msg *producer.Message, pipe config.Pipe, delivery amqp.Delivery, settle func(err error)) error {
+	copies := sinkMessages(msg, pipe, delivery)
+
+	if pipe.KafkaSinkPolicy == config.SinkPolicyPrimary {
+		err := w.handleMessage(msg, pipe, delivery, settle)
+		if err != nil && err != amqp.ErrAckDeferred {
+			// sinks get the message on redelivery, if it is requeued
+			return err
+		}
+
+		for _, sinkMsg := range copies {
+			// sink copies are accepted without deferred settlement, so they never hold back AMQP delivery
+			if sinkErr := w.handleMessage(sinkMsg, pipe, delivery, nil); sinkErr != nil {
+				log.WithError(sinkErr).WithField("msg", sinkMsg.String()).Warning("Failed to accept message for pipe sink, dropping it")
+				w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"sink", "failed", sinkMsg.Topic})
+			}
+		}
+
+		return err
+	}
+
+	messages := append([]*producer.Message{msg}, copies...)
+	if settle != nil {
+		settle = fanOutSettle(settle, len(messages))
+	}
+
+	for i, m := range messages {
+		err := w.handleMessage(m, pipe, delivery, settle)
+		if err == amqp.ErrAckDeferred {
+			continue
+		}
+		if settle != nil {
+			settle(err)
+		}
+		if err == nil {
+			continue
+		}
+
+		log.WithError(err).WithField("msg", m.String()).Warning("Failed to accept message copy, failing the whole delivery")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"sink", "failed", m.Topic})
+		if settle == nil {
+			return err
+		}
+
+		// the rest of copies are not accepted, as delivery is requeued or rejected anyway
+		for range messages[i+1:] {
+			settle(err)
+		}
+		break
+	}
+
+	if settle != nil {
+		return amqp.ErrAckDeferred
+	}
+	return nil
+}
+
+// sinkMessages returns message copies for pipe sinks, copies keep message id, so they can be traced back to it,
+// and get their own order and dedup keys, so they are ordered and deduplicated within their topics
+func sinkMessages(msg *producer.Message, pipe config.Pipe, delivery amqp.Delivery) []*producer.Message {
+	copies := make([]*producer.Message, 0, len(pipe.KafkaSinks))
+	for _, topic := range pipe.KafkaSinks {
+		sinkMsg := *msg
+		sinkMsg.Topic = topic
+		sinkMsg.OrderKey = orderKey(pipe, &sinkMsg, delivery)
+		if msg.DedupKey != "" {
+			sinkMsg.DedupKey = msg.DedupKey + "@" + topic
+		}
+		if msg.Headers != nil {
+			sinkMsg.Headers = make(map[string]string, len(msg.Headers))
+			for k, v := range msg.Headers {
+				sinkMsg.Headers[k] = v
+			}
+		}
+
+		copies = append(copies, &sinkMsg)
+	}
+
+	return copies
+}
+
+// fanOutSettle returns settlement shared by n copies of end-to-end message, AMQP delivery is settled once all
+// the copies are settled, with the first error if some of them failed
+func fanOutSettle(settle func(err error), n int) func(err error) {
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	return func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		n--
+		if n == 0 {
+			settle(firstErr)
+		}
+	}
+}
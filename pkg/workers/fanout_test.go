@@ -0,0 +1,139 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// topicEncoder fails to encode messages of a single topic
+type topicEncoder struct {
+	failTopic string
+	err       error
+}
+
+func (e *topicEncoder) Encode(settings config.SchemaSettings, topic string, body []byte) ([]byte, error) {
+	if topic == e.failTopic {
+		return nil, e.err
+	}
+
+	return append([]byte(topic+":"), body...), nil
+}
+
+func TestBridgeWorker_MessageHandler_fanOut(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, &mockEncoder{}, statsClient)
+	require.NoError(t, err)
+
+	pipe := config.Pipe{
+		KafkaTopic:      "orders",
+		RabbitQueueName: "shop",
+		KafkaSinks:      []string{"orders-audit"},
+		KafkaHeaders:    &config.HeadersMapping{},
+		KafkaSchema:     &config.SchemaSettings{Type: config.SchemaTypeJSON},
+		DedupKey:        "messageId",
+	}
+	delivery := amqp.Delivery{Body: []byte("body"), MessageID: "m-1", Headers: map[string]interface{}{"tenant": "eu"}}
+	require.NoError(t, worker.MessageHandler(delivery, pipe))
+
+	// copies are encoded for their own topics and deduplicated independently
+	require.Len(t, worker.cache, 2)
+	primary, sink := worker.cache[0], worker.cache[1]
+	assert.Equal(t, "orders", primary.Topic)
+	assert.Equal(t, "orders-audit", sink.Topic)
+	assert.Equal(t, primary.ID, sink.ID)
+	assert.Equal(t, []byte("orders:body"), primary.Body)
+	assert.Equal(t, []byte("orders-audit:body"), sink.Body)
+	assert.Equal(t, "shop:m-1", primary.DedupKey)
+	assert.Equal(t, "shop:m-1@orders-audit", sink.DedupKey)
+	assert.Equal(t, primary.Headers, sink.Headers)
+
+	sink.Headers["tenant"] = "us"
+	assert.Equal(t, "eu", primary.Headers["tenant"])
+}
+
+func TestBridgeWorker_MessageHandler_fanOutPolicy(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	encoder := &topicEncoder{failTopic: "orders-audit", err: errors.New("registry is not available")}
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, encoder, statsClient)
+	require.NoError(t, err)
+
+	pipe := config.Pipe{
+		KafkaTopic:  "orders",
+		KafkaSinks:  []string{"orders-audit", "orders-archive"},
+		KafkaSchema: &config.SchemaSettings{Type: config.SchemaTypeJSON},
+	}
+
+	// delivery is requeued at the first failed sink, so the rest of sinks are not accepted
+	assert.Equal(t, encoder.err, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, "orders", worker.cache[0].Topic)
+
+	// with primary policy sink failure does not fail delivery
+	pipe.KafkaSinkPolicy = config.SinkPolicyPrimary
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+	require.Len(t, worker.cache, 3)
+	assert.Equal(t, "orders", worker.cache[1].Topic)
+	assert.Equal(t, "orders-archive", worker.cache[2].Topic)
+
+	// sinks are not accepted if primary topic fails
+	encoder.failTopic = "orders"
+	assert.Equal(t, encoder.err, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe))
+	assert.Len(t, worker.cache, 3)
+
+	memoryStats, _ := statsClient.(*client.Memory)
+	assert.Equal(t, 2, memoryStats.CountMetrics[statsWorkerSection+".sink.failed.orders-audit"])
+}
+
+func TestBridgeWorker_MessageHandler_fanOutEndToEnd(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	mockProducer := &mockProducer{t: t}
+	buffer := &mockBuffer{data: map[uint64][]byte{}}
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, buffer, nil, mockProducer, nil, statsClient)
+	require.NoError(t, err)
+
+	var settled []error
+	delivery := amqp.Delivery{Body: []byte("body"), Settle: func(err error) { settled = append(settled, err) }}
+	pipe := config.Pipe{KafkaTopic: "orders", KafkaSinks: []string{"orders-audit"}, KafkaDelivery: config.DeliveryEndToEnd}
+	assert.Equal(t, amqp.ErrAckDeferred, worker.MessageHandler(delivery, pipe))
+	require.Len(t, worker.cache, 2)
+	assert.Empty(t, buffer.data)
+
+	// delivery is settled once all the copies are published, with the first failure
+	messages := worker.cache
+	worker.cache = nil
+	publishErr := errors.New("leader not available")
+	mockProducer.publishAssertParam = []producer.Message{*messages[0]}
+	mockProducer.publishResult = []error{nil}
+	worker.publishMessages(messages[:1])
+	assert.Empty(t, settled)
+
+	mockProducer.publishAssertParam = append(mockProducer.publishAssertParam, *messages[1])
+	mockProducer.publishResult = append(mockProducer.publishResult, publishErr)
+	worker.publishMessages(messages[1:])
+	assert.Equal(t, []error{publishErr}, settled)
+
+	// with primary policy sink copy is accepted as at-least-once message, so it is written to disk buffer
+	settled = nil
+	pipe.KafkaSinkPolicy = config.SinkPolicyPrimary
+	assert.Equal(t, amqp.ErrAckDeferred, worker.MessageHandler(delivery, pipe))
+	require.Len(t, worker.cache, 2)
+	assert.Len(t, buffer.data, 1)
+
+	messages = worker.cache
+	worker.cache = nil
+	mockProducer.publishAssertParam = append(mockProducer.publishAssertParam, *messages[0])
+	mockProducer.publishResult = append(mockProducer.publishResult, nil)
+	worker.publishMessages(messages[:1])
+	assert.Equal(t, []error{nil}, settled)
+}
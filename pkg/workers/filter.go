@@ -0,0 +1,68 @@
+package workers
+
+import (
+	"encoding/json"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+// filterMatches checks if message must be forwarded to Kafka, that is pipe has no filter or its filter expression
+// evaluated with the message data is true
+func (w *BridgeWorker) filterMatches(pipe config.Pipe, delivery amqp.Delivery) (bool, error) {
+	if pipe.Filter == "" {
+		return true, nil
+	}
+
+	program, err := w.filterProgram(pipe.Filter)
+	if err != nil {
+		return false, err
+	}
+
+	headers := make(map[string]string, len(delivery.Headers))
+	for name, value := range delivery.Headers {
+		headers[name] = keyString(value)
+	}
+	result, _, err := program.Eval(map[string]interface{}{
+		config.FilterRoutingKey: delivery.RoutingKey,
+		config.FilterExchange:   delivery.Exchange,
+		config.FilterHeaders:    headers,
+		config.FilterBody:       string(delivery.Body),
+		// body is decoded only if expression refers to its data, JSON numbers are doubles in expression
+		config.FilterData: func() ref.Val {
+			var data interface{}
+			if err := json.Unmarshal(delivery.Body, &data); err != nil {
+				return types.NewErr("failed to decode message body: %s", err)
+			}
+			return types.DefaultTypeAdapter.NativeToValue(data)
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	matches, ok := result.Value().(bool)
+	if !ok {
+		return false, config.ErrFilterNotBool
+	}
+
+	return matches, nil
+}
+
+// filterProgram returns compiled filter expression, expressions are compiled once and cached by their text
+func (w *BridgeWorker) filterProgram(expr string) (cel.Program, error) {
+	if program, ok := w.filters.Load(expr); ok {
+		return program.(cel.Program), nil
+	}
+
+	program, err := config.CompileFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	w.filters.Store(expr, program)
+
+	return program, nil
+}
@@ -0,0 +1,65 @@
+package workers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeWorker_filterMatches(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+
+	delivery := amqp.Delivery{
+		Body:       []byte(`{"order":{"type":"subscription"}}`),
+		RoutingKey: "order.created",
+		Headers:    map[string]interface{}{"region": "eu", "x-test-user": true},
+	}
+
+	for filter, expected := range map[string]bool{
+		"":                                      true,
+		`routingKey == "order.created"`:         true,
+		`headers.region == "us"`:                false,
+		`headers["x-test-user"] != "true"`:      false,
+		`data.order.type == "subscription"`:     true,
+		`body.contains("subscription")`:         true,
+		`exchange == "" && "region" in headers`: true,
+		`headers.region == "eu" && data.order.type == "one-off"`: false,
+		`headers.region == "eu" || data.order.type == "one-off"`: true,
+		// data is not decoded if expression is decided without it
+		`routingKey.startsWith("customer.") && data.order.type == "one-off"`: false,
+	} {
+		matches, err := worker.filterMatches(config.Pipe{Filter: filter}, delivery)
+		assert.NoError(t, err, filter)
+		assert.Equal(t, expected, matches, filter)
+	}
+
+	_, err := worker.filterMatches(config.Pipe{Filter: `data.order.type == "one-off"`}, amqp.Delivery{Body: []byte("not a json")})
+	assert.Error(t, err)
+
+	// missing header or field fails evaluation
+	_, err = worker.filterMatches(config.Pipe{Filter: `headers.tenant == "de"`}, delivery)
+	assert.Error(t, err)
+}
+
+func TestBridgeWorker_MessageHandler_filter(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+	pipe := config.Pipe{KafkaTopic: "orders", RabbitQueueName: "kandalf-orders", Filter: `headers.region == "eu"`}
+
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("eu"), Headers: map[string]interface{}{"region": "eu"}}, pipe))
+	assert.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("us"), Headers: map[string]interface{}{"region": "us"}}, pipe))
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, []byte("eu"), worker.cache[0].Body)
+
+	memoryStats := worker.statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.filter.dropped.%s", statsWorkerSection, pipe.RabbitQueueName)])
+
+	// message that can not be evaluated is rejected, so it is dead-lettered
+	pipe.Filter = `data.region == "eu"`
+	assert.Equal(t, amqp.ErrRejectMessage, worker.MessageHandler(amqp.Delivery{Body: []byte("not a json")}, pipe))
+	assert.Len(t, worker.cache, 1)
+}
@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+// Kafka record headers AMQP message properties are copied to
+const (
+	headerContentType   = "content-type"
+	headerCorrelationID = "correlation-id"
+	headerMessageID     = "message-id"
+	headerTimestamp     = "timestamp"
+	headerExchange      = "exchange"
+	headerRoutingKey    = "routing-key"
+)
+
+// recordHeaders returns Kafka record headers for the message according to pipe headers mapping,
+// message properties are copied along with headers, empty values are skipped
+func recordHeaders(mapping config.HeadersMapping, msg amqp.Delivery) map[string]string {
+	headers := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" && mapping.Allowed(name) {
+			headers[name] = value
+		}
+	}
+
+	for name, value := range msg.Headers {
+		set(name, headerString(value))
+	}
+
+	set(headerContentType, msg.ContentType)
+	set(headerCorrelationID, msg.CorrelationID)
+	set(headerMessageID, msg.MessageID)
+	set(headerExchange, msg.Exchange)
+	set(headerRoutingKey, msg.RoutingKey)
+	if !msg.Timestamp.IsZero() {
+		set(headerTimestamp, msg.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+
+	return headers
+}
+
+func headerString(value interface{}) string {
+	switch v := value.(type) {
+	case nil, string, []byte:
+		return keyString(value)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		// nested tables and arrays are not representable as plain strings
+		data, err := json.Marshal(value)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	return keyString(value)
+}
@@ -0,0 +1,49 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordHeaders(t *testing.T) {
+	msg := amqp.Delivery{
+		Exchange:      "customers",
+		RoutingKey:    "order.created",
+		ContentType:   "application/json",
+		CorrelationID: "correlation",
+		Timestamp:     time.Date(2018, 7, 1, 12, 30, 0, 0, time.UTC),
+		Headers: map[string]interface{}{
+			"customer-id": "c-1",
+			"retries":     int32(3),
+			"tags":        []interface{}{"a", "b"},
+		},
+	}
+
+	assert.Equal(t, map[string]string{
+		"customer-id":    "c-1",
+		"retries":        "3",
+		"tags":           `["a","b"]`,
+		"content-type":   "application/json",
+		"correlation-id": "correlation",
+		"timestamp":      "2018-07-01T12:30:00Z",
+		"exchange":       "customers",
+		"routing-key":    "order.created",
+	}, recordHeaders(config.HeadersMapping{}, msg))
+
+	assert.Equal(t, map[string]string{
+		"customer-id": "c-1",
+		"routing-key": "order.created",
+	}, recordHeaders(config.HeadersMapping{Include: []string{"customer-id", "routing-key", "message-id"}}, msg))
+
+	assert.Equal(t, map[string]string{
+		"customer-id":  "c-1",
+		"content-type": "application/json",
+	}, recordHeaders(config.HeadersMapping{
+		Include: []string{"customer-id", "content-type", "exchange"},
+		Exclude: []string{"exchange"},
+	}, msg))
+}
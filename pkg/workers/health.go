@@ -0,0 +1,27 @@
+package workers
+
+import "errors"
+
+var (
+	// ErrKafkaUnavailable is readiness error worker returns while Kafka publishes fail or circuit breaker is not closed
+	ErrKafkaUnavailable = errors.New("kafka is not available")
+	// ErrConsumptionPaused is readiness error worker returns while messages consumption is paused, e.g. because
+	// buffer reached high water mark or limits, or worker is draining
+	ErrConsumptionPaused = errors.New("messages consumption is paused")
+)
+
+// Ready checks if worker is able to bridge messages - Kafka is available and worker buffer is below its thresholds,
+// so consumption is not paused
+func (w *BridgeWorker) Ready() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.kafkaFailing || w.circuit != CircuitClosed {
+		return ErrKafkaUnavailable
+	}
+	if w.resumed != nil {
+		return ErrConsumptionPaused
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeWorker_Ready(t *testing.T) {
+	workerConfig := config.WorkerConfig{CacheHighWaterMark: 2}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+	assert.NoError(t, worker.Ready())
+
+	// Kafka is unavailable until some message is published, even without circuit breaker
+	outage := errors.New("kafka is not available")
+	worker.recordPublishResults([]error{outage, outage})
+	assert.Equal(t, ErrKafkaUnavailable, worker.Ready())
+
+	worker.recordPublishResults([]error{producer.ErrPublishDeadlineExceeded})
+	assert.Equal(t, ErrKafkaUnavailable, worker.Ready())
+
+	worker.recordPublishResults([]error{nil, outage})
+	assert.NoError(t, worker.Ready())
+
+	// buffer reaching high water mark pauses consumption
+	worker.Lock()
+	worker.cache = generateRandomMessages(2)
+	worker.applyBackpressure()
+	worker.Unlock()
+	assert.Equal(t, ErrConsumptionPaused, worker.Ready())
+}
@@ -0,0 +1,25 @@
+package workers
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var errWorkerHung = errors.New("worker loop is blocked")
+
+// markCycle records start of worker loop cycle
+func (w *BridgeWorker) markCycle() {
+	atomic.StoreInt64(&w.cycledAt, time.Now().UnixNano())
+}
+
+// Alive returns error if worker loop has not started a new cycle within timeout, e.g. it is blocked on publishing
+// or on worker lock, it is never alive before the loop is started
+func (w *BridgeWorker) Alive(timeout time.Duration) error {
+	cycledAt := atomic.LoadInt64(&w.cycledAt)
+	if cycledAt == 0 || time.Since(time.Unix(0, cycledAt)) > timeout {
+		return errWorkerHung
+	}
+
+	return nil
+}
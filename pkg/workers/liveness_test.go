@@ -0,0 +1,25 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeWorker_Alive(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(config.WorkerConfig{}, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	assert.Equal(t, errWorkerHung, worker.Alive(time.Minute))
+
+	worker.markCycle()
+	assert.NoError(t, worker.Alive(time.Minute))
+
+	worker.cycledAt = time.Now().Add(-2 * time.Minute).UnixNano()
+	assert.Equal(t, errWorkerHung, worker.Alive(time.Minute))
+}
@@ -0,0 +1,81 @@
+package workers
+
+import (
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+// orderKey returns topic scoped key messages of key ordered pipe are published in consume order by, that is
+// message partition key, or delivery routing key if pipe has no partition key, empty key for unordered pipes
+func orderKey(pipe config.Pipe, msg *producer.Message, delivery amqp.Delivery) string {
+	if !pipe.KafkaKeyOrdering {
+		return ""
+	}
+
+	key := msg.Key
+	if pipe.KafkaPartitionKey == "" {
+		key = delivery.RoutingKey
+	}
+
+	return msg.Topic + ":" + key
+}
+
+// takeBatch takes messages to publish from cache, ordered messages with key that has message being published
+// are kept in cache, so messages with the same key are published one at a time, must be called with worker locked
+func (w *BridgeWorker) takeBatch() []*producer.Message {
+	messages := make([]*producer.Message, 0, len(w.cache))
+	held := []*producer.Message{}
+	for _, msg := range w.cache {
+		if msg.OrderKey != "" {
+			if _, ok := w.orderKeys[msg.OrderKey]; ok {
+				held = append(held, msg)
+				continue
+			}
+			w.orderKeys[msg.OrderKey] = struct{}{}
+		}
+		messages = append(messages, msg)
+	}
+	w.cache = held
+
+	return messages
+}
+
+// retryInPlace checks if ordered message failed to be published must be retried from cache, as moving it
+// to storage would let messages with the same key to be published ahead of it
+func retryInPlace(msg *producer.Message, err error) bool {
+	return msg.OrderKey != "" && producer.IsRetriable(err) && msg.Delivery != config.DeliveryFireAndForget
+}
+
+// releaseOrderKeys returns retried ordered messages to the head of cache, as they precede the rest of cached
+// messages with their keys, and releases keys of published batch, so messages with them are published next
+func (w *BridgeWorker) releaseOrderKeys(messages []*producer.Message, retried []*producer.Message) {
+	for _, msg := range retried {
+		log.WithField("msg", msg.String()).WithField("attempts", msg.Attempts).
+			Warning("Failed to publish ordered message to Kafka, retrying it before the rest of messages with its key")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"ordered", "retry", msg.Topic})
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if len(retried) > 0 {
+		cache := make([]*producer.Message, 0, len(retried)+len(w.cache))
+		w.cache = append(append(cache, retried...), w.cache...)
+		for _, msg := range retried {
+			// message goes back to cache without leaving buffer, so its bytes are still counted
+			w.inFlight--
+			delete(w.publishing, msg)
+		}
+	}
+
+	for _, msg := range messages {
+		if msg.OrderKey != "" {
+			delete(w.orderKeys, msg.OrderKey)
+		}
+	}
+
+	w.applyBackpressure()
+}
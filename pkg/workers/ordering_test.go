@@ -0,0 +1,92 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderKey(t *testing.T) {
+	delivery := amqp.Delivery{RoutingKey: "order.created"}
+	msg := &producer.Message{Topic: "orders", Key: "c-1"}
+
+	assert.Empty(t, orderKey(config.Pipe{KafkaPartitionKey: "json:customer.id"}, msg, delivery))
+	assert.Equal(t, "orders:c-1", orderKey(config.Pipe{KafkaKeyOrdering: true, KafkaPartitionKey: "json:customer.id"}, msg, delivery))
+	assert.Equal(t, "orders:order.created", orderKey(config.Pipe{KafkaKeyOrdering: true}, msg, delivery))
+}
+
+func TestBridgeWorker_publishMessages_keyOrdering(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+
+	mockProducer := &mockProducer{t: t}
+	worker.producer = mockProducer
+
+	messages := generateRandomMessages(4)
+	messages[0].OrderKey = "topic:a"
+	messages[1].OrderKey = "topic:a"
+	messages[2].OrderKey = "topic:b"
+	worker.cache = messages
+	for _, msg := range messages {
+		worker.bufferedBytes += len(msg.Body)
+	}
+
+	// only the first message with the key is published, the next one waits for it
+	worker.Lock()
+	batch := worker.takeBatch()
+	worker.inFlight += len(batch)
+	worker.Unlock()
+	assert.Equal(t, []*producer.Message{messages[0], messages[2], messages[3]}, batch)
+	assert.Equal(t, []*producer.Message{messages[1]}, worker.cache)
+
+	mockProducer.publishAssertParam = []producer.Message{*messages[0], *messages[2], *messages[3]}
+	mockProducer.publishResult = []error{errors.New("kafka is not available"), nil, nil}
+
+	// failed ordered message is retried ahead of the next message with its key instead of moving to storage
+	worker.publishMessages(batch)
+	assert.Equal(t, []*producer.Message{messages[0], messages[1]}, worker.cache)
+	assert.Equal(t, 1, messages[0].Attempts)
+	assert.Zero(t, worker.inFlight)
+	assert.Equal(t, len(messages[0].Body)+len(messages[1].Body), worker.bufferedBytes)
+	assert.Empty(t, worker.orderKeys)
+
+	memoryStats := worker.statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.ordered.retry.%s", statsWorkerSection, messages[0].Topic)])
+
+	worker.Lock()
+	batch = worker.takeBatch()
+	worker.Unlock()
+	assert.Equal(t, []*producer.Message{messages[0]}, batch)
+	assert.Equal(t, []*producer.Message{messages[1]}, worker.cache)
+}
+
+func TestBridgeWorker_publishMessages_keyOrderingFatalError(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+
+	mockProducer := &mockProducer{t: t}
+	worker.producer = mockProducer
+
+	messages := generateRandomMessages(1)
+	messages[0].OrderKey = "topic:a"
+	worker.cache = messages
+
+	worker.Lock()
+	batch := worker.takeBatch()
+	worker.inFlight += len(batch)
+	worker.Unlock()
+	require.Len(t, batch, 1)
+
+	mockProducer.publishAssertParam = []producer.Message{*messages[0]}
+	mockProducer.publishResult = []error{producer.ErrPublishDeadlineExceeded}
+
+	// message that can never be published does not hold back the rest of messages with its key
+	worker.publishMessages(batch)
+	assert.Empty(t, worker.cache)
+	assert.Empty(t, worker.orderKeys)
+}
@@ -0,0 +1,113 @@
+package workers
+
+import (
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+func validOverflowPolicy(policy string) bool {
+	switch policy {
+	case "", config.OverflowPolicyBlock, config.OverflowPolicyDropOldest, config.OverflowPolicyDropNewest, config.OverflowPolicySpill,
+		config.OverflowPolicySpillToStorage:
+		return true
+	}
+
+	return false
+}
+
+// blockOverflow checks if consumption is paused while buffer is full, that is the default overflow policy
+func (w *BridgeWorker) blockOverflow() bool {
+	return w.config.BufferOverflowPolicy == "" || w.config.BufferOverflowPolicy == config.OverflowPolicyBlock
+}
+
+// overflows checks if message of given size does not fit buffer limits, must be called with worker locked
+func (w *BridgeWorker) overflows(size int) bool {
+	if w.config.BufferMaxMessages > 0 && len(w.cache)+w.inFlight >= w.config.BufferMaxMessages {
+		return true
+	}
+
+	return w.config.BufferMaxBytes > 0 && w.bufferedBytes+size > w.config.BufferMaxBytes
+}
+
+// full checks if buffer limits are reached, so there is no room for any new message, must be called with worker locked
+func (w *BridgeWorker) full() bool {
+	if w.config.BufferMaxMessages > 0 && len(w.cache)+w.inFlight >= w.config.BufferMaxMessages {
+		return true
+	}
+
+	return w.config.BufferMaxBytes > 0 && w.bufferedBytes >= w.config.BufferMaxBytes
+}
+
+// handleOverflow applies buffer overflow policy to new message, it returns false if message must not be cached
+// together with error, if message must be requeued
+func (w *BridgeWorker) handleOverflow(msg *producer.Message) (bool, error) {
+	if w.blockOverflow() {
+		// consumption is paused by backpressure, so messages that are already being handled are accepted
+		return true, nil
+	}
+
+	w.Lock()
+	overflows := w.overflows(len(msg.Body))
+	var evicted []*producer.Message
+	var evictedSeqs, evictedReplicaSeqs []uint64
+	if overflows && w.config.BufferOverflowPolicy == config.OverflowPolicyDropOldest {
+		// messages being published can not be evicted, so new message is dropped if they fill buffer alone
+		for len(w.cache) > 0 && w.overflows(len(msg.Body)) {
+			evicted = append(evicted, w.cache[0])
+			w.bufferedBytes -= len(w.cache[0].Body)
+			w.cache = w.cache[1:]
+		}
+		overflows = w.overflows(len(msg.Body))
+		evictedSeqs, evictedReplicaSeqs = w.bufferedSeqs(evicted)
+	}
+	w.Unlock()
+
+	for _, evictedMsg := range evicted {
+		if w.requeueEvicted(evictedMsg) {
+			continue
+		}
+		log.WithField("msg", evictedMsg.String()).Warning("Worker buffer is full, dropping the oldest message")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"overflow", config.OverflowPolicyDropOldest, evictedMsg.Topic})
+	}
+	w.removeBuffered(evictedSeqs, evictedReplicaSeqs)
+
+	if !overflows {
+		return true, nil
+	}
+
+	logger := log.WithField("msg", msg.String())
+	switch w.config.BufferOverflowPolicy {
+	case config.OverflowPolicySpill, config.OverflowPolicySpillToStorage:
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"overflow", config.OverflowPolicySpill, msg.Topic})
+		if err := w.storeMessage(msg); err != nil {
+			// broker keeps the message until there is room in buffer or storage is available again
+			logger.WithError(err).Warning("Worker buffer is full and storage is not available, requeueing message")
+			return false, err
+		}
+		logger.Debug("Worker buffer is full, moved message to storage")
+	default:
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"overflow", config.OverflowPolicyDropNewest, msg.Topic})
+		logger.Warning("Worker buffer is full, dropping new message")
+	}
+
+	return false, nil
+}
+
+// requeueEvicted requeues message evicted from buffer if it is end-to-end message, that is not acknowledged yet
+func (w *BridgeWorker) requeueEvicted(msg *producer.Message) bool {
+	w.Lock()
+	settle, ok := w.pending[msg]
+	delete(w.pending, msg)
+	w.Unlock()
+	if !ok {
+		return false
+	}
+
+	log.WithField("msg", msg.String()).Warning("Worker buffer is full, requeueing the oldest end-to-end message")
+	w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"overflow", "requeue", msg.Topic})
+	settle(errBufferFull)
+
+	return true
+}
@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+// partitionKey evaluates pipe partition key expression for the message, empty key is returned
+// if message has no value for the expression
+func partitionKey(expression string, msg amqp.Delivery) (string, error) {
+	switch {
+	case expression == "":
+		return "", nil
+	case expression == config.PartitionKeyRoutingKey:
+		return msg.RoutingKey, nil
+	case strings.HasPrefix(expression, config.PartitionKeyHeaderPrefix):
+		return keyString(msg.Headers[strings.TrimPrefix(expression, config.PartitionKeyHeaderPrefix)]), nil
+	case strings.HasPrefix(expression, config.PartitionKeyJSONPrefix):
+		return jsonFieldKey(msg.Body, strings.TrimPrefix(expression, config.PartitionKeyJSONPrefix))
+	}
+
+	return "", config.ErrInvalidPartitionKey
+}
+
+func jsonFieldKey(body []byte, field string) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// numbers are kept as is, so big integer ids are not turned into floats
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", nil
+		}
+		value = object[name]
+	}
+
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", nil
+	}
+
+	return keyString(value), nil
+}
+
+func keyString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,43 @@
+package workers
+
+import (
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionKey(t *testing.T) {
+	msg := amqp.Delivery{
+		Body:       []byte(`{"id":12345678901234567890,"customer":{"id":"c-1","tags":["a"]},"country":"DE"}`),
+		RoutingKey: "order.created",
+		Headers:    map[string]interface{}{"customer-id": "c-1", "shard": int32(7), "raw": []byte("raw-value")},
+	}
+
+	for expression, expected := range map[string]string{
+		"":                     "",
+		"routingKey":           "order.created",
+		"header:customer-id":   "c-1",
+		"header:shard":         "7",
+		"header:raw":           "raw-value",
+		"header:missing":       "",
+		"json:id":              "12345678901234567890",
+		"json:customer.id":     "c-1",
+		"json:country":         "DE",
+		"json:customer.tags":   "",
+		"json:customer":        "",
+		"json:country.missing": "",
+		"json:missing":         "",
+	} {
+		key, err := partitionKey(expression, msg)
+		assert.NoError(t, err, expression)
+		assert.Equal(t, expected, key, expression)
+	}
+
+	_, err := partitionKey("json:id", amqp.Delivery{Body: []byte("not a json")})
+	assert.Error(t, err)
+
+	_, err = partitionKey("exchange", msg)
+	assert.Equal(t, config.ErrInvalidPartitionKey, err)
+}
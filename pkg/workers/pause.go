@@ -0,0 +1,88 @@
+package workers
+
+import (
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+// pipeState is pipe consumption state, resumed is closed when paused pipe is resumed, it is nil while pipe is running
+type pipeState struct {
+	queue   string
+	resumed chan struct{}
+	traffic traffic
+	// published is throughput of pipe messages published to Kafka
+	published throughputWindow
+}
+
+// waitPipeResumed blocks while pipe is paused, so AMQP server stops delivering new messages of the pipe once its
+// consumers prefetch count is reached, while consumers of other pipes are not affected
+func (w *BridgeWorker) waitPipeResumed(pipe config.Pipe) {
+	w.Lock()
+	state, ok := w.pipeStates[rateLimitKey(pipe)]
+	if !ok {
+		// pipe is consumed for the first time, so its state is not reloaded yet
+		state = w.setPipePaused(pipe, pipe.Paused)
+	}
+	resumed := state.resumed
+	w.Unlock()
+
+	if resumed != nil {
+		<-resumed
+	}
+}
+
+// UpdatePausedPipes pauses and resumes pipes of reloaded pipes config, pipes that are not consumed yet get
+// their state once messages consumption starts. Pipes missing in reloaded config are resumed, so their consumers
+// blocked on pause can stop.
+func (w *BridgeWorker) UpdatePausedPipes(pipes []config.Pipe) {
+	w.Lock()
+	defer w.Unlock()
+
+	reloaded := make(map[string]bool, len(pipes))
+	for _, pipe := range pipes {
+		w.setPipePaused(pipe, pipe.Paused)
+		reloaded[rateLimitKey(pipe)] = true
+	}
+
+	for key, state := range w.pipeStates {
+		if !reloaded[key] {
+			w.resumePipe(state)
+			delete(w.pipeStates, key)
+		}
+	}
+}
+
+// setPipePaused pauses or resumes pipe messages consumption, must be called with worker locked
+func (w *BridgeWorker) setPipePaused(pipe config.Pipe, paused bool) *pipeState {
+	state, ok := w.pipeStates[rateLimitKey(pipe)]
+	if !ok {
+		state = &pipeState{queue: pipe.RabbitQueueName}
+		w.pipeStates[rateLimitKey(pipe)] = state
+	}
+
+	if !paused {
+		w.resumePipe(state)
+		return state
+	}
+
+	if state.resumed == nil {
+		log.WithField("queue", pipe.RabbitQueueName).Warning("Pausing pipe messages consumption")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"pipe", "paused", pipe.RabbitQueueName})
+		state.resumed = make(chan struct{})
+	}
+
+	return state
+}
+
+// resumePipe resumes paused pipe messages consumption, must be called with worker locked
+func (w *BridgeWorker) resumePipe(state *pipeState) {
+	if state.resumed == nil {
+		return
+	}
+
+	log.WithField("queue", state.queue).Info("Resuming pipe messages consumption")
+	w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"pipe", "resumed", state.queue})
+	close(state.resumed)
+	state.resumed = nil
+}
@@ -0,0 +1,61 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeWorker_UpdatePausedPipes(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(config.WorkerConfig{}, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	pipe := config.Pipe{RabbitQueueName: "orders", Paused: true}
+	other := config.Pipe{RabbitQueueName: "payments"}
+
+	// running pipe is not blocked
+	worker.waitPipeResumed(other)
+
+	done := make(chan struct{})
+	go func(pipe config.Pipe) {
+		worker.waitPipeResumed(pipe)
+		close(done)
+	}(pipe)
+
+	select {
+	case <-done:
+		t.Fatal("paused pipe consumption is not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// reloaded state overrides the one pipe was consumed with
+	pipe.Paused = false
+	worker.UpdatePausedPipes([]config.Pipe{pipe, other})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("resumed pipe consumption is still blocked")
+	}
+	worker.waitPipeResumed(config.Pipe{RabbitQueueName: "orders", Paused: true})
+
+	worker.UpdatePausedPipes([]config.Pipe{pipe, {RabbitQueueName: "payments", Paused: true}})
+	assert.NotNil(t, worker.pipeStates[rateLimitKey(other)].resumed)
+	assert.Nil(t, worker.pipeStates[rateLimitKey(pipe)].resumed)
+
+	// pipes removed from config are resumed, so their blocked consumers can stop
+	worker.UpdatePausedPipes([]config.Pipe{pipe})
+	assert.NotContains(t, worker.pipeStates, rateLimitKey(other))
+
+	metrics := worker.statsClient.(*client.Memory).CountMetrics
+	assert.Equal(t, 1, metrics["worker.pipe.paused.orders"])
+	assert.Equal(t, 1, metrics["worker.pipe.resumed.orders"])
+	assert.Equal(t, 1, metrics["worker.pipe.paused.payments"])
+	assert.Equal(t, 1, metrics["worker.pipe.resumed.payments"])
+}
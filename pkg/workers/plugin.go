@@ -0,0 +1,89 @@
+package workers
+
+import (
+	"errors"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/plugins"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errPluginNotLoaded = errors.New("pipe plugin is not loaded")
+	errEmptyPluginBody = errors.New("plugin transformer returned empty body")
+)
+
+// LoadPlugins loads and instantiates plugins of pipes, pipes get new plugin instances with their current config,
+// so it must be called on start and for pipes that are changed on reload before their consumption starts
+func (w *BridgeWorker) LoadPlugins(pipes []config.Pipe) error {
+	for _, pipe := range pipes {
+		if pipe.Plugin == nil {
+			w.plugins.Delete(pipe.Key())
+			continue
+		}
+
+		p, err := plugins.Load(*pipe.Plugin)
+		if err != nil {
+			return err
+		}
+		w.plugins.Store(pipe.Key(), p)
+		log.WithField("queue", pipe.RabbitQueueName).WithField("plugin", pipe.Plugin.Path).Debug("Loaded pipe plugin")
+	}
+
+	return nil
+}
+
+// pipePlugin returns pipe plugin instance, it returns nil if pipe has no plugin
+func (w *BridgeWorker) pipePlugin(pipe config.Pipe) (*plugins.Plugin, error) {
+	if pipe.Plugin == nil {
+		return nil, nil
+	}
+
+	p, ok := w.plugins.Load(pipe.Key())
+	if !ok {
+		return nil, errPluginNotLoaded
+	}
+
+	return p.(*plugins.Plugin), nil
+}
+
+// pluginMatches checks if message must be forwarded to Kafka according to pipe plugin filter
+func (w *BridgeWorker) pluginMatches(pipe config.Pipe, delivery amqp.Delivery) (bool, error) {
+	p, err := w.pipePlugin(pipe)
+	if err != nil {
+		return false, err
+	}
+	if p == nil || p.Filter == nil {
+		return true, nil
+	}
+
+	return p.Filter.Match(delivery)
+}
+
+// pluginTransform returns body transformed with pipe plugin transformer, body is returned as is
+// if pipe plugin has no transformer
+func (w *BridgeWorker) pluginTransform(pipe config.Pipe, delivery amqp.Delivery, body []byte) ([]byte, error) {
+	p, err := w.pipePlugin(pipe)
+	if err != nil || p == nil || p.Transformer == nil {
+		return body, err
+	}
+
+	delivery.Body = body
+	transformed, err := p.Transformer.Transform(delivery)
+	if err == nil && len(transformed) == 0 {
+		return nil, errEmptyPluginBody
+	}
+
+	return transformed, err
+}
+
+// pluginResult returns handling result for plugin error, message is rejected if plugin rejects it or returns
+// empty body and requeued otherwise
+func pluginResult(err error) error {
+	if err == plugins.ErrReject || err == errEmptyPluginBody {
+		return amqp.ErrRejectMessage
+	}
+
+	return err
+}
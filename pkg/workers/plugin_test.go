@@ -0,0 +1,88 @@
+package workers
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/plugins"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockPlugin struct {
+	matchErr     error
+	transformErr error
+}
+
+func (p *mockPlugin) Match(delivery amqp.Delivery) (bool, error) {
+	return delivery.RoutingKey == "order.created", p.matchErr
+}
+
+func (p *mockPlugin) Transform(delivery amqp.Delivery) ([]byte, error) {
+	if p.transformErr != nil {
+		return nil, p.transformErr
+	}
+
+	return bytes.ToUpper(delivery.Body), nil
+}
+
+func TestBridgeWorker_MessageHandler_plugin(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	pipe := config.Pipe{
+		KafkaTopic:      "orders",
+		RabbitQueueName: "orders",
+		Transform:       `{"event":{{.Body}}}`,
+		Plugin:          &config.PluginSettings{Path: "orders.so"},
+	}
+	delivery := amqp.Delivery{Body: []byte(`"created"`), RoutingKey: "order.created"}
+
+	// message is requeued until plugin is loaded
+	assert.Equal(t, errPluginNotLoaded, worker.MessageHandler(delivery, pipe))
+
+	p := &mockPlugin{}
+	worker.plugins.Store(pipe.Key(), &plugins.Plugin{Filter: p, Transformer: p})
+
+	// plugin gets body transformed with template
+	require.NoError(t, worker.MessageHandler(delivery, pipe))
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, []byte(`{"EVENT":"CREATED"}`), worker.cache[0].Body)
+
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte(`"paid"`), RoutingKey: "order.paid"}, pipe))
+	assert.Len(t, worker.cache, 1)
+
+	p.transformErr = errors.New("enrichment service is not available")
+	assert.Equal(t, p.transformErr, worker.MessageHandler(delivery, pipe))
+
+	p.transformErr = plugins.ErrReject
+	assert.Equal(t, amqp.ErrRejectMessage, worker.MessageHandler(delivery, pipe))
+
+	p.matchErr = plugins.ErrReject
+	assert.Equal(t, amqp.ErrRejectMessage, worker.MessageHandler(delivery, pipe))
+	assert.Len(t, worker.cache, 1)
+}
+
+func TestBridgeWorker_LoadPlugins(t *testing.T) {
+	workerConfig := config.WorkerConfig{}
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(workerConfig, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	pipe := config.Pipe{RabbitQueueName: "orders"}
+	worker.plugins.Store(pipe.Key(), &plugins.Plugin{})
+
+	// plugin of pipe that has no plugin anymore is dropped
+	require.NoError(t, worker.LoadPlugins([]config.Pipe{pipe}))
+	_, ok := worker.plugins.Load(pipe.Key())
+	assert.False(t, ok)
+
+	pipe.Plugin = &config.PluginSettings{Path: "missing.so"}
+	assert.Error(t, worker.LoadPlugins([]config.Pipe{pipe}))
+}
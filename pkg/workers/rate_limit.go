@@ -0,0 +1,111 @@
+package workers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimiter is a token bucket limiting pipe consumption rate, bucket holds up to limit messages,
+// so short bursts within limit are not delayed
+type rateLimiter struct {
+	sync.Mutex
+
+	limit  config.RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit config.RateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit, tokens: float64(limit.Messages), last: time.Now()}
+}
+
+// setLimit changes limiter rate, tokens collected so far are kept within the new limit
+func (l *rateLimiter) setLimit(limit config.RateLimit) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.refill(time.Now())
+	l.limit = limit
+	if l.tokens > float64(limit.Messages) {
+		l.tokens = float64(limit.Messages)
+	}
+}
+
+// reserve takes token for the message and returns amount of time message must wait for its turn,
+// tokens may go below zero, so concurrent consumers of the pipe are served in order they came
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.limit.Messages <= 0 {
+		return 0
+	}
+
+	l.refill(now)
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate() * float64(time.Second))
+}
+
+// refill adds tokens collected since the last refill, must be called with limiter locked
+func (l *rateLimiter) refill(now time.Time) {
+	if l.limit.Messages > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate()
+		if l.tokens > float64(l.limit.Messages) {
+			l.tokens = float64(l.limit.Messages)
+		}
+	}
+	l.last = now
+}
+
+// rate returns number of tokens added per second
+func (l *rateLimiter) rate() float64 {
+	return float64(l.limit.Messages) / l.limit.Per.Seconds()
+}
+
+// rateLimitKey returns key pipe rate limiter is stored with, pipes are identified by their queue
+func rateLimitKey(pipe config.Pipe) string {
+	return pipe.Key()
+}
+
+// throttle blocks message consumption while pipe rate limit is exceeded, the blocked consumer stops
+// receiving messages once its prefetch count is reached, while consumers of other pipes are not affected
+func (w *BridgeWorker) throttle(pipe config.Pipe, topic string) {
+	limiter, ok := w.rateLimiters.Load(rateLimitKey(pipe))
+	if !ok {
+		// pipe rate limit is validated on load, so invalid value can not get here
+		limit, _ := config.ParseRateLimit(pipe.RateLimit)
+		limiter, _ = w.rateLimiters.LoadOrStore(rateLimitKey(pipe), newRateLimiter(limit))
+	}
+
+	if delay := limiter.(*rateLimiter).reserve(time.Now()); delay > 0 {
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"ratelimit", "throttled", topic})
+		time.Sleep(delay)
+	}
+}
+
+// UpdateRateLimits applies rate limits of reloaded pipes config, pipes that are not consumed yet get
+// their limits once messages consumption starts
+func (w *BridgeWorker) UpdateRateLimits(pipes []config.Pipe) error {
+	for _, pipe := range pipes {
+		limit, err := config.ParseRateLimit(pipe.RateLimit)
+		if err != nil {
+			return err
+		}
+
+		limiter, loaded := w.rateLimiters.LoadOrStore(rateLimitKey(pipe), newRateLimiter(limit))
+		if loaded {
+			limiter.(*rateLimiter).setLimit(limit)
+		}
+		log.WithField("queue", pipe.RabbitQueueName).WithField("rate_limit", pipe.RateLimit).Debug("Applied pipe rate limit")
+	}
+
+	return nil
+}
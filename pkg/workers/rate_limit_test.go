@@ -0,0 +1,51 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimit{Messages: 2, Per: time.Second})
+	now := limiter.last
+
+	// burst within limit is not delayed
+	assert.Equal(t, time.Duration(0), limiter.reserve(now))
+	assert.Equal(t, time.Duration(0), limiter.reserve(now))
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve(now))
+	assert.Equal(t, time.Second, limiter.reserve(now))
+
+	// tokens are refilled with the limit rate
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, time.Duration(0), limiter.reserve(now))
+
+	limiter.setLimit(config.RateLimit{})
+	assert.Equal(t, time.Duration(0), limiter.reserve(now))
+}
+
+func TestBridgeWorker_UpdateRateLimits(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(config.WorkerConfig{}, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	pipe := config.Pipe{RabbitQueueName: "orders", RateLimit: "100/s"}
+	worker.throttle(pipe, "topic")
+
+	pipe.RateLimit = "10/m"
+	require.NoError(t, worker.UpdateRateLimits([]config.Pipe{pipe, {RabbitQueueName: "payments"}}))
+
+	limiter, ok := worker.rateLimiters.Load(rateLimitKey(pipe))
+	require.True(t, ok)
+	assert.Equal(t, config.RateLimit{Messages: 10, Per: time.Minute}, limiter.(*rateLimiter).limit)
+
+	limiter, ok = worker.rateLimiters.Load(rateLimitKey(config.Pipe{RabbitQueueName: "payments"}))
+	require.True(t, ok)
+	assert.Equal(t, config.RateLimit{}, limiter.(*rateLimiter).limit)
+
+	assert.Equal(t, config.ErrInvalidRateLimit, worker.UpdateRateLimits([]config.Pipe{{RabbitQueueName: "orders", RateLimit: "fast"}}))
+}
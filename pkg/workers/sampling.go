@@ -0,0 +1,144 @@
+package workers
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	log "github.com/sirupsen/logrus"
+)
+
+// Message sampling stages messages are logged at
+const (
+	stageConsumed    = "consumed"
+	stageTransformed = "transformed"
+	stagePublished   = "published"
+)
+
+var (
+	errUnknownPipe        = errors.New("pipe is not loaded")
+	errInvalidSampleRatio = errors.New("sample ratio must be between 0 and 1")
+)
+
+// sampler picks messages of pipes sampled for debugging, sampled messages of all the pipes are limited by max
+// rate, so sampling can not flood logs whatever pipes ratios are
+type sampler struct {
+	sync.Mutex
+
+	// ratios are sample ratios mapped by pipe key
+	ratios  map[string]float64
+	maxRate float64
+	tokens  float64
+	last    time.Time
+}
+
+func newSampler(maxRate int) *sampler {
+	return &sampler{ratios: make(map[string]float64), maxRate: float64(maxRate), tokens: float64(maxRate), last: time.Now()}
+}
+
+// sample checks if message of the pipe is sampled, tokens are refilled with max rate and never exceed it,
+// so sampled messages bursts are limited either
+func (s *sampler) sample(pipeKey string, now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	ratio, ok := s.ratios[pipeKey]
+	if !ok {
+		return false
+	}
+
+	s.tokens += now.Sub(s.last).Seconds() * s.maxRate
+	if s.tokens > s.maxRate {
+		s.tokens = s.maxRate
+	}
+	s.last = now
+
+	if s.tokens < 1 || rand.Float64() >= ratio {
+		return false
+	}
+	s.tokens--
+
+	return true
+}
+
+// SampleMessages changes sample ratio of the pipe messages logged at every stage for debugging, 0 ratio stops
+// sampling, while 1 samples every message up to WorkerConfig.SampleMaxRate messages per second
+func (w *BridgeWorker) SampleMessages(pipeKey string, ratio float64) error {
+	if ratio < 0 || ratio > 1 {
+		return errInvalidSampleRatio
+	}
+
+	w.Lock()
+	_, ok := w.pipeStates[pipeKey]
+	w.Unlock()
+	if !ok {
+		return errUnknownPipe
+	}
+
+	w.sampler.Lock()
+	defer w.sampler.Unlock()
+
+	if ratio == 0 {
+		delete(w.sampler.ratios, pipeKey)
+	} else {
+		w.sampler.ratios[pipeKey] = ratio
+	}
+	log.WithField("pipe", pipeKey).WithField("ratio", ratio).Warning("Pipe messages sampling is changed")
+
+	return nil
+}
+
+// SampledPipes returns sample ratios of sampled pipes mapped by pipe key
+func (w *BridgeWorker) SampledPipes() map[string]float64 {
+	w.sampler.Lock()
+	defer w.sampler.Unlock()
+
+	ratios := make(map[string]float64, len(w.sampler.ratios))
+	for key, ratio := range w.sampler.ratios {
+		ratios[key] = ratio
+	}
+
+	return ratios
+}
+
+// logConsumed logs sampled AMQP delivery as it is consumed
+func logConsumed(pipe config.Pipe, delivery amqp.Delivery) {
+	log.WithFields(log.Fields{
+		"stage":       stageConsumed,
+		"pipe":        pipe.String(),
+		"exchange":    delivery.Exchange,
+		"routing_key": delivery.RoutingKey,
+		"message_id":  delivery.MessageID,
+		"timestamp":   delivery.Timestamp,
+		"headers":     delivery.Headers,
+		"body":        string(delivery.Body),
+	}).Info("Sampled message")
+}
+
+// logTransformed logs sampled message as it is accepted for publishing
+func logTransformed(pipe config.Pipe, msg *producer.Message) {
+	log.WithFields(log.Fields{
+		"stage":   stageTransformed,
+		"pipe":    pipe.String(),
+		"msg":     msg.String(),
+		"key":     msg.Key,
+		"headers": msg.Headers,
+		"body":    string(msg.Body),
+	}).Info("Sampled message")
+}
+
+// logPublished logs sampled message publish result, published is message copy producer has set partition and
+// offset of
+func logPublished(msg *producer.Message, published producer.Message, err error) {
+	entry := log.WithFields(log.Fields{"stage": stagePublished, "pipe": msg.Pipe, "msg": msg.String()})
+	if err != nil {
+		entry.WithError(err).WithField("attempts", msg.Attempts).Info("Sampled message failed to be published")
+		return
+	}
+
+	entry.WithField("partition", published.Partition).WithField("offset", published.Offset).Info("Sampled message")
+}
@@ -0,0 +1,56 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampler(t *testing.T) {
+	s := newSampler(2)
+	now := time.Now()
+	assert.False(t, s.sample("/orders", now))
+
+	s.ratios["/orders"] = 1
+	assert.True(t, s.sample("/orders", now))
+	assert.True(t, s.sample("/orders", now))
+	// max rate is reached
+	assert.False(t, s.sample("/orders", now))
+
+	// tokens are refilled up to max rate only
+	now = now.Add(time.Minute)
+	assert.True(t, s.sample("/orders", now))
+	assert.True(t, s.sample("/orders", now))
+	assert.False(t, s.sample("/orders", now))
+}
+
+func TestBridgeWorker_SampleMessages(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(config.WorkerConfig{SampleMaxRate: 10}, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	orders := config.Pipe{RabbitQueueName: "orders", KafkaTopic: "orders"}
+	worker.UpdatePausedPipes([]config.Pipe{orders})
+
+	assert.Equal(t, errUnknownPipe, worker.SampleMessages("/payments", 1))
+	assert.Equal(t, errInvalidSampleRatio, worker.SampleMessages("/orders", 2))
+	assert.Empty(t, worker.SampledPipes())
+
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("not sampled")}, orders))
+
+	require.NoError(t, worker.SampleMessages("/orders", 1))
+	assert.Equal(t, map[string]float64{"/orders": 1}, worker.SampledPipes())
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("sampled")}, orders))
+
+	require.Len(t, worker.cache, 2)
+	assert.False(t, worker.cache[0].Sampled)
+	assert.True(t, worker.cache[1].Sampled)
+
+	require.NoError(t, worker.SampleMessages("/orders", 0))
+	assert.Empty(t, worker.SampledPipes())
+}
@@ -0,0 +1,80 @@
+package workers
+
+import (
+	"errors"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errBufferFull   = errors.New("worker buffer is full")
+	errWorkerClosed = errors.New("worker is closed")
+)
+
+// settlements are deferred AMQP deliveries settlements of end-to-end messages
+type settlements map[*producer.Message]func(err error)
+
+// acceptEndToEnd caches end-to-end message and defers its AMQP delivery settlement until it is published.
+// AMQP broker keeps the message until then, so it is neither written to durable buffer nor moved to storage,
+// and it is requeued when buffer is full, regardless of overflow policy.
+func (w *BridgeWorker) acceptEndToEnd(msg *producer.Message, settle func(err error)) error {
+	w.Lock()
+	full := !w.blockOverflow() && w.overflows(len(msg.Body))
+	if !full {
+		w.pending[msg] = settle
+	}
+	w.Unlock()
+
+	if full {
+		log.WithField("msg", msg.String()).Warning("Worker buffer is full, requeueing end-to-end message")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"overflow", "requeue", msg.Topic})
+		return errBufferFull
+	}
+
+	w.cacheMessage(msg)
+
+	return amqp.ErrAckDeferred
+}
+
+// settleMessage settles AMQP delivery of end-to-end message with its publish result, it returns false
+// for messages without deferred settlement. Message is acknowledged once it is published, requeued
+// if publishing can be retried, and rejected otherwise, unless it is moved to error topic,
+// then settlement is deferred until error topic message is published.
+func (w *BridgeWorker) settleMessage(msg *producer.Message, err error) bool {
+	w.Lock()
+	settle, ok := w.pending[msg]
+	delete(w.pending, msg)
+	w.Unlock()
+	if !ok {
+		return false
+	}
+
+	logger := log.WithField("msg", msg.String())
+	switch {
+	case err == nil:
+		settle(nil)
+	case producer.IsRetriable(err):
+		logger.WithError(err).Warning("Failed to publish end-to-end message to Kafka, requeueing it")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"publish", "requeue", msg.Topic})
+		settle(err)
+	case msg.ErrorTopic != "":
+		logger.WithError(err).WithField("error_topic", msg.ErrorTopic).
+			Error("Failed to publish end-to-end message to Kafka with non-retriable error, moving it to error topic")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"publish", "fatal", msg.Topic})
+		errorMsg := errorTopicMessage(msg, err)
+		w.Lock()
+		w.pending[errorMsg] = settle
+		w.Unlock()
+		w.cacheMessage(errorMsg)
+	default:
+		// rejected message is dead-lettered by broker, if pipe queue has dead letter exchange
+		logger.WithError(err).Error("Failed to publish end-to-end message to Kafka with non-retriable error, rejecting it")
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"publish", "fatal", msg.Topic})
+		settle(amqp.ErrRejectMessage)
+	}
+
+	return true
+}
@@ -0,0 +1,224 @@
+package workers
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go/bucket"
+	"github.com/hellofresh/stats-go/timer"
+)
+
+// Pipe consumption states reported by status
+const (
+	PipeRunning = "running"
+	PipePaused  = "paused"
+)
+
+// traffic counts messages handled by pipe or published to topic and remembers the last failure
+type traffic struct {
+	handled  uint64
+	failed   uint64
+	lastErr  string
+	failedAt time.Time
+	window   throughputWindow
+}
+
+// record counts message of given size handled with err, latency is counted for messages published to Kafka
+func (t *traffic) record(err error, now time.Time, bytes int, latency time.Duration) {
+	t.handled++
+	t.window.add(now, bytes, latency)
+	if err != nil {
+		t.failed++
+		t.lastErr = err.Error()
+		t.failedAt = now
+	}
+}
+
+// TrafficStatus is runtime status of messages flow through pipe or to topic
+type TrafficStatus struct {
+	// Handled is number of messages handled since start, either successfully or not
+	Handled uint64 `json:"handled"`
+	// Failed is number of messages failed to be handled since start
+	Failed uint64 `json:"failed"`
+	// LastMinute is throughput of handled messages over the last minute
+	LastMinute ThroughputStatus `json:"lastMinute"`
+	// LastError is error of the last failed message, empty if there were no failures
+	LastError string `json:"lastError,omitempty"`
+	// LastErrorAt is time of the last failure, nil if there were no failures
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+func (t *traffic) status(now time.Time) TrafficStatus {
+	status := TrafficStatus{Handled: t.handled, Failed: t.failed, LastMinute: t.window.status(now), LastError: t.lastErr}
+	if !t.failedAt.IsZero() {
+		failedAt := t.failedAt
+		status.LastErrorAt = &failedAt
+	}
+
+	return status
+}
+
+// PipeStatus is runtime status of pipe consumption, messages are counted as handled once they are accepted,
+// requeued or rejected
+type PipeStatus struct {
+	TrafficStatus
+	// Key is pipe key - virtual host and queue name
+	Key string `json:"key"`
+	// Queue is name of the pipe queue
+	Queue string `json:"queue"`
+	// State is pipe consumption state, either PipeRunning or PipePaused
+	State string `json:"state"`
+	// Published is throughput of pipe messages published to Kafka over the last minute, latency is counted
+	// from AMQP message timestamp, or from consumption time for messages without it, to Kafka acknowledgement
+	Published ThroughputStatus `json:"published"`
+}
+
+// TopicStatus is runtime status of publishing to Kafka topic, messages are counted for every publish attempt
+type TopicStatus struct {
+	TrafficStatus
+	// Topic is Kafka topic name
+	Topic string `json:"topic"`
+}
+
+// BufferStatus is worker buffer depth and age
+type BufferStatus struct {
+	// Messages is number of cached and in-flight messages
+	Messages int `json:"messages"`
+	// InFlight is number of messages being published
+	InFlight int `json:"inFlight"`
+	// Bytes is total body size of cached and in-flight messages
+	Bytes int `json:"bytes"`
+	// AgeSeconds is age of the oldest unpublished message, 0 if buffer is empty
+	AgeSeconds float64 `json:"ageSeconds"`
+}
+
+// Status is worker runtime status
+type Status struct {
+	// Circuit is circuit breaker state - "closed", "open" or "half-open"
+	Circuit string `json:"circuit"`
+	// Paused is true while messages consumption of all the pipes is paused
+	Paused bool `json:"paused"`
+	// Draining is true once worker is publishing buffered messages before exit
+	Draining bool `json:"draining"`
+	// Buffer is buffer depth and age
+	Buffer BufferStatus `json:"buffer"`
+	// Pipes are pipes consumption statuses sorted by pipe key, pipes appear once they are loaded or consumed
+	Pipes []PipeStatus `json:"pipes"`
+	// Topics are topics publishing statuses sorted by topic, topics appear once messages are published to them
+	Topics []TopicStatus `json:"topics"`
+}
+
+// Status returns worker runtime status
+func (w *BridgeWorker) Status() Status {
+	w.Lock()
+	defer w.Unlock()
+
+	now := time.Now()
+	buffer := w.bufferStats(now)
+	status := Status{
+		Circuit:  w.circuit.String(),
+		Paused:   w.resumed != nil,
+		Draining: w.draining,
+		Buffer: BufferStatus{
+			Messages:   buffer.messages,
+			InFlight:   w.inFlight,
+			Bytes:      buffer.bytes,
+			AgeSeconds: buffer.age.Seconds(),
+		},
+		Pipes:  make([]PipeStatus, 0, len(w.pipeStates)),
+		Topics: make([]TopicStatus, 0, len(w.topics)),
+	}
+
+	for key, state := range w.pipeStates {
+		pipeStatus := PipeStatus{
+			TrafficStatus: state.traffic.status(now),
+			Key:           key,
+			Queue:         state.queue,
+			State:         PipeRunning,
+			Published:     state.published.status(now),
+		}
+		if state.resumed != nil {
+			pipeStatus.State = PipePaused
+		}
+		status.Pipes = append(status.Pipes, pipeStatus)
+	}
+	sort.Slice(status.Pipes, func(i, j int) bool { return status.Pipes[i].Key < status.Pipes[j].Key })
+
+	for topic, t := range w.topics {
+		status.Topics = append(status.Topics, TopicStatus{TrafficStatus: t.status(now), Topic: topic})
+	}
+	sort.Slice(status.Topics, func(i, j int) bool { return status.Topics[i].Topic < status.Topics[j].Topic })
+
+	return status
+}
+
+// recordPipeTraffic counts message handled by pipe, deferred acknowledgement is not a failure. Pipes that are
+// removed by reload while their messages are being handled are not counted anymore.
+func (w *BridgeWorker) recordPipeTraffic(pipe config.Pipe, delivery amqp.Delivery, err error) {
+	if err == amqp.ErrAckDeferred {
+		err = nil
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if state, ok := w.pipeStates[rateLimitKey(pipe)]; ok {
+		state.traffic.record(err, time.Now(), len(delivery.Body), 0)
+	}
+}
+
+// recordPublishTraffic counts publish results of messages by their topics, messages published successfully
+// are counted by their pipes along with their end-to-end latency
+func (w *BridgeWorker) recordPublishTraffic(messages []*producer.Message, errs []error) {
+	now := time.Now()
+
+	w.Lock()
+	defer w.Unlock()
+
+	for i, msg := range messages {
+		var latency time.Duration
+		if errs[i] == nil {
+			latency = messageLatency(msg, now)
+		}
+
+		t, ok := w.topics[msg.Topic]
+		if !ok {
+			t = &traffic{}
+			w.topics[msg.Topic] = t
+		}
+		t.record(errs[i], now, len(msg.Body), latency)
+
+		state, ok := w.pipeStates[msg.Pipe]
+		if errs[i] != nil || !ok {
+			continue
+		}
+		state.published.add(now, len(msg.Body), latency)
+
+		var latencyTimer timer.Timer
+		if latency > 0 {
+			latencyTimer = timer.NewDuration(latency)
+		}
+		w.statsClient.TrackOperation(statsWorkerSection, bucket.MetricOperation{"pipe", "latency", state.queue}, latencyTimer, true)
+		w.statsClient.TrackMetricN(statsWorkerSection, bucket.MetricOperation{"pipe", "bytes", state.queue}, len(msg.Body))
+	}
+}
+
+// messageLatency returns time passed since message was sent to AMQP server, consumption time is used for messages
+// without AMQP timestamp, latency is 0 if publisher clock is ahead
+func messageLatency(msg *producer.Message, now time.Time) time.Duration {
+	sentAt := msg.SentAt
+	if sentAt == 0 {
+		sentAt = msg.CreatedAt
+	}
+	if sentAt == 0 {
+		return 0
+	}
+	if latency := now.Sub(time.Unix(0, sentAt)); latency > 0 {
+		return latency
+	}
+
+	return 0
+}
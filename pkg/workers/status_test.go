@@ -0,0 +1,119 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/kandalf/pkg/producer"
+	"github.com/hellofresh/stats-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeWorker_Status(t *testing.T) {
+	statsClient, _ := stats.NewClient("memory://")
+	worker, err := NewBridgeWorker(config.WorkerConfig{}, &mockStorage{}, nil, nil, &mockProducer{}, nil, statsClient)
+	require.NoError(t, err)
+
+	orders := config.Pipe{RabbitQueueName: "orders", KafkaTopic: `{{.Header "tenant"}}`}
+	payments := config.Pipe{RabbitQueueName: "payments", KafkaTopic: "payments", Paused: true}
+	worker.UpdatePausedPipes([]config.Pipe{orders, payments})
+
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte("body"), Headers: map[string]interface{}{"tenant": "acme"}}, orders))
+	assert.Equal(t, amqp.ErrRejectMessage, worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, orders))
+
+	messages := generateRandomMessages(2)
+	messages[0].Pipe = "/orders"
+	messages[0].SentAt = time.Now().Add(-3 * time.Second).UnixNano()
+	messages[1].Pipe = "/orders"
+	worker.recordPublishTraffic(messages, []error{nil, errors.New("kafka is down")})
+
+	status := worker.Status()
+	assert.Equal(t, "closed", status.Circuit)
+	assert.False(t, status.Paused)
+	assert.Equal(t, 1, status.Buffer.Messages)
+	assert.Equal(t, 4, status.Buffer.Bytes)
+
+	require.Len(t, status.Pipes, 2)
+	assert.Equal(t, "/orders", status.Pipes[0].Key)
+	assert.Equal(t, PipeRunning, status.Pipes[0].State)
+	assert.Equal(t, uint64(2), status.Pipes[0].Handled)
+	assert.Equal(t, uint64(1), status.Pipes[0].Failed)
+	assert.Equal(t, 2.0/throughputWindowSeconds, status.Pipes[0].LastMinute.Messages)
+	assert.Equal(t, 8.0/throughputWindowSeconds, status.Pipes[0].LastMinute.Bytes)
+	assert.Zero(t, status.Pipes[0].LastMinute.LatencyMax)
+	assert.Equal(t, 1.0/throughputWindowSeconds, status.Pipes[0].Published.Messages)
+	assert.Equal(t, float64(len(messages[0].Body))/throughputWindowSeconds, status.Pipes[0].Published.Bytes)
+	assert.InDelta(t, 3, status.Pipes[0].Published.LatencyAvg, 0.5)
+	assert.Equal(t, status.Pipes[0].Published.LatencyAvg, status.Pipes[0].Published.LatencyMax)
+	assert.Equal(t, amqp.ErrRejectMessage.Error(), status.Pipes[0].LastError)
+	assert.NotNil(t, status.Pipes[0].LastErrorAt)
+	assert.Equal(t, "payments", status.Pipes[1].Queue)
+	assert.Equal(t, PipePaused, status.Pipes[1].State)
+	assert.Equal(t, uint64(0), status.Pipes[1].Handled)
+	assert.Nil(t, status.Pipes[1].LastErrorAt)
+	assert.Zero(t, status.Pipes[1].Published.Messages)
+
+	require.Len(t, status.Topics, 2)
+	for _, topic := range status.Topics {
+		assert.Equal(t, uint64(1), topic.Handled)
+		if topic.Topic == messages[1].Topic {
+			assert.Equal(t, "kafka is down", topic.LastError)
+		} else {
+			assert.Equal(t, messages[0].Topic, topic.Topic)
+			assert.Empty(t, topic.LastError)
+		}
+	}
+}
+
+func TestTraffic(t *testing.T) {
+	var tr traffic
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		tr.record(nil, now, 6, time.Second)
+	}
+	tr.record(producer.ErrPublishDeadlineExceeded, now, 6, 0)
+
+	status := tr.status(now)
+	assert.Equal(t, uint64(11), status.Handled)
+	assert.Equal(t, uint64(1), status.Failed)
+	assert.Equal(t, producer.ErrPublishDeadlineExceeded.Error(), status.LastError)
+	assert.Equal(t, &now, status.LastErrorAt)
+	assert.Equal(t, 11.0/throughputWindowSeconds, status.LastMinute.Messages)
+}
+
+func TestThroughputWindow(t *testing.T) {
+	var tw throughputWindow
+	now := time.Unix(1600000000, 0)
+	tw.add(now.Add(-90*time.Second), 1000, time.Hour)
+	tw.add(now.Add(-30*time.Second), 20, time.Second)
+	tw.add(now.Add(-time.Second), 10, 3*time.Second)
+	tw.add(now, 30, 0)
+
+	assert.Equal(t, ThroughputStatus{
+		Messages:   3.0 / throughputWindowSeconds,
+		Bytes:      60.0 / throughputWindowSeconds,
+		LatencyAvg: 2,
+		LatencyMax: 3,
+	}, tw.status(now))
+
+	// bucket of the same second a window ago is reused
+	tw.add(now.Add(throughputWindowSeconds*time.Second), 5, 0)
+	assert.Equal(t, ThroughputStatus{Messages: 1.0 / throughputWindowSeconds, Bytes: 5.0 / throughputWindowSeconds},
+		tw.status(now.Add(throughputWindowSeconds*time.Second)))
+}
+
+func TestMessageLatency(t *testing.T) {
+	now := time.Now()
+	msg := &producer.Message{CreatedAt: now.Add(-time.Second).UnixNano()}
+	assert.Equal(t, time.Second, messageLatency(msg, now))
+
+	msg.SentAt = now.Add(-time.Minute).UnixNano()
+	assert.Equal(t, time.Minute, messageLatency(msg, now))
+
+	msg.SentAt = now.Add(time.Second).UnixNano()
+	assert.Zero(t, messageLatency(msg, now))
+}
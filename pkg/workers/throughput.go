@@ -0,0 +1,83 @@
+package workers
+
+import "time"
+
+// throughputWindowSeconds is length of rolling window pipes and topics throughput is reported for
+const throughputWindowSeconds = 60
+
+// throughputBucket accumulates messages counted within one second
+type throughputBucket struct {
+	second     int64
+	messages   int
+	bytes      int
+	timed      int
+	latency    time.Duration
+	maxLatency time.Duration
+}
+
+// throughputWindow is rolling window of per-second buckets, so rates are not skewed by traffic that is long gone
+type throughputWindow struct {
+	buckets [throughputWindowSeconds]throughputBucket
+}
+
+// add counts message of given size, latency is counted only if it is positive, as it is unknown otherwise
+func (tw *throughputWindow) add(now time.Time, bytes int, latency time.Duration) {
+	second := now.Unix()
+	b := &tw.buckets[second%throughputWindowSeconds]
+	if b.second != second {
+		*b = throughputBucket{second: second}
+	}
+
+	b.messages++
+	b.bytes += bytes
+	if latency > 0 {
+		b.timed++
+		b.latency += latency
+		if latency > b.maxLatency {
+			b.maxLatency = latency
+		}
+	}
+}
+
+// ThroughputStatus is messages flow over the last minute
+type ThroughputStatus struct {
+	// Messages is average number of messages per second
+	Messages float64 `json:"messagesPerSecond"`
+	// Bytes is average body size of messages per second
+	Bytes float64 `json:"bytesPerSecond"`
+	// LatencyAvg is average end-to-end latency of messages in seconds, omitted if it is not counted
+	LatencyAvg float64 `json:"latencyAvgSeconds,omitempty"`
+	// LatencyMax is max end-to-end latency of messages in seconds, omitted if it is not counted
+	LatencyMax float64 `json:"latencyMaxSeconds,omitempty"`
+}
+
+func (tw *throughputWindow) status(now time.Time) ThroughputStatus {
+	var (
+		total      throughputBucket
+		maxLatency time.Duration
+	)
+	second := now.Unix()
+	for _, b := range tw.buckets {
+		if b.second <= second-throughputWindowSeconds || b.second > second {
+			continue
+		}
+		total.messages += b.messages
+		total.bytes += b.bytes
+		total.timed += b.timed
+		total.latency += b.latency
+		if b.maxLatency > maxLatency {
+			maxLatency = b.maxLatency
+		}
+	}
+
+	status := ThroughputStatus{
+		Messages:   float64(total.messages) / throughputWindowSeconds,
+		Bytes:      float64(total.bytes) / throughputWindowSeconds,
+		LatencyMax: maxLatency.Seconds(),
+	}
+	if total.timed > 0 {
+		status.LatencyAvg = (total.latency / time.Duration(total.timed)).Seconds()
+	}
+
+	return status
+}
@@ -0,0 +1,49 @@
+package workers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+// messageTimestamp evaluates pipe timestamp expression for the message, zero time is returned
+// if message has no value for the expression
+func messageTimestamp(expression string, msg amqp.Delivery) (time.Time, error) {
+	switch {
+	case expression == "":
+		return time.Time{}, nil
+	case expression == config.TimestampProperty:
+		return msg.Timestamp, nil
+	case strings.HasPrefix(expression, config.PartitionKeyHeaderPrefix):
+		value := msg.Headers[strings.TrimPrefix(expression, config.PartitionKeyHeaderPrefix)]
+		// AMQP 0-9-1 has timestamp field type, so headers may contain time values
+		if t, ok := value.(time.Time); ok {
+			return t, nil
+		}
+		return parseTimestamp(keyString(value))
+	case strings.HasPrefix(expression, config.PartitionKeyJSONPrefix):
+		value, err := jsonFieldKey(msg.Body, strings.TrimPrefix(expression, config.PartitionKeyJSONPrefix))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return parseTimestamp(value)
+	}
+
+	return time.Time{}, config.ErrInvalidTimestamp
+}
+
+// parseTimestamp parses either Unix time in milliseconds, as Kafka record timestamps are, or RFC 3339 time
+func parseTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, millis*int64(time.Millisecond)), nil
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -0,0 +1,48 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageTimestamp(t *testing.T) {
+	occurredAt := time.Date(2018, 7, 1, 12, 30, 0, 0, time.UTC)
+	msg := amqp.Delivery{
+		Body:      []byte(`{"occurredAt":"2018-07-01T12:30:00Z","event":{"time":1530448200000},"name":"order"}`),
+		Timestamp: occurredAt,
+		Headers: map[string]interface{}{
+			"occurred-at": occurredAt,
+			"millis":      int64(1530448200000),
+			"rfc3339":     "2018-07-01T14:30:00+02:00",
+		},
+	}
+
+	for expression, expected := range map[string]time.Time{
+		"":                   {},
+		"timestamp":          occurredAt,
+		"header:occurred-at": occurredAt,
+		"header:millis":      occurredAt,
+		"header:rfc3339":     occurredAt,
+		"header:missing":     {},
+		"json:occurredAt":    occurredAt,
+		"json:event.time":    occurredAt,
+		"json:missing":       {},
+	} {
+		timestamp, err := messageTimestamp(expression, msg)
+		assert.NoError(t, err, expression)
+		assert.True(t, expected.Equal(timestamp), expression)
+	}
+
+	_, err := messageTimestamp("json:name", msg)
+	assert.Error(t, err)
+
+	_, err = messageTimestamp("json:occurredAt", amqp.Delivery{Body: []byte("not a json")})
+	assert.Error(t, err)
+
+	_, err = messageTimestamp("routingKey", msg)
+	assert.Equal(t, config.ErrInvalidTimestamp, err)
+}
@@ -0,0 +1,154 @@
+package workers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"text/template"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/bucket"
+)
+
+var (
+	errEmptyTopic = errors.New("topic template expanded to empty topic")
+	errNoRoute    = errors.New("message route key has no route and pipe has no default topic")
+)
+
+// messageTemplateData is data available in pipe topic and transform templates, e.g. "events.{{.RoutingKey}}"
+type messageTemplateData struct {
+	delivery amqp.Delivery
+}
+
+// RoutingKey returns message routing key
+func (d messageTemplateData) RoutingKey() string {
+	return d.delivery.RoutingKey
+}
+
+// Exchange returns name of exchange message was published to
+func (d messageTemplateData) Exchange() string {
+	return d.delivery.Exchange
+}
+
+// Header returns message header value, empty string if message has no such header
+func (d messageTemplateData) Header(name string) string {
+	return keyString(d.delivery.Headers[name])
+}
+
+// Body returns raw message body
+func (d messageTemplateData) Body() string {
+	return string(d.delivery.Body)
+}
+
+// Data returns decoded JSON message body, numbers are kept as is, so big integer ids are not turned into floats
+func (d messageTemplateData) Data() (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(d.delivery.Body))
+	decoder.UseNumber()
+
+	var data interface{}
+	err := decoder.Decode(&data)
+	return data, err
+}
+
+// JSON returns JSON message body field value, nested fields are separated by dots, e.g. "tenant.id"
+func (d messageTemplateData) JSON(field string) (string, error) {
+	return jsonFieldKey(d.delivery.Body, field)
+}
+
+// topic returns destination topic of the message, that is topic of the first pipe route matching message route key,
+// or pipe topic, its template is expanded with the message data
+func (w *BridgeWorker) topic(pipe config.Pipe, delivery amqp.Delivery) (string, error) {
+	if pipe.KafkaRouteKey != "" {
+		key, err := partitionKey(pipe.KafkaRouteKey, delivery)
+		if err != nil {
+			return "", err
+		}
+		for _, route := range pipe.KafkaRoutes {
+			topic, ok, err := w.routeTopic(route, key)
+			if err != nil || ok {
+				return topic, err
+			}
+		}
+
+		if pipe.KafkaTopic == "" {
+			return "", errNoRoute
+		}
+		w.statsClient.TrackMetric(statsWorkerSection, bucket.MetricOperation{"route", "default", pipe.RabbitQueueName})
+	}
+
+	if !config.IsTopicTemplate(pipe.KafkaTopic) {
+		return pipe.KafkaTopic, nil
+	}
+
+	tmpl, err := w.messageTemplate(pipe.KafkaTopic)
+	if err != nil {
+		return "", err
+	}
+
+	var topic bytes.Buffer
+	if err := tmpl.Execute(&topic, messageTemplateData{delivery: delivery}); err != nil {
+		return "", err
+	}
+	if topic.Len() == 0 {
+		return "", errEmptyTopic
+	}
+
+	return topic.String(), nil
+}
+
+// messageTemplate returns parsed topic or transform template, templates are parsed once and cached by their text
+func (w *BridgeWorker) messageTemplate(text string) (*template.Template, error) {
+	if tmpl, ok := w.templates.Load(text); ok {
+		return tmpl.(*template.Template), nil
+	}
+
+	tmpl, err := config.ParseTemplate("message", text)
+	if err != nil {
+		return nil, err
+	}
+	w.templates.Store(text, tmpl)
+
+	return tmpl, nil
+}
+
+// routeTopic returns route topic if route key is equal to route key or matches route pattern, pattern route topic
+// is expanded with pattern capturing groups, e.g. "orders.$1"
+func (w *BridgeWorker) routeTopic(route config.Route, key string) (string, bool, error) {
+	if route.Pattern == "" {
+		return route.Topic, route.Key == key, nil
+	}
+
+	pattern, err := w.routePattern(route.Pattern)
+	if err != nil {
+		return "", false, err
+	}
+
+	match := pattern.FindStringSubmatchIndex(key)
+	if match == nil {
+		return "", false, nil
+	}
+
+	topic := pattern.ExpandString(nil, route.Topic, key, match)
+	if len(topic) == 0 {
+		return "", false, errEmptyTopic
+	}
+
+	return string(topic), true, nil
+}
+
+// routePattern returns compiled route pattern, patterns are compiled once and cached by their text
+func (w *BridgeWorker) routePattern(text string) (*regexp.Regexp, error) {
+	if pattern, ok := w.routePatterns.Load(text); ok {
+		return pattern.(*regexp.Regexp), nil
+	}
+
+	pattern, err := regexp.Compile(text)
+	if err != nil {
+		return nil, err
+	}
+	w.routePatterns.Store(text, pattern)
+
+	return pattern, nil
+}
@@ -0,0 +1,116 @@
+package workers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/hellofresh/stats-go/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBridgeWorker_topic(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+
+	delivery := amqp.Delivery{
+		Body:       []byte(`{"tenant":{"id":"acme"}}`),
+		Exchange:   "events",
+		RoutingKey: "order.created",
+		Headers:    map[string]interface{}{"tenant": "acme"},
+	}
+
+	for template, expected := range map[string]string{
+		"orders":                        "orders",
+		"events.{{.RoutingKey}}":        "events.order.created",
+		"{{.Exchange}}.{{.RoutingKey}}": "events.order.created",
+		`{{.Header "tenant"}}.audit`:    "acme.audit",
+		`{{.JSON "tenant.id"}}.audit`:   "acme.audit",
+	} {
+		topic, err := worker.topic(config.Pipe{KafkaTopic: template}, delivery)
+		assert.NoError(t, err, template)
+		assert.Equal(t, expected, topic, template)
+	}
+
+	_, err := worker.topic(config.Pipe{KafkaTopic: `{{.Header "missing"}}`}, delivery)
+	assert.Equal(t, errEmptyTopic, err)
+
+	_, err = worker.topic(config.Pipe{KafkaTopic: `{{.JSON "tenant.id"}}.audit`}, amqp.Delivery{Body: []byte("not a json")})
+	assert.Error(t, err)
+}
+
+func TestBridgeWorker_MessageHandler_topicTemplate(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+	pipe := config.Pipe{KafkaTopic: `{{.Header "tenant"}}`}
+
+	err := worker.MessageHandler(amqp.Delivery{Body: []byte("body"), Headers: map[string]interface{}{"tenant": "acme"}}, pipe)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", worker.cache[0].Topic)
+
+	// topic template expands to empty string for message without tenant header
+	err = worker.MessageHandler(amqp.Delivery{Body: []byte("body")}, pipe)
+	assert.Equal(t, amqp.ErrRejectMessage, err)
+	assert.Len(t, worker.cache, 1)
+}
+
+func TestBridgeWorker_topic_routes(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+	pipe := config.Pipe{
+		KafkaTopic:      "customer-events",
+		RabbitQueueName: "kandalf-customer-events",
+		KafkaRouteKey:   "json:event_type",
+		KafkaRoutes:     []config.Route{{Key: "order.created", Topic: "new-orders"}, {Key: "badge.received", Topic: "loyalty"}},
+	}
+
+	topic, err := worker.topic(pipe, amqp.Delivery{Body: []byte(`{"event_type":"order.created"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, "new-orders", topic)
+
+	topic, err = worker.topic(pipe, amqp.Delivery{Body: []byte(`{"event_type":"badge.received"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, "loyalty", topic)
+
+	// messages without route are published to pipe topic
+	topic, err = worker.topic(pipe, amqp.Delivery{Body: []byte(`{"event_type":"customer.deleted"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, "customer-events", topic)
+
+	memoryStats := worker.statsClient.(*client.Memory)
+	assert.Equal(t, 1, memoryStats.CountMetrics[fmt.Sprintf("%s.route.default.%s", statsWorkerSection, pipe.RabbitQueueName)])
+
+	_, err = worker.topic(pipe, amqp.Delivery{Body: []byte("not a json")})
+	assert.Error(t, err)
+
+	pipe.KafkaTopic = ""
+	_, err = worker.topic(pipe, amqp.Delivery{Body: []byte(`{"event_type":"customer.deleted"}`)})
+	assert.Equal(t, errNoRoute, err)
+}
+
+func TestBridgeWorker_topic_routePatterns(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+	pipe := config.Pipe{
+		KafkaTopic:      "events",
+		RabbitQueueName: "kandalf-events",
+		KafkaRouteKey:   "routingKey",
+		KafkaRoutes: []config.Route{
+			{Key: "order.vip.created", Topic: "vip-orders"},
+			{Pattern: `^order\.(\w+)\.created$`, Topic: "orders.$1"},
+			{Pattern: `^(\w+)\.deleted$`, Topic: "deletions"},
+			{Pattern: `^payment\.(\w*)$`, Topic: "$1"},
+		},
+	}
+
+	for routingKey, expected := range map[string]string{
+		"order.vip.created":  "vip-orders",
+		"order.eu.created":   "orders.eu",
+		"customer.deleted":   "deletions",
+		"order.eu.us.placed": "events",
+	} {
+		topic, err := worker.topic(pipe, amqp.Delivery{RoutingKey: routingKey})
+		assert.NoError(t, err, routingKey)
+		assert.Equal(t, expected, topic, routingKey)
+	}
+
+	_, err := worker.topic(pipe, amqp.Delivery{RoutingKey: "payment."})
+	assert.Equal(t, errEmptyTopic, err)
+}
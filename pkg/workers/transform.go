@@ -0,0 +1,34 @@
+package workers
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+)
+
+var errEmptyBody = errors.New("transform template expanded to empty body")
+
+// transformBody returns message body transformed with pipe transform template, body is returned as is
+// if pipe has no transform
+func (w *BridgeWorker) transformBody(pipe config.Pipe, delivery amqp.Delivery) ([]byte, error) {
+	if pipe.Transform == "" {
+		return delivery.Body, nil
+	}
+
+	tmpl, err := w.messageTemplate(pipe.Transform)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, messageTemplateData{delivery: delivery}); err != nil {
+		return nil, err
+	}
+	if body.Len() == 0 {
+		return nil, errEmptyBody
+	}
+
+	return body.Bytes(), nil
+}
@@ -0,0 +1,49 @@
+package workers
+
+import (
+	"testing"
+
+	"github.com/hellofresh/kandalf/pkg/amqp"
+	"github.com/hellofresh/kandalf/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeWorker_transformBody(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+
+	delivery := amqp.Delivery{
+		Body:       []byte(`{"id":12345678901234567890,"customer":{"id":"c-1","email":"c@example.com"}}`),
+		RoutingKey: "order.created",
+	}
+
+	for transform, expected := range map[string]string{
+		"": `{"id":12345678901234567890,"customer":{"id":"c-1","email":"c@example.com"}}`,
+		`{{toJSON (omit .Data "customer.email")}}`:                                    `{"customer":{"id":"c-1"},"id":12345678901234567890}`,
+		`{"event":"{{.RoutingKey}}","payload":{{.Body}}}`:                             `{"event":"order.created","payload":{"id":12345678901234567890,"customer":{"id":"c-1","email":"c@example.com"}}}`,
+		`{{toJSON (dict "orderId" (.JSON "id") "customerId" (.JSON "customer.id"))}}`: `{"customerId":"c-1","orderId":"12345678901234567890"}`,
+	} {
+		body, err := worker.transformBody(config.Pipe{Transform: transform}, delivery)
+		assert.NoError(t, err, transform)
+		assert.Equal(t, expected, string(body), transform)
+	}
+
+	_, err := worker.transformBody(config.Pipe{Transform: `{{toJSON .Data}}`}, amqp.Delivery{Body: []byte("not a json")})
+	assert.Error(t, err)
+
+	_, err = worker.transformBody(config.Pipe{Transform: `{{.Header "missing"}}`}, delivery)
+	assert.Equal(t, errEmptyBody, err)
+}
+
+func TestBridgeWorker_MessageHandler_transform(t *testing.T) {
+	worker := getDefaultBridgeWorker(t)
+	pipe := config.Pipe{KafkaTopic: "orders", Transform: `{{toJSON (pick .Data "id")}}`}
+
+	require.NoError(t, worker.MessageHandler(amqp.Delivery{Body: []byte(`{"id":1,"email":"c@example.com"}`)}, pipe))
+	require.Len(t, worker.cache, 1)
+	assert.Equal(t, `{"id":1}`, string(worker.cache[0].Body))
+
+	// message that can not be transformed is rejected, so it is dead-lettered
+	assert.Equal(t, amqp.ErrRejectMessage, worker.MessageHandler(amqp.Delivery{Body: []byte("not a json")}, pipe))
+	assert.Len(t, worker.cache, 1)
+}